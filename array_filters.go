@@ -0,0 +1,145 @@
+package gorp
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An arrayFilter is a filter that uses one of the dialect's array
+// operators (see ArrayComparer).
+type arrayFilter struct {
+	left  interface{}
+	op    string
+	right interface{}
+}
+
+func (filter *arrayFilter) Where(structMap ColumnResolver, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
+	comparer, ok := dialect.(ArrayComparer)
+	if !ok {
+		return "", nil, errors.New("gorp: Array operators are not supported by this dialect")
+	}
+	operands := &comparisonFilter{filter.left, "", filter.right}
+	left, right, args, err := operands.operands(structMap, dialect, startBindIdx)
+	if err != nil {
+		return "", nil, err
+	}
+	switch filter.op {
+	case "any":
+		return comparer.Any(left, right), args, nil
+	case "contains":
+		return comparer.Contains(left, right), args, nil
+	default:
+		return comparer.Overlaps(left, right), args, nil
+	}
+}
+
+func (filter *arrayFilter) shape(structMap ColumnResolver, key *bytes.Buffer, args []interface{}) ([]interface{}, error) {
+	args, err := shapeOperand(filter.left, structMap, key, args)
+	if err != nil {
+		return nil, err
+	}
+	key.WriteString(" array " + filter.op + " ")
+	return shapeOperand(filter.right, structMap, key, args)
+}
+
+// EqualAny returns a filter for fieldPtr = ANY(values), which matches
+// rows where fieldPtr equals any element of values, a slice.  The
+// whole slice is bound to a single bind variable, so the statement is
+// the same no matter how many values there are.
+func EqualAny(fieldPtr interface{}, values interface{}) Filter {
+	return &arrayFilter{fieldPtr, "any", arrayParam(values)}
+}
+
+// ArrayHas returns a filter matching rows where the array column for
+// fieldPtr has an element equal to value.
+func ArrayHas(fieldPtr interface{}, value interface{}) Filter {
+	return &arrayFilter{value, "any", fieldPtr}
+}
+
+// ArrayContains returns a filter for fieldPtr @> values, which
+// matches rows where the array column for fieldPtr contains every
+// element of values, a slice:
+//
+//     query.Where(gorp.ArrayContains(&post.Tags, []string{"go", "sql"}))
+func ArrayContains(fieldPtr interface{}, values interface{}) Filter {
+	return &arrayFilter{fieldPtr, "contains", arrayParam(values)}
+}
+
+// ArrayOverlaps returns a filter for fieldPtr && values, which
+// matches rows where the array column for fieldPtr has any element in
+// common with values, a slice.
+func ArrayOverlaps(fieldPtr interface{}, values interface{}) Filter {
+	return &arrayFilter{fieldPtr, "overlaps", arrayParam(values)}
+}
+
+// arrayParam wraps values, a slice, so that it is passed to the driver
+// as an array literal.  Values that already implement driver.Valuer
+// (e.g. pq.StringArray) are passed through unchanged.
+func arrayParam(values interface{}) interface{} {
+	if _, ok := values.(driver.Valuer); ok {
+		return values
+	}
+	return arrayValue{values}
+}
+
+// An arrayValue is a slice that is bound as an array literal, e.g.
+// {"a","b"}.
+type arrayValue struct {
+	values interface{}
+}
+
+// Value implements driver.Valuer.
+func (a arrayValue) Value() (driver.Value, error) {
+	v := reflect.ValueOf(a.values)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("gorp: Array operators need a slice, got %T", a.values)
+	}
+	elements := make([]string, v.Len())
+	for i := range elements {
+		element, err := arrayElement(v.Index(i))
+		if err != nil {
+			return nil, err
+		}
+		elements[i] = element
+	}
+	return "{" + strings.Join(elements, ",") + "}", nil
+}
+
+// arrayElement returns the array literal representation of v.
+func arrayElement(v reflect.Value) (string, error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "NULL", nil
+		}
+		v = v.Elem()
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		return quoteArrayElement(t.Format(time.RFC3339Nano)), nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return quoteArrayElement(v.String()), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64), nil
+	}
+	return "", fmt.Errorf("gorp: Unsupported array element type %s", v.Type())
+}
+
+// quoteArrayElement double quotes s, escaping quotes and backslashes.
+func quoteArrayElement(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + s + `"`
+}
@@ -0,0 +1,463 @@
+package gorp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"time"
+)
+
+// Values from the Apache Arrow IPC format's flatbuffers schemas
+// (Message.fbs and Schema.fbs).
+const (
+	arrowMetadataV5 = 4
+
+	arrowSchemaMessage      = 1
+	arrowRecordBatchMessage = 3
+
+	arrowInt       = 2
+	arrowFloat     = 3
+	arrowBinary    = 4
+	arrowUtf8      = 5
+	arrowBool      = 6
+	arrowTimestamp = 10
+
+	arrowSingle      = 1
+	arrowDouble      = 2
+	arrowMicrosecond = 2
+)
+
+// An ArrowWriter writes RecordBatches to an Apache Arrow IPC stream,
+// which analytics tools (e.g. pyarrow, DuckDB, or Spark) read
+// directly or convert to Parquet files:
+//
+//     w := gorp.NewArrowWriter(f)
+//     err := dbmap.Query(inv).Where().Equal(&inv.IsPaid, true).
+//         SelectBatches(10000, w.Write)
+//     if err == nil {
+//         err = w.Close()
+//     }
+//
+// The stream's schema is taken from the first batch.  Columns of
+// integer, float, bool, string, []byte and time.Time fields (with
+// times written as microseconds since the epoch in UTC) are
+// supported, as are pointers to them and the sql.Null types, which
+// are written as nullable columns.  gorp doesn't write Parquet files
+// itself.
+type ArrowWriter struct {
+	w      io.Writer
+	names  []string
+	fields []arrowField
+}
+
+// arrowField is the Arrow type of a column.
+type arrowField struct {
+	typeID   byte
+	bitWidth int
+	signed   bool
+	nullable bool
+}
+
+// NewArrowWriter returns a writer of an Arrow IPC stream to w.
+func NewArrowWriter(w io.Writer) *ArrowWriter {
+	return &ArrowWriter{w: w}
+}
+
+// Write writes batch to the stream, preceded by the stream's schema
+// if it is the first batch.
+func (aw *ArrowWriter) Write(batch *RecordBatch) error {
+	if aw.fields == nil {
+		fields := make([]arrowField, len(batch.Types))
+		for i, t := range batch.Types {
+			field, err := arrowFieldFor(t)
+			if err != nil {
+				return fmt.Errorf("gorp: column %s: %s", batch.Names[i], err)
+			}
+			fields[i] = field
+		}
+		aw.names, aw.fields = batch.Names, fields
+		if err := aw.writeMessage(arrowSchemaMessage, aw.schema, nil); err != nil {
+			return err
+		}
+	}
+	if len(batch.Columns) != len(aw.fields) {
+		return fmt.Errorf("gorp: batch has %d columns, but the Arrow stream has %d", len(batch.Columns), len(aw.fields))
+	}
+	body := &arrowBody{}
+	for i, field := range aw.fields {
+		if err := body.column(field, reflect.ValueOf(batch.Columns[i]), batch.Len); err != nil {
+			return fmt.Errorf("gorp: column %s: %s", aw.names[i], err)
+		}
+	}
+	header := func(b *fbBuilder) int {
+		return b.table([]fbField{
+			fbInt64(int64(batch.Len)),
+			fbRef(func(b *fbBuilder) int { return b.structs(len(body.nodes)/16, body.nodes) }),
+			fbRef(func(b *fbBuilder) int { return b.structs(len(body.buffers)/16, body.buffers) }),
+		})
+	}
+	return aw.writeMessage(arrowRecordBatchMessage, header, body.data)
+}
+
+// Close ends the stream.  It doesn't close the underlying writer.
+func (aw *ArrowWriter) Close() error {
+	if aw.fields == nil {
+		return errors.New("gorp: an Arrow stream needs at least one batch")
+	}
+	_, err := aw.w.Write([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0})
+	return err
+}
+
+// writeMessage writes an encapsulated message, whose metadata is a
+// Message flatbuffer with the given header, followed by body.
+func (aw *ArrowWriter) writeMessage(headerType byte, header func(b *fbBuilder) int, body []byte) error {
+	b := &fbBuilder{buf: make([]byte, 4)}
+	b.patch(0, b.table([]fbField{
+		fbInt16(arrowMetadataV5),
+		fbUint8(headerType),
+		fbRef(header),
+		fbInt64(int64(len(body))),
+	}))
+	b.pad(8)
+	prefix := make([]byte, 8)
+	binary.LittleEndian.PutUint32(prefix, 0xffffffff)
+	binary.LittleEndian.PutUint32(prefix[4:], uint32(len(b.buf)))
+	for _, data := range [][]byte{prefix, b.buf, body} {
+		if _, err := aw.w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// schema writes the stream's Schema table.
+func (aw *ArrowWriter) schema(b *fbBuilder) int {
+	fields := make([]func(b *fbBuilder) int, len(aw.fields))
+	for i := range aw.fields {
+		name, field := aw.names[i], aw.fields[i]
+		fields[i] = func(b *fbBuilder) int {
+			return b.table([]fbField{
+				fbRef(func(b *fbBuilder) int { return b.string(name) }),
+				fbBool(field.nullable),
+				fbUint8(field.typeID),
+				fbRef(field.typeTable),
+				{},
+				fbRef(func(b *fbBuilder) int { return b.tables(nil) }),
+			})
+		}
+	}
+	return b.table([]fbField{
+		fbInt16(0), // little endian
+		fbRef(func(b *fbBuilder) int { return b.tables(fields) }),
+	})
+}
+
+// typeTable writes the table of field's member of the Type union.
+func (field arrowField) typeTable(b *fbBuilder) int {
+	switch field.typeID {
+	case arrowInt:
+		return b.table([]fbField{fbInt32(int32(field.bitWidth)), fbBool(field.signed)})
+	case arrowFloat:
+		precision := int16(arrowDouble)
+		if field.bitWidth == 32 {
+			precision = arrowSingle
+		}
+		return b.table([]fbField{fbInt16(precision)})
+	case arrowTimestamp:
+		return b.table([]fbField{
+			fbInt16(arrowMicrosecond),
+			fbRef(func(b *fbBuilder) int { return b.string("UTC") }),
+		})
+	}
+	return b.table(nil)
+}
+
+// arrowFieldFor returns the Arrow type of a column of type t.
+func arrowFieldFor(t reflect.Type) (arrowField, error) {
+	field := arrowField{}
+	if t.Kind() == reflect.Ptr {
+		field.nullable = true
+		t = t.Elem()
+	} else if isSQLNull(t) {
+		field.nullable = true
+		t = t.Field(0).Type
+	}
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		field.typeID = arrowTimestamp
+		return field, nil
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		field.typeID = arrowBinary
+		return field, nil
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		field.typeID = arrowBool
+	case reflect.String:
+		field.typeID = arrowUtf8
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		field.typeID, field.bitWidth, field.signed = arrowInt, t.Bits(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		field.typeID, field.bitWidth = arrowInt, t.Bits()
+	case reflect.Float32, reflect.Float64:
+		field.typeID, field.bitWidth = arrowFloat, t.Bits()
+	default:
+		return field, fmt.Errorf("type %s can't be written to Arrow", t)
+	}
+	return field, nil
+}
+
+// isSQLNull returns whether t is one of the sql.Null types, which
+// hold a value and whether it is valid.
+func isSQLNull(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t.PkgPath() == "database/sql" &&
+		t.NumField() == 2 && t.Field(1).Name == "Valid"
+}
+
+// arrowValue returns the value held by v, a column's value, and
+// whether it isn't null.
+func arrowValue(v reflect.Value) (reflect.Value, bool) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return reflect.Zero(v.Type().Elem()), false
+		}
+		return v.Elem(), true
+	}
+	if isSQLNull(v.Type()) {
+		return v.Field(0), v.Field(1).Bool()
+	}
+	return v, true
+}
+
+// arrowBody builds the body of a record batch message, along with the
+// FieldNode and Buffer structs that describe it.
+type arrowBody struct {
+	data    []byte
+	nodes   []byte
+	buffers []byte
+}
+
+// buffer appends data to the body, padded to 8 bytes.
+func (body *arrowBody) buffer(data []byte) {
+	body.buffers = appendInt64s(body.buffers, int64(len(body.data)), int64(len(data)))
+	body.data = append(body.data, data...)
+	for len(body.data)%8 != 0 {
+		body.data = append(body.data, 0)
+	}
+}
+
+// column appends the first n values of column, a slice, to the body.
+func (body *arrowBody) column(field arrowField, column reflect.Value, n int) error {
+	values := make([]reflect.Value, n)
+	valid := make([]byte, (n+7)/8)
+	nulls := 0
+	for i := range values {
+		var ok bool
+		if values[i], ok = arrowValue(column.Index(i)); ok {
+			valid[i/8] |= 1 << uint(i%8)
+		} else {
+			nulls++
+		}
+	}
+	body.nodes = appendInt64s(body.nodes, int64(n), int64(nulls))
+	if nulls == 0 {
+		valid = nil
+	}
+	body.buffer(valid)
+
+	switch field.typeID {
+	case arrowBool:
+		bits := make([]byte, (n+7)/8)
+		for i, v := range values {
+			if v.Bool() {
+				bits[i/8] |= 1 << uint(i%8)
+			}
+		}
+		body.buffer(bits)
+	case arrowInt, arrowFloat, arrowTimestamp:
+		width := field.bitWidth / 8
+		if field.typeID == arrowTimestamp {
+			width = 8
+		}
+		data := make([]byte, n*width)
+		word := make([]byte, 8)
+		for i, v := range values {
+			var bits uint64
+			switch {
+			case field.typeID == arrowTimestamp:
+				bits = uint64(v.Interface().(time.Time).UnixMicro())
+			case field.typeID == arrowFloat && width == 4:
+				bits = uint64(math.Float32bits(float32(v.Float())))
+			case field.typeID == arrowFloat:
+				bits = math.Float64bits(v.Float())
+			case field.signed:
+				bits = uint64(v.Int())
+			default:
+				bits = v.Uint()
+			}
+			binary.LittleEndian.PutUint64(word, bits)
+			copy(data[i*width:], word[:width])
+		}
+		body.buffer(data)
+	case arrowUtf8, arrowBinary:
+		offsets := make([]byte, 4*(n+1))
+		var data []byte
+		for i, v := range values {
+			if field.typeID == arrowUtf8 {
+				data = append(data, v.String()...)
+			} else {
+				data = append(data, v.Bytes()...)
+			}
+			if len(data) > math.MaxInt32 {
+				return errors.New("too much data for one Arrow batch; use smaller batches")
+			}
+			binary.LittleEndian.PutUint32(offsets[4*(i+1):], uint32(len(data)))
+		}
+		body.buffer(offsets)
+		body.buffer(data)
+	}
+	return nil
+}
+
+// appendInt64s appends values to buf in little-endian order.
+func appendInt64s(buf []byte, values ...int64) []byte {
+	for _, v := range values {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(v))
+	}
+	return buf
+}
+
+// fbBuilder encodes a flatbuffer front to back: each table is written
+// before the strings, vectors and tables that it refers to, since
+// references must point forward, and the references are patched as
+// those are written.
+type fbBuilder struct {
+	buf []byte
+}
+
+// An fbField is a field of a flatbuffers table: either a scalar, in
+// little-endian order, or a reference to an object written after the
+// table.  The zero fbField is an absent field.
+type fbField struct {
+	scalar []byte
+	ref    func(b *fbBuilder) int
+}
+
+func fbUint8(v byte) fbField { return fbField{scalar: []byte{v}} }
+
+func fbBool(v bool) fbField {
+	if v {
+		return fbUint8(1)
+	}
+	return fbUint8(0)
+}
+
+func fbInt16(v int16) fbField {
+	return fbField{scalar: binary.LittleEndian.AppendUint16(nil, uint16(v))}
+}
+
+func fbInt32(v int32) fbField {
+	return fbField{scalar: binary.LittleEndian.AppendUint32(nil, uint32(v))}
+}
+
+func fbInt64(v int64) fbField {
+	return fbField{scalar: binary.LittleEndian.AppendUint64(nil, uint64(v))}
+}
+
+func fbRef(ref func(b *fbBuilder) int) fbField { return fbField{ref: ref} }
+
+// width returns the size of the field in its table.
+func (f fbField) width() int {
+	if f.ref != nil {
+		return 4
+	}
+	return len(f.scalar)
+}
+
+// pad pads the buffer to a multiple of align bytes.
+func (b *fbBuilder) pad(align int) {
+	for len(b.buf)%align != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+// patch sets the reference at the given position to refer to to.
+func (b *fbBuilder) patch(at, to int) {
+	binary.LittleEndian.PutUint32(b.buf[at:], uint32(to-at))
+}
+
+// table writes a table with fields, indexed by their ids, preceded by
+// its vtable, and returns its position.
+func (b *fbBuilder) table(fields []fbField) int {
+	// The table is aligned to 8 bytes and starts with the offset of
+	// its vtable; the fields follow, largest first, each aligned to
+	// its size.
+	offsets := make([]int, len(fields))
+	size := 4
+	for _, width := range []int{8, 4, 2, 1} {
+		for i, f := range fields {
+			if f.width() == width {
+				size = (size + width - 1) / width * width
+				offsets[i] = size
+				size += width
+			}
+		}
+	}
+	b.pad(2)
+	vtable := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint16(b.buf, uint16(4+2*len(fields)))
+	b.buf = binary.LittleEndian.AppendUint16(b.buf, uint16(size))
+	for _, offset := range offsets {
+		b.buf = binary.LittleEndian.AppendUint16(b.buf, uint16(offset))
+	}
+	b.pad(8)
+	table := len(b.buf)
+	b.buf = append(b.buf, make([]byte, size)...)
+	binary.LittleEndian.PutUint32(b.buf[table:], uint32(table-vtable))
+	for i, f := range fields {
+		copy(b.buf[table+offsets[i]:], f.scalar)
+	}
+	for i, f := range fields {
+		if f.ref != nil {
+			b.patch(table+offsets[i], f.ref(b))
+		}
+	}
+	return table
+}
+
+// string writes s and returns its position.
+func (b *fbBuilder) string(s string) int {
+	b.pad(4)
+	pos := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(s)))
+	b.buf = append(append(b.buf, s...), 0)
+	return pos
+}
+
+// tables writes a vector of the tables written by elems and returns
+// its position.
+func (b *fbBuilder) tables(elems []func(b *fbBuilder) int) int {
+	b.pad(4)
+	pos := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(elems)))
+	b.buf = append(b.buf, make([]byte, 4*len(elems))...)
+	for i, elem := range elems {
+		b.patch(pos+4+4*i, elem(b))
+	}
+	return pos
+}
+
+// structs writes a vector of n structs, which are aligned to 8 bytes,
+// from data, and returns its position.
+func (b *fbBuilder) structs(n int, data []byte) int {
+	for (len(b.buf)+4)%8 != 0 {
+		b.buf = append(b.buf, 0)
+	}
+	pos := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(n))
+	b.buf = append(b.buf, data...)
+	return pos
+}
@@ -0,0 +1,106 @@
+package gorp
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// A StatementAuditor records statements before gorp runs them.  If
+// AuditStatement returns an error, the statement is not run and the
+// error is returned instead.
+type StatementAuditor interface {
+	AuditStatement(query string, args []interface{}) error
+}
+
+// AuditFunc is a function that implements StatementAuditor.
+type AuditFunc func(query string, args []interface{}) error
+
+// AuditStatement calls f(query, args).
+func (f AuditFunc) AuditStatement(query string, args []interface{}) error {
+	return f(query, args)
+}
+
+// SetStatementAuditor makes this DbMap pass every DDL statement it
+// generates (create, drop, and truncate) and every unscoped write run
+// by a query plan (an update or delete without filters or joins) to
+// auditor before running it, giving an operational record of schema
+// and mass-data changes.  Pass nil to stop auditing.
+//
+//     f, err := os.OpenFile("gorp-audit.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+//     ...
+//     dbmap.SetStatementAuditor(gorp.NewWriterAuditor(f))
+//
+// Statements passed to Exec() directly are not audited.
+func (m *DbMap) SetStatementAuditor(auditor StatementAuditor) {
+	m.auditor = auditor
+}
+
+// audit passes query to the DbMap's auditor, if it has one.
+func (m *DbMap) audit(query string, args []interface{}) error {
+	if m.auditor == nil {
+		return nil
+	}
+	if err := m.auditor.AuditStatement(query, m.scrubArgs(query, args)); err != nil {
+		return fmt.Errorf("gorp: Failed to audit statement: %s", err)
+	}
+	return nil
+}
+
+// execAudited audits query, then runs it using exec.
+func (m *DbMap) execAudited(exec SqlExecutor, query string, args ...interface{}) error {
+	if err := m.audit(query, args); err != nil {
+		return err
+	}
+	_, err := exec.Exec(query, args...)
+	return err
+}
+
+type writerAuditor struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterAuditor returns a StatementAuditor that appends a line
+// with the current time, the statement, and its arguments to w for
+// each statement.  Lines are written with a single call to w.Write.
+func NewWriterAuditor(w io.Writer) StatementAuditor {
+	return &writerAuditor{w: w}
+}
+
+func (a *writerAuditor) AuditStatement(query string, args []interface{}) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err := fmt.Fprintf(a.w, "%s\t%s\t%v\n", time.Now().UTC().Format(time.RFC3339Nano), query, args)
+	return err
+}
+
+type tableAuditor struct {
+	dbmap *DbMap
+	query string
+}
+
+// NewTableAuditor returns a StatementAuditor that inserts a row for
+// each statement into the named table, which must already exist and
+// have these columns:
+//
+//     audited_at timestamp, statement text, args text
+//
+// Rows are inserted outside of any transaction, so they are kept even
+// if the audited statement is rolled back.
+func NewTableAuditor(m *DbMap, schemaName, tableName string) StatementAuditor {
+	d := m.Dialect
+	return &tableAuditor{
+		dbmap: m,
+		query: fmt.Sprintf("insert into %s (%s, %s, %s) values (%s, %s, %s)",
+			d.QuotedTableForQuery(schemaName, tableName),
+			d.QuoteField("audited_at"), d.QuoteField("statement"), d.QuoteField("args"),
+			d.BindVar(0), d.BindVar(1), d.BindVar(2)),
+	}
+}
+
+func (a *tableAuditor) AuditStatement(query string, args []interface{}) error {
+	_, err := a.dbmap.Db.Exec(a.query, time.Now().UTC(), query, fmt.Sprintf("%v", args))
+	return err
+}
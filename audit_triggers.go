@@ -0,0 +1,101 @@
+package gorp
+
+import "fmt"
+
+// SetAuditTrigger marks the table to have its changes recorded by the
+// database, if b is true.  CreateTables then also creates an audit
+// table named after the table with an "_audit" suffix, and triggers
+// that insert a row into it for every insert, update, and delete of
+// the table's rows, so changes made outside the application are
+// recorded too:
+//
+//     dbmap.AddTable(Invoice{}).SetKeys(true, "Id").SetAuditTrigger(true)
+//
+// Each audit row holds the operation ("insert", "update", or
+// "delete"), the time of the change, and the old and new rows as JSON
+// objects, null where a row doesn't exist.  The dialect must implement
+// AuditTriggerer.  CreateTablesIfNotExists doesn't create audit tables;
+// use CreateAuditTriggers for tables that already exist.  Dropping the
+// table drops its triggers, but keeps its audit table, so the history
+// outlives the table; use DropAuditTables to drop audit tables.
+func (t *TableMap) SetAuditTrigger(b bool) *TableMap {
+	t.auditTrigger = b
+	return t
+}
+
+// auditTableName returns the name of the table's audit table (see
+// SetAuditTrigger).
+func (t *TableMap) auditTableName() string {
+	return t.TableName + "_audit"
+}
+
+// CreateAuditTriggers creates the audit tables and triggers of the
+// registered tables marked with TableMap.SetAuditTrigger, for tables
+// created before they were marked or with CreateTablesIfNotExists.
+// Returns an error if an audit table already exists.
+func (m *DbMap) CreateAuditTriggers() error {
+	for _, table := range m.tables {
+		if !table.auditTrigger {
+			continue
+		}
+		if err := m.createAuditTriggers(table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DropAuditTables drops the audit tables of the registered tables
+// marked with TableMap.SetAuditTrigger, along with the history they
+// hold.  It is never done by DropTables, so it must be asked for
+// explicitly, e.g. when tearing down a test database.  Audit tables
+// that don't exist are skipped.
+func (m *DbMap) DropAuditTables() error {
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+	for _, table := range m.tables {
+		if !table.auditTrigger {
+			continue
+		}
+		if err := m.execAudited(m, fmt.Sprintf("drop table if exists %s;", m.Dialect.QuotedTableForQuery(table.SchemaName, table.auditTableName()))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropAuditTriggers drops what the audit triggers of table leave
+// behind once table has been dropped (see AuditTriggerDropper).
+func (m *DbMap) dropAuditTriggers(table *TableMap) error {
+	dropper, ok := m.Dialect.(AuditTriggerDropper)
+	if !ok {
+		return nil
+	}
+	for _, statement := range dropper.DropAuditTriggers(table.SchemaName, table.TableName, table.auditTableName()) {
+		if err := m.execAudited(m, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createAuditTriggers creates the audit table and triggers of table.
+func (m *DbMap) createAuditTriggers(table *TableMap) error {
+	triggerer, ok := m.Dialect.(AuditTriggerer)
+	if !ok {
+		return fmt.Errorf("gorp: dialect %T does not support audit triggers", m.Dialect)
+	}
+	var columns []string
+	for _, col := range table.columns {
+		if col.inSchema() {
+			columns = append(columns, col.ColumnName)
+		}
+	}
+	for _, statement := range triggerer.AuditTriggers(table.SchemaName, table.TableName, table.auditTableName(), columns) {
+		if err := m.execAudited(m, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package gorp
+
+import (
+	"errors"
+	"reflect"
+)
+
+// A RecordBatch holds a batch of rows in column-oriented form, one
+// typed slice per selected column, which is the layout expected by
+// columnar formats like Apache Arrow record batches and Parquet row
+// groups.  ArrowWriter writes RecordBatches as an Arrow IPC stream;
+// writers for other formats can build each of their batches from a
+// RecordBatch.
+type RecordBatch struct {
+	// Names are the names of the selected columns.
+	Names []string
+
+	// Types are the Go types of the selected columns' fields.
+	Types []reflect.Type
+
+	// Columns holds a slice (e.g. []int64 or []*string) of Len values
+	// for each selected column.
+	Columns []interface{}
+
+	// Len is the number of rows in the batch.
+	Len int
+
+	fields  []string
+	columns []reflect.Value
+}
+
+// newRecordBatch returns an empty batch for cols with room for size
+// rows.
+func newRecordBatch(cols []*ColumnMap, size int) *RecordBatch {
+	batch := &RecordBatch{
+		Names:   make([]string, len(cols)),
+		Types:   make([]reflect.Type, len(cols)),
+		Columns: make([]interface{}, len(cols)),
+		fields:  make([]string, len(cols)),
+		columns: make([]reflect.Value, len(cols)),
+	}
+	for i, col := range cols {
+		batch.Names[i] = col.ColumnName
+		batch.Types[i] = col.gotype
+		batch.fields[i] = col.fieldName
+		batch.columns[i] = reflect.MakeSlice(reflect.SliceOf(col.gotype), 0, size)
+		batch.Columns[i] = batch.columns[i].Interface()
+	}
+	return batch
+}
+
+// append adds row, a pointer to a struct, to the batch.
+func (batch *RecordBatch) append(row reflect.Value) {
+	row = reflect.Indirect(row)
+	for i, field := range batch.fields {
+		batch.columns[i] = reflect.Append(batch.columns[i], row.FieldByName(field))
+		batch.Columns[i] = batch.columns[i].Interface()
+	}
+	batch.Len++
+}
+
+// SelectBatches runs this query plan as a select statement, passing
+// the rows to handler in batches of up to size rows, so large results
+// can be streamed to a columnar writer without holding every row in
+// memory:
+//
+//     w := gorp.NewArrowWriter(f)
+//     err := dbmap.Query(inv).Where().Equal(&inv.IsPaid, true).
+//         SelectBatches(10000, w.Write)
+//
+// Each batch is newly allocated, so handler may keep it.  If there are
+// no rows, handler is passed one empty batch, so that writers still
+// learn the columns.  Column restrictions and masks apply as they do
+// for SelectEach.
+func (plan *QueryPlan) SelectBatches(size int, handler func(batch *RecordBatch) error) error {
+	if size < 1 {
+		return errors.New("gorp: SelectBatches requires a positive batch size")
+	}
+	cols := plan.selectColumns()
+	batch := newRecordBatch(cols, size)
+	selected := false
+	err := plan.SelectEach(func(row interface{}) error {
+		batch.append(reflect.ValueOf(row))
+		if batch.Len < size {
+			return nil
+		}
+		full := batch
+		batch = newRecordBatch(cols, size)
+		selected = true
+		return handler(full)
+	})
+	if err != nil {
+		return err
+	}
+	if batch.Len > 0 || !selected {
+		return handler(batch)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package gorp
+
+import (
+	"fmt"
+	"time"
+)
+
+// SetValidTime marks fromField and toField as the valid time columns
+// of this table: the period during which a row's values were (or are
+// planned to be) true in the real world, independent of when the row
+// was written to the database.  A row is effective from fromField up
+// to, but not including, toField.  A null toField means the row is
+// effective indefinitely.
+//
+// Valid time can be combined with a system-versioned table's
+// transaction time (see QueryPlan.AsOf) to ask what was effective on
+// one date, as known at another:
+//
+//     dbmap.AddTable(Policy{}).SetKeys(true, "Id").
+//         SetValidTime("EffectiveFrom", "EffectiveTo")
+//
+//     policies, err := dbmap.Query(policy).
+//         Where().
+//         Equal(&policy.HolderId, holderId).
+//         EffectiveOn(claimDate).
+//         AsOf(reportDate).
+//         Select()
+//
+// Panics if either field can't be found.
+func (t *TableMap) SetValidTime(fromField, toField string) *TableMap {
+	t.validFrom = t.ColMap(fromField)
+	t.validTo = t.ColMap(toField)
+	return t
+}
+
+// EffectiveOn restricts the query to rows of the plan's table that
+// were effective at time t, according to the table's valid time
+// columns (see TableMap.SetValidTime).
+func (plan *QueryPlan) EffectiveOn(t time.Time) SelectQuery {
+	if plan.table.validFrom == nil {
+		plan.Errors = append(plan.Errors, fmt.Errorf("gorp: EffectiveOn: table %s has no valid time columns", plan.table.TableName))
+		return plan
+	}
+	from, err := plan.colMap.pointerForColumn(plan.table.validFrom)
+	if err != nil {
+		plan.Errors = append(plan.Errors, err)
+		return plan
+	}
+	to, err := plan.colMap.pointerForColumn(plan.table.validTo)
+	if err != nil {
+		plan.Errors = append(plan.Errors, err)
+		return plan
+	}
+	plan.storeJoin()
+	if plan.filters == nil {
+		plan.filters = new(andFilter)
+	}
+	plan.filters.Add(LessOrEqual(from, t), Or(Null(to), &comparisonFilter{to, ">", t}))
+	return plan
+}
@@ -0,0 +1,189 @@
+package gorp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A DeleteAction is what happens to the children of a one-to-many
+// association when their parent is deleted (see HasManyMap.OnDelete).
+type DeleteAction int
+
+const (
+	// NoAction leaves the children alone.  This is the default.
+	NoAction DeleteAction = iota
+
+	// CascadeDelete deletes the children.
+	CascadeDelete
+
+	// CascadeNullify sets the children's foreign keys to NULL.
+	CascadeNullify
+)
+
+// OnDelete sets what happens to a parent's children when the parent is
+// deleted by Delete() or by a query plan's Delete:
+//
+//     dbmap.AddTable(Post{}).SetKeys(true, "Id").
+//         ColMap("Comments").HasMany("PostId").
+//         OnDelete(gorp.CascadeDelete)
+//
+// If the child's foreign key column references the parent's table
+// (see ColumnMap.References) and the dialect supports foreign keys,
+// the action is left to the database: CreateTables adds it to the
+// foreign key constraint.  Otherwise, the children are deleted or
+// nullified by statements run before the parent's delete statement, in
+// the same transaction; a DbMap starts one for the purpose.  Cascades
+// continue to the children's own associations, but the children's
+// hooks aren't run, and their counters and shadow tables aren't
+// updated.  Tables whose delete actions are run by gorp must have a
+// single primary key column.
+func (h *HasManyMap) OnDelete(action DeleteAction) *HasManyMap {
+	h.onDelete = action
+	return h
+}
+
+// inDatabase returns true if the association's delete action is
+// enforced by a foreign key constraint.
+func (h *HasManyMap) inDatabase() bool {
+	return h.foreignKey.references == h.parent.gotype && h.foreignKey.inSchema() &&
+		supportsForeignKeys(h.parent.dbmap.Dialect)
+}
+
+// onDeleteClause returns the clause for the foreign key constraint of
+// the association's delete action.
+func (h *HasManyMap) onDeleteClause() string {
+	switch h.onDelete {
+	case CascadeDelete:
+		return " on delete cascade"
+	case CascadeNullify:
+		return " on delete set null"
+	}
+	return ""
+}
+
+// onDeleteClause returns the clause for the delete action of the
+// association enforced by this foreign key, if there is one.
+func (fk foreignKey) onDeleteClause() string {
+	for _, assoc := range fk.parent.hasMany {
+		if assoc.child == fk.table && assoc.foreignKey == fk.col {
+			return assoc.onDeleteClause()
+		}
+	}
+	return ""
+}
+
+// withOnDelete adds the foreign key's delete action to stmt, a
+// statement that adds the constraint.
+func (fk foreignKey) withOnDelete(stmt string) string {
+	clause := fk.onDeleteClause()
+	if clause == "" {
+		return stmt
+	}
+	if strings.HasSuffix(stmt, ";") {
+		return strings.TrimSuffix(stmt, ";") + clause + ";"
+	}
+	return stmt + clause
+}
+
+// cascades returns the table's associations whose delete actions are
+// run by gorp.  Associations whose child table isn't registered yet
+// are left out.
+func (t *TableMap) cascades() []*HasManyMap {
+	var cascades []*HasManyMap
+	for _, assoc := range t.hasMany {
+		if assoc.child != nil && assoc.onDelete != NoAction && !assoc.inDatabase() {
+			cascades = append(cascades, assoc)
+		}
+	}
+	return cascades
+}
+
+// hasCascades returns true if deleting any of the rows in list runs
+// delete actions.
+func (m *DbMap) hasCascades(list []interface{}) bool {
+	for _, ptr := range list {
+		if table, _, err := m.tableForPointer(ptr, false); err == nil && len(table.cascades()) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCascades returns an error if the table has delete actions run
+// by gorp but doesn't have a single primary key column to find the
+// children by.
+func (t *TableMap) checkCascades() error {
+	if len(t.keys) != 1 && len(t.cascades()) > 0 {
+		return fmt.Errorf("gorp: Cannot cascade deletes from table %s, which doesn't have exactly one primary key column", t.TableName)
+	}
+	return nil
+}
+
+// cascadeDelete runs the delete actions of the table's associations
+// for the children of the rows whose primary keys are selected by keys,
+// a list of bind variables or a subquery that takes args.
+func (t *TableMap) cascadeDelete(exec SqlExecutor, keys string, args []interface{}) error {
+	return t.cascadeDeleteFrom(exec, keys, args, map[*TableMap]bool{t: true})
+}
+
+func (t *TableMap) cascadeDeleteFrom(exec SqlExecutor, keys string, args []interface{}, path map[*TableMap]bool) error {
+	dialect := t.dbmap.Dialect
+	for _, assoc := range t.cascades() {
+		child := assoc.child
+		quotedChild := dialect.QuotedTableForQuery(child.SchemaName, child.TableName)
+		foreignKey := dialect.QuoteField(assoc.foreignKey.ColumnName)
+		where := fmt.Sprintf(" where %s in (%s)", foreignKey, keys)
+		if assoc.onDelete == CascadeNullify {
+			if _, err := exec.Exec(fmt.Sprintf("update %s set %s = null%s", quotedChild, foreignKey, where), args...); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(child.cascades()) > 0 {
+			if path[child] {
+				return fmt.Errorf("gorp: Cascading deletes from table %s to table %s form a cycle", t.TableName, child.TableName)
+			}
+			if err := child.checkCascades(); err != nil {
+				return err
+			}
+			path[child] = true
+			childKeys := fmt.Sprintf("select %s from %s%s", dialect.QuoteField(child.keys[0].ColumnName), quotedChild, where)
+			if err := child.cascadeDeleteFrom(exec, childKeys, args, path); err != nil {
+				return err
+			}
+			path[child] = false
+		}
+		if _, err := exec.Exec("delete from "+quotedChild+where, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cascadingDelete runs the plan's delete statement, query, after the
+// delete actions of the plan's table for the rows selected by keys,
+// in a transaction.
+func (plan *QueryPlan) cascadingDelete(query, keys string) (int64, error) {
+	if err := plan.table.checkCascades(); err != nil {
+		return -1, err
+	}
+	tx, exec, err := beginFor(plan.executor)
+	if err != nil {
+		return -1, err
+	}
+	if tx != nil {
+		outer := plan.executor
+		plan.executor = exec
+		defer func() { plan.executor = outer }()
+		rows, err := plan.cascadingDelete(query, keys)
+		if err != nil {
+			tx.Rollback()
+			return -1, err
+		}
+		return rows, tx.Commit()
+	}
+	if err := plan.table.cascadeDelete(plan.executor, keys, plan.args); err != nil {
+		return -1, err
+	}
+	return plan.execCount(query)
+}
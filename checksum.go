@@ -0,0 +1,218 @@
+package gorp
+
+import (
+	"crypto/sha1"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// A RowDiff describes a single row that differs between two result
+// sets.  Left or Right will be nil if the row was missing from that
+// side of the comparison.
+type RowDiff struct {
+	// Keys contains the primary key values of the row, or nil if the
+	// table has no primary key.
+	Keys []interface{}
+
+	// Left and Right are the rows as they were loaded from the left
+	// and right executors.
+	Left  interface{}
+	Right interface{}
+
+	// LeftChecksum and RightChecksum are the row checksums that were
+	// compared.
+	LeftChecksum  string
+	RightChecksum string
+}
+
+// A ResultDiff is the result of comparing the same query plan when
+// run against two different executors.
+type ResultDiff struct {
+	// Missing contains rows that were returned by the left executor
+	// but not the right executor.
+	Missing []RowDiff
+
+	// Extra contains rows that were returned by the right executor
+	// but not the left executor.
+	Extra []RowDiff
+
+	// Changed contains rows that were returned by both executors
+	// (matched by primary key) but whose column values differ.
+	Changed []RowDiff
+
+	// LeftRows and RightRows are the number of rows that each
+	// executor returned.
+	LeftRows  int
+	RightRows int
+}
+
+// Equal returns true if no differences were found.
+func (diff *ResultDiff) Equal() bool {
+	return len(diff.Missing) == 0 && len(diff.Extra) == 0 && len(diff.Changed) == 0
+}
+
+// CompareResults runs the select statement generated by plan against
+// both the left and right executors (e.g. a primary and a replica, or
+// an old and new schema) and reports row-level differences between
+// the two result sets.  The plan's own executor is not used.
+//
+// Rows are matched using the primary key of the plan's table.  If the
+// table has no primary key, rows are matched by checksum, so changed
+// rows will be reported as one missing row and one extra row.
+func CompareResults(plan Selector, left, right SqlExecutor) (*ResultDiff, error) {
+	p, ok := plan.(queryPlanner)
+	if !ok {
+		return nil, errors.New("gorp: CompareResults requires a query plan created by Query()")
+	}
+	q := p.queryPlan()
+	query, err := q.selectQuery()
+	if err != nil {
+		return nil, err
+	}
+	leftRows, err := left.Select(q.target.Interface(), query, q.args...)
+	if err != nil {
+		return nil, err
+	}
+	rightRows, err := right.Select(q.target.Interface(), query, q.args...)
+	if err != nil {
+		return nil, err
+	}
+	return q.table.diffRows(leftRows, rightRows), nil
+}
+
+// RowChecksum returns a checksum of the mapped, non-transient column
+// values of row, which must be a struct or pointer to a struct of a
+// type that has been registered with this DbMap.
+func (m *DbMap) RowChecksum(row interface{}) (string, error) {
+	t, err := toType(row)
+	if err != nil {
+		return "", err
+	}
+	table, err := m.tableFor(t, false)
+	if err != nil {
+		return "", err
+	}
+	return table.rowChecksum(reflect.Indirect(reflect.ValueOf(row))), nil
+}
+
+func (t *TableMap) rowChecksum(elem reflect.Value) string {
+	hash := sha1.New()
+	for _, col := range t.columns {
+		if col.Transient {
+			continue
+		}
+		fmt.Fprintf(hash, "%s=%#v;", col.ColumnName, checksumValue(elem.FieldByName(col.fieldName)))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// checksumValue returns the value that is stored in the database for
+// v, so that equal rows have equal checksums: pointers are
+// dereferenced (nil pointers are nil), driver.Valuers are converted,
+// and times are converted to UTC.
+func checksumValue(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	value := v.Interface()
+	if v.CanAddr() {
+		if _, ok := value.(driver.Valuer); !ok {
+			if valuer, ok := v.Addr().Interface().(driver.Valuer); ok {
+				value = valuer
+			}
+		}
+	}
+	if valuer, ok := value.(driver.Valuer); ok {
+		var err error
+		if value, err = valuer.Value(); err != nil {
+			return err.Error()
+		}
+	}
+	if t, ok := value.(time.Time); ok {
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	return value
+}
+
+func (t *TableMap) rowKeys(elem reflect.Value) []interface{} {
+	if len(t.keys) == 0 {
+		return nil
+	}
+	keys := make([]interface{}, 0, len(t.keys))
+	for _, col := range t.keys {
+		keys = append(keys, elem.FieldByName(col.fieldName).Interface())
+	}
+	return keys
+}
+
+type checksummedRow struct {
+	row      interface{}
+	keys     []interface{}
+	checksum string
+}
+
+func (t *TableMap) diffRows(leftRows, rightRows []interface{}) *ResultDiff {
+	diff := &ResultDiff{LeftRows: len(leftRows), RightRows: len(rightRows)}
+
+	index := func(rows []interface{}) (map[string][]checksummedRow, []string) {
+		byKey := make(map[string][]checksummedRow, len(rows))
+		order := make([]string, 0, len(rows))
+		for _, row := range rows {
+			elem := reflect.Indirect(reflect.ValueOf(row))
+			summed := checksummedRow{row: row, keys: t.rowKeys(elem), checksum: t.rowChecksum(elem)}
+			key := summed.checksum
+			if summed.keys != nil {
+				keys := make([]interface{}, len(summed.keys))
+				for i, k := range summed.keys {
+					keys[i] = checksumValue(reflect.ValueOf(k))
+				}
+				key = fmt.Sprintf("%#v", keys)
+			}
+			if _, ok := byKey[key]; !ok {
+				order = append(order, key)
+			}
+			byKey[key] = append(byKey[key], summed)
+		}
+		return byKey, order
+	}
+	leftIndex, leftOrder := index(leftRows)
+	rightIndex, rightOrder := index(rightRows)
+
+	for _, key := range leftOrder {
+		lefts, rights := leftIndex[key], rightIndex[key]
+		for i, l := range lefts {
+			if i >= len(rights) {
+				diff.Missing = append(diff.Missing, RowDiff{Keys: l.keys, Left: l.row, LeftChecksum: l.checksum})
+				continue
+			}
+			r := rights[i]
+			if l.checksum != r.checksum {
+				diff.Changed = append(diff.Changed, RowDiff{
+					Keys:          l.keys,
+					Left:          l.row,
+					Right:         r.row,
+					LeftChecksum:  l.checksum,
+					RightChecksum: r.checksum,
+				})
+			}
+		}
+	}
+	for _, key := range rightOrder {
+		lefts, rights := leftIndex[key], rightIndex[key]
+		for i := len(lefts); i < len(rights); i++ {
+			r := rights[i]
+			diff.Extra = append(diff.Extra, RowDiff{Keys: r.keys, Right: r.row, RightChecksum: r.checksum})
+		}
+	}
+	return diff
+}
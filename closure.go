@@ -0,0 +1,226 @@
+package gorp
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+)
+
+// A closureTable keeps a closure table for a self-referencing table
+// up to date.  The closure table has a row for every (ancestor,
+// descendant) pair in the tree, including a row for each node with
+// itself at depth 0.
+type closureTable struct {
+	schemaName string
+	tableName  string
+	parentCol  *ColumnMap
+}
+
+// SetClosureTable maintains a closure table named name for this
+// self-referencing table, where parentField is the name of the field
+// holding the primary key of a row's parent.  Rows written through
+// Insert(), Update(), and Delete() keep the closure table in sync, and
+// DbMap.Descendants and DbMap.Ancestors use it instead of recursive
+// queries.  This makes subtree queries fast on databases without
+// recursive queries (e.g. MySQL before 8.0).
+//
+// The closure table has ancestor, descendant, and depth columns, and
+// is created and dropped along with this table by CreateTables and
+// DropTables.  The table must have a single primary key column.
+//
+// Deleting a row detaches its children, which become roots of their
+// own trees.  Writes made by query plans are not tracked.
+//
+// Panics if parentField can't be found.
+func (t *TableMap) SetClosureTable(parentField, name string) *TableMap {
+	if len(t.keys) != 1 {
+		panic(fmt.Sprintf("gorp: SetClosureTable: table %s must have exactly one primary key column", t.TableName))
+	}
+	t.closure = &closureTable{
+		schemaName: t.SchemaName,
+		tableName:  name,
+		parentCol:  t.ColMap(parentField),
+	}
+	return t
+}
+
+func (c *closureTable) quotedName(dialect Dialect) string {
+	return dialect.QuotedTableForQuery(c.schemaName, c.tableName)
+}
+
+// createSql returns the statement that creates the closure table.
+func (c *closureTable) createSql(t *TableMap, ifNotExists bool) string {
+	dialect := t.dbmap.Dialect
+	key := t.keys[0]
+	keyType := dialect.ToSqlType(key.gotype, key.MaxSize, false)
+	depthType := dialect.ToSqlType(reflect.TypeOf(int64(0)), 0, false)
+	create := "create table"
+	if ifNotExists {
+		create += " if not exists"
+	}
+	return fmt.Sprintf("%s %s (%s %s not null, %s %s not null, %s %s not null, primary key (%s, %s)) %s;",
+		create, c.quotedName(dialect),
+		dialect.QuoteField("ancestor"), keyType,
+		dialect.QuoteField("descendant"), keyType,
+		dialect.QuoteField("depth"), depthType,
+		dialect.QuoteField("ancestor"), dialect.QuoteField("descendant"),
+		dialect.CreateTableSuffix())
+}
+
+// write updates the closure table after op was run for elem.
+func (c *closureTable) write(t *TableMap, exec SqlExecutor, op writeOp, elem reflect.Value) error {
+	dialect := t.dbmap.Dialect
+	closure := c.quotedName(dialect)
+	id := elem.FieldByName(t.keys[0].fieldName).Interface()
+	parents := distinctValues([]interface{}{elem.Addr().Interface()}, c.parentCol.fieldName)
+
+	switch op {
+	case writeInsert:
+		_, err := exec.Exec(fmt.Sprintf("insert into %s (%s, %s, %s) values (%s, %s, 0)",
+			closure, dialect.QuoteField("ancestor"), dialect.QuoteField("descendant"), dialect.QuoteField("depth"),
+			dialect.BindVar(0), dialect.BindVar(1)), id, id)
+		if err != nil {
+			return err
+		}
+		return c.attach(t, exec, id, parents)
+	case writeUpdate:
+		current, err := selectKeys(exec, t.keys[0].gotype, fmt.Sprintf("select %s from %s where %s = %s and %s = 1",
+			dialect.QuoteField("ancestor"), closure, dialect.QuoteField("descendant"), dialect.BindVar(0), dialect.QuoteField("depth")), id)
+		if err != nil {
+			return err
+		}
+		if len(current) == len(parents) && (len(current) == 0 || CacheKey(current[0]) == CacheKey(parents[0])) {
+			// The parent hasn't changed.
+			return nil
+		}
+		if err = c.detach(t, exec, id); err != nil {
+			return err
+		}
+		return c.attach(t, exec, id, parents)
+	case writeDelete:
+		if err := c.detach(t, exec, id); err != nil {
+			return err
+		}
+		_, err := exec.Exec(fmt.Sprintf("delete from %s where %s = %s or %s = %s",
+			closure, dialect.QuoteField("ancestor"), dialect.BindVar(0), dialect.QuoteField("descendant"), dialect.BindVar(1)), id, id)
+		return err
+	}
+	return nil
+}
+
+// attach adds paths from each of parent's ancestors (and parent
+// itself) to each node in id's subtree.
+func (c *closureTable) attach(t *TableMap, exec SqlExecutor, id interface{}, parents []interface{}) error {
+	if len(parents) == 0 {
+		return nil
+	}
+	dialect := t.dbmap.Dialect
+	closure := c.quotedName(dialect)
+	ancestor, descendant, depth := dialect.QuoteField("ancestor"), dialect.QuoteField("descendant"), dialect.QuoteField("depth")
+	_, err := exec.Exec(fmt.Sprintf("insert into %s (%s, %s, %s) select a.%s, b.%s, a.%s + b.%s + 1 from %s a, %s b where a.%s = %s and b.%s = %s",
+		closure, ancestor, descendant, depth,
+		ancestor, descendant, depth, depth,
+		closure, closure,
+		descendant, dialect.BindVar(0), ancestor, dialect.BindVar(1)), parents[0], id)
+	return err
+}
+
+// detach removes the paths from id's ancestors to each node in id's
+// subtree.  The subtree is loaded first, since MySQL doesn't allow
+// deleting from a table using a subquery on the same table.
+func (c *closureTable) detach(t *TableMap, exec SqlExecutor, id interface{}) error {
+	dialect := t.dbmap.Dialect
+	closure := c.quotedName(dialect)
+	subtree, err := selectKeys(exec, t.keys[0].gotype, fmt.Sprintf("select %s from %s where %s = %s",
+		dialect.QuoteField("descendant"), closure, dialect.QuoteField("ancestor"), dialect.BindVar(0)), id)
+	if err != nil || len(subtree) == 0 {
+		return err
+	}
+	s := bytes.Buffer{}
+	args := make([]interface{}, 0, 2*len(subtree))
+	s.WriteString(fmt.Sprintf("delete from %s where %s in (", closure, dialect.QuoteField("descendant")))
+	for i, node := range subtree {
+		if i > 0 {
+			s.WriteString(",")
+		}
+		s.WriteString(dialect.BindVar(len(args)))
+		args = append(args, node)
+	}
+	s.WriteString(fmt.Sprintf(") and %s not in (", dialect.QuoteField("ancestor")))
+	for i, node := range subtree {
+		if i > 0 {
+			s.WriteString(",")
+		}
+		s.WriteString(dialect.BindVar(len(args)))
+		args = append(args, node)
+	}
+	s.WriteString(")")
+	_, err = exec.Exec(s.String(), args...)
+	return err
+}
+
+// selectKeys runs a query returning a single column of keys of type
+// keyType.
+func selectKeys(exec SqlExecutor, keyType reflect.Type, query string, args ...interface{}) ([]interface{}, error) {
+	keys := reflect.New(reflect.SliceOf(keyType))
+	if _, err := exec.Select(keys.Interface(), query, args...); err != nil {
+		return nil, err
+	}
+	slice := keys.Elem()
+	values := make([]interface{}, slice.Len())
+	for i := range values {
+		values[i] = slice.Index(i).Interface()
+	}
+	return values, nil
+}
+
+// writeClosure updates this table's closure table after op was run
+// for elem, if it has one.
+func (t *TableMap) writeClosure(exec SqlExecutor, op writeOp, elem reflect.Value) error {
+	if t.closure == nil {
+		return nil
+	}
+	return t.closure.write(t, exec, op, elem)
+}
+
+// closureQuery runs a tree query using the table's closure table.
+func (tree *treePlan) closureQuery() ([]interface{}, error) {
+	dialect := tree.table.dbmap.Dialect
+	quotedTable := dialect.QuotedTableForQuery(tree.table.SchemaName, tree.table.TableName)
+	quotedKey := quotedTable + "." + dialect.QuoteField(tree.table.keys[0].ColumnName)
+	closure := tree.table.closure.quotedName(dialect)
+
+	where, args, err := tree.start.Where(tree.plan.colMap, dialect, 0)
+	if err != nil {
+		return nil, err
+	}
+	join, match := "descendant", "ancestor"
+	if tree.up {
+		join, match = "ancestor", "descendant"
+	}
+
+	s := bytes.Buffer{}
+	s.WriteString("select ")
+	x := 0
+	for _, col := range tree.table.columns {
+		if col.inSchema() {
+			if x > 0 {
+				s.WriteString(",")
+			}
+			s.WriteString(quotedTable + "." + dialect.QuoteField(col.ColumnName))
+			x++
+		}
+	}
+	s.WriteString(" from " + quotedTable + " inner join " + closure + " gorp_closure on " + quotedKey + " = gorp_closure." + dialect.QuoteField(join))
+	s.WriteString(" where gorp_closure." + dialect.QuoteField(match) + " in (select " + quotedKey + " from " + quotedTable)
+	if where != "" {
+		s.WriteString(" where " + where)
+	}
+	s.WriteString(") and gorp_closure." + dialect.QuoteField("depth") + " > 0")
+	if tree.depth > 0 {
+		s.WriteString(" and gorp_closure." + dialect.QuoteField("depth") + " <= " + dialect.BindVar(len(args)))
+		args = append(args, tree.depth)
+	}
+	s.WriteString(" order by gorp_closure." + dialect.QuoteField("depth"))
+	return tree.exec.Select(reflect.New(tree.table.gotype).Interface(), s.String(), args...)
+}
@@ -0,0 +1,139 @@
+package gorp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"io"
+	"reflect"
+)
+
+// A FieldTargeter is a type that can return pointers to its own
+// fields without using reflection.  If a mapped type implements
+// FieldTargeter, gorp will use it to get scan targets when loading
+// rows, and fall back to reflection if it returns false.
+//
+// Implementations are normally generated using
+// DbMap.GenerateFieldAccessors.
+type FieldTargeter interface {
+	// FieldTargets should set targets[i] to a pointer to the field
+	// named fields[i] for each field, returning false if any of the
+	// field names are unknown.
+	FieldTargets(fields []string, targets []interface{}) bool
+}
+
+// A FieldValuer is a type that can return the values of its own
+// fields without using reflection.  If a mapped type implements
+// FieldValuer, gorp will use it to get bind values when writing
+// rows, and fall back to reflection if it returns false.
+//
+// Implementations are normally generated using
+// DbMap.GenerateFieldAccessors.
+type FieldValuer interface {
+	// FieldValue should return the value of the field named field,
+	// and false if the field name is unknown.
+	FieldValue(field string) (interface{}, bool)
+}
+
+var fieldTargeterType = reflect.TypeOf((*FieldTargeter)(nil)).Elem()
+
+// GenerateFieldAccessors writes Go source code for package pkg to w,
+// containing FieldTargeter and FieldValuer implementations for the
+// passed in models.  If no models are passed in, code will be
+// generated for every table registered with this DbMap.  All of the
+// models must be registered with this DbMap and defined in pkg.
+//
+// This is meant to be run from a small program called by go
+// generate, e.g.
+//
+//     dbmap.AddTable(Invoice{}).SetKeys(true, "Id")
+//     f, _ := os.Create("invoice_gorp.go")
+//     defer f.Close()
+//     err := dbmap.GenerateFieldAccessors(f, "models")
+//
+// The generated code has to be regenerated whenever a model's fields
+// change.
+func (m *DbMap) GenerateFieldAccessors(w io.Writer, pkg string, models ...interface{}) error {
+	tables := m.tables
+	if len(models) > 0 {
+		tables = make([]*TableMap, 0, len(models))
+		for _, model := range models {
+			t, err := toType(model)
+			if err != nil {
+				return err
+			}
+			table, err := m.tableFor(t, false)
+			if err != nil {
+				return err
+			}
+			tables = append(tables, table)
+		}
+	}
+	if len(tables) == 0 {
+		return errors.New("gorp: GenerateFieldAccessors: no tables to generate code for")
+	}
+
+	buffer := bytes.Buffer{}
+	buffer.WriteString("// Code generated by gorp; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buffer, "package %s\n", pkg)
+	for _, table := range tables {
+		writeFieldAccessors(&buffer, table)
+	}
+	source, err := format.Source(buffer.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(source)
+	return err
+}
+
+func writeFieldAccessors(buffer *bytes.Buffer, table *TableMap) {
+	typeName := table.gotype.Name()
+
+	fmt.Fprintf(buffer, "\n// FieldTargets implements gorp.FieldTargeter.\n")
+	fmt.Fprintf(buffer, "func (m *%s) FieldTargets(fields []string, targets []interface{}) bool {\n", typeName)
+	buffer.WriteString("for i, field := range fields {\nswitch field {\n")
+	for _, col := range table.columns {
+		if col.Transient {
+			continue
+		}
+		fmt.Fprintf(buffer, "case %q:\ntargets[i] = &m.%s\n", col.fieldName, col.fieldName)
+	}
+	buffer.WriteString("default:\nreturn false\n}\n}\nreturn true\n}\n")
+
+	fmt.Fprintf(buffer, "\n// FieldValue implements gorp.FieldValuer.\n")
+	fmt.Fprintf(buffer, "func (m *%s) FieldValue(field string) (interface{}, bool) {\n", typeName)
+	buffer.WriteString("switch field {\n")
+	for _, col := range table.columns {
+		if col.Transient {
+			continue
+		}
+		fmt.Fprintf(buffer, "case %q:\nreturn m.%s, true\n", col.fieldName, col.fieldName)
+	}
+	buffer.WriteString("}\nreturn nil, false\n}\n")
+}
+
+// fieldTargets fills targets with pointers to the named fields of
+// elem, using FieldTargeter if elem implements it.
+func fieldTargets(elem reflect.Value, fields []string, targets []interface{}) {
+	if targeter, ok := elem.Addr().Interface().(FieldTargeter); ok && targeter.FieldTargets(fields, targets) {
+		return
+	}
+	for x, fieldName := range fields {
+		targets[x] = elem.FieldByName(fieldName).Addr().Interface()
+	}
+}
+
+// fieldValue returns the value of the named field of elem, using
+// FieldValuer if elem implements it.
+func fieldValue(elem reflect.Value, field string) interface{} {
+	if elem.CanAddr() {
+		if valuer, ok := elem.Addr().Interface().(FieldValuer); ok {
+			if val, ok := valuer.FieldValue(field); ok {
+				return val
+			}
+		}
+	}
+	return elem.FieldByName(field).Interface()
+}
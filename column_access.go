@@ -0,0 +1,166 @@
+package gorp
+
+import (
+	"context"
+	"fmt"
+)
+
+// SetReadAccess restricts who may read this column.  allowed is called
+// with the context of each query plan (see DbMap.QueryContext) that
+// selects from this column's table; if it returns false, the column is
+// left out of the select statement and its field is left as its zero
+// value, and filtering, ordering, or grouping by the column fails with
+// an error, since matching rows would reveal its values, as does
+// assigning an expression that uses it, which would copy its values to
+// a column that can be read.  Primary key columns are
+// always selected.  Pass nil to remove the restriction.
+//
+//     table.ColMap("Salary").SetReadAccess(func(ctx context.Context) bool {
+//         return userFromContext(ctx).IsManager
+//     })
+//
+// Restrictions only apply to query plans; Get() and raw SQL queries
+// are not checked.
+func (c *ColumnMap) SetReadAccess(allowed func(ctx context.Context) bool) *ColumnMap {
+	c.readAccess = allowed
+	return c
+}
+
+// SetWriteAccess restricts who may write this column.  allowed is
+// called with the context of each query plan that assigns to this
+// column; if it returns false, the plan fails with an error.  Pass nil
+// to remove the restriction.  Insert() and Update() on the DbMap are
+// not checked.
+func (c *ColumnMap) SetWriteAccess(allowed func(ctx context.Context) bool) *ColumnMap {
+	c.writeAccess = allowed
+	return c
+}
+
+// readable returns true if col may be selected by this plan.
+func (plan *QueryPlan) readable(col *ColumnMap) bool {
+	return col.isPK || col.readAccess == nil || col.readAccess(plan.ctx)
+}
+
+// A readAccessError is returned when a plan refers to a column that it
+// may not read.
+type readAccessError struct {
+	col   *ColumnMap
+	table string
+}
+
+func (e readAccessError) Error() string {
+	return fmt.Sprintf("gorp: Not allowed to read column %s of table %s", e.col.ColumnName, e.table)
+}
+
+// readableColumns is a ColumnResolver that returns a readAccessError
+// for the columns that its plan may not read.
+type readableColumns struct {
+	structColumnMap
+	plan *QueryPlan
+
+	// unmasked makes it return an error for the columns that are
+	// masked for the plan's role too.
+	unmasked bool
+}
+
+// readableColumns returns a resolver for the columns this plan may
+// read, or the plan's column map if every column is readable.
+func (plan *QueryPlan) readableColumns() ColumnResolver {
+	for _, fieldMap := range plan.colMap {
+		if fieldMap.column != nil && fieldMap.column.readAccess != nil {
+			return readableColumns{structColumnMap: plan.colMap, plan: plan}
+		}
+	}
+	return plan.colMap
+}
+
+// expressionColumns returns a resolver for the columns this plan may
+// use in the expressions it selects: the columns it may read whose
+// values aren't masked for its role, since the results of expressions
+// on masked columns would reveal their values.  It returns the plan's
+// column map if every column may be used.
+func (plan *QueryPlan) expressionColumns() ColumnResolver {
+	role := RoleFromContext(plan.ctx)
+	for _, fieldMap := range plan.colMap {
+		if fieldMap.column != nil && (fieldMap.column.readAccess != nil || fieldMap.column.masked(role)) {
+			return readableColumns{structColumnMap: plan.colMap, plan: plan, unmasked: true}
+		}
+	}
+	return plan.colMap
+}
+
+func (cols readableColumns) fieldMapForPointer(fieldPtr interface{}) (*fieldColumnMap, error) {
+	fieldMap, err := cols.structColumnMap.fieldMapForPointer(fieldPtr)
+	if err != nil {
+		return nil, err
+	}
+	table := cols.plan.table
+	if fieldMap.column.table != nil {
+		table = fieldMap.column.table
+	}
+	if !cols.plan.readable(fieldMap.column) {
+		return nil, readAccessError{col: fieldMap.column, table: table.TableName}
+	}
+	if cols.unmasked && fieldMap.column.masked(RoleFromContext(cols.plan.ctx)) {
+		return nil, fmt.Errorf("gorp: Masked column %s of table %s cannot be used in a selected expression", fieldMap.column.ColumnName, table.TableName)
+	}
+	return fieldMap, nil
+}
+
+func (cols readableColumns) Column(fieldPtr interface{}) (string, error) {
+	return cols.tableColumnForPointer(fieldPtr)
+}
+
+func (cols readableColumns) ColumnMap(fieldPtr interface{}) (*ColumnMap, error) {
+	fieldMap, err := cols.fieldMapForPointer(fieldPtr)
+	if err != nil {
+		return nil, err
+	}
+	return fieldMap.column, nil
+}
+
+func (cols readableColumns) columnForPointer(fieldPtr interface{}) (string, error) {
+	fieldMap, err := cols.fieldMapForPointer(fieldPtr)
+	if err != nil {
+		return "", err
+	}
+	return fieldMap.quotedColumn, nil
+}
+
+func (cols readableColumns) tableColumnForPointer(fieldPtr interface{}) (string, error) {
+	fieldMap, err := cols.fieldMapForPointer(fieldPtr)
+	if err != nil {
+		return "", err
+	}
+	return fieldMap.quotedTable + "." + fieldMap.quotedColumn, nil
+}
+
+// checkReadable adds an error to the plan if any of filters refers to
+// a column that the plan may not read.
+func (plan *QueryPlan) checkReadable(filters []Filter) {
+	cols, ok := plan.readableColumns().(readableColumns)
+	if !ok {
+		return
+	}
+	for _, filter := range filters {
+		if _, _, err := filter.Where(cols, plan.table.dbmap.Dialect, 0); err != nil {
+			if _, denied := err.(readAccessError); denied {
+				plan.Errors = append(plan.Errors, err)
+			}
+		}
+	}
+}
+
+// checkWritable adds an error to the plan if col may not be assigned
+// by this plan, returning false.
+func (plan *QueryPlan) checkWritable(col *ColumnMap) bool {
+	if col == plan.table.tenant {
+		plan.Errors = append(plan.Errors, fmt.Errorf("gorp: Column %s of table %s holds the tenant, which is assigned automatically", col.ColumnName, plan.table.TableName))
+		return false
+	}
+	if col.writeAccess == nil || col.writeAccess(plan.ctx) {
+		return true
+	}
+	plan.Errors = append(plan.Errors, fmt.Errorf("gorp: Not allowed to write column %s of table %s", col.ColumnName, plan.table.TableName))
+	return false
+}
@@ -0,0 +1,64 @@
+package gorp
+
+import (
+	"fmt"
+)
+
+// MapResultColumn selects column, a column of the plan's table or view
+// that has a different name than the column mapped to fieldPtr, into
+// the field that fieldPtr points to.  This lets rows from views, and
+// from tables whose columns were renamed in the Go code but not (yet)
+// in the database, be loaded into current structs:
+//
+//     inv := new(Invoice)
+//     invoices, err := dbmap.Query(inv).
+//         Where().
+//         Equal(&inv.PersonId, id).
+//         MapResultColumn("memo_text", &inv.Memo).
+//         Select()
+//
+// Filters on the field refer to column as well, as do orderings added
+// after MapResultColumn.  Plans with mapped result columns don't use
+// the table's statement cache.
+func (plan *QueryPlan) MapResultColumn(column string, fieldPtr interface{}) SelectQuery {
+	for i := range plan.colMap {
+		fieldMap := &plan.colMap[i]
+		if fieldMap.addr != fieldPtr {
+			continue
+		}
+		if fieldMap.column.Transient || !plan.ownsColumn(fieldMap.column) {
+			plan.Errors = append(plan.Errors, fmt.Errorf("gorp: MapResultColumn: field %s is not a column of table %s", fieldMap.column.fieldName, plan.table.TableName))
+			return plan
+		}
+		if plan.resultColumns == nil {
+			plan.resultColumns = make(map[*ColumnMap]string)
+		}
+		plan.resultColumns[fieldMap.column] = column
+		fieldMap.quotedColumn = plan.table.dbmap.Dialect.QuoteField(column)
+		return plan
+	}
+	plan.Errors = append(plan.Errors, fmt.Errorf("gorp: MapResultColumn: no field found for field pointer of type %T", fieldPtr))
+	return plan
+}
+
+// ownsColumn returns true if col is one of the columns of the plan's
+// table, rather than of a joined table.
+func (plan *QueryPlan) ownsColumn(col *ColumnMap) bool {
+	for _, tableCol := range plan.table.columns {
+		if tableCol == col {
+			return true
+		}
+	}
+	return false
+}
+
+// selectColumn returns the select list entry for col, a column of the
+// plan's table, which is aliased to col's name if the plan maps a
+// different result column to it.
+func (plan *QueryPlan) selectColumn(quotedTable string, col *ColumnMap) string {
+	dialect := plan.table.dbmap.Dialect
+	if column, ok := plan.resultColumns[col]; ok {
+		return quotedTable + "." + dialect.QuoteField(column) + " as " + dialect.QuoteField(col.ColumnName)
+	}
+	return quotedTable + "." + dialect.QuoteField(col.ColumnName)
+}
@@ -0,0 +1,109 @@
+package gorp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ColumnGroup defines a named group of columns, which can be used to
+// load only some of a table's columns (see QueryPlan.OnlyGroups) and
+// load the rest on demand (see DbMap.LoadGroup).  This is useful for
+// tables with large text or blob columns that are rarely needed.
+//
+// Calling ColumnGroup again with the same name replaces the group.
+// Panics if any of the fields can't be found.
+func (t *TableMap) ColumnGroup(name string, fieldNames ...string) *TableMap {
+	if t.columnGroups == nil {
+		t.columnGroups = make(map[string][]*ColumnMap)
+	}
+	cols := make([]*ColumnMap, 0, len(fieldNames))
+	for _, fieldName := range fieldNames {
+		cols = append(cols, t.ColMap(fieldName))
+	}
+	t.columnGroups[name] = cols
+	return t
+}
+
+// LoadGroup loads the columns in the named column group into obj,
+// which must be a pointer to a struct whose primary key fields are
+// set.  Use it to fill in columns that were skipped by
+// QueryPlan.OnlyGroups.
+//
+// Returns sql.ErrNoRows if the row no longer exists.
+func (m *DbMap) LoadGroup(obj interface{}, group string) error {
+	return loadGroup(m, m, obj, group)
+}
+
+// LoadGroup has the same behavior as DbMap.LoadGroup(), but runs in
+// a transaction.
+func (t *Transaction) LoadGroup(obj interface{}, group string) error {
+	return loadGroup(t.dbmap, t, obj, group)
+}
+
+func loadGroup(m *DbMap, exec SqlExecutor, obj interface{}, group string) error {
+	table, elem, err := m.tableForPointer(obj, true)
+	if err != nil {
+		return err
+	}
+	cols, ok := table.columnGroups[group]
+	if !ok {
+		return fmt.Errorf("gorp: No column group %s in table %s", group, table.TableName)
+	}
+
+	s := bytes.Buffer{}
+	s.WriteString("select ")
+	fields := make([]string, 0, len(cols))
+	for _, col := range cols {
+		if !col.inSchema() {
+			continue
+		}
+		if len(fields) > 0 {
+			s.WriteString(",")
+		}
+		s.WriteString(m.Dialect.QuoteField(col.ColumnName))
+		fields = append(fields, col.fieldName)
+	}
+	if len(fields) == 0 {
+		return errors.New("gorp: LoadGroup: no columns to load for group " + group)
+	}
+	s.WriteString(" from ")
+	s.WriteString(m.Dialect.QuotedTableForQuery(table.SchemaName, table.TableName))
+	s.WriteString(" where ")
+	keys := make([]interface{}, 0, len(table.keys))
+	for x, col := range table.keys {
+		if x > 0 {
+			s.WriteString(" and ")
+		}
+		s.WriteString(m.Dialect.QuoteField(col.ColumnName))
+		s.WriteString("=")
+		s.WriteString(m.Dialect.BindVar(x))
+		keys = append(keys, fieldValue(elem, col.fieldName))
+	}
+
+	dest := make([]interface{}, len(fields))
+	fieldTargets(elem, fields, dest)
+	custScan := make([]CustomScanner, 0)
+	if m.TypeConverter != nil {
+		for x, target := range dest {
+			if scanner, ok := m.TypeConverter.FromDb(target); ok {
+				dest[x] = scanner.Holder
+				custScan = append(custScan, scanner)
+			}
+		}
+		for x, key := range keys {
+			if keys[x], err = m.TypeConverter.ToDb(key); err != nil {
+				return err
+			}
+		}
+	}
+	if err = exec.queryRow(s.String(), keys...).Scan(dest...); err != nil {
+		return err
+	}
+	for _, c := range custScan {
+		if err = c.Bind(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
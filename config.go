@@ -0,0 +1,85 @@
+package gorp
+
+import (
+	"time"
+)
+
+// RuntimeConfig holds the settings of a DbMap that can be changed
+// while it is in use, e.g. from a configuration file that is watched
+// for changes.  Use DbMap.SetRuntimeConfig to replace them all at
+// once; query plans and statements that are already running keep
+// the settings they started with.
+type RuntimeConfig struct {
+	// RequireWhere makes query plans reject updates and deletes
+	// without filters or joins (see RequireWhereForMutations).
+	RequireWhere bool
+
+	// DefaultLimit is the limit of the select statements run by the
+	// Select and SelectToTarget methods (and shown by SQL) of query
+	// plans that don't set one (using Limit or Page), or 0 for no
+	// limit.  It isn't applied to derived tables (see QueryFrom), or
+	// to the statements of other methods, such as SelectEach and
+	// Preload, which would otherwise silently drop rows.
+	DefaultLimit int64
+
+	// SlowQueryThreshold is the duration after which a statement is
+	// reported to OnSlowQuery, with its arguments scrubbed (see
+	// SetArgScrubber).  Statements are timed until the database
+	// returns, so the time spent reading rows isn't included.
+	SlowQueryThreshold time.Duration
+	OnSlowQuery        func(query string, args []interface{}, elapsed time.Duration)
+
+	// MemoSampleRate and OnMemoMismatch configure the verification
+	// of memoized selects (see VerifyMemos).
+	MemoSampleRate float64
+	OnMemoMismatch MemoMismatchFunc
+}
+
+// SetRuntimeConfig atomically replaces this DbMap's runtime settings
+// with config, without recreating the DbMap:
+//
+//     config := dbmap.RuntimeConfig()
+//     config.SlowQueryThreshold = 500 * time.Millisecond
+//     dbmap.SetRuntimeConfig(config)
+//
+// It is safe to call SetRuntimeConfig while the DbMap is in use.
+func (m *DbMap) SetRuntimeConfig(config RuntimeConfig) {
+	m.config.Store(&config)
+}
+
+// RuntimeConfig returns a copy of this DbMap's current runtime
+// settings.
+func (m *DbMap) RuntimeConfig() RuntimeConfig {
+	return *m.runtimeConfig()
+}
+
+// runtimeConfig returns the DbMap's current runtime settings, which
+// must not be modified.
+func (m *DbMap) runtimeConfig() *RuntimeConfig {
+	if config, ok := m.config.Load().(*RuntimeConfig); ok {
+		return config
+	}
+	return &RuntimeConfig{}
+}
+
+// updateRuntimeConfig replaces the DbMap's runtime settings with a
+// copy changed by update.
+func (m *DbMap) updateRuntimeConfig(update func(config *RuntimeConfig)) {
+	m.configMu.Lock()
+	defer m.configMu.Unlock()
+	config := m.RuntimeConfig()
+	update(&config)
+	m.SetRuntimeConfig(config)
+}
+
+// traceSlow reports query to the slow query hook if it has been
+// running since start for longer than the slow query threshold.
+func (m *DbMap) traceSlow(start time.Time, query string, args []interface{}) {
+	config := m.runtimeConfig()
+	if config.OnSlowQuery == nil || config.SlowQueryThreshold <= 0 {
+		return
+	}
+	if elapsed := time.Since(start); elapsed >= config.SlowQueryThreshold {
+		config.OnSlowQuery(query, m.scrubArgs(query, args), elapsed)
+	}
+}
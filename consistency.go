@@ -0,0 +1,184 @@
+package gorp
+
+import (
+	"database/sql"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Consistency is the read consistency that a query plan's select
+// statements require.  See QueryPlan.Consistency.
+type Consistency int
+
+const (
+	// Strong reads see every write that was committed before the
+	// statement started.  This is the default.
+	Strong Consistency = iota
+
+	// Eventual reads may return stale rows, in exchange for being
+	// served by a replica (see DbMap.SetReplicas) or, on databases
+	// whose dialect implements StaleReader, by the nearest copy of
+	// the data.
+	Eventual
+)
+
+// String returns the name of the consistency level.
+func (c Consistency) String() string {
+	switch c {
+	case Strong:
+		return "strong"
+	case Eventual:
+		return "eventual"
+	}
+	return "unknown"
+}
+
+// errReplicaWrite is returned by writes sent to a replica.
+var errReplicaWrite = errors.New("gorp: Cannot write to a replica; use Consistency(gorp.Strong) for plans that write")
+
+// SetReplicas sets the read replicas that serve query plans run with
+// Eventual consistency, in turn.  Replicas are only used by plans
+// created from the DbMap, not from a Transaction, and only for reads.
+// Like AddTable, SetReplicas must be called before the DbMap is used.
+// Passing no replicas sends all reads to Db.
+func (m *DbMap) SetReplicas(replicas ...*sql.DB) *DbMap {
+	m.replicas = replicas
+	return m
+}
+
+// replica returns an executor for the next replica, or nil if the
+// DbMap has none.
+func (m *DbMap) replica() *replicaExecutor {
+	if len(m.replicas) == 0 {
+		return nil
+	}
+	next := atomic.AddUint32(&m.replicaNext, 1)
+	return &replicaExecutor{dbmap: m, db: m.replicas[int(next)%len(m.replicas)]}
+}
+
+// Consistency sets the read consistency that the plan requires, so
+// callers choose how fresh their reads must be instead of choosing
+// executors:
+//
+//     plan.Where().Equal(&inv.PersonId, id).Consistency(gorp.Eventual).Select()
+//
+// Plans created from a DbMap with Eventual consistency are run on one
+// of the DbMap's replicas, if it has any; plans created from a
+// Transaction always read from the transaction.  If the dialect
+// implements StaleReader, Eventual select statements also include its
+// stale read clause, e.g. CockroachDB's follower reads.  Plans with
+// Eventual consistency can't write.
+func (plan *QueryPlan) Consistency(c Consistency) SelectQuery {
+	plan.consistency = c
+	if hedged, ok := plan.executor.(*hedgedExecutor); ok {
+		hedged.first = routeReads(hedged.first, c)
+	} else {
+		plan.executor = routeReads(plan.executor, c)
+	}
+	return plan
+}
+
+// routeReads returns the executor that reads with consistency c
+// should use instead of exec.
+func routeReads(exec SqlExecutor, c Consistency) SqlExecutor {
+	if executor, ok := exec.(*contextExecutor); ok {
+		return withContext(executor.ctx, executor.dbmap, routeReads(executor.SqlExecutor, c))
+	}
+	switch executor := exec.(type) {
+	case *DbMap:
+		if c == Eventual {
+			if replica := executor.replica(); replica != nil {
+				return replica
+			}
+		}
+	case *replicaExecutor:
+		if c == Strong {
+			return executor.dbmap
+		}
+	}
+	return exec
+}
+
+// staleReadClause returns the dialect's stale read clause if this
+// plan's reads may be stale.
+func (plan *QueryPlan) staleReadClause() string {
+	if plan.consistency != Eventual || plan.asOf != nil {
+		return ""
+	}
+	if reader, ok := plan.table.dbmap.Dialect.(StaleReader); ok {
+		return reader.StaleRead()
+	}
+	return ""
+}
+
+// A replicaExecutor is a read-only SqlExecutor for one of a DbMap's
+// replicas.
+type replicaExecutor struct {
+	dbmap *DbMap
+	db    *sql.DB
+}
+
+func (r *replicaExecutor) Get(i interface{}, keys ...interface{}) (interface{}, error) {
+	return get(r.dbmap, r, i, keys...)
+}
+
+func (r *replicaExecutor) Insert(list ...interface{}) error {
+	return errReplicaWrite
+}
+
+func (r *replicaExecutor) Update(list ...interface{}) (int64, error) {
+	return 0, errReplicaWrite
+}
+
+func (r *replicaExecutor) Delete(list ...interface{}) (int64, error) {
+	return 0, errReplicaWrite
+}
+
+func (r *replicaExecutor) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return nil, errReplicaWrite
+}
+
+func (r *replicaExecutor) Select(i interface{}, query string, args ...interface{}) ([]interface{}, error) {
+	return hookedselect(r.dbmap, r, i, query, args...)
+}
+
+func (r *replicaExecutor) SelectInt(query string, args ...interface{}) (int64, error) {
+	return SelectInt(r, query, args...)
+}
+
+func (r *replicaExecutor) SelectNullInt(query string, args ...interface{}) (sql.NullInt64, error) {
+	return SelectNullInt(r, query, args...)
+}
+
+func (r *replicaExecutor) SelectFloat(query string, args ...interface{}) (float64, error) {
+	return SelectFloat(r, query, args...)
+}
+
+func (r *replicaExecutor) SelectNullFloat(query string, args ...interface{}) (sql.NullFloat64, error) {
+	return SelectNullFloat(r, query, args...)
+}
+
+func (r *replicaExecutor) SelectStr(query string, args ...interface{}) (string, error) {
+	return SelectStr(r, query, args...)
+}
+
+func (r *replicaExecutor) SelectNullStr(query string, args ...interface{}) (sql.NullString, error) {
+	return SelectNullStr(r, query, args...)
+}
+
+func (r *replicaExecutor) SelectOne(holder interface{}, query string, args ...interface{}) error {
+	return SelectOne(r.dbmap, r, holder, query, args...)
+}
+
+func (r *replicaExecutor) queryRow(query string, args ...interface{}) *sql.Row {
+	r.dbmap.trace(query, args...)
+	defer r.dbmap.traceSlow(time.Now(), query, args)
+	return r.db.QueryRow(query, args...)
+}
+
+func (r *replicaExecutor) query(query string, args ...interface{}) (*sql.Rows, error) {
+	r.dbmap.trace(query, args...)
+	defer r.dbmap.traceSlow(time.Now(), query, args)
+	return r.db.Query(query, args...)
+}
@@ -0,0 +1,174 @@
+package gorp
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// A contextExecutor is a SqlExecutor that runs its statements with the
+// context of the query plan that created it (see DbMap.QueryContext),
+// using the Context methods of database/sql, so that canceling the
+// context (or reaching its deadline) cancels the plan's statements.
+// Statements run by hooks that are passed the executor use the context
+// too.
+type contextExecutor struct {
+	SqlExecutor
+	dbmap *DbMap
+	ctx   context.Context
+}
+
+// withContext returns an executor that runs exec's statements with
+// ctx, or exec itself if ctx can never be canceled.
+func withContext(ctx context.Context, m *DbMap, exec SqlExecutor) SqlExecutor {
+	if ctx == nil || ctx.Done() == nil {
+		return exec
+	}
+	if c, ok := exec.(*contextExecutor); ok {
+		exec = c.SqlExecutor
+	}
+	return &contextExecutor{SqlExecutor: exec, dbmap: m, ctx: ctx}
+}
+
+// withoutContext returns the executor that exec runs its statements
+// on, without a context.
+func withoutContext(exec SqlExecutor) SqlExecutor {
+	if c, ok := exec.(*contextExecutor); ok {
+		return c.SqlExecutor
+	}
+	return exec
+}
+
+// executorContext returns the context that exec runs its statements
+// with, or the background context if it has none.
+func executorContext(exec SqlExecutor) context.Context {
+	if c, ok := exec.(*contextExecutor); ok {
+		return c.ctx
+	}
+	return context.Background()
+}
+
+// beginFor starts a transaction for exec if it runs statements outside
+// of one, i.e. if it is a DbMap with or without a context.  It returns
+// the transaction and the executor that runs statements in it, with
+// exec's context, or a nil transaction if exec is already in one.
+func beginFor(exec SqlExecutor) (*Transaction, SqlExecutor, error) {
+	m, ok := withoutContext(exec).(*DbMap)
+	if !ok {
+		return nil, exec, nil
+	}
+	c, ok := exec.(*contextExecutor)
+	if !ok {
+		tx, err := m.Begin()
+		if err != nil {
+			return nil, nil, err
+		}
+		return tx, tx, nil
+	}
+	m.trace("begin;")
+	sqlTx, err := m.Db.BeginTx(c.ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	tx := &Transaction{dbmap: m, tx: sqlTx}
+	return tx, &contextExecutor{SqlExecutor: tx, dbmap: m, ctx: c.ctx}, nil
+}
+
+func (c *contextExecutor) query(query string, args ...interface{}) (*sql.Rows, error) {
+	c.dbmap.trace(query, args...)
+	defer c.dbmap.traceSlow(time.Now(), query, args)
+	switch exec := c.SqlExecutor.(type) {
+	case *DbMap:
+		return exec.Db.QueryContext(c.ctx, query, args...)
+	case *Transaction:
+		return exec.tx.QueryContext(c.ctx, query, args...)
+	case *replicaExecutor:
+		return exec.db.QueryContext(c.ctx, query, args...)
+	}
+	return c.SqlExecutor.query(query, args...)
+}
+
+func (c *contextExecutor) queryRow(query string, args ...interface{}) *sql.Row {
+	c.dbmap.trace(query, args...)
+	defer c.dbmap.traceSlow(time.Now(), query, args)
+	switch exec := c.SqlExecutor.(type) {
+	case *DbMap:
+		return exec.Db.QueryRowContext(c.ctx, query, args...)
+	case *Transaction:
+		return exec.tx.QueryRowContext(c.ctx, query, args...)
+	case *replicaExecutor:
+		return exec.db.QueryRowContext(c.ctx, query, args...)
+	}
+	return c.SqlExecutor.queryRow(query, args...)
+}
+
+func (c *contextExecutor) Exec(query string, args ...interface{}) (sql.Result, error) {
+	switch exec := c.SqlExecutor.(type) {
+	case *DbMap:
+		c.dbmap.trace(query, args...)
+		defer c.dbmap.traceSlow(time.Now(), query, args)
+		return exec.Db.ExecContext(c.ctx, query, args...)
+	case *Transaction:
+		c.dbmap.trace(query, args...)
+		defer c.dbmap.traceSlow(time.Now(), query, args)
+		return exec.tx.ExecContext(c.ctx, query, args...)
+	}
+	return c.SqlExecutor.Exec(query, args...)
+}
+
+func (c *contextExecutor) Get(i interface{}, keys ...interface{}) (interface{}, error) {
+	return get(c.dbmap, c, i, keys...)
+}
+
+func (c *contextExecutor) Insert(list ...interface{}) error {
+	if _, ok := c.SqlExecutor.(*replicaExecutor); ok {
+		return errReplicaWrite
+	}
+	return insert(c.dbmap, c, list...)
+}
+
+func (c *contextExecutor) Update(list ...interface{}) (int64, error) {
+	if _, ok := c.SqlExecutor.(*replicaExecutor); ok {
+		return 0, errReplicaWrite
+	}
+	return update(c.dbmap, c, list...)
+}
+
+func (c *contextExecutor) Delete(list ...interface{}) (int64, error) {
+	if _, ok := c.SqlExecutor.(*replicaExecutor); ok {
+		return 0, errReplicaWrite
+	}
+	return delete(c.dbmap, c, list...)
+}
+
+func (c *contextExecutor) Select(i interface{}, query string, args ...interface{}) ([]interface{}, error) {
+	return hookedselect(c.dbmap, c, i, query, args...)
+}
+
+func (c *contextExecutor) SelectInt(query string, args ...interface{}) (int64, error) {
+	return SelectInt(c, query, args...)
+}
+
+func (c *contextExecutor) SelectNullInt(query string, args ...interface{}) (sql.NullInt64, error) {
+	return SelectNullInt(c, query, args...)
+}
+
+func (c *contextExecutor) SelectFloat(query string, args ...interface{}) (float64, error) {
+	return SelectFloat(c, query, args...)
+}
+
+func (c *contextExecutor) SelectNullFloat(query string, args ...interface{}) (sql.NullFloat64, error) {
+	return SelectNullFloat(c, query, args...)
+}
+
+func (c *contextExecutor) SelectStr(query string, args ...interface{}) (string, error) {
+	return SelectStr(c, query, args...)
+}
+
+func (c *contextExecutor) SelectNullStr(query string, args ...interface{}) (sql.NullString, error) {
+	return SelectNullStr(c, query, args...)
+}
+
+func (c *contextExecutor) SelectOne(holder interface{}, query string, args ...interface{}) error {
+	return SelectOne(c.dbmap, c, holder, query, args...)
+}
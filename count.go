@@ -0,0 +1,49 @@
+package gorp
+
+// CountWhere returns the number of rows in model's table that match
+// all of the passed in filters.  Filters must reference fields of
+// model, the same way they would for a query plan:
+//
+//     inv := new(Invoice)
+//     count, err := dbmap.CountWhere(inv, gorp.Equal(&inv.IsPaid, false))
+//
+func (m *DbMap) CountWhere(model interface{}, filters ...Filter) (int64, error) {
+	return countWhere(m, m, model, filters...)
+}
+
+// ExistsWhere returns true if any rows in model's table match all of
+// the passed in filters.  See CountWhere.
+func (m *DbMap) ExistsWhere(model interface{}, filters ...Filter) (bool, error) {
+	return existsWhere(m, m, model, filters...)
+}
+
+// CountWhere has the same behavior as DbMap.CountWhere(), but runs in
+// a transaction.
+func (t *Transaction) CountWhere(model interface{}, filters ...Filter) (int64, error) {
+	return countWhere(t.dbmap, t, model, filters...)
+}
+
+// ExistsWhere has the same behavior as DbMap.ExistsWhere(), but runs
+// in a transaction.
+func (t *Transaction) ExistsWhere(model interface{}, filters ...Filter) (bool, error) {
+	return existsWhere(t.dbmap, t, model, filters...)
+}
+
+func countWhere(m *DbMap, exec SqlExecutor, model interface{}, filters ...Filter) (int64, error) {
+	plan := query(m, exec, model).Where(filters...).(*QueryPlan)
+	query, err := plan.countQuery()
+	if err != nil {
+		return 0, err
+	}
+	return SelectInt(exec, query, plan.args...)
+}
+
+func existsWhere(m *DbMap, exec SqlExecutor, model interface{}, filters ...Filter) (bool, error) {
+	plan := query(m, exec, model).Where(filters...).(*QueryPlan)
+	query, err := plan.existsQuery()
+	if err != nil {
+		return false, err
+	}
+	exists, err := SelectInt(exec, query, plan.args...)
+	return exists == 1, err
+}
@@ -0,0 +1,131 @@
+package gorp
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// A HasManyMap describes a one-to-many association between a parent
+// table and a child table, where the child table has a field holding
+// the primary key of its parent.  Create one with TableMap.HasMany,
+// or with ColumnMap.HasMany to also load the children into a field of
+// the parent (see QueryPlan.Preload).
+type HasManyMap struct {
+	parent     *TableMap
+	child      *TableMap
+	foreignKey *ColumnMap
+	counter    *ColumnMap
+	onDelete   DeleteAction
+
+	// field is the parent's field holding the children, for
+	// associations declared with ColumnMap.HasMany.  Their child and
+	// foreignKey are nil until the child's table is registered, and
+	// pending holds what waits for them until then.
+	field           *ColumnMap
+	foreignKeyField string
+	pending         []func()
+
+	denormalized []denormalizedColumn
+}
+
+// HasMany adds a one-to-many association from this table to child's
+// table, where foreignKeyField is the name of the field in child that
+// holds the primary key of a row in this table.  This table must have
+// a single primary key column, and child's table must already be
+// registered.  Panics if either table or the field can't be found.  If
+// the association was already declared on a field with
+// ColumnMap.HasMany, that association is returned.
+func (t *TableMap) HasMany(child interface{}, foreignKeyField string) *HasManyMap {
+	if len(t.keys) != 1 {
+		panic(fmt.Sprintf("gorp: HasMany: table %s must have exactly one primary key column", t.TableName))
+	}
+	childTable := t.dbmap.mustTableFor(child)
+	foreignKey := childTable.ColMap(foreignKeyField)
+	if assoc := t.hasManyFor(childTable, foreignKey); assoc != nil {
+		return assoc
+	}
+	assoc := &HasManyMap{
+		parent:     t,
+		child:      childTable,
+		foreignKey: foreignKey,
+	}
+	t.hasMany = append(t.hasMany, assoc)
+	return assoc
+}
+
+// hasManyFor returns the table's association with child through
+// foreignKey, or nil if there is none.
+func (t *TableMap) hasManyFor(child *TableMap, foreignKey *ColumnMap) *HasManyMap {
+	for _, assoc := range t.hasMany {
+		if assoc.child == child && assoc.foreignKey == foreignKey {
+			return assoc
+		}
+	}
+	return nil
+}
+
+// whenResolved runs f once the association's child table is known.
+func (h *HasManyMap) whenResolved(f func()) {
+	if h.child == nil {
+		h.pending = append(h.pending, f)
+		return
+	}
+	f()
+}
+
+// CountedBy keeps a count of each parent's children in the parent's
+// counterField.  Inserting a child through Insert() increments the
+// counter of the child's parent, and deleting a child through Delete()
+// decrements it, using a single atomic update:
+//
+//     dbmap.AddTable(Comment{}).SetKeys(true, "Id")
+//     dbmap.AddTable(Post{}).SetKeys(true, "Id").
+//         HasMany(Comment{}, "PostId").
+//         CountedBy("CommentCount")
+//
+// The counter is only updated in the database; parent structs that
+// have already been loaded are not changed.  Updates that change a
+// child's parent and writes made by query plans are not counted.
+func (h *HasManyMap) CountedBy(counterField string) *HasManyMap {
+	h.counter = h.parent.ColMap(counterField)
+	h.whenResolved(func() {
+		h.child.counters = append(h.child.counters, h)
+	})
+	return h
+}
+
+// writeCounter updates the parent's counter after op was run for
+// elem, a row in the child table.
+func (h *HasManyMap) writeCounter(exec SqlExecutor, op writeOp, elem reflect.Value) error {
+	var operator string
+	switch op {
+	case writeInsert:
+		operator = " + 1"
+	case writeDelete:
+		operator = " - 1"
+	default:
+		return nil
+	}
+	parents := distinctValues([]interface{}{elem.Addr().Interface()}, h.foreignKey.fieldName)
+	if len(parents) == 0 {
+		return nil
+	}
+	dialect := h.parent.dbmap.Dialect
+	counter := dialect.QuoteField(h.counter.ColumnName)
+	_, err := exec.Exec(fmt.Sprintf("update %s set %s = %s%s where %s = %s",
+		dialect.QuotedTableForQuery(h.parent.SchemaName, h.parent.TableName),
+		counter, counter, operator,
+		dialect.QuoteField(h.parent.keys[0].ColumnName), dialect.BindVar(0)), parents[0])
+	return err
+}
+
+// writeCounters updates the counter caches of this table's parents
+// after op was run for elem.
+func (t *TableMap) writeCounters(exec SqlExecutor, op writeOp, elem reflect.Value) error {
+	for _, counter := range t.counters {
+		if err := counter.writeCounter(exec, op, elem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package gorp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// ErrDeadline is returned by batch operations (DbMap.Import and
+// QueryPlan.DeleteBatches) that stop before a batch that wouldn't
+// finish before their context's deadline.  The batches before it
+// have been committed, and the operation can be resumed from the
+// position it returns.
+var ErrDeadline = errors.New("gorp: Stopped before the context's deadline; resume from the returned position")
+
+// A batchTimer times the batches of a batch operation, to decide
+// whether another batch fits before a context's deadline.
+type batchTimer struct {
+	start   time.Time
+	slowest time.Duration
+}
+
+// begin returns ErrDeadline if ctx's deadline is closer than the
+// slowest batch so far took, and starts timing the next batch
+// otherwise.  The first batch always starts, since there is nothing
+// to estimate its time from.
+func (b *batchTimer) begin(ctx context.Context) error {
+	now := time.Now()
+	if deadline, ok := ctx.Deadline(); ok && b.slowest > 0 && deadline.Sub(now) < b.slowest {
+		return ErrDeadline
+	}
+	b.start = now
+	return nil
+}
+
+// end records the time taken by the batch started by begin.
+func (b *batchTimer) end() {
+	if took := time.Since(b.start); took > b.slowest {
+		b.slowest = took
+	}
+}
+
+// DeleteBatches runs this query plan as a series of delete statements
+// that each delete up to size of the rows it matches, by primary key,
+// until none are left, and returns the number of rows deleted.  Each
+// statement is committed on its own (unless the plan was created from
+// a Transaction), so deleting a large number of rows doesn't hold
+// locks for long:
+//
+//     ctx, cancel := context.WithTimeout(ctx, time.Minute)
+//     defer cancel()
+//     n, err := dbmap.QueryContext(ctx, inv).Where().Less(&inv.Created, cutoff).DeleteBatches(1000)
+//     if err == gorp.ErrDeadline {
+//         // n rows were deleted; run the plan again to delete the rest.
+//     }
+//
+// Before each batch, DeleteBatches checks the plan's context: if its
+// deadline is closer than the slowest batch so far took, it stops
+// with ErrDeadline instead of being canceled partway through a batch.
+// Since deleted rows no longer match the plan, running it again
+// resumes the deletion.  Tables with a soft-delete column (see
+// TableMap.SetSoftDelete) have their rows marked as deleted.  Hooks
+// and delete actions of associations are not run, and the table must
+// have exactly one primary key column.
+func (plan *QueryPlan) DeleteBatches(size int) (int64, error) {
+	if len(plan.Errors) > 0 {
+		return 0, plan.Errors[0]
+	}
+	if size < 1 {
+		return 0, errors.New("gorp: DeleteBatches requires a positive batch size")
+	}
+	if len(plan.table.keys) != 1 {
+		return 0, fmt.Errorf("gorp: DeleteBatches: table %s doesn't have exactly one primary key column", plan.table.TableName)
+	}
+	if err := plan.dbMap.checkMutable(); err != nil {
+		return 0, err
+	}
+	if err := plan.checkScoped(); err != nil {
+		return 0, err
+	}
+	key := plan.table.keys[0]
+	keyPtr, err := plan.colMap.pointerForColumn(key)
+	if err != nil {
+		return 0, err
+	}
+	plan.limit = int64(size)
+	var deleted int64
+	var timer batchTimer
+	for {
+		if err = plan.ctx.Err(); err != nil {
+			return deleted, err
+		}
+		if err = timer.begin(plan.ctx); err != nil {
+			return deleted, err
+		}
+		keys := reflect.New(reflect.SliceOf(key.gotype))
+		if err = plan.SelectColumn(keyPtr, keys.Interface()); err != nil {
+			return deleted, err
+		}
+		batch := keys.Elem()
+		if batch.Len() == 0 {
+			return deleted, nil
+		}
+		rows, err := plan.deleteKeys(batch)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += rows
+		timer.end()
+		if batch.Len() < size {
+			return deleted, nil
+		}
+	}
+}
+
+// deleteKeys deletes, or marks as deleted, the rows of the plan's
+// table with the primary keys in keys, a slice.
+func (plan *QueryPlan) deleteKeys(keys reflect.Value) (int64, error) {
+	dialect := plan.table.dbmap.Dialect
+	quotedTable := dialect.QuotedTableForQuery(plan.table.SchemaName, plan.table.TableName)
+	keyList, args := bindList(dialect, keys)
+	where := fmt.Sprintf(" where %s in %s", dialect.QuoteField(plan.table.keys[0].ColumnName), keyList)
+	var query string
+	if plan.softDeletes() {
+		args = append(args, plan.dbMap.now())
+		query = fmt.Sprintf("update %s set %s = %s%s", quotedTable, dialect.QuoteField(plan.table.deletedAt.ColumnName), dialect.BindVar(len(args)-1), where)
+	} else {
+		query = "delete from " + quotedTable + where
+	}
+	query += plan.commentClause()
+	if err := plan.table.throttleWrite(plan.ctx); err != nil {
+		return -1, err
+	}
+	res, err := plan.executor.Exec(query, args...)
+	plan.clearMemo()
+	if err != nil {
+		return -1, err
+	}
+	return res.RowsAffected()
+}
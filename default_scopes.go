@@ -0,0 +1,120 @@
+package gorp
+
+import (
+	"context"
+	"fmt"
+)
+
+// A DefaultScope returns a filter that every query plan on a table
+// includes (see TableMap.AddDefaultScope).  target is the plan's
+// target, to take field pointers from, and ctx is the plan's context.
+// It may return nil to filter nothing.
+type DefaultScope func(ctx context.Context, target interface{}) Filter
+
+// defaultScope is a default scope and its name.
+type defaultScope struct {
+	name  string
+	scope DefaultScope
+}
+
+// AddDefaultScope adds a filter, named name, to the where clauses of
+// the query plans on this table: selects (including counts and
+// existence checks), updates, and deletes.  Use QueryPlan.Unscoped to
+// leave some or all of the table's default scopes out of a plan.
+//
+//     table.AddDefaultScope("account", func(ctx context.Context, target interface{}) gorp.Filter {
+//         inv := target.(*Invoice)
+//         return gorp.Equal(&inv.AccountId, ctx.Value(accountKey))
+//     })
+//
+// Scopes are called each time a plan's statement is generated, with
+// the context passed to QueryContext (or context.Background).  They
+// only filter the plan's own table, not its joined tables, and don't
+// apply to Get(), Update(), Delete(), raw SQL, SweepExpired, or
+// Archive.
+func (t *TableMap) AddDefaultScope(name string, scope DefaultScope) *TableMap {
+	t.defaultScopes = append(t.defaultScopes, defaultScope{name: name, scope: scope})
+	t.ResetSql()
+	return t
+}
+
+// Unscoped leaves the named default scopes of the plan's table (see
+// TableMap.AddDefaultScope) out of the plan.  Without names, it leaves
+// every default scope out, and also includes soft-deleted rows like
+// WithDeleted.  Its tenant (see TableMap.SetTenantColumn) is never
+// left out.  To only see deleted rows, e.g. to restore one, use
+// WithDeleted, which keeps the default scopes.
+func (plan *QueryPlan) Unscoped(names ...string) Query {
+	if len(names) == 0 {
+		plan.unscoped = true
+		return plan
+	}
+	if plan.unscopedNames == nil {
+		plan.unscopedNames = make(map[string]bool, len(names))
+	}
+	for _, name := range names {
+		if !plan.table.hasDefaultScope(name) {
+			plan.Errors = append(plan.Errors, fmt.Errorf("gorp: Unscoped: table %s has no default scope named %q", plan.table.TableName, name))
+			continue
+		}
+		plan.unscopedNames[name] = true
+	}
+	return plan
+}
+
+// hasDefaultScope returns whether the table has a default scope named
+// name.
+func (t *TableMap) hasDefaultScope(name string) bool {
+	for _, scope := range t.defaultScopes {
+		if scope.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultScopes returns the default scopes of the plan's table that
+// the plan includes.
+func (plan *QueryPlan) defaultScopes() []DefaultScope {
+	if plan.unscoped {
+		return nil
+	}
+	var scopes []DefaultScope
+	for _, scope := range plan.table.defaultScopes {
+		if !plan.unscopedNames[scope.name] {
+			scopes = append(scopes, scope.scope)
+		}
+	}
+	return scopes
+}
+
+// whereFilter returns the filter of the plan's where clause: its
+// filters, its tenant's filter (see TableMap.SetTenantColumn), and the
+// default scopes of its table that the plan includes.
+func (plan *QueryPlan) whereFilter() Filter {
+	var scopes []Filter
+	if plan.tenancy != nil {
+		// Tenancy isn't a default scope, so Unscoped can't remove it.
+		scopes = append(scopes, plan.tenancy)
+	}
+	if defaults := plan.defaultScopes(); len(defaults) > 0 {
+		target := plan.target.Interface()
+		for _, scope := range defaults {
+			if filter := scope(plan.ctx, target); filter != nil {
+				scopes = append(scopes, filter)
+			}
+		}
+	}
+	if len(scopes) == 0 {
+		return plan.filters
+	}
+	plan.storeJoin()
+	scoped := new(andFilter)
+	if filters, ok := plan.filters.(*andFilter); ok {
+		scoped.Add(filters.subFilters...)
+	} else if plan.filters != nil {
+		scoped.Add(plan.filters)
+	}
+	scoped.Add(scopes...)
+	return scoped
+}
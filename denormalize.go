@@ -0,0 +1,83 @@
+package gorp
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// A denormalizedColumn is a column of a parent table that is copied
+// to a column of its children.
+type denormalizedColumn struct {
+	parentCol *ColumnMap
+	childCol  *ColumnMap
+}
+
+// Denormalize copies the parent's parentField to each child's
+// childField whenever a parent is updated through Update(), so that
+// children can be read without joining to their parent (e.g. a
+// customer's name stored on each of their invoices):
+//
+//     dbmap.AddTable(Customer{}).SetKeys(true, "Id").
+//         HasMany(Invoice{}, "CustomerId").
+//         Denormalize("Name", "CustomerName")
+//
+// All of an association's denormalized columns are copied using a
+// single update statement, joining the children to their parent if
+// the dialect implements UpdateJoiner.  Values are not copied when a
+// child is inserted; set the child's field before inserting it.
+func (h *HasManyMap) Denormalize(parentField, childField string) *HasManyMap {
+	parentCol := h.parent.ColMap(parentField)
+	h.whenResolved(func() {
+		if len(h.denormalized) == 0 {
+			h.parent.denormalizers = append(h.parent.denormalizers, h)
+		}
+		h.denormalized = append(h.denormalized, denormalizedColumn{
+			parentCol: parentCol,
+			childCol:  h.child.ColMap(childField),
+		})
+	})
+	return h
+}
+
+// denormalizeSql returns the statement that copies the denormalized
+// columns from a parent (whose key is bound to the first bind
+// variable) to its children.
+func (h *HasManyMap) denormalizeSql() string {
+	dialect := h.parent.dbmap.Dialect
+	parentTable := dialect.QuotedTableForQuery(h.parent.SchemaName, h.parent.TableName)
+	childTable := dialect.QuotedTableForQuery(h.child.SchemaName, h.child.TableName)
+	parentKey := parentTable + "." + dialect.QuoteField(h.parent.keys[0].ColumnName)
+	foreignKey := childTable + "." + dialect.QuoteField(h.foreignKey.ColumnName)
+
+	columns := make([]string, 0, len(h.denormalized))
+	values := make([]string, 0, len(h.denormalized))
+	for _, col := range h.denormalized {
+		columns = append(columns, dialect.QuoteField(col.childCol.ColumnName))
+		values = append(values, parentTable+"."+dialect.QuoteField(col.parentCol.ColumnName))
+	}
+
+	if joiner, ok := dialect.(UpdateJoiner); ok {
+		return joiner.UpdateJoin(childTable, parentTable, foreignKey+" = "+parentKey, columns, values, parentKey+" = "+dialect.BindVar(0))
+	}
+	assignments := make([]string, len(columns))
+	for i, column := range columns {
+		assignments[i] = fmt.Sprintf("%s = (select %s from %s where %s = %s)", column, values[i], parentTable, parentKey, foreignKey)
+	}
+	return fmt.Sprintf("update %s set %s where %s = %s", childTable, strings.Join(assignments, ", "), foreignKey, dialect.BindVar(0))
+}
+
+// writeDenormalized copies denormalized columns from elem, a row in
+// this table, to its children after it has been updated.
+func (t *TableMap) writeDenormalized(exec SqlExecutor, op writeOp, elem reflect.Value) error {
+	if op != writeUpdate {
+		return nil
+	}
+	for _, h := range t.denormalizers {
+		key := fieldValue(elem, t.keys[0].fieldName)
+		if _, err := exec.Exec(h.denormalizeSql(), key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package gorp
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// errDerivedWrite is returned by writes run by query plans created
+// with QueryFrom.
+var errDerivedWrite = errors.New("gorp: Cannot insert into, update, or delete from a derived table")
+
+// A derivedTable is a select query plan used as the from clause of
+// another plan.
+type derivedTable struct {
+	plan    *QueryPlan
+	columns []ResultColumn
+}
+
+// QueryFrom creates a select query plan for target, a pointer to a
+// struct that doesn't need to be mapped to a table, that selects from
+// the rows returned by sub instead of from a table.  This makes it
+// possible to build layered queries, e.g. to filter and aggregate the
+// results of another aggregate:
+//
+//     type PersonTotal struct {
+//         PersonId int64
+//         Total    int64
+//     }
+//
+//     inv := new(Invoice)
+//     totals := dbmap.Query(inv).Where().GroupBy(&inv.PersonId)
+//     total := new(PersonTotal)
+//     var big []PersonTotal
+//     err := dbmap.QueryFrom(totals, total,
+//         gorp.As("PersonId", &inv.PersonId),
+//         gorp.As("Total", gorp.Func("sum", &inv.Amount))).
+//         Where().
+//         Greater(&total.Total, 1000).
+//         SelectToTarget(&big)
+//
+// sub selects columns into target's fields, with the same aliases as
+// SelectResults.  If columns is empty, sub selects its table's
+// columns, and target's fields must match them.  sub's arguments are
+// bound when the plan is run, so later changes to sub are used as
+// well.  Columns of sub that are masked for the plan's role can't be
+// selected, and the plan can't be used for writes or with AsOf or
+// UseIndex.
+func (m *DbMap) QueryFrom(sub SelectQuery, target interface{}, columns ...ResultColumn) Query {
+	return queryFrom(m, m, sub, target, columns)
+}
+
+// QueryFrom has the same behavior as DbMap.QueryFrom, but runs in a
+// transaction.
+func (t *Transaction) QueryFrom(sub SelectQuery, target interface{}, columns ...ResultColumn) Query {
+	return queryFrom(t.dbmap, t, sub, target, columns)
+}
+
+func queryFrom(m *DbMap, exec SqlExecutor, sub SelectQuery, target interface{}, columns []ResultColumn) Query {
+	plan := &QueryPlan{dbMap: m, executor: exec}
+	planner, ok := sub.(queryPlanner)
+	if !ok {
+		plan.Errors = append(plan.Errors, fmt.Errorf("gorp: QueryFrom: cannot select from a query of type %T", sub))
+		return plan
+	}
+	plan.from = &derivedTable{plan: planner.queryPlan(), columns: columns}
+	plan.ctx = plan.from.plan.ctx
+
+	targetVal := reflect.ValueOf(target)
+	if targetVal.Kind() != reflect.Ptr || targetVal.Elem().Kind() != reflect.Struct {
+		plan.Errors = append(plan.Errors, errors.New("gorp: Cannot create query plan - target value must be a pointer to a struct"))
+		return plan
+	}
+	t := targetVal.Type().Elem()
+	table := &TableMap{gotype: t, TableName: t.Name(), dbmap: m}
+	table.columns, table.version = readStructColumns(t)
+	if err := plan.mapColumns(table, targetVal); err != nil {
+		plan.Errors = append(plan.Errors, err)
+		return plan
+	}
+	plan.target = targetVal
+	plan.table = table
+	return plan
+}
+
+// sql returns the subquery of the derived table, with its bind
+// variables starting at startBindIdx, along with its arguments.
+func (from *derivedTable) sql(startBindIdx int) (string, []interface{}, error) {
+	sub := from.plan
+	if len(sub.Errors) > 0 {
+		return "", nil, sub.Errors[0]
+	}
+	sub.bindOffset, sub.derived = startBindIdx, true
+	defer func() { sub.bindOffset, sub.derived = 0, false }()
+
+	var query string
+	var err error
+	var masked []*ColumnMap
+	if len(from.columns) > 0 {
+		var maskedFields map[string]*ColumnMap
+		query, maskedFields, err = sub.resultsQuery(from.columns)
+		for _, col := range maskedFields {
+			masked = append(masked, col)
+		}
+	} else {
+		role := RoleFromContext(sub.ctx)
+		for _, col := range sub.selectColumns() {
+			if col.masked(role) {
+				masked = append(masked, col)
+			}
+		}
+		query, err = sub.buildSelectQuery()
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if len(masked) > 0 {
+		return "", nil, fmt.Errorf("gorp: QueryFrom: cannot select masked column %s of table %s", masked[0].ColumnName, sub.table.TableName)
+	}
+	return "(" + query + ")", sub.args[startBindIdx:], nil
+}
@@ -51,6 +51,28 @@ type Dialect interface {
 	QuotedTableForQuery(schema string, table string) string
 }
 
+// LimitClauser is implemented by dialects whose limit and offset
+// syntax isn't " limit ? offset ?", or that need a limit before an
+// offset.  Queries on other dialects use the standard syntax.
+type LimitClauser interface {
+	// LimitClause returns the clause to append to a select statement
+	// to return at most limit rows after skipping offset rows, along
+	// with its bind arguments.  A zero limit or offset means no limit
+	// or no offset, and the clause is empty if both are zero.
+	//
+	// startBindIdx is the index of the clause's first bind variable
+	LimitClause(limit, offset int64, startBindIdx int) (string, []interface{})
+}
+
+// limitClause returns the dialect's limit clause (see LimitClauser),
+// or the standard one if the dialect doesn't implement LimitClauser.
+func limitClause(d Dialect, limit, offset int64, startBindIdx int) (string, []interface{}) {
+	if clauser, ok := d.(LimitClauser); ok {
+		return clauser.LimitClause(limit, offset, startBindIdx)
+	}
+	return standardLimitClause(d, limit, offset, startBindIdx, "")
+}
+
 // IntegerAutoIncrInserter is implemented by dialects that can perform
 // inserts with automatically incremented integer primary keys.  If
 // the dialect can handle automatic assignment of more than just
@@ -70,6 +92,297 @@ type TargetedAutoIncrInserter interface {
 	InsertAutoIncrToTarget(exec SqlExecutor, insertSql string, target interface{}, params ...interface{}) error
 }
 
+// CaseInsensitiveComparer is implemented by dialects that have a
+// better way to compare strings without regard to case than
+// lower(left) = lower(right).  Both arguments are already quoted
+// columns or bind variables.
+type CaseInsensitiveComparer interface {
+	EqualFold(left, right string) string
+}
+
+// RowValueComparer is implemented by dialects that can compare row
+// values, e.g. (a, b) > (?, ?).  RowValue returns the row value made
+// of the passed in quoted columns or bind variables.  Tuple filters
+// are expanded into equivalent comparisons of single values for all
+// other dialects.
+type RowValueComparer interface {
+	RowValue(values []string) string
+}
+
+// ArrayComparer is implemented by dialects that support array
+// columns (e.g. Postgres' text[]).  All arguments are already quoted
+// columns or bind variables; array bind variables are bound to the
+// array literal of a Go slice (e.g. {"a","b"}).
+type ArrayComparer interface {
+	// Any returns a condition that is true if value equals any
+	// element of array.
+	Any(value, array string) string
+
+	// Contains returns a condition that is true if array contains
+	// every element of elements.
+	Contains(array, elements string) string
+
+	// Overlaps returns a condition that is true if left and right
+	// have any elements in common.
+	Overlaps(left, right string) string
+}
+
+// ForeignKeyAdder is implemented by dialects that can add a foreign
+// key constraint to an existing table.  AddForeignKey returns the
+// statement that adds it; all arguments are already quoted.
+type ForeignKeyAdder interface {
+	AddForeignKey(table, constraint, column, refTable, refColumn string) string
+}
+
+// NullsOrderer is implemented by dialects that support placing nulls
+// first or last in an order by clause.  Queries on other dialects
+// will emulate it.
+type NullsOrderer interface {
+	// NullsOrder returns the string to append to an order by column
+	// to place nulls first (if first is true) or last.
+	NullsOrder(first bool) string
+}
+
+// RecursiveQuerier is implemented by dialects that support recursive
+// common table expressions (with recursive ...).  Tree queries (see
+// DbMap.Descendants) on other dialects fall back to running one query
+// per level of the tree.
+type RecursiveQuerier interface {
+	SupportsRecursiveQueries() bool
+}
+
+// UpdateJoiner is implemented by dialects that can update a table
+// using values from a joined table.  table and joinTable are quoted
+// table names, on and where are conditions that may reference either
+// table, columns are quoted (unqualified) columns of table, and values
+// are the expressions to assign to them.  Other dialects use
+// correlated subqueries instead.
+type UpdateJoiner interface {
+	UpdateJoin(table, joinTable, on string, columns, values []string, where string) string
+}
+
+// SystemTimeQuerier is implemented by dialects that can query a
+// system-versioned table as it was at a point in time.  AsOf returns
+// the clause that follows the table name in a from clause, given the
+// bind variable holding the point in time.
+type SystemTimeQuerier interface {
+	AsOf(bindVar string) string
+}
+
+// StaleReader is implemented by dialects for databases that can serve
+// reads that may be slightly stale from the nearest copy of the data,
+// like CockroachDB's follower reads.  StaleRead returns the clause that
+// follows the from clause of select statements run with Eventual
+// consistency.
+type StaleReader interface {
+	StaleRead() string
+}
+
+// Explainer is implemented by dialects that can describe how the
+// database will run a select statement.  Explain returns a statement
+// that explains query.  If analyze is true, the statement should also
+// run query and report actual timings, or return an error if the
+// database can't do that.
+type Explainer interface {
+	Explain(query string, analyze bool) (string, error)
+}
+
+// AdvisoryLocker is implemented by dialects that support advisory
+// locks held by a database session.  TryAdvisoryLock returns a query
+// that tries to take the lock for name without waiting, returning a
+// single row which is true (or 1) if the lock was taken.
+// AdvisoryUnlock returns a statement that releases the lock.
+type AdvisoryLocker interface {
+	TryAdvisoryLock(name string) (query string, args []interface{})
+	AdvisoryUnlock(name string) (query string, args []interface{})
+}
+
+// IndexHinter is implemented by dialects that let a select statement
+// name the indexes the database should use for a table.  UseIndex
+// returns the clause that follows the table name, given the quoted
+// index names.
+type IndexHinter interface {
+	UseIndex(indexes []string) string
+}
+
+// OptimizerHinter is implemented by dialects that accept optimizer
+// hints in a comment following the select keyword.  HintComment
+// returns the comment, including any trailing space.
+type OptimizerHinter interface {
+	HintComment(hints []string) string
+}
+
+// TruncateOptioner is implemented by dialects that support options
+// on truncate statements.  TruncateOptions returns the clause that
+// follows the table name, or an error if an option isn't supported.
+type TruncateOptioner interface {
+	TruncateOptions(cascade, restartIdentity bool) (string, error)
+}
+
+// RandomOrderer is implemented by dialects whose random function
+// isn't random().  Random returns the expression to order by for a
+// random order, and SeededRandom returns one that produces the same
+// order for the same seed, or "" if the database has no seeded random
+// function.
+type RandomOrderer interface {
+	Random() string
+	SeededRandom(seed int64) string
+}
+
+// AppendOnlyDialect is implemented by dialects for databases whose
+// tables are append-only, like ClickHouse.  If AppendOnly returns
+// true, updates and deletes fail with an error instead of sending
+// statements that the database would reject.
+type AppendOnlyDialect interface {
+	AppendOnly() bool
+}
+
+// ReadOnlyDialect is implemented by dialects for databases that gorp
+// only reads from, like BigQuery.  If ReadOnly returns true, inserts,
+// updates, and deletes fail with an error, as do the other statements
+// gorp runs that change the database: creating, dropping, and
+// truncating tables, sweeping expired rows, archiving, restoring
+// snapshots, and running scripts.
+type ReadOnlyDialect interface {
+	ReadOnly() bool
+}
+
+// LimitByer is implemented by dialects that can limit the number of
+// rows returned for each distinct value of a set of columns.  LimitBy
+// returns the clause that follows the order by clause, given the
+// quoted columns.
+type LimitByer interface {
+	LimitBy(n int64, columns []string) string
+}
+
+// StringAggregator is implemented by dialects whose function for
+// concatenating a group's values isn't group_concat(expr, separator).
+// StringAgg returns the aggregate expression, given the expression to
+// concatenate and the (unquoted) separator.
+type StringAggregator interface {
+	StringAgg(expr, separator string) string
+}
+
+// ForeignKeySupporter is implemented by dialects for databases that
+// may not support foreign key constraints.  If SupportsForeignKeys
+// returns false, CreateTables leaves foreign keys out of the tables it
+// creates, though tables are still created in dependency order.
+type ForeignKeySupporter interface {
+	SupportsForeignKeys() bool
+}
+
+// supportsForeignKeys returns false if d doesn't support foreign key
+// constraints.
+func supportsForeignKeys(d Dialect) bool {
+	supporter, ok := d.(ForeignKeySupporter)
+	return !ok || supporter.SupportsForeignKeys()
+}
+
+// KeyPredicateRequirer is implemented by dialects for databases that
+// need updates and deletes to target rows by primary key, like
+// sharded Vitess keyspaces.  If RequiresKeyPredicates returns true,
+// updates and deletes run by query plans fail with an error unless
+// they filter on a primary key column with Equal.
+type KeyPredicateRequirer interface {
+	RequiresKeyPredicates() bool
+}
+
+// RegexpMatcher is implemented by dialects that can match strings
+// against regular expressions.  MatchRegexp returns a condition that
+// is true if expr matches the (unquoted) pattern.
+type RegexpMatcher interface {
+	MatchRegexp(expr, pattern string) string
+}
+
+// CharLengther is implemented by dialects whose function for the
+// number of characters in a string isn't length(expr).  CharLength
+// returns the expression for the length of expr.
+type CharLengther interface {
+	CharLength(expr string) string
+}
+
+// ScriptSplitter is implemented by dialects whose scripts use syntax
+// that affects where statements end, like Postgres' dollar-quoted
+// strings.  SplitScript returns the statements in script, without
+// their terminators (see DbMap.SplitScript).
+type ScriptSplitter interface {
+	SplitScript(script string) ([]string, error)
+}
+
+// AuditTriggerer is implemented by dialects that can record the changes
+// made to a table in an audit table using triggers (see
+// TableMap.SetAuditTrigger).  AuditTriggers returns the statements that
+// create auditTable and the triggers on table that fill it, given the
+// (unquoted) schema, table, and column names.
+type AuditTriggerer interface {
+	AuditTriggers(schema, table, auditTable string, columns []string) []string
+}
+
+// AuditTriggerDropper is implemented by audit triggerers whose triggers
+// leave objects behind when their table is dropped, like Postgres'
+// trigger functions.  DropAuditTriggers returns the statements that
+// drop them, which are run after the table is dropped.
+type AuditTriggerDropper interface {
+	DropAuditTriggers(schema, table, auditTable string) []string
+}
+
+// quoteString returns s as a SQL string literal.
+func quoteString(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// standardLimitClause returns " limit ? offset ?", using noLimit as
+// the limit when there is an offset but no limit, for databases that
+// require a limit before an offset.
+func standardLimitClause(d Dialect, limit, offset int64, startBindIdx int, noLimit string) (string, []interface{}) {
+	clause := ""
+	args := make([]interface{}, 0, 2)
+	if limit > 0 {
+		clause = " limit " + d.BindVar(startBindIdx)
+		args = append(args, limit)
+	} else if offset > 0 && noLimit != "" {
+		clause = " limit " + noLimit
+	}
+	if offset > 0 {
+		clause += " offset " + d.BindVar(startBindIdx+len(args))
+		args = append(args, offset)
+	}
+	return clause, args
+}
+
+// jsonObject returns a json_object() call that builds an object of the
+// columns of row (e.g. "new" or "old" in a trigger), for databases
+// whose json_object takes alternating keys and values.
+func jsonObject(d Dialect, row string, columns []string) string {
+	pairs := make([]string, len(columns))
+	for i, column := range columns {
+		pairs[i] = quoteString(column) + ", " + row + "." + d.QuoteField(column)
+	}
+	return "json_object(" + strings.Join(pairs, ", ") + ")"
+}
+
+// rowAuditTriggers returns a trigger for each kind of write to table
+// that inserts the old and new rows into auditTable as JSON objects,
+// for databases with one trigger per event.  begin and end enclose the
+// trigger body.
+func rowAuditTriggers(d Dialect, schema, table, auditTable string, columns []string, begin, end string) []string {
+	quotedTable := d.QuotedTableForQuery(schema, table)
+	quotedAudit := d.QuotedTableForQuery(schema, auditTable)
+	events := []struct {
+		event, oldRow, newRow string
+	}{
+		{"insert", "null", jsonObject(d, "new", columns)},
+		{"update", jsonObject(d, "old", columns), jsonObject(d, "new", columns)},
+		{"delete", jsonObject(d, "old", columns), "null"},
+	}
+	triggers := make([]string, len(events))
+	for i, e := range events {
+		triggers[i] = fmt.Sprintf("create trigger %s after %s on %s for each row %sinsert into %s (operation, old_row, new_row) values ('%s', %s, %s)%s",
+			d.QuotedTableForQuery(schema, auditTable+"_"+e.event), e.event, quotedTable, begin, quotedAudit, e.event, e.oldRow, e.newRow, end)
+	}
+	return triggers
+}
+
 func standardInsertAutoIncr(exec SqlExecutor, insertSql string, params ...interface{}) (int64, error) {
 	res, err := exec.Exec(insertSql, params...)
 	if err != nil {
@@ -153,6 +466,35 @@ func (d SqliteDialect) InsertAutoIncr(exec SqlExecutor, insertSql string, params
 	return standardInsertAutoIncr(exec, insertSql, params...)
 }
 
+// Returns left = right collate nocase
+func (d SqliteDialect) EqualFold(left, right string) string {
+	return left + "=" + right + " collate nocase"
+}
+
+// Returns group_concat(expr, 'separator')
+func (d SqliteDialect) StringAgg(expr, separator string) string {
+	return "group_concat(" + expr + ", " + quoteString(separator) + ")"
+}
+
+// Returns true; sqlite has supported recursive queries since 3.8.3
+func (d SqliteDialect) SupportsRecursiveQueries() bool {
+	return true
+}
+
+// Returns explain query plan query; sqlite can't analyze queries
+func (d SqliteDialect) Explain(query string, analyze bool) (string, error) {
+	if analyze {
+		return "", errors.New("gorp: sqlite does not support explain analyze")
+	}
+	return "explain query plan " + query, nil
+}
+
+// Returns " limit ? offset ?"; sqlite requires a limit before an
+// offset, so -1 (no limit) is used if there is only an offset
+func (d SqliteDialect) LimitClause(limit, offset int64, startBindIdx int) (string, []interface{}) {
+	return standardLimitClause(d, limit, offset, startBindIdx, "-1")
+}
+
 func (d SqliteDialect) QuoteField(f string) string {
 	return `"` + f + `"`
 }
@@ -162,6 +504,14 @@ func (d SqliteDialect) QuotedTableForQuery(schema string, table string) string {
 	return d.QuoteField(table)
 }
 
+// Returns an audit table and insert, update, and delete triggers that
+// record rows with json_object()
+func (d SqliteDialect) AuditTriggers(schema, table, auditTable string, columns []string) []string {
+	create := fmt.Sprintf("create table %s (audit_id integer primary key autoincrement, operation varchar(6) not null, changed_at datetime not null default current_timestamp, old_row text, new_row text);",
+		d.QuotedTableForQuery(schema, auditTable))
+	return append([]string{create}, rowAuditTriggers(d, schema, table, auditTable, columns, "begin ", "; end;")...)
+}
+
 ///////////////////////////////////////////////////////
 // PostgreSQL //
 ////////////////
@@ -257,6 +607,130 @@ func (d PostgresDialect) InsertAutoIncrToTarget(exec SqlExecutor, insertSql stri
 	return errors.New("No serial value returned for insert: " + insertSql + " Encountered error: " + rows.Err().Error())
 }
 
+// Returns string_agg(cast(expr as text), 'separator')
+func (d PostgresDialect) StringAgg(expr, separator string) string {
+	return "string_agg(cast(" + expr + " as text), " + quoteString(separator) + ")"
+}
+
+// Returns expr ~ 'pattern'
+func (d PostgresDialect) MatchRegexp(expr, pattern string) string {
+	return expr + " ~ " + quoteString(pattern)
+}
+
+// Returns " nulls first" or " nulls last"
+func (d PostgresDialect) NullsOrder(first bool) string {
+	if first {
+		return " nulls first"
+	}
+	return " nulls last"
+}
+
+// Returns true
+func (d PostgresDialect) SupportsRecursiveQueries() bool {
+	return true
+}
+
+// Returns update table set column = value from joinTable where on and where
+func (d PostgresDialect) UpdateJoin(table, joinTable, on string, columns, values []string, where string) string {
+	assignments := make([]string, len(columns))
+	for i, column := range columns {
+		assignments[i] = column + " = " + values[i]
+	}
+	return fmt.Sprintf("update %s set %s from %s where %s and %s", table, strings.Join(assignments, ", "), joinTable, on, where)
+}
+
+// Splits script at semicolons outside of dollar-quoted strings
+func (d PostgresDialect) SplitScript(script string) ([]string, error) {
+	return splitScript(script, scriptSyntax{dollarQuotes: true})
+}
+
+// Returns an audit table and a trigger function, run after every
+// write, that records rows with to_jsonb()
+func (d PostgresDialect) AuditTriggers(schema, table, auditTable string, columns []string) []string {
+	quotedAudit := d.QuotedTableForQuery(schema, auditTable)
+	function := d.QuotedTableForQuery(schema, auditTable+"_trigger")
+	insert := "insert into " + quotedAudit + " (operation, old_row, new_row) values "
+	return []string{
+		fmt.Sprintf("create table %s (audit_id bigserial primary key, operation varchar(6) not null, changed_at timestamp with time zone not null default now(), old_row jsonb, new_row jsonb);", quotedAudit),
+		fmt.Sprintf(`create or replace function %s() returns trigger as $$
+begin
+    if tg_op = 'INSERT' then
+        %s('insert', null, to_jsonb(new));
+    elsif tg_op = 'UPDATE' then
+        %s('update', to_jsonb(old), to_jsonb(new));
+    else
+        %s('delete', to_jsonb(old), null);
+    end if;
+    return null;
+end;
+$$ language plpgsql;`, function, insert, insert, insert),
+		fmt.Sprintf("create trigger %s after insert or update or delete on %s for each row execute procedure %s();",
+			d.QuoteField(auditTable), d.QuotedTableForQuery(schema, table), function),
+	}
+}
+
+// Returns a statement dropping the trigger function, which isn't
+// dropped with the table
+func (d PostgresDialect) DropAuditTriggers(schema, table, auditTable string) []string {
+	return []string{fmt.Sprintf("drop function if exists %s();", d.QuotedTableForQuery(schema, auditTable+"_trigger"))}
+}
+
+// Returns explain query or explain analyze query
+func (d PostgresDialect) Explain(query string, analyze bool) (string, error) {
+	if analyze {
+		return "explain analyze " + query, nil
+	}
+	return "explain " + query, nil
+}
+
+// Returns select pg_try_advisory_lock($1), with name hashed to a key
+func (d PostgresDialect) TryAdvisoryLock(name string) (string, []interface{}) {
+	return "select pg_try_advisory_lock($1)", []interface{}{advisoryLockKey(name)}
+}
+
+// Returns select pg_advisory_unlock($1), with name hashed to a key
+func (d PostgresDialect) AdvisoryUnlock(name string) (string, []interface{}) {
+	return "select pg_advisory_unlock($1)", []interface{}{advisoryLockKey(name)}
+}
+
+// Returns "(values)"
+func (d PostgresDialect) RowValue(values []string) string {
+	return "(" + strings.Join(values, ", ") + ")"
+}
+
+// Returns "alter table table add constraint constraint foreign key
+// (column) references refTable (refColumn);"
+func (d PostgresDialect) AddForeignKey(table, constraint, column, refTable, refColumn string) string {
+	return fmt.Sprintf("alter table %s add constraint %s foreign key (%s) references %s (%s);", table, constraint, column, refTable, refColumn)
+}
+
+// Returns "value = ANY(array)"
+func (d PostgresDialect) Any(value, array string) string {
+	return value + " = ANY(" + array + ")"
+}
+
+// Returns "array @> elements"
+func (d PostgresDialect) Contains(array, elements string) string {
+	return array + " @> " + elements
+}
+
+// Returns "left && right"
+func (d PostgresDialect) Overlaps(left, right string) string {
+	return left + " && " + right
+}
+
+// Returns " restart identity" and/or " cascade"
+func (d PostgresDialect) TruncateOptions(cascade, restartIdentity bool) (string, error) {
+	clause := ""
+	if restartIdentity {
+		clause += " restart identity"
+	}
+	if cascade {
+		clause += " cascade"
+	}
+	return clause, nil
+}
+
 func (d PostgresDialect) QuoteField(f string) string {
 	return `"` + strings.ToLower(f) + `"`
 }
@@ -377,11 +851,423 @@ func (m MySQLDialect) InsertAutoIncr(exec SqlExecutor, insertSql string, params
 	return standardInsertAutoIncr(exec, insertSql, params...)
 }
 
+// Returns update table inner join joinTable on on set table.column = value where where
+func (m MySQLDialect) UpdateJoin(table, joinTable, on string, columns, values []string, where string) string {
+	assignments := make([]string, len(columns))
+	for i, column := range columns {
+		assignments[i] = table + "." + column + " = " + values[i]
+	}
+	return fmt.Sprintf("update %s inner join %s on %s set %s where %s", table, joinTable, on, strings.Join(assignments, ", "), where)
+}
+
+// Returns explain query or explain analyze query; explain analyze
+// requires MySQL 8.0.18 or later
+func (m MySQLDialect) Explain(query string, analyze bool) (string, error) {
+	if analyze {
+		return "explain analyze " + query, nil
+	}
+	return "explain " + query, nil
+}
+
+// Returns select get_lock(name, 0)
+func (m MySQLDialect) TryAdvisoryLock(name string) (string, []interface{}) {
+	return "select get_lock(?, 0)", []interface{}{name}
+}
+
+// Returns select release_lock(name)
+func (m MySQLDialect) AdvisoryUnlock(name string) (string, []interface{}) {
+	return "select release_lock(?)", []interface{}{name}
+}
+
+// Returns "alter table table add constraint constraint foreign key
+// (column) references refTable (refColumn);"
+func (m MySQLDialect) AddForeignKey(table, constraint, column, refTable, refColumn string) string {
+	return fmt.Sprintf("alter table %s add constraint %s foreign key (%s) references %s (%s);", table, constraint, column, refTable, refColumn)
+}
+
+// Returns "(values)"
+func (m MySQLDialect) RowValue(values []string) string {
+	return "(" + strings.Join(values, ", ") + ")"
+}
+
+// Returns ""; truncate always resets auto_increment counters in MySQL,
+// and cascading truncates aren't supported
+func (m MySQLDialect) TruncateOptions(cascade, restartIdentity bool) (string, error) {
+	if cascade {
+		return "", errors.New("gorp: MySQL does not support cascading truncates")
+	}
+	return "", nil
+}
+
+// Returns group_concat(expr separator 'separator'); MySQL treats
+// backslashes in string literals as escapes
+func (m MySQLDialect) StringAgg(expr, separator string) string {
+	return "group_concat(" + expr + " separator " + quoteString(strings.Replace(separator, `\`, `\\`, -1)) + ")"
+}
+
+// Returns expr regexp 'pattern', with backslashes escaped
+func (m MySQLDialect) MatchRegexp(expr, pattern string) string {
+	return expr + " regexp " + quoteString(strings.Replace(pattern, `\`, `\\`, -1))
+}
+
+// Returns char_length(expr), since length() counts bytes
+func (m MySQLDialect) CharLength(expr string) string {
+	return "char_length(" + expr + ")"
+}
+
+// Returns an audit table and insert, update, and delete triggers that
+// record rows with json_object()
+func (m MySQLDialect) AuditTriggers(schema, table, auditTable string, columns []string) []string {
+	create := fmt.Sprintf("create table %s (audit_id bigint not null auto_increment primary key, operation varchar(6) not null, changed_at timestamp(6) not null default current_timestamp(6), old_row json, new_row json) %s;",
+		m.QuotedTableForQuery(schema, auditTable), m.CreateTableSuffix())
+	return append([]string{create}, rowAuditTriggers(m, schema, table, auditTable, columns, "", "")...)
+}
+
+// Returns "rand()"
+func (m MySQLDialect) Random() string {
+	return "rand()"
+}
+
+// Returns "rand(seed)"
+func (m MySQLDialect) SeededRandom(seed int64) string {
+	return fmt.Sprintf("rand(%d)", seed)
+}
+
+// Returns " use index (indexes)"
+func (m MySQLDialect) UseIndex(indexes []string) string {
+	return " use index (" + strings.Join(indexes, ", ") + ")"
+}
+
+// Returns "/*+ hints */ "; optimizer hints require MySQL 5.7 or later
+func (m MySQLDialect) HintComment(hints []string) string {
+	return "/*+ " + strings.Join(hints, " ") + " */ "
+}
+
+// Returns " limit ? offset ?"; MySQL requires a limit before an
+// offset, so the largest possible limit is used if there is only an
+// offset
+func (d MySQLDialect) LimitClause(limit, offset int64, startBindIdx int) (string, []interface{}) {
+	return standardLimitClause(d, limit, offset, startBindIdx, "18446744073709551615")
+}
+
 func (d MySQLDialect) QuoteField(f string) string {
 	return "`" + f + "`"
 }
 
+// Splits script at its delimiter (a semicolon, unless it is changed
+// with the DELIMITER command) outside of strings and backquoted names
+func (d MySQLDialect) SplitScript(script string) ([]string, error) {
+	return splitScript(script, scriptSyntax{backslashEscapes: true, backticks: true, delimiters: true})
+}
+
 // MySQL does not have schemas like PostgreSQL does, so just escape it like normal
 func (d MySQLDialect) QuotedTableForQuery(schema string, table string) string {
 	return d.QuoteField(table)
 }
+
+///////////////////////////////////////////////////////
+// MariaDB //
+/////////////
+
+// MariaDBDialect is a MySQLDialect for MariaDB, which adds queries on
+// system-versioned tables (see SystemTimeQuerier).  MySQL doesn't
+// have system-versioned tables.
+type MariaDBDialect struct {
+	MySQLDialect
+}
+
+// Returns " for system_time as of timestamp bindVar"
+func (d MariaDBDialect) AsOf(bindVar string) string {
+	return " for system_time as of timestamp " + bindVar
+}
+
+///////////////////////////////////////////////////////
+// Vitess //
+////////////
+
+// VitessDialect is a MySQLDialect for Vitess (and PlanetScale), which
+// doesn't accept foreign key DDL, needs updates and deletes to target
+// rows by primary key (see KeyPredicateRequirer), and reads query
+// directives from /*vt+ ... */ comments (see QueryPlan.Hint).
+type VitessDialect struct {
+	MySQLDialect
+
+	// Keyspace qualifies tables that don't have a schema.  Since
+	// vtgate accepts targets as qualifiers, it may also target a
+	// shard or tablet type, e.g. "commerce:-80" or "commerce@replica".
+	Keyspace string
+}
+
+// Returns false; Vitess doesn't support foreign key constraints
+func (d VitessDialect) SupportsForeignKeys() bool {
+	return false
+}
+
+// Returns true
+func (d VitessDialect) RequiresKeyPredicates() bool {
+	return true
+}
+
+// Returns "/*vt+ hints */ "
+func (d VitessDialect) HintComment(hints []string) string {
+	return "/*vt+ " + strings.Join(hints, " ") + " */ "
+}
+
+// Returns `schema`.`table`, qualifying the table with the Keyspace if
+// it has no schema
+func (d VitessDialect) QuotedTableForQuery(schema string, table string) string {
+	if strings.TrimSpace(schema) == "" {
+		schema = d.Keyspace
+	}
+	if strings.TrimSpace(schema) == "" {
+		return d.QuoteField(table)
+	}
+	return d.QuoteField(schema) + "." + d.QuoteField(table)
+}
+
+///////////////////////////////////////////////////////
+// ClickHouse //
+////////////////
+
+// ClickHouseDialect generates SQL for ClickHouse.  ClickHouse tables
+// are append-only (see AppendOnlyDialect) and have no auto-increment
+// columns, so tables are usually mapped without keys; their sorting
+// key is part of the table engine instead.
+type ClickHouseDialect struct {
+	// Engine is the table engine clause used by CreateTables, e.g.
+	// "MergeTree() order by (PersonId, Created)".  The default is
+	// "MergeTree() order by tuple()", which doesn't sort rows.
+	Engine string
+}
+
+func (d ClickHouseDialect) ToSqlType(val reflect.Type, maxsize int, isAutoIncr bool) string {
+	switch val.Kind() {
+	case reflect.Ptr:
+		return "Nullable(" + d.ToSqlType(val.Elem(), maxsize, isAutoIncr) + ")"
+	case reflect.Bool:
+		return "Bool"
+	case reflect.Int8:
+		return "Int8"
+	case reflect.Uint8:
+		return "UInt8"
+	case reflect.Int16:
+		return "Int16"
+	case reflect.Uint16:
+		return "UInt16"
+	case reflect.Int32:
+		return "Int32"
+	case reflect.Uint32:
+		return "UInt32"
+	case reflect.Int, reflect.Int64:
+		return "Int64"
+	case reflect.Uint, reflect.Uint64:
+		return "UInt64"
+	case reflect.Float32:
+		return "Float32"
+	case reflect.Float64:
+		return "Float64"
+	}
+
+	switch val.Name() {
+	case "NullInt64":
+		return "Nullable(Int64)"
+	case "NullFloat64":
+		return "Nullable(Float64)"
+	case "NullBool":
+		return "Nullable(Bool)"
+	case "NullString":
+		return "Nullable(String)"
+	case "Time":
+		return "DateTime64(6)"
+	}
+
+	// ClickHouse strings (which also hold binary data) have no
+	// maximum size.
+	return "String"
+}
+
+// Returns ""; ClickHouse has no auto-increment columns
+func (d ClickHouseDialect) AutoIncrStr() string {
+	return ""
+}
+
+func (d ClickHouseDialect) AutoIncrBindValue() string {
+	return ""
+}
+
+func (d ClickHouseDialect) AutoIncrInsertSuffix(col *ColumnMap) string {
+	return ""
+}
+
+// Returns " engine = Engine"
+func (d ClickHouseDialect) CreateTableSuffix() string {
+	engine := d.Engine
+	if engine == "" {
+		engine = "MergeTree() order by tuple()"
+	}
+	return " engine = " + engine
+}
+
+func (d ClickHouseDialect) TruncateClause() string {
+	return "truncate table"
+}
+
+// Returns "?"
+func (d ClickHouseDialect) BindVar(i int) string {
+	return "?"
+}
+
+// Returns true
+func (d ClickHouseDialect) AppendOnly() bool {
+	return true
+}
+
+// Returns " limit n by columns"
+func (d ClickHouseDialect) LimitBy(n int64, columns []string) string {
+	return fmt.Sprintf(" limit %d by %s", n, strings.Join(columns, ", "))
+}
+
+// Returns "explain query"; ClickHouse can't analyze queries
+func (d ClickHouseDialect) Explain(query string, analyze bool) (string, error) {
+	if analyze {
+		return "", errors.New("gorp: ClickHouse does not support explain analyze")
+	}
+	return "explain " + query, nil
+}
+
+// Returns arrayStringConcat(groupArray(expr), 'separator')
+func (d ClickHouseDialect) StringAgg(expr, separator string) string {
+	return "arrayStringConcat(groupArray(" + expr + "), " + quoteString(strings.Replace(separator, `\`, `\\`, -1)) + ")"
+}
+
+// Returns "rand()"
+func (d ClickHouseDialect) Random() string {
+	return "rand()"
+}
+
+// Returns ""; ClickHouse has no seeded random function
+func (d ClickHouseDialect) SeededRandom(seed int64) string {
+	return ""
+}
+
+// Returns " limit ? offset ?"; ClickHouse requires a limit before an
+// offset, so the largest possible limit is used if there is only an
+// offset
+func (d ClickHouseDialect) LimitClause(limit, offset int64, startBindIdx int) (string, []interface{}) {
+	return standardLimitClause(d, limit, offset, startBindIdx, "18446744073709551615")
+}
+
+func (d ClickHouseDialect) QuoteField(f string) string {
+	return "`" + f + "`"
+}
+
+// Returns `database`.`table`; ClickHouse databases play the part of
+// schemas
+func (d ClickHouseDialect) QuotedTableForQuery(schema string, table string) string {
+	if strings.TrimSpace(schema) == "" {
+		return d.QuoteField(table)
+	}
+	return d.QuoteField(schema) + "." + d.QuoteField(table)
+}
+
+///////////////////////////////////////////////////////
+// BigQuery //
+//////////////
+
+// BigQueryDialect generates BigQuery standard SQL for selecting from
+// warehouse tables.  It is read-only (see ReadOnlyDialect), and
+// expects the driver to use positional ? parameters.  Tables without
+// a schema are read from the connection's default dataset.
+type BigQueryDialect struct{}
+
+func (d BigQueryDialect) ToSqlType(val reflect.Type, maxsize int, isAutoIncr bool) string {
+	switch val.Kind() {
+	case reflect.Ptr:
+		// BigQuery columns are nullable unless declared otherwise.
+		return d.ToSqlType(val.Elem(), maxsize, isAutoIncr)
+	case reflect.Bool:
+		return "BOOL"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "INT64"
+	case reflect.Float32, reflect.Float64:
+		return "FLOAT64"
+	case reflect.Slice:
+		if val.Elem().Kind() == reflect.Uint8 {
+			return "BYTES"
+		}
+	}
+
+	switch val.Name() {
+	case "NullInt64":
+		return "INT64"
+	case "NullFloat64":
+		return "FLOAT64"
+	case "NullBool":
+		return "BOOL"
+	case "Time":
+		return "TIMESTAMP"
+	}
+
+	return "STRING"
+}
+
+// Returns ""; BigQuery has no auto-increment columns
+func (d BigQueryDialect) AutoIncrStr() string {
+	return ""
+}
+
+func (d BigQueryDialect) AutoIncrBindValue() string {
+	return ""
+}
+
+func (d BigQueryDialect) AutoIncrInsertSuffix(col *ColumnMap) string {
+	return ""
+}
+
+func (d BigQueryDialect) CreateTableSuffix() string {
+	return ""
+}
+
+func (d BigQueryDialect) TruncateClause() string {
+	return "truncate table"
+}
+
+// Returns "?"
+func (d BigQueryDialect) BindVar(i int) string {
+	return "?"
+}
+
+// Returns true
+func (d BigQueryDialect) ReadOnly() bool {
+	return true
+}
+
+// Returns "rand()"
+func (d BigQueryDialect) Random() string {
+	return "rand()"
+}
+
+// Returns ""; BigQuery has no seeded random function
+func (d BigQueryDialect) SeededRandom(seed int64) string {
+	return ""
+}
+
+// Returns " limit ? offset ?"; BigQuery requires a limit before an
+// offset, so the largest possible limit is used if there is only an
+// offset
+func (d BigQueryDialect) LimitClause(limit, offset int64, startBindIdx int) (string, []interface{}) {
+	return standardLimitClause(d, limit, offset, startBindIdx, "9223372036854775807")
+}
+
+func (d BigQueryDialect) QuoteField(f string) string {
+	return "`" + f + "`"
+}
+
+// Returns `dataset.table`, or `table` for tables without a schema
+func (d BigQueryDialect) QuotedTableForQuery(schema string, table string) string {
+	if strings.TrimSpace(schema) == "" {
+		return d.QuoteField(table)
+	}
+	return d.QuoteField(schema + "." + table)
+}
@@ -0,0 +1,241 @@
+package gorp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// SetExpiration marks expiresField as the expiration column of this
+// table.  A row whose expiration time has passed is expired: query
+// plan selects (including counts and existence checks) skip expired
+// rows, and DbMap.SweepExpired deletes them.  A null expiration time
+// means the row never expires.
+//
+//     dbmap.AddTable(Session{}).SetKeys(false, "Token").SetExpiration("ExpiresAt")
+//
+// Expiration times are compared to the local clock, not the
+// database's.  Rows loaded using Get() or raw SQL are not filtered.
+// The table must have a single primary key column.  Panics if
+// expiresField can't be found.
+func (t *TableMap) SetExpiration(expiresField string) *TableMap {
+	if len(t.keys) != 1 {
+		panic(fmt.Sprintf("gorp: SetExpiration: table %s must have exactly one primary key column", t.TableName))
+	}
+	t.expiresAt = t.ColMap(expiresField)
+	return t
+}
+
+// unexpiredClause returns a condition matching rows of the plan's
+// table that haven't expired, and adds its argument to the plan's
+// arguments.  It returns an empty string if the plan should include
+// expired rows.
+func (plan *QueryPlan) unexpiredClause() string {
+	if plan.table.expiresAt == nil || plan.includeExpired {
+		return ""
+	}
+	dialect := plan.table.dbmap.Dialect
+	column := dialect.QuotedTableForQuery(plan.table.SchemaName, plan.table.TableName) + "." + dialect.QuoteField(plan.table.expiresAt.ColumnName)
+	clause := "(" + column + " is null or " + column + " > " + dialect.BindVar(len(plan.args)) + ")"
+	plan.args = append(plan.args, time.Now())
+	return clause
+}
+
+// SweepExpired deletes the expired rows of model's table (see
+// TableMap.SetExpiration), batchSize rows at a time, and returns the
+// number of rows deleted.  Each batch is a separate statement, so
+// that sweeping a large backlog of expired rows doesn't hold locks
+// on the table for long.
+//
+// Rows of tables whose deletes do more than remove the row (PreDelete
+// and PostDelete hooks, shadow tables, closure tables, counter caches,
+// and delete actions) are loaded and deleted one at a time, as
+// Delete() would, so that those run for every swept row.
+func (m *DbMap) SweepExpired(model interface{}, batchSize int) (int64, error) {
+	return sweepExpired(m, m, model, batchSize)
+}
+
+// SweepExpired has the same behavior as DbMap.SweepExpired, but runs
+// in a transaction.
+func (t *Transaction) SweepExpired(model interface{}, batchSize int) (int64, error) {
+	return sweepExpired(t.dbmap, t, model, batchSize)
+}
+
+func sweepExpired(m *DbMap, exec SqlExecutor, model interface{}, batchSize int) (int64, error) {
+	t, err := toType(model)
+	if err != nil {
+		return 0, err
+	}
+	table, err := m.tableFor(t, false)
+	if err != nil {
+		return 0, err
+	}
+	return table.sweepExpired(exec, batchSize)
+}
+
+func (t *TableMap) sweepExpired(exec SqlExecutor, batchSize int) (int64, error) {
+	if err := t.dbmap.checkWritable(); err != nil {
+		return 0, err
+	}
+	if t.expiresAt == nil {
+		return 0, fmt.Errorf("gorp: SweepExpired: table %s has no expiration column", t.TableName)
+	}
+	if batchSize <= 0 {
+		return 0, fmt.Errorf("gorp: SweepExpired: invalid batch size %d", batchSize)
+	}
+	dialect := t.dbmap.Dialect
+	key := t.keys[0]
+	var deleted int64
+	for {
+		plan := t.maintenanceQuery(exec)
+		expiresAt, err := plan.colMap.pointerForColumn(t.expiresAt)
+		if err != nil {
+			return deleted, err
+		}
+		keyPtr, err := plan.colMap.pointerForColumn(key)
+		if err != nil {
+			return deleted, err
+		}
+		keys := reflect.New(reflect.SliceOf(key.gotype))
+		err = plan.Where(LessOrEqual(expiresAt, time.Now())).Limit(int64(batchSize)).SelectColumn(keyPtr, keys.Interface())
+		if err != nil {
+			return deleted, err
+		}
+		batch := keys.Elem()
+		if batch.Len() == 0 {
+			return deleted, nil
+		}
+
+		if t.deletesEachRow() {
+			rows, err := t.deleteEachRow(exec, key, batch)
+			deleted += rows
+			if err != nil {
+				return deleted, err
+			}
+			if batch.Len() < batchSize {
+				return deleted, nil
+			}
+			continue
+		}
+
+		keyList, args := bindList(dialect, batch)
+		res, err := exec.Exec(fmt.Sprintf("delete from %s where %s in %s",
+			dialect.QuotedTableForQuery(t.SchemaName, t.TableName), dialect.QuoteField(key.ColumnName), keyList), args...)
+		if err != nil {
+			return deleted, err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return deleted, err
+		}
+		deleted += rows
+		if batch.Len() < batchSize {
+			return deleted, nil
+		}
+	}
+}
+
+// deletesEachRow returns true if deleting a row of this table does
+// more than remove the row, so that rows must be deleted one at a
+// time using Delete() instead of in batches.
+func (t *TableMap) deletesEachRow() bool {
+	ptrType := reflect.PtrTo(t.gotype)
+	return t.shadow != nil || t.closure != nil || len(t.counters) > 0 || len(t.cascades()) > 0 ||
+		ptrType.Implements(reflect.TypeOf((*HasPreDelete)(nil)).Elem()) ||
+		ptrType.Implements(reflect.TypeOf((*HasPostDelete)(nil)).Elem())
+}
+
+// deleteEachRow loads the rows whose keys (of the key column) are in
+// keys, a slice, and deletes them using Delete(), returning the number
+// of rows deleted.
+func (t *TableMap) deleteEachRow(exec SqlExecutor, key *ColumnMap, keys reflect.Value) (int64, error) {
+	args := make([]interface{}, keys.Len())
+	for i := range args {
+		args[i] = keys.Index(i).Interface()
+	}
+	rows, err := exec.Select(reflect.New(t.gotype).Interface(), selectInQuery(t, key, len(args)), args...)
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+	count, err := exec.Delete(rows...)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// maintenanceQuery returns a query plan on this table for jobs that
+// maintain all of its rows, like SweepExpired and Archive.  The plan
+// includes expired rows, soft-deleted rows (see SetSoftDelete), rows
+// left out by default scopes (see AddDefaultScope), and, since the
+// jobs run without a context, the rows of every tenant (see
+// SetTenantColumn).
+func (t *TableMap) maintenanceQuery(exec SqlExecutor) *QueryPlan {
+	plan := newQueryPlan(context.Background(), t.dbmap, exec, reflect.New(t.gotype).Interface())
+	plan.includeExpired = true
+	plan.unscoped = true
+	plan.withDeleted = true
+	return plan
+}
+
+// bindList returns a parenthesized list of bind variables for the
+// elements of values, a slice, along with the elements.
+func bindList(dialect Dialect, values reflect.Value) (string, []interface{}) {
+	s := bytes.Buffer{}
+	args := make([]interface{}, values.Len())
+	s.WriteString("(")
+	for i := range args {
+		if i > 0 {
+			s.WriteString(",")
+		}
+		s.WriteString(dialect.BindVar(i))
+		args[i] = values.Index(i).Interface()
+	}
+	s.WriteString(")")
+	return s.String(), args
+}
+
+// A Sweeper periodically deletes the expired rows of every table in a
+// DbMap that has an expiration column.  Create one with
+// DbMap.StartSweeper.
+type Sweeper struct {
+	dbmap     *DbMap
+	batchSize int
+	onError   func(table *TableMap, err error)
+	loop      *periodic
+}
+
+// StartSweeper starts a goroutine that calls SweepExpired for each
+// table with an expiration column every interval, until the returned
+// Sweeper is stopped.  Errors are passed to onError, which may be
+// nil, and don't stop the sweeper.  Every process that starts a
+// sweeper sweeps; to sweep from a single process, run SweepExpired
+// from a PeriodicRunner instead.
+func (m *DbMap) StartSweeper(interval time.Duration, batchSize int, onError func(table *TableMap, err error)) *Sweeper {
+	s := &Sweeper{
+		dbmap:     m,
+		batchSize: batchSize,
+		onError:   onError,
+	}
+	s.loop = startPeriodic(interval, s.sweep)
+	return s
+}
+
+func (s *Sweeper) sweep() {
+	for _, table := range s.dbmap.tables {
+		if table.expiresAt == nil {
+			continue
+		}
+		if _, err := table.sweepExpired(s.dbmap, s.batchSize); err != nil && s.onError != nil {
+			s.onError(table, err)
+		}
+	}
+}
+
+// Stop stops the sweeper, waiting for a sweep that is in progress to
+// finish.
+func (s *Sweeper) Stop() {
+	s.loop.halt()
+}
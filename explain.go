@@ -0,0 +1,72 @@
+package gorp
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Explain returns the database's plan for running this query plan's
+// select statement, without running it.  The dialect must implement
+// Explainer.  Each row of the database's output is written on its own
+// line, with columns separated by tabs.
+func (plan *QueryPlan) Explain() (string, error) {
+	return plan.explain(false)
+}
+
+// ExplainAnalyze runs this query plan's select statement and returns
+// the database's plan for it, including the actual time spent on each
+// step.  Since explain analyze runs the statement, it is only offered
+// for selects.
+func (plan *QueryPlan) ExplainAnalyze() (string, error) {
+	return plan.explain(true)
+}
+
+func (plan *QueryPlan) explain(analyze bool) (string, error) {
+	if len(plan.Errors) > 0 {
+		return "", plan.Errors[0]
+	}
+	explainer, ok := plan.table.dbmap.Dialect.(Explainer)
+	if !ok {
+		return "", fmt.Errorf("gorp: Explain: dialect %T does not support explain", plan.table.dbmap.Dialect)
+	}
+	query, err := plan.selectQuery()
+	if err != nil {
+		return "", err
+	}
+	if query, err = explainer.Explain(query, analyze); err != nil {
+		return "", err
+	}
+	rows, err := plan.executor.query(query, plan.args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	text := bytes.Buffer{}
+	values := make([]interface{}, len(cols))
+	targets := make([]interface{}, len(cols))
+	for i := range values {
+		targets[i] = &values[i]
+	}
+	for rows.Next() {
+		if err = rows.Scan(targets...); err != nil {
+			return "", err
+		}
+		if text.Len() > 0 {
+			text.WriteString("\n")
+		}
+		for i, value := range values {
+			if i > 0 {
+				text.WriteString("\t")
+			}
+			if b, ok := value.([]byte); ok {
+				value = string(b)
+			}
+			text.WriteString(fmt.Sprint(value))
+		}
+	}
+	return text.String(), rows.Err()
+}
@@ -0,0 +1,287 @@
+package gorp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// An Expression is a SQL expression that can be used in place of a
+// field pointer in filters, assignments, order by, and group by.  Use
+// Raw, Func, Col, or Val to create them.
+type Expression interface {
+	// Expr should take a ColumnResolver, a dialect, and the index
+	// to start binding at, and return the SQL for the expression
+	// along with its query arguments.
+	Expr(structMap ColumnResolver, dialect Dialect, startBindIdx int) (string, []interface{}, error)
+}
+
+// A rawExpression is a raw SQL fragment with ? placeholders.
+type rawExpression struct {
+	sql  string
+	args []interface{}
+}
+
+func (expr *rawExpression) Expr(structMap ColumnResolver, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
+	sql, err := bindExpr(dialect, expr.sql, startBindIdx, len(expr.args))
+	if err != nil {
+		return "", nil, err
+	}
+	return sql, expr.args, nil
+}
+
+// Raw returns an expression for a raw SQL fragment.  Each ?
+// placeholder in sql is replaced with the dialect's bind variable for
+// the matching argument:
+//
+//     query.GroupBy(gorp.Raw("extract(year from created)"))
+//
+// Column names in sql are not quoted or checked, so prefer Func when
+// the expression refers to the plan's fields.  Question marks in string
+// literals, quoted identifiers, and comments are left alone, as are
+// Postgres' ?| and ?& operators; write Postgres' ? operator as ??:
+//
+//     query.Where().Equal(gorp.Raw("tags ?? ?", "urgent"), true)
+func Raw(sql string, args ...interface{}) Expression {
+	return &rawExpression{sql, args}
+}
+
+// A funcExpression is a call to a SQL function.
+type funcExpression struct {
+	name     string
+	operands []interface{}
+}
+
+func (expr *funcExpression) Expr(structMap ColumnResolver, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
+	buffer := bytes.Buffer{}
+	args := make([]interface{}, 0, len(expr.operands))
+	buffer.WriteString(expr.name)
+	buffer.WriteString("(")
+	for i, operand := range expr.operands {
+		if i > 0 {
+			buffer.WriteString(", ")
+		}
+		sql, operandArgs, err := operandExpr(operand, structMap, dialect, startBindIdx+len(args))
+		if err != nil {
+			return "", nil, err
+		}
+		buffer.WriteString(sql)
+		args = append(args, operandArgs...)
+	}
+	buffer.WriteString(")")
+	return buffer.String(), args, nil
+}
+
+// Func returns an expression that calls the named SQL function.  Each
+// operand may be a field pointer, another Expression, or a value to
+// bind:
+//
+//     query.GroupBy(gorp.Func("date", &inv.Created))
+//     query.GroupBy(gorp.Func("date_trunc", "month", &inv.Created))
+//
+func Func(name string, operands ...interface{}) Expr {
+	return Expr{&funcExpression{name, operands}}
+}
+
+// An Expr is an Expression that can be combined with other operands
+// using arithmetic operators, to build expressions like
+//
+//     gorp.Col(&line.Price).Mul(&line.Quantity).Sub(gorp.Val(discount))
+//
+// Each operand may be a field pointer, another Expression, or a value
+// to bind.  Exprs can be used wherever field pointers are accepted by
+// query plans:
+//
+//     query.Where().Greater(gorp.Col(&line.Price).Mul(&line.Quantity), 100)
+//     query.Assign(&line.Total, gorp.Col(&line.Price).Mul(&line.Quantity))
+//     query.OrderBy(gorp.Desc(gorp.Col(&line.Price).Mul(&line.Quantity)))
+//     query.GroupBy(gorp.Func("date", &line.Created))
+//
+// Plans with Exprs in their filters aren't cached, and can't be
+// defined (see QueryPlan.Definition).
+type Expr struct {
+	expr Expression
+}
+
+func (e Expr) Expr(structMap ColumnResolver, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
+	return e.expr.Expr(structMap, dialect, startBindIdx)
+}
+
+// Add returns the expression (e + operand).
+func (e Expr) Add(operand interface{}) Expr {
+	return Expr{&binaryExpression{e, " + ", operand}}
+}
+
+// Sub returns the expression (e - operand).
+func (e Expr) Sub(operand interface{}) Expr {
+	return Expr{&binaryExpression{e, " - ", operand}}
+}
+
+// Mul returns the expression (e * operand).
+func (e Expr) Mul(operand interface{}) Expr {
+	return Expr{&binaryExpression{e, " * ", operand}}
+}
+
+// Div returns the expression (e / operand).  Whether this is integer
+// division for integer operands is up to the database.
+func (e Expr) Div(operand interface{}) Expr {
+	return Expr{&binaryExpression{e, " / ", operand}}
+}
+
+// Col returns an expression for the column of fieldPtr.
+func Col(fieldPtr interface{}) Expr {
+	return Expr{&columnExpression{fieldPtr}}
+}
+
+// Val returns an expression that binds value, e.g. to use a value as
+// the left operand of an arithmetic operator.
+func Val(value interface{}) Expr {
+	return Expr{&valueExpression{value}}
+}
+
+// A columnExpression is a reference to a column.
+type columnExpression struct {
+	fieldPtr interface{}
+}
+
+func (expr *columnExpression) Expr(structMap ColumnResolver, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
+	column, err := structMap.tableColumnForPointer(expr.fieldPtr)
+	if err != nil {
+		return "", nil, err
+	}
+	return column, nil, nil
+}
+
+// A valueExpression is a value to bind.
+type valueExpression struct {
+	value interface{}
+}
+
+func (expr *valueExpression) Expr(structMap ColumnResolver, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
+	return dialect.BindVar(startBindIdx), []interface{}{expr.value}, nil
+}
+
+// A binaryExpression applies an arithmetic operator to two operands.
+type binaryExpression struct {
+	left     interface{}
+	operator string
+	right    interface{}
+}
+
+func (expr *binaryExpression) Expr(structMap ColumnResolver, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
+	left, args, err := operandExpr(expr.left, structMap, dialect, startBindIdx)
+	if err != nil {
+		return "", nil, err
+	}
+	right, rightArgs, err := operandExpr(expr.right, structMap, dialect, startBindIdx+len(args))
+	if err != nil {
+		return "", nil, err
+	}
+	return "(" + left + expr.operator + right + ")", append(args, rightArgs...), nil
+}
+
+// A stringAggExpression concatenates the values of a column in each
+// group.
+type stringAggExpression struct {
+	fieldPtr  interface{}
+	separator string
+}
+
+func (expr *stringAggExpression) Expr(structMap ColumnResolver, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
+	column, err := structMap.tableColumnForPointer(expr.fieldPtr)
+	if err != nil {
+		return "", nil, err
+	}
+	if aggregator, ok := dialect.(StringAggregator); ok {
+		return aggregator.StringAgg(column, expr.separator), nil, nil
+	}
+	return "group_concat(" + column + ", " + quoteString(expr.separator) + ")", nil, nil
+}
+
+// StringAgg returns an aggregate expression that concatenates the
+// values of fieldPtr's column in each group, separated by separator,
+// using the dialect's function for it (see StringAggregator).  Use it
+// with GroupBy, selecting it into a transient field with Computed or
+// into a result struct with SelectResults:
+//
+//     type Post struct {
+//         Id   int64
+//         Tags string `db:"-"`
+//     }
+//
+//     dbmap.Query(post).Join(tag).On(gorp.Equal(&tag.PostId, &post.Id)).
+//         Where().
+//         GroupBy(&post.Id).
+//         Computed(&post.Tags, gorp.StringAgg(&tag.Name, ", ")).
+//         Select()
+//
+// The order of the values is up to the database.
+func StringAgg(fieldPtr interface{}, separator string) Expression {
+	return &stringAggExpression{fieldPtr, separator}
+}
+
+// operandExpr returns the SQL for a single operand of an expression.
+func operandExpr(operand interface{}, structMap ColumnResolver, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
+	if expr, ok := operand.(Expression); ok {
+		return expr.Expr(structMap, dialect, startBindIdx)
+	}
+	if reflect.ValueOf(operand).Kind() == reflect.Ptr {
+		column, err := structMap.tableColumnForPointer(operand)
+		if err != nil {
+			return "", nil, err
+		}
+		return column, nil, nil
+	}
+	return dialect.BindVar(startBindIdx), []interface{}{operand}, nil
+}
+
+// A computedColumn is an expression selected into a transient field.
+type computedColumn struct {
+	alias string
+	expr  Expression
+}
+
+// Computed selects expr into fieldPtr, a pointer to a transient
+// field (tagged with `db:"-"`) of the query's target, alongside the
+// table's columns.  This is how aggregates like StringAgg are loaded
+// into rows of a grouped query:
+//
+//     query.GroupBy(&post.Id).Computed(&post.Tags, gorp.StringAgg(&tag.Name, ", "))
+//
+// Columns used in expr are subject to column restrictions and masks as
+// they are in SelectResults.  Plans with computed fields aren't cached.
+func (plan *QueryPlan) Computed(fieldPtr interface{}, expr Expression) SelectQuery {
+	for _, fieldMap := range plan.colMap {
+		if fieldMap.addr != fieldPtr {
+			continue
+		}
+		if !fieldMap.column.Transient {
+			plan.Errors = append(plan.Errors, fmt.Errorf("gorp: Computed: field %s is not transient", fieldMap.column.fieldName))
+			return plan
+		}
+		plan.computed = append(plan.computed, computedColumn{alias: fieldMap.column.fieldName, expr: expr})
+		return plan
+	}
+	plan.Errors = append(plan.Errors, errors.New("gorp: Computed: cannot find a field matching the passed in pointer"))
+	return plan
+}
+
+// computedColumns returns the select list entries for the plan's
+// computed fields, along with their arguments.
+func (plan *QueryPlan) computedColumns() (string, []interface{}, error) {
+	buffer := bytes.Buffer{}
+	var args []interface{}
+	for _, computed := range plan.computed {
+		sql, exprArgs, err := computed.expr.Expr(plan.expressionColumns(), plan.table.dbmap.Dialect, plan.bindOffset+len(args))
+		if err != nil {
+			return "", nil, err
+		}
+		buffer.WriteString(",")
+		buffer.WriteString(sql)
+		buffer.WriteString(" as ")
+		buffer.WriteString(plan.table.dbmap.Dialect.QuoteField(computed.alias))
+		args = append(args, exprArgs...)
+	}
+	return buffer.String(), args, nil
+}
@@ -0,0 +1,167 @@
+package gorp
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// FailoverOptions configure a FailoverConnector.
+type FailoverOptions struct {
+	// Attempts is the number of times the list of DSNs is tried
+	// before opening a connection fails.  It defaults to 1.
+	Attempts int
+
+	// RetryDelay is the time to wait before trying the list again.
+	RetryDelay time.Duration
+
+	// OnFailover, if not nil, is called when a connection is opened
+	// to a different DSN than the previous connection, with the
+	// indexes of both DSNs in the list.
+	OnFailover func(from, to int)
+}
+
+// A FailoverConnector is a driver.Connector that opens each connection
+// to the first healthy DSN in an ordered list, e.g. a primary followed
+// by its standbys.  A DSN is healthy if a connection can be opened and,
+// if the driver supports it, pinged.  Connections are opened by the
+// sql.DB's pool, which discards broken connections and opens new ones
+// as needed, so when the primary fails, new connections go to the next
+// healthy DSN without restarting the process, and they return to the
+// primary once it is healthy again.  Use DB.SetConnMaxLifetime to
+// limit how long connections to a standby are kept after that.
+type FailoverConnector struct {
+	driver     driver.Driver
+	dsns       []string
+	opts       FailoverOptions
+	connectors []driver.Connector
+
+	mu     sync.Mutex
+	active int
+}
+
+// NewFailoverConnector returns a FailoverConnector for dsns, which are
+// data source names for the registered driver driverName.
+func NewFailoverConnector(driverName string, dsns []string, opts FailoverOptions) (*FailoverConnector, error) {
+	if len(dsns) == 0 {
+		return nil, errors.New("gorp: NewFailoverConnector requires at least one DSN")
+	}
+	if opts.Attempts == 0 {
+		opts.Attempts = 1
+	}
+	if opts.Attempts < 0 {
+		return nil, fmt.Errorf("gorp: NewFailoverConnector: invalid number of attempts %d", opts.Attempts)
+	}
+	// sql.Open doesn't connect, so it can be used to look up the
+	// driver.
+	db, err := sql.Open(driverName, "")
+	if err != nil {
+		return nil, err
+	}
+	d := db.Driver()
+	db.Close()
+
+	c := &FailoverConnector{driver: d, dsns: dsns, opts: opts, active: -1}
+	if dc, ok := d.(driver.DriverContext); ok {
+		c.connectors = make([]driver.Connector, len(dsns))
+		for i, dsn := range dsns {
+			if c.connectors[i], err = dc.OpenConnector(dsn); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return c, nil
+}
+
+// OpenFailover returns a DbMap whose connections are opened by a
+// FailoverConnector for dsns:
+//
+//     dbmap, err := gorp.OpenFailover(gorp.PostgresDialect{}, "postgres",
+//         []string{primaryDSN, standbyDSN},
+//         gorp.FailoverOptions{Attempts: 3, RetryDelay: time.Second})
+//
+func OpenFailover(dialect Dialect, driverName string, dsns []string, opts FailoverOptions) (*DbMap, error) {
+	connector, err := NewFailoverConnector(driverName, dsns, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &DbMap{Db: sql.OpenDB(connector), Dialect: dialect}, nil
+}
+
+// Connect opens a connection to the first healthy DSN, trying the
+// list up to opts.Attempts times.  It returns the last DSN's error if
+// none are healthy.
+func (c *FailoverConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	var err error
+	for attempt := 0; attempt < c.opts.Attempts; attempt++ {
+		if attempt > 0 && c.opts.RetryDelay > 0 {
+			select {
+			case <-time.After(c.opts.RetryDelay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		for i := range c.dsns {
+			var conn driver.Conn
+			if conn, err = c.connect(ctx, i); err == nil {
+				c.setActive(i)
+				return conn, nil
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+		}
+	}
+	return nil, err
+}
+
+// connect opens a connection to the DSN at index i and checks that it
+// is healthy.
+func (c *FailoverConnector) connect(ctx context.Context, i int) (driver.Conn, error) {
+	var conn driver.Conn
+	var err error
+	if c.connectors != nil {
+		conn, err = c.connectors[i].Connect(ctx)
+	} else {
+		conn, err = c.driver.Open(c.dsns[i])
+	}
+	if err != nil {
+		return nil, err
+	}
+	if pinger, ok := conn.(driver.Pinger); ok {
+		if err = pinger.Ping(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// setActive records that the last connection was opened to the DSN
+// at index i.
+func (c *FailoverConnector) setActive(i int) {
+	c.mu.Lock()
+	from := c.active
+	c.active = i
+	c.mu.Unlock()
+	if from != i && from >= 0 && c.opts.OnFailover != nil {
+		c.opts.OnFailover(from, i)
+	}
+}
+
+// Active returns the index of the DSN that the last connection was
+// opened to, or -1 if no connection has been opened yet.
+func (c *FailoverConnector) Active() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.active
+}
+
+// Driver returns the connector's driver.
+func (c *FailoverConnector) Driver() driver.Driver {
+	return c.driver
+}
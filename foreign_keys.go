@@ -0,0 +1,168 @@
+package gorp
+
+import (
+	"fmt"
+)
+
+// References declares that this column holds the primary key of a
+// row in parent's table, which must have a single primary key column.
+// CreateTables adds a foreign key constraint for the column, and
+// creates the tables in an order that satisfies their foreign keys,
+// regardless of the order they were registered in:
+//
+//     dbmap.AddTable(Comment{}).SetKeys(true, "Id").
+//         ColMap("PostId").References(Post{})
+//     dbmap.AddTable(Post{}).SetKeys(true, "Id")
+//
+// parent's table doesn't need to be registered until the tables are
+// created.  Panics if parent isn't a struct or a pointer to one.
+func (c *ColumnMap) References(parent interface{}) *ColumnMap {
+	t, err := toType(parent)
+	if err != nil {
+		panic(err.Error())
+	}
+	c.references = t
+	return c
+}
+
+// A foreignKey is a foreign key constraint from a column to the
+// primary key of its parent table.
+type foreignKey struct {
+	table  *TableMap
+	col    *ColumnMap
+	parent *TableMap
+}
+
+// constraintName returns the name used for the constraint when it is
+// added after the table has been created.
+func (fk foreignKey) constraintName() string {
+	return "fk_" + fk.table.TableName + "_" + fk.col.ColumnName
+}
+
+// clause returns the foreign key clause for the table's create
+// statement.
+func (fk foreignKey) clause(dialect Dialect) string {
+	return fmt.Sprintf(", foreign key (%s) references %s (%s)%s",
+		dialect.QuoteField(fk.col.ColumnName),
+		dialect.QuotedTableForQuery(fk.parent.SchemaName, fk.parent.TableName),
+		dialect.QuoteField(fk.parent.keys[0].ColumnName),
+		fk.onDeleteClause())
+}
+
+// foreignKeys returns the foreign keys declared on this table's
+// columns.
+func (t *TableMap) foreignKeys() ([]foreignKey, error) {
+	var fks []foreignKey
+	for _, col := range t.columns {
+		if col.references == nil || !col.inSchema() {
+			continue
+		}
+		parent, err := t.dbmap.tableFor(col.references, false)
+		if err != nil {
+			return nil, fmt.Errorf("gorp: Column %s of table %s references an unregistered type %s", col.ColumnName, t.TableName, col.references)
+		}
+		if len(parent.keys) != 1 {
+			return nil, fmt.Errorf("gorp: Column %s of table %s references table %s, which must have exactly one primary key column", col.ColumnName, t.TableName, parent.TableName)
+		}
+		fks = append(fks, foreignKey{table: t, col: col, parent: parent})
+	}
+	return fks, nil
+}
+
+// creationOrder returns the registered tables ordered so that each
+// table comes after the tables its foreign keys reference, keeping
+// registration order where possible.  Foreign keys that are part of a
+// cycle between tables can't be satisfied by any order; they are
+// returned as deferred, to be added once all of the tables exist, if
+// the dialect implements ForeignKeyAdder.  Dialects that don't check
+// references at creation time (i.e. sqlite) keep them in the create
+// statement instead.
+func (m *DbMap) creationOrder() (tables []*TableMap, deferred map[*ColumnMap]bool, err error) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	_, canDefer := m.Dialect.(ForeignKeyAdder)
+	canDefer = canDefer && supportsForeignKeys(m.Dialect)
+	state := make(map[*TableMap]int, len(m.tables))
+	deferred = make(map[*ColumnMap]bool)
+	var visit func(t *TableMap) error
+	visit = func(t *TableMap) error {
+		if state[t] == visited {
+			return nil
+		}
+		state[t] = visiting
+		fks, err := t.foreignKeys()
+		if err != nil {
+			return err
+		}
+		for _, fk := range fks {
+			switch {
+			case fk.parent == t:
+				// Self references are always allowed.
+			case state[fk.parent] == visiting:
+				if canDefer {
+					deferred[fk.col] = true
+				}
+			default:
+				if err := visit(fk.parent); err != nil {
+					return err
+				}
+			}
+		}
+		state[t] = visited
+		tables = append(tables, t)
+		return nil
+	}
+	for _, t := range m.tables {
+		if err = visit(t); err != nil {
+			return nil, nil, err
+		}
+	}
+	return tables, deferred, nil
+}
+
+// addDeferredForeignKeys adds the deferred foreign key constraints of
+// tables, once all of them have been created.
+func (m *DbMap) addDeferredForeignKeys(tables []*TableMap, deferred map[*ColumnMap]bool) error {
+	if len(deferred) == 0 {
+		return nil
+	}
+	adder := m.Dialect.(ForeignKeyAdder)
+	for _, table := range tables {
+		fks, err := table.foreignKeys()
+		if err != nil {
+			return err
+		}
+		for _, fk := range fks {
+			if !deferred[fk.col] {
+				continue
+			}
+			err = m.execAudited(m, fk.withOnDelete(adder.AddForeignKey(
+				m.Dialect.QuotedTableForQuery(table.SchemaName, table.TableName),
+				m.Dialect.QuoteField(fk.constraintName()),
+				m.Dialect.QuoteField(fk.col.ColumnName),
+				m.Dialect.QuotedTableForQuery(fk.parent.SchemaName, fk.parent.TableName),
+				m.Dialect.QuoteField(fk.parent.keys[0].ColumnName))))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dropOrder returns the registered tables ordered so that each table
+// comes before the tables its foreign keys reference.
+func (m *DbMap) dropOrder() ([]*TableMap, error) {
+	tables, _, err := m.creationOrder()
+	if err != nil {
+		return nil, err
+	}
+	reversed := make([]*TableMap, len(tables))
+	for i, table := range tables {
+		reversed[len(tables)-1-i] = table
+	}
+	return reversed, nil
+}
+
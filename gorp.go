@@ -13,20 +13,27 @@ package gorp
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"reflect"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var zeroVal reflect.Value
+var nullStringType = reflect.TypeOf(sql.NullString{})
 var versFieldConst = "[gorp_ver_field]"
 
 // OptimisticLockError is returned by Update() or Delete() if the
 // struct being modified has a Version field and the value is not equal to
-// the current value in the database
+// the current value in the database.  Query plan updates return it if
+// their target has a Version field and no rows with that version
+// matched the plan.
 type OptimisticLockError struct {
 	// Table name where the lock error occurred
 	TableName string
@@ -41,14 +48,21 @@ type OptimisticLockError struct {
 	RowExists bool
 
 	// Version value on the struct passed to Update/Delete. This value is
-	// out of sync with the database.
-	LocalVersion int64
+	// out of sync with the database.  LocalVersion is only set for
+	// integer version fields; LocalVersionValue is set for all version
+	// strategies (see VersionStrategy).
+	LocalVersion      int64
+	LocalVersionValue interface{}
 }
 
 // Error returns a description of the cause of the lock error
 func (e OptimisticLockError) Error() string {
 	if e.RowExists {
-		return fmt.Sprintf("gorp: OptimisticLockError table=%s keys=%v out of date version=%d", e.TableName, e.Keys, e.LocalVersion)
+		version := e.LocalVersionValue
+		if version == nil {
+			version = e.LocalVersion
+		}
+		return fmt.Sprintf("gorp: OptimisticLockError table=%s keys=%v out of date version=%v", e.TableName, e.Keys, version)
 	}
 
 	return fmt.Sprintf("gorp: OptimisticLockError no row found for table=%s keys=%v", e.TableName, e.Keys)
@@ -111,27 +125,59 @@ type DbMap struct {
 
 	TypeConverter TypeConverter
 
-	tables    []*TableMap
-	logger    GorpLogger
-	logPrefix string
+	tables      []*TableMap
+	logger      GorpLogger
+	logPrefix   string
+	auditor     StatementAuditor
+	interceptor PlanInterceptor
+	scrubber    ArgScrubber
+	replicas    []*sql.DB
+	replicaNext uint32
+	clock       func() time.Time
+	strict      bool
+	schema      string
+
+	config   atomic.Value
+	configMu sync.Mutex
 }
 
 // TableMap represents a mapping between a Go struct and a database table
 // Use dbmap.AddTable() or dbmap.AddTableWithName() to create these
 type TableMap struct {
 	// Name of database table.
-	TableName      string
-	SchemaName     string
-	gotype         reflect.Type
-	columns        []*ColumnMap
-	keys           []*ColumnMap
-	uniqueTogether [][]string
-	version        *ColumnMap
-	insertPlan     bindPlan
-	updatePlan     bindPlan
-	deletePlan     bindPlan
-	getPlan        bindPlan
-	dbmap          *DbMap
+	TableName       string
+	SchemaName      string
+	gotype          reflect.Type
+	columns         []*ColumnMap
+	keys            []*ColumnMap
+	uniqueTogether  [][]string
+	version         *ColumnMap
+	versionStrategy VersionStrategy
+	insertPlan      bindPlan
+	updatePlan      bindPlan
+	deletePlan      bindPlan
+	getPlan         bindPlan
+	dbmap           *DbMap
+	shadow          *shadowWriter
+	columnGroups    map[string][]*ColumnMap
+	queryCache      sqlCache
+	polymorphics    map[string]*PolymorphicMap
+	closure         *closureTable
+	hasMany         []*HasManyMap
+	manyToMany      []*ManyToManyMap
+	counters        []*HasManyMap
+	denormalizers   []*HasManyMap
+	validFrom       *ColumnMap
+	validTo         *ColumnMap
+	expiresAt       *ColumnMap
+	deletedAt       *ColumnMap
+	defaultScopes   []defaultScope
+	scopes          map[string]reflect.Value
+	tenant          *ColumnMap
+	retention       *retentionPolicy
+	writeLimit      *tokenBucket
+	auditTrigger    bool
+	tags            []string
 }
 
 // ResetSql removes cached insert/update/select/delete SQL strings
@@ -142,6 +188,10 @@ func (t *TableMap) ResetSql() {
 	t.updatePlan = bindPlan{}
 	t.deletePlan = bindPlan{}
 	t.getPlan = bindPlan{}
+	t.queryCache.reset()
+	if t.shadow != nil {
+		t.shadow.reset()
+	}
 }
 
 // SetKeys lets you specify the fields on a struct that map to primary
@@ -208,6 +258,13 @@ func (t *TableMap) ColMap(field string) *ColumnMap {
 	return col
 }
 
+// Columns returns the table's columns, in the order of the struct's
+// fields, including transient columns.  The returned slice must not be
+// modified.
+func (t *TableMap) Columns() []*ColumnMap {
+	return t.columns
+}
+
 func colMapOrNil(t *TableMap, field string) *ColumnMap {
 	for _, col := range t.columns {
 		if col.fieldName == field || col.ColumnName == field {
@@ -221,6 +278,9 @@ func colMapOrNil(t *TableMap, field string) *ColumnMap {
 // the "Version" field is used.  Returns the column found, or panics
 // if the struct does not contain a field matching this name.
 //
+// Query plan updates check the version held by the plan's target, if
+// it isn't zero, and store the next version in it when they succeed.
+//
 // Automatically calls ResetSql() to ensure SQL statements are regenerated.
 func (t *TableMap) SetVersionCol(field string) *ColumnMap {
 	c := t.ColMap(field)
@@ -234,6 +294,7 @@ type bindPlan struct {
 	argFields         []string
 	keyFields         []string
 	versField         string
+	versioning        VersionStrategy
 	autoIncrIdx       int
 	autoIncrFieldName string
 }
@@ -241,7 +302,7 @@ type bindPlan struct {
 func (plan bindPlan) createBindInstance(elem reflect.Value, conv TypeConverter) (bindInstance, error) {
 	bi := bindInstance{query: plan.query, autoIncrIdx: plan.autoIncrIdx, autoIncrFieldName: plan.autoIncrFieldName, versField: plan.versField}
 	if plan.versField != "" {
-		bi.existingVersion = elem.FieldByName(plan.versField).Int()
+		bi.existingVersion = elem.FieldByName(plan.versField).Interface()
 	}
 
 	var err error
@@ -249,13 +310,16 @@ func (plan bindPlan) createBindInstance(elem reflect.Value, conv TypeConverter)
 	for i := 0; i < len(plan.argFields); i++ {
 		k := plan.argFields[i]
 		if k == versFieldConst {
-			newVer := bi.existingVersion + 1
-			bi.args = append(bi.args, newVer)
-			if bi.existingVersion == 0 {
-				elem.FieldByName(plan.versField).SetInt(int64(newVer))
+			bi.newVersion, err = nextVersion(plan.versioning, elem, plan.versField)
+			if err != nil {
+				return bindInstance{}, err
+			}
+			bi.args = append(bi.args, bi.newVersion)
+			if !bi.versionChecked() {
+				elem.FieldByName(plan.versField).Set(reflect.ValueOf(bi.newVersion))
 			}
 		} else {
-			val := elem.FieldByName(k).Interface()
+			val := fieldValue(elem, k)
 			if conv != nil {
 				val, err = conv.ToDb(val)
 				if err != nil {
@@ -268,7 +332,7 @@ func (plan bindPlan) createBindInstance(elem reflect.Value, conv TypeConverter)
 
 	for i := 0; i < len(plan.keyFields); i++ {
 		k := plan.keyFields[i]
-		val := elem.FieldByName(k).Interface()
+		val := fieldValue(elem, k)
 		if conv != nil {
 			val, err = conv.ToDb(val)
 			if err != nil {
@@ -285,7 +349,8 @@ type bindInstance struct {
 	query             string
 	args              []interface{}
 	keys              []interface{}
-	existingVersion   int64
+	existingVersion   interface{}
+	newVersion        interface{}
 	versField         string
 	autoIncrIdx       int
 	autoIncrFieldName string
@@ -305,7 +370,7 @@ func (t *TableMap) bindInsert(elem reflect.Value) (bindInstance, error) {
 		for y := range t.columns {
 			col := t.columns[y]
 
-			if !col.Transient {
+			if col.inSchema() {
 				if !first {
 					s.WriteString(",")
 					s2.WriteString(",")
@@ -320,6 +385,7 @@ func (t *TableMap) bindInsert(elem reflect.Value) (bindInstance, error) {
 					s2.WriteString(t.dbmap.Dialect.BindVar(x))
 					if col == t.version {
 						plan.versField = col.fieldName
+						plan.versioning = t.versioning()
 						plan.argFields = append(plan.argFields, versFieldConst)
 					} else {
 						plan.argFields = append(plan.argFields, col.fieldName)
@@ -356,7 +422,7 @@ func (t *TableMap) bindUpdate(elem reflect.Value) (bindInstance, error) {
 
 		for y := range t.columns {
 			col := t.columns[y]
-			if !col.isPK && !col.Transient {
+			if !col.isPK && col.inSchema() {
 				if x > 0 {
 					s.WriteString(", ")
 				}
@@ -366,6 +432,7 @@ func (t *TableMap) bindUpdate(elem reflect.Value) (bindInstance, error) {
 
 				if col == t.version {
 					plan.versField = col.fieldName
+					plan.versioning = t.versioning()
 					plan.argFields = append(plan.argFields, versFieldConst)
 				} else {
 					plan.argFields = append(plan.argFields, col.fieldName)
@@ -413,7 +480,7 @@ func (t *TableMap) bindDelete(elem reflect.Value) (bindInstance, error) {
 
 		for y := range t.columns {
 			col := t.columns[y]
-			if !col.Transient {
+			if col.inSchema() {
 				if col == t.version {
 					plan.versField = col.fieldName
 				}
@@ -459,7 +526,7 @@ func (t *TableMap) bindGet() bindPlan {
 
 		x := 0
 		for _, col := range t.columns {
-			if !col.Transient {
+			if col.inSchema() {
 				if x > 0 {
 					s.WriteString(",")
 				}
@@ -511,11 +578,64 @@ type ColumnMap struct {
 	// Not used elsewhere
 	MaxSize int
 
+	table      *TableMap
 	fieldName  string
 	gotype     reflect.Type
 	isPK       bool
 	isAutoIncr bool
 	isNotNull  bool
+	isOptional bool
+	isInterned bool
+
+	// required is set for columns whose values must be set (see
+	// setValidationRules).
+	required bool
+
+	// isFilterable is set for columns that end users may filter on
+	// (see ColumnMap.SetFilterable).
+	isFilterable bool
+
+	// references is the type of the table that this column holds the
+	// primary key of (see ColumnMap.References).
+	references reflect.Type
+
+	// relation is the relation declared on this column's field (see
+	// ColumnMap.HasMany).
+	relation *fieldRelation
+
+	// validators constrain the column's values (see
+	// ColumnMap.Validate).
+	validators []Validator
+
+	// autoCreateTime and autoUpdateTime mark columns that query plans
+	// set to the current time (see ColumnMap.SetAutoCreateTime).
+	autoCreateTime bool
+	autoUpdateTime bool
+
+	// readAccess and writeAccess decide whether query plans may read
+	// or write this column (see ColumnMap.SetReadAccess).
+	readAccess  func(ctx context.Context) bool
+	writeAccess func(ctx context.Context) bool
+
+	// mask obfuscates the column's values unless a query plan's role
+	// is one of unmaskedRoles (see ColumnMap.SetMask).
+	mask          Mask
+	unmaskedRoles []string
+
+	// jsonName is the key used for this column by TableMap.Project,
+	// or "-" to leave the column out (see ColumnMap.SetJSONName).
+	jsonName string
+
+	// transitions maps each state (see CacheKey) to the set of states
+	// that this column may change to from it.
+	transitions    map[string]map[string]bool
+	compareAndSwap bool
+
+	// missing is set to 1 by DbMap.ValidateSchema when an optional
+	// column does not exist in the database yet.  It is accessed
+	// atomically, since ValidateSchema may run while statements are
+	// being generated.
+	missing uint32
 }
 
 // Rename allows you to specify the column name in the table
@@ -527,6 +647,20 @@ func (c *ColumnMap) Rename(colname string) *ColumnMap {
 	return c
 }
 
+// FieldName returns the name of the struct field this column is
+// mapped to.
+func (c *ColumnMap) FieldName() string {
+	return c.fieldName
+}
+
+// Generated returns true if the column's values are generated on
+// insert: by the database, for auto-increment keys (see
+// TableMap.SetKeys), or by gorp, for version columns (see
+// TableMap.SetVersionCol).
+func (c *ColumnMap) Generated() bool {
+	return c.isAutoIncr || (c.table != nil && c.table.version == c)
+}
+
 // SetTransient allows you to mark the column as transient. If true
 // this column will be skipped when SQL statements are generated
 func (c *ColumnMap) SetTransient(b bool) *ColumnMap {
@@ -534,6 +668,55 @@ func (c *ColumnMap) SetTransient(b bool) *ColumnMap {
 	return c
 }
 
+// SetOptional marks the column as optional in the database schema.
+// If DbMap.ValidateSchema finds that an optional column does not
+// exist yet, the column will be left out of generated SQL statements
+// until a later call to ValidateSchema finds it.  This allows code
+// using a new column to be deployed before the migration adding it
+// has been run.
+func (c *ColumnMap) SetOptional(b bool) *ColumnMap {
+	c.isOptional = b
+	if !b && c.setMissing(false) && c.table != nil {
+		c.table.ResetSql()
+	}
+	return c
+}
+
+// isMissing returns true if ValidateSchema found that this optional
+// column does not exist in the database.
+func (c *ColumnMap) isMissing() bool {
+	return atomic.LoadUint32(&c.missing) == 1
+}
+
+// setMissing records whether this column is missing from the
+// database, returning true if that changed.
+func (c *ColumnMap) setMissing(missing bool) bool {
+	var v uint32
+	if missing {
+		v = 1
+	}
+	return atomic.SwapUint32(&c.missing, v) != v
+}
+
+// SetInterned marks the column's values for interning when loading
+// rows.  When a select returns the same string value for an interned
+// column more than once, every row will share a single copy of the
+// string.  This can save a lot of memory for low-cardinality text
+// columns (status codes, enum-like values) in large result sets.
+//
+// Only string and sql.NullString fields can be interned; the setting
+// is ignored for other types.
+func (c *ColumnMap) SetInterned(b bool) *ColumnMap {
+	c.isInterned = b
+	return c
+}
+
+// inSchema returns true if the column should be included in generated
+// SQL statements.
+func (c *ColumnMap) inSchema() bool {
+	return !c.Transient && !c.isMissing()
+}
+
 // SetUnique adds "unique" to the create table statements for this
 // column, if b is true.
 func (c *ColumnMap) SetUnique(b bool) *ColumnMap {
@@ -564,6 +747,16 @@ type Transaction struct {
 	dbmap  *DbMap
 	tx     *sql.Tx
 	closed bool
+
+	// savepoint is the name of the savepoint that Commit releases and
+	// Rollback rolls back to, for transactions created by Nested.
+	savepoint string
+
+	// nestedCount is the number of savepoints WithTransaction has
+	// created in the root transaction, used to name them.  It is
+	// shared with the transactions created by Nested, so that names
+	// are unique across all levels of nesting.
+	nestedCount *int
 }
 
 // SqlExecutor exposes gorp operations that can be run from Pre/Post
@@ -605,7 +798,8 @@ type GorpLogger interface {
 // strings, which can aid in filtering log lines.
 //
 // Use TraceOn if you want to spy on the SQL statements that gorp
-// generates.
+// generates.  Use SetArgScrubber to keep sensitive arguments out of
+// the log.
 //
 // Note that the base log.Logger type satisfies GorpLogger, but adapters can
 // easily be written for other logging packages (e.g., the golang-sanctioned
@@ -625,6 +819,23 @@ func (m *DbMap) TraceOff() {
 	m.logPrefix = ""
 }
 
+// SetDefaultSchema sets the schema of the tables added to this DbMap
+// without one, so that an application whose tables live in one schema
+// doesn't have to name it for each table:
+//
+//     dbmap.SetDefaultSchema("billing")
+//     dbmap.AddTable(Invoice{})                                   // billing.Invoice
+//     dbmap.AddTableWithNameAndSchema(Person{}, "crm", "person")  // crm.person
+//
+// Tables keep the schema they were added with, so SetDefaultSchema
+// must be called before AddTable.  Query plans qualify every table by
+// its own schema, so plans may join, update from, and delete using
+// tables in other schemas.
+func (m *DbMap) SetDefaultSchema(schema string) *DbMap {
+	m.schema = schema
+	return m
+}
+
 // AddTable registers the given interface type with gorp. The table name
 // will be given the name of the TypeOf(i).  You must call this function,
 // or AddTableWithName, for any struct type you wish to persist with
@@ -643,12 +854,16 @@ func (m *DbMap) AddTableWithName(i interface{}, name string) *TableMap {
 }
 
 // AddTableWithNameAndSchema has the same behavior as AddTable, but sets
-// table.TableName to name.
+// table.TableName to name and table.SchemaName to schema, or to the
+// DbMap's default schema (see SetDefaultSchema) if schema is empty.
 func (m *DbMap) AddTableWithNameAndSchema(i interface{}, schema string, name string) *TableMap {
 	t := reflect.TypeOf(i)
 	if name == "" {
 		name = t.Name()
 	}
+	if schema == "" {
+		schema = m.schema
+	}
 
 	// check if we have a table for this type already
 	// if so, update the name and return the existing pointer
@@ -656,13 +871,24 @@ func (m *DbMap) AddTableWithNameAndSchema(i interface{}, schema string, name str
 		table := m.tables[i]
 		if table.gotype == t {
 			table.TableName = name
+			if schema != "" {
+				table.SchemaName = schema
+			}
+			table.ResetSql()
 			return table
 		}
 	}
 
 	tmap := &TableMap{gotype: t, TableName: name, SchemaName: schema, dbmap: m}
 	tmap.columns, tmap.version = readStructColumns(t)
+	for _, col := range tmap.columns {
+		col.table = tmap
+	}
+	if m.strict {
+		tmap.checkStrict()
+	}
 	m.tables = append(m.tables, tmap)
+	m.resolveHasMany(tmap)
 
 	return tmap
 }
@@ -702,6 +928,9 @@ func readStructColumns(t reflect.Type) (cols []*ColumnMap, version *ColumnMap) {
 				fieldName:  f.Name,
 				gotype:     f.Type,
 			}
+			if rules := f.Tag.Get("validate"); rules != "" {
+				cm.setValidationRules(rules)
+			}
 			// Check for nested fields of the same field name and
 			// override them.
 			shouldAppend := true
@@ -725,25 +954,38 @@ func readStructColumns(t reflect.Type) (cols []*ColumnMap, version *ColumnMap) {
 
 // CreateTables iterates through TableMaps registered to this DbMap and
 // executes "create table" statements against the database for each.
+// Tables are created after the tables their foreign keys reference
+// (see ColumnMap.References).  Foreign keys between tables that
+// reference each other are added once all of the tables exist, if the
+// dialect implements ForeignKeyAdder.
 //
 // This is particularly useful in unit tests where you want to create
 // and destroy the schema automatically.
 func (m *DbMap) CreateTables() error {
-	return m.createTables(false)
+	return m.createTables(false, nil)
 }
 
 // CreateTablesIfNotExists is similar to CreateTables, but starts
 // each statement with "create table if not exists" so that existing
-// tables do not raise errors
+// tables do not raise errors.  Foreign keys between tables that
+// reference each other are not added, since they can't be added
+// conditionally; use CreateTables to create such schemas.
 func (m *DbMap) CreateTablesIfNotExists() error {
-	return m.createTables(true)
+	return m.createTables(true, nil)
 }
 
-func (m *DbMap) createTables(ifNotExists bool) error {
-	var err error
-	for i := range m.tables {
-		table := m.tables[i]
-
+// createTables creates the registered tables for which match returns
+// true, or all of them if match is nil.
+func (m *DbMap) createTables(ifNotExists bool, match func(*TableMap) bool) error {
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+	tables, deferred, err := m.creationOrder()
+	if err != nil {
+		return err
+	}
+	tables = filterTables(tables, match)
+	for _, table := range tables {
 		s := bytes.Buffer{}
 
 		if strings.TrimSpace(table.SchemaName) != "" {
@@ -808,15 +1050,46 @@ func (m *DbMap) createTables(ifNotExists bool) error {
 				s.WriteString(")")
 			}
 		}
+		for _, check := range table.checkConstraints(m.Dialect) {
+			s.WriteString(", check (")
+			s.WriteString(check)
+			s.WriteString(")")
+		}
+		fks, err := table.foreignKeys()
+		if err != nil {
+			return err
+		}
+		for _, fk := range fks {
+			if !deferred[fk.col] && supportsForeignKeys(m.Dialect) {
+				s.WriteString(fk.clause(m.Dialect))
+			}
+		}
 		s.WriteString(") ")
 		s.WriteString(m.Dialect.CreateTableSuffix())
 		s.WriteString(";")
-		_, err = m.Exec(s.String())
+		err = m.execAudited(m, s.String())
 		if err != nil {
-			break
+			return err
+		}
+		if table.closure != nil {
+			err = m.execAudited(m, table.closure.createSql(table, ifNotExists))
+			if err != nil {
+				return err
+			}
+		}
+		if table.auditTrigger && !ifNotExists {
+			err = m.createAuditTriggers(table)
+			if err != nil {
+				return err
+			}
 		}
 	}
-	return err
+	if ifNotExists {
+		// Tables that already existed already have their
+		// constraints, and adding a constraint twice is an error.
+		return nil
+	}
+	return m.addDeferredForeignKeys(tables, deferred)
 }
 
 // DropTable drops an individual table.  Will throw an error
@@ -836,20 +1109,27 @@ func (m *DbMap) DropTableIfExists(table interface{}) error {
 // DropTables iterates through TableMaps registered to this DbMap and
 // executes "drop table" statements against the database for each.
 func (m *DbMap) DropTables() error {
-	return m.dropTables(false)
+	return m.dropTables(false, nil)
 }
 
 // DropTablesIfExists is the same as DropTables, but uses the "if exists" clause to
 // avoid errors for tables that do not exist.
 func (m *DbMap) DropTablesIfExists() error {
-	return m.dropTables(true)
+	return m.dropTables(true, nil)
 }
 
-// Goes through all the registered tables, dropping them one by one.
-// If an error is encountered, then it is returned and the rest of
-// the tables are not dropped.
-func (m *DbMap) dropTables(addIfExists bool) (err error) {
-	for _, table := range m.tables {
+// Goes through the registered tables for which match returns true (or
+// all of them if match is nil), dropping them one by one,
+// before the tables their foreign keys reference.  If an error is
+// encountered, then it is returned and the rest of the tables are not
+// dropped.
+func (m *DbMap) dropTables(addIfExists bool, match func(*TableMap) bool) (err error) {
+	tables, err := m.dropOrder()
+	if err != nil {
+		return err
+	}
+	tables = filterTables(tables, match)
+	for _, table := range tables {
 		err = m.dropTableImpl(table, addIfExists)
 		if err != nil {
 			return
@@ -869,12 +1149,26 @@ func (m *DbMap) dropTable(t reflect.Type, addIfExists bool) error {
 }
 
 func (m *DbMap) dropTableImpl(table *TableMap, addIfExists bool) (err error) {
+	if err = m.checkWritable(); err != nil {
+		return err
+	}
 	ifExists := ""
 	if addIfExists {
 		ifExists = " if exists"
 	}
-	_, err = m.Exec(fmt.Sprintf("drop table%s %s;", ifExists, m.Dialect.QuotedTableForQuery(table.SchemaName, table.TableName)))
-	return err
+	if table.closure != nil {
+		err = m.execAudited(m, fmt.Sprintf("drop table%s %s;", ifExists, table.closure.quotedName(m.Dialect)))
+		if err != nil {
+			return err
+		}
+	}
+	err = m.execAudited(m, fmt.Sprintf("drop table%s %s;", ifExists, m.Dialect.QuotedTableForQuery(table.SchemaName, table.TableName)))
+	if err != nil || !table.auditTrigger {
+		return err
+	}
+	// The table's triggers were dropped with it, and its audit table
+	// is kept (see DropAuditTables).
+	return m.dropAuditTriggers(table)
 }
 
 // TruncateTables iterates through TableMaps registered to this DbMap and
@@ -882,10 +1176,13 @@ func (m *DbMap) dropTableImpl(table *TableMap, addIfExists bool) (err error) {
 // sqlite, a "delete from" with no "where" clause, which uses the truncate optimization
 // (http://www.sqlite.org/lang_delete.html)
 func (m *DbMap) TruncateTables() error {
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
 	var err error
 	for i := range m.tables {
 		table := m.tables[i]
-		_, e := m.Exec(fmt.Sprintf("%s %s;", m.Dialect.TruncateClause(), m.Dialect.QuotedTableForQuery(table.SchemaName, table.TableName)))
+		e := m.execAudited(m, fmt.Sprintf("%s %s;", m.Dialect.TruncateClause(), m.Dialect.QuotedTableForQuery(table.SchemaName, table.TableName)))
 		if e != nil {
 			err = e
 		}
@@ -928,6 +1225,13 @@ func (m *DbMap) Query(target interface{}) Query {
 	return query(m, m, target)
 }
 
+// QueryContext is the same as Query, but the generated query plan
+// will run within ctx.  Per-request options stored in the context
+// (e.g. WithMemo) will be applied to the query.
+func (m *DbMap) QueryContext(ctx context.Context, target interface{}) Query {
+	return queryContext(ctx, m, m, target)
+}
+
 // Insert runs a SQL INSERT statement for each element in list.  List
 // items must be pointers.
 //
@@ -1016,6 +1320,7 @@ func (m *DbMap) Select(i interface{}, query string, args ...interface{}) ([]inte
 // This is equivalent to running:  Exec() using database/sql
 func (m *DbMap) Exec(query string, args ...interface{}) (sql.Result, error) {
 	m.trace(query, args...)
+	defer m.traceSlow(time.Now(), query, args)
 	return m.Db.Exec(query, args...)
 }
 
@@ -1061,7 +1366,14 @@ func (m *DbMap) Begin() (*Transaction, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Transaction{m, tx, false}, nil
+	return &Transaction{dbmap: m, tx: tx}, nil
+}
+
+// TableFor returns the TableMap registered for t, a struct type, or
+// an error if there is none.  If checkPK is true, it also returns an
+// error if the table has no primary key.
+func (m *DbMap) TableFor(t reflect.Type, checkPK bool) (*TableMap, error) {
+	return m.tableFor(t, checkPK)
 }
 
 func (m *DbMap) tableFor(t reflect.Type, checkPK bool) (*TableMap, error) {
@@ -1108,17 +1420,19 @@ func (m *DbMap) tableForPointer(ptr interface{}, checkPK bool) (*TableMap, refle
 
 func (m *DbMap) queryRow(query string, args ...interface{}) *sql.Row {
 	m.trace(query, args...)
+	defer m.traceSlow(time.Now(), query, args)
 	return m.Db.QueryRow(query, args...)
 }
 
 func (m *DbMap) query(query string, args ...interface{}) (*sql.Rows, error) {
 	m.trace(query, args...)
+	defer m.traceSlow(time.Now(), query, args)
 	return m.Db.Query(query, args...)
 }
 
 func (m *DbMap) trace(query string, args ...interface{}) {
 	if m.logger != nil {
-		m.logger.Printf("%s%s %v", m.logPrefix, query, args)
+		m.logger.Printf("%s%s %v", m.logPrefix, query, m.scrubArgs(query, args))
 	}
 }
 
@@ -1128,6 +1442,12 @@ func (t *Transaction) Query(target interface{}) Query {
 	return query(t.dbmap, t, target)
 }
 
+// QueryContext has the same behavior as DbMap.QueryContext(), but
+// runs in a transaction.
+func (t *Transaction) QueryContext(ctx context.Context, target interface{}) Query {
+	return queryContext(ctx, t.dbmap, t, target)
+}
+
 // Insert has the same behavior as DbMap.Insert(), but runs in a transaction.
 func (t *Transaction) Insert(list ...interface{}) error {
 	return insert(t.dbmap, t, list...)
@@ -1156,6 +1476,7 @@ func (t *Transaction) Select(i interface{}, query string, args ...interface{}) (
 // Exec has the same behavior as DbMap.Exec(), but runs in a transaction.
 func (t *Transaction) Exec(query string, args ...interface{}) (sql.Result, error) {
 	t.dbmap.trace(query, args...)
+	defer t.dbmap.traceSlow(time.Now(), query, args)
 	return t.tx.Exec(query, args...)
 }
 
@@ -1194,8 +1515,13 @@ func (t *Transaction) SelectOne(holder interface{}, query string, args ...interf
 	return SelectOne(t.dbmap, t, holder, query, args...)
 }
 
-// Commit commits the underlying database transaction.
+// Commit commits the underlying database transaction, or releases the
+// savepoint of a nested transaction (see Nested).
 func (t *Transaction) Commit() error {
+	if !t.closed && t.savepoint != "" {
+		t.closed = true
+		return t.ReleaseSavepoint(t.savepoint)
+	}
 	if !t.closed {
 		t.closed = true
 		t.dbmap.trace("commit;")
@@ -1205,8 +1531,13 @@ func (t *Transaction) Commit() error {
 	return sql.ErrTxDone
 }
 
-// Rollback rolls back the underlying database transaction.
+// Rollback rolls back the underlying database transaction, or rolls
+// back to the savepoint of a nested transaction (see Nested).
 func (t *Transaction) Rollback() error {
+	if !t.closed && t.savepoint != "" {
+		t.closed = true
+		return t.RollbackToSavepoint(t.savepoint)
+	}
 	if !t.closed {
 		t.closed = true
 		t.dbmap.trace("rollback;")
@@ -1246,13 +1577,34 @@ func (t *Transaction) ReleaseSavepoint(savepoint string) error {
 	return err
 }
 
+// Nested creates a savepoint with the given name and returns a
+// transaction that runs in this one, where Commit releases the
+// savepoint and Rollback rolls back to it.  This lets code that
+// commits or rolls back its own transaction run inside a transaction
+// that is rolled back afterwards, e.g. in tests.  The name is
+// interpolated directly into the SQL, like Savepoint.
+func (t *Transaction) Nested(name string) (*Transaction, error) {
+	if t.closed {
+		return nil, sql.ErrTxDone
+	}
+	if err := t.Savepoint(name); err != nil {
+		return nil, err
+	}
+	if t.nestedCount == nil {
+		t.nestedCount = new(int)
+	}
+	return &Transaction{dbmap: t.dbmap, tx: t.tx, savepoint: name, nestedCount: t.nestedCount}, nil
+}
+
 func (t *Transaction) queryRow(query string, args ...interface{}) *sql.Row {
 	t.dbmap.trace(query, args...)
+	defer t.dbmap.traceSlow(time.Now(), query, args)
 	return t.tx.QueryRow(query, args...)
 }
 
 func (t *Transaction) query(query string, args ...interface{}) (*sql.Rows, error) {
 	t.dbmap.trace(query, args...)
+	defer t.dbmap.traceSlow(time.Now(), query, args)
 	return t.tx.Query(query, args...)
 }
 
@@ -1400,6 +1752,13 @@ func selectVal(e SqlExecutor, holder interface{}, query string, args ...interfac
 
 func hookedselect(m *DbMap, exec SqlExecutor, i interface{}, query string,
 	args ...interface{}) ([]interface{}, error) {
+	return maskedselect(m, exec, nil, i, query, args...)
+}
+
+// maskedselect is hookedselect, but applies the masks of the masked
+// columns to each row before its PostGet hook runs.
+func maskedselect(m *DbMap, exec SqlExecutor, masked []*ColumnMap, i interface{}, query string,
+	args ...interface{}) ([]interface{}, error) {
 
 	list, err := rawselect(m, exec, i, query, args...)
 	if err != nil {
@@ -1409,6 +1768,7 @@ func hookedselect(m *DbMap, exec SqlExecutor, i interface{}, query string,
 	// Determine where the results are: written to i, or returned in list
 	if t, _ := toSliceType(i); t == nil {
 		for _, v := range list {
+			maskRow(masked, reflect.ValueOf(v))
 			if v, ok := v.(HasPostGet); ok {
 				err := v.PostGet(exec)
 				if err != nil {
@@ -1419,6 +1779,7 @@ func hookedselect(m *DbMap, exec SqlExecutor, i interface{}, query string,
 	} else {
 		resultsValue := reflect.Indirect(reflect.ValueOf(i))
 		for i := 0; i < resultsValue.Len(); i++ {
+			maskRow(masked, resultsValue.Index(i))
 			if v, ok := resultsValue.Index(i).Interface().(HasPostGet); ok {
 				err := v.PostGet(exec)
 				if err != nil {
@@ -1476,19 +1837,11 @@ func rawselect(m *DbMap, exec SqlExecutor, i interface{}, query string,
 		return nil, err
 	}
 
-	if !intoStruct && len(cols) > 1 {
-		return nil, fmt.Errorf("gorp: select into non-struct slice requires 1 column, got %d", len(cols))
-	}
-
-	var colToFieldIndex [][]int
-	if intoStruct {
-		if colToFieldIndex, err = columnToFieldIndex(m, t, cols); err != nil {
-			return nil, err
-		}
+	scanner, err := newRowScanner(m, t, cols, intoStruct)
+	if err != nil {
+		return nil, err
 	}
 
-	conv := m.TypeConverter
-
 	// Add results to one of these two slices.
 	var (
 		list       = make([]interface{}, 0)
@@ -1505,38 +1858,10 @@ func rawselect(m *DbMap, exec SqlExecutor, i interface{}, query string,
 			break
 		}
 		v := reflect.New(t)
-		dest := make([]interface{}, len(cols))
-
-		custScan := make([]CustomScanner, 0)
-
-		for x := range cols {
-			f := v.Elem()
-			if intoStruct {
-				f = f.FieldByIndex(colToFieldIndex[x])
-			}
-			target := f.Addr().Interface()
-			if conv != nil {
-				scanner, ok := conv.FromDb(target)
-				if ok {
-					target = scanner.Holder
-					custScan = append(custScan, scanner)
-				}
-			}
-			dest[x] = target
-		}
-
-		err = rows.Scan(dest...)
-		if err != nil {
+		if err = scanner.scan(rows, v); err != nil {
 			return nil, err
 		}
 
-		for _, c := range custScan {
-			err = c.Bind()
-			if err != nil {
-				return nil, err
-			}
-		}
-
 		if appendToSlice {
 			if !pointerElements {
 				v = v.Elem()
@@ -1554,6 +1879,140 @@ func rawselect(m *DbMap, exec SqlExecutor, i interface{}, query string,
 	return list, nil
 }
 
+// eachRow runs query and scans each row into a value of type t (which
+// must be a struct type) allocated by alloc, applying the masks of the
+// masked columns and running any PostGet hook, and then passing the
+// value to handler.  Rows are not accumulated, so handler may recycle
+// the values it is passed.
+func eachRow(m *DbMap, exec SqlExecutor, t reflect.Type, masked []*ColumnMap, query string, args []interface{},
+	alloc func() reflect.Value, handler func(v reflect.Value) error) error {
+
+	if len(args) == 1 {
+		query, args = maybeExpandNamedQuery(m, query, args)
+	}
+	rows, err := openRows(m, exec, t, query, args)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	rows.masked = masked
+
+	for rows.Next() {
+		v := alloc()
+		if err = rows.scan(v); err != nil {
+			return err
+		}
+		if err = handler(v); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// A rowScanner scans rows from a single result set into values of a
+// single type.
+type rowScanner struct {
+	cols            []string
+	intoStruct      bool
+	colToFieldIndex [][]int
+	fieldNames      []string
+	targeter        bool
+	conv            TypeConverter
+
+	// internCols contains the indexes of columns whose values should
+	// be interned, and interned contains the values seen so far.
+	internCols []int
+	interned   map[string]string
+}
+
+// newRowScanner prepares a rowScanner to scan the passed in columns
+// into values of type t.  If intoStruct is false, t must be a type
+// that can be scanned into directly, and there must be exactly one
+// column.
+func newRowScanner(m *DbMap, t reflect.Type, cols []string, intoStruct bool) (*rowScanner, error) {
+	if !intoStruct && len(cols) > 1 {
+		return nil, fmt.Errorf("gorp: select into non-struct slice requires 1 column, got %d", len(cols))
+	}
+	scanner := &rowScanner{cols: cols, intoStruct: intoStruct, conv: m.TypeConverter}
+	if intoStruct {
+		var err error
+		if scanner.colToFieldIndex, err = columnToFieldIndex(m, t, cols); err != nil {
+			return nil, err
+		}
+		// Types with generated field accessors can skip reflection
+		// when building scan targets for each row.
+		if scanner.targeter = reflect.PtrTo(t).Implements(fieldTargeterType); scanner.targeter {
+			scanner.fieldNames = make([]string, len(cols))
+			for x, index := range scanner.colToFieldIndex {
+				scanner.fieldNames[x] = t.FieldByIndex(index).Name
+			}
+		}
+		if table := tableOrNil(m, t); table != nil {
+			for x, index := range scanner.colToFieldIndex {
+				field := t.FieldByIndex(index)
+				col := colMapOrNil(table, field.Name)
+				if col != nil && col.isInterned && (field.Type.Kind() == reflect.String || field.Type == nullStringType) {
+					scanner.internCols = append(scanner.internCols, x)
+				}
+			}
+			if len(scanner.internCols) > 0 {
+				scanner.interned = make(map[string]string)
+			}
+		}
+	}
+	return scanner, nil
+}
+
+// scan scans the current row into v, which must be a pointer to the
+// type that the scanner was created for.
+func (s *rowScanner) scan(rows *sql.Rows, v reflect.Value) error {
+	dest := make([]interface{}, len(s.cols))
+	custScan := make([]CustomScanner, 0)
+
+	if !s.targeter || !v.Interface().(FieldTargeter).FieldTargets(s.fieldNames, dest) {
+		for x := range s.cols {
+			f := v.Elem()
+			if s.intoStruct {
+				f = f.FieldByIndex(s.colToFieldIndex[x])
+			}
+			dest[x] = f.Addr().Interface()
+		}
+	}
+	if s.conv != nil {
+		for x, target := range dest {
+			scanner, ok := s.conv.FromDb(target)
+			if ok {
+				dest[x] = scanner.Holder
+				custScan = append(custScan, scanner)
+			}
+		}
+	}
+
+	if err := rows.Scan(dest...); err != nil {
+		return err
+	}
+
+	for _, c := range custScan {
+		if err := c.Bind(); err != nil {
+			return err
+		}
+	}
+
+	for _, x := range s.internCols {
+		f := v.Elem().FieldByIndex(s.colToFieldIndex[x])
+		if f.Type() == nullStringType {
+			f = f.Field(0)
+		}
+		str := f.String()
+		if existing, ok := s.interned[str]; ok {
+			f.SetString(existing)
+		} else {
+			s.interned[str] = str
+		}
+	}
+	return nil
+}
+
 // maybeExpandNamedQuery checks the given arg to see if it's eligible to be used
 // as input to a named query.  If so, it rewrites the query to use
 // dialect-dependent bindvars and instantiates the corresponding slice of
@@ -1722,17 +2181,15 @@ func get(m *DbMap, exec SqlExecutor, i interface{},
 	conv := m.TypeConverter
 	custScan := make([]CustomScanner, 0)
 
-	for x, fieldName := range plan.argFields {
-		f := v.Elem().FieldByName(fieldName)
-		target := f.Addr().Interface()
-		if conv != nil {
+	fieldTargets(v.Elem(), plan.argFields, dest)
+	if conv != nil {
+		for x, target := range dest {
 			scanner, ok := conv.FromDb(target)
 			if ok {
-				target = scanner.Holder
+				dest[x] = scanner.Holder
 				custScan = append(custScan, scanner)
 			}
 		}
-		dest[x] = target
 	}
 
 	row := exec.queryRow(plan.query, keys...)
@@ -1761,7 +2218,52 @@ func get(m *DbMap, exec SqlExecutor, i interface{},
 	return v.Interface(), nil
 }
 
+// A writeOp is a type of write made to a table by Insert(), Update(),
+// or Delete().
+type writeOp int
+
+const (
+	writeInsert writeOp = iota
+	writeUpdate
+	writeDelete
+)
+
+// afterWrite keeps anything that depends on this table's rows (shadow
+// tables, closure tables, counter caches, denormalized columns) in sync
+// after op was run for elem.
+func (t *TableMap) afterWrite(exec SqlExecutor, op writeOp, elem reflect.Value) error {
+	if err := t.writeShadow(exec, op, elem); err != nil {
+		return err
+	}
+	if err := t.writeClosure(exec, op, elem); err != nil {
+		return err
+	}
+	if err := t.writeCounters(exec, op, elem); err != nil {
+		return err
+	}
+	return t.writeDenormalized(exec, op, elem)
+}
+
 func delete(m *DbMap, exec SqlExecutor, list ...interface{}) (int64, error) {
+	if err := m.checkMutable(); err != nil {
+		return -1, err
+	}
+	if m.hasCascades(list) {
+		// Children are deleted in the same transaction as their
+		// parents.
+		tx, txExec, err := beginFor(exec)
+		if err != nil {
+			return -1, err
+		}
+		if tx != nil {
+			count, err := delete(m, txExec, list...)
+			if err != nil {
+				tx.Rollback()
+				return -1, err
+			}
+			return count, tx.Commit()
+		}
+	}
 	count := int64(0)
 	for _, ptr := range list {
 		table, elem, err := m.tableForPointer(ptr, true)
@@ -1782,6 +2284,20 @@ func delete(m *DbMap, exec SqlExecutor, list ...interface{}) (int64, error) {
 			return -1, err
 		}
 
+		if err = table.throttleWrite(executorContext(exec)); err != nil {
+			return -1, err
+		}
+
+		if len(table.cascades()) > 0 {
+			if err = table.checkCascades(); err != nil {
+				return -1, err
+			}
+			key := elem.FieldByName(table.keys[0].fieldName).Interface()
+			if err = table.cascadeDelete(exec, m.Dialect.BindVar(0), []interface{}{key}); err != nil {
+				return -1, err
+			}
+		}
+
 		res, err := exec.Exec(bi.query, bi.args...)
 		if err != nil {
 			return -1, err
@@ -1791,13 +2307,17 @@ func delete(m *DbMap, exec SqlExecutor, list ...interface{}) (int64, error) {
 			return -1, err
 		}
 
-		if rows == 0 && bi.existingVersion > 0 {
+		if rows == 0 && bi.versionChecked() {
 			return lockError(m, exec, table.TableName,
 				bi.existingVersion, elem, bi.keys...)
 		}
 
 		count += rows
 
+		if err = table.afterWrite(exec, writeDelete, elem); err != nil {
+			return -1, err
+		}
+
 		if v, ok := eval.(HasPostDelete); ok {
 			err := v.PostDelete(exec)
 			if err != nil {
@@ -1810,6 +2330,9 @@ func delete(m *DbMap, exec SqlExecutor, list ...interface{}) (int64, error) {
 }
 
 func update(m *DbMap, exec SqlExecutor, list ...interface{}) (int64, error) {
+	if err := m.checkMutable(); err != nil {
+		return -1, err
+	}
 	count := int64(0)
 	for _, ptr := range list {
 		table, elem, err := m.tableForPointer(ptr, true)
@@ -1825,11 +2348,30 @@ func update(m *DbMap, exec SqlExecutor, list ...interface{}) (int64, error) {
 			}
 		}
 
+		if err = table.validate(elem); err != nil {
+			return -1, err
+		}
+
+		if v, ok := eval.(HasValidate); ok {
+			if err = v.Validate(exec); err != nil {
+				return -1, err
+			}
+		}
+
 		bi, err := table.bindUpdate(elem)
 		if err != nil {
 			return -1, err
 		}
 
+		swapped, err := table.checkTransitions(exec, &bi, elem)
+		if err != nil {
+			return -1, err
+		}
+
+		if err = table.throttleWrite(executorContext(exec)); err != nil {
+			return -1, err
+		}
+
 		res, err := exec.Exec(bi.query, bi.args...)
 		if err != nil {
 			return -1, err
@@ -1840,17 +2382,27 @@ func update(m *DbMap, exec SqlExecutor, list ...interface{}) (int64, error) {
 			return -1, err
 		}
 
-		if rows == 0 && bi.existingVersion > 0 {
+		if rows == 0 && bi.versionChecked() {
 			return lockError(m, exec, table.TableName,
 				bi.existingVersion, elem, bi.keys...)
 		}
 
+		if rows == 0 && swapped != nil {
+			if err = table.staleTransition(exec, bi, swapped); err != nil {
+				return -1, err
+			}
+		}
+
 		if bi.versField != "" {
-			elem.FieldByName(bi.versField).SetInt(bi.existingVersion + 1)
+			elem.FieldByName(bi.versField).Set(reflect.ValueOf(bi.newVersion))
 		}
 
 		count += rows
 
+		if err = table.afterWrite(exec, writeUpdate, elem); err != nil {
+			return -1, err
+		}
+
 		if v, ok := eval.(HasPostUpdate); ok {
 			err = v.PostUpdate(exec)
 			if err != nil {
@@ -1862,6 +2414,9 @@ func update(m *DbMap, exec SqlExecutor, list ...interface{}) (int64, error) {
 }
 
 func insert(m *DbMap, exec SqlExecutor, list ...interface{}) error {
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
 	for _, ptr := range list {
 		table, elem, err := m.tableForPointer(ptr, false)
 		if err != nil {
@@ -1876,11 +2431,25 @@ func insert(m *DbMap, exec SqlExecutor, list ...interface{}) error {
 			}
 		}
 
+		if err = table.validate(elem); err != nil {
+			return err
+		}
+
+		if v, ok := eval.(HasValidate); ok {
+			if err = v.Validate(exec); err != nil {
+				return err
+			}
+		}
+
 		bi, err := table.bindInsert(elem)
 		if err != nil {
 			return err
 		}
 
+		if err = table.throttleWrite(executorContext(exec)); err != nil {
+			return err
+		}
+
 		if bi.autoIncrIdx > -1 {
 			f := elem.FieldByName(bi.autoIncrFieldName)
 			switch inserter := m.Dialect.(type) {
@@ -1912,6 +2481,10 @@ func insert(m *DbMap, exec SqlExecutor, list ...interface{}) error {
 			}
 		}
 
+		if err = table.afterWrite(exec, writeInsert, elem); err != nil {
+			return err
+		}
+
 		if v, ok := eval.(HasPostInsert); ok {
 			err := v.PostInsert(exec)
 			if err != nil {
@@ -1923,7 +2496,7 @@ func insert(m *DbMap, exec SqlExecutor, list ...interface{}) error {
 }
 
 func lockError(m *DbMap, exec SqlExecutor, tableName string,
-	existingVer int64, elem reflect.Value,
+	existingVer interface{}, elem reflect.Value,
 	keys ...interface{}) (int64, error) {
 
 	existing, err := get(m, exec, elem.Interface(), keys...)
@@ -1931,7 +2504,10 @@ func lockError(m *DbMap, exec SqlExecutor, tableName string,
 		return -1, err
 	}
 
-	ole := OptimisticLockError{tableName, keys, true, existingVer}
+	ole := OptimisticLockError{TableName: tableName, Keys: keys, RowExists: true, LocalVersionValue: existingVer}
+	if v := reflect.ValueOf(existingVer); v.Kind() >= reflect.Int && v.Kind() <= reflect.Int64 {
+		ole.LocalVersion = v.Int()
+	}
 	if existing == nil {
 		ole.RowExists = false
 	}
@@ -1972,3 +2548,11 @@ type HasPreUpdate interface {
 type HasPreInsert interface {
 	PreInsert(SqlExecutor) error
 }
+
+// Validate() will be executed before INSERT and UPDATE statements,
+// after PreInsert() or PreUpdate() and once the columns' values are
+// valid (see ColumnMap.Validate), to check the row as a whole.  Its
+// error is returned by the write, which is skipped.
+type HasValidate interface {
+	Validate(SqlExecutor) error
+}
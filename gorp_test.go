@@ -2,6 +2,7 @@ package gorp
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -10,10 +11,12 @@ import (
 	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 	_ "github.com/ziutek/mymysql/godrv"
+	"io"
 	"log"
 	"os"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -41,6 +44,92 @@ type Person struct {
 	Version int64
 }
 
+type PolicyVersion struct {
+	Id            int64
+	Premium       int64
+	EffectiveFrom time.Time
+	EffectiveTo   *time.Time
+}
+
+type Author struct {
+	Id             int64
+	Name           string
+	FavoriteBookId *int64
+}
+
+type Book struct {
+	Id       int64
+	Title    string
+	AuthorId int64
+}
+
+type TaggedInvoice struct {
+	Id   int64 `gorp:"table=tagged_invoices,pk=Id,autoincrement"`
+	Memo string
+}
+
+type TaggedLineKey struct {
+	InvoiceId int64 `gorp:"schema=billing, table=tagged_lines, pk=InvoiceId, pk=Line"`
+	Line      int64
+}
+
+type TaggedLine struct {
+	TaggedLineKey
+	Amount int64
+}
+
+type PolymorphicComment struct {
+	Id              int64
+	Body            string
+	CommentableType string
+	CommentableId   int64
+	Commentable     interface{} `db:"-"`
+}
+
+type PersonWithMemos struct {
+	Id    int64
+	FName string
+	Memos string `db:"-"`
+}
+
+type CountedPerson struct {
+	Id           int64
+	Name         string
+	InvoiceCount int64
+}
+
+type TreeNode struct {
+	Id       int64
+	ParentId int64
+	Name     string
+}
+
+type InvoiceWithPerson struct {
+	Invoice
+	Person *Person `db:"-"`
+}
+
+type PersonWithInvoices struct {
+	Person
+	Invoices []Invoice `db:"-"`
+}
+
+type Document struct {
+	Id   int64
+	Body string
+	ETag string
+}
+
+type Label struct {
+	Id   int64
+	Name string
+}
+
+type InvoiceLabel struct {
+	InvoiceId int64
+	LabelId   int64
+}
+
 type InvoicePersonView struct {
 	InvoiceId     int64
 	PersonId      int64
@@ -655,6 +744,37 @@ func TestOptimisticLocking(t *testing.T) {
 	}
 }
 
+func TestQueryPlanOptimisticLocking(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	p1 := &Person{0, 0, 0, "Bob", "Smith", 0}
+	dbmap.Insert(p1)
+	obj, err := dbmap.Get(Person{}, p1.Id)
+	if err != nil {
+		panic(err)
+	}
+	p2 := obj.(*Person)
+	count, err := dbmap.Query(p2).Assign(&p2.LName, "Edwards").Where().Equal(&p2.Id, p2.Id).Update()
+	if err != nil {
+		t.Fatalf("Failed to update: %s", err)
+	}
+	if count != 1 || p2.Version != 2 {
+		t.Errorf("Expected one row at version 2, got %d rows at version %d", count, p2.Version)
+	}
+
+	count, err = dbmap.Query(p1).Assign(&p1.LName, "Howard").Where().Equal(&p1.Id, p1.Id).Update()
+	if ole, ok := err.(OptimisticLockError); !ok || !ole.RowExists {
+		t.Errorf("Expected OptimisticLockError for an existing row, got: %v", err)
+	}
+	if count != -1 {
+		t.Errorf("Expected -1 count, got: %d", count)
+	}
+	if p1.Version != 1 {
+		t.Errorf("Expected a failed update to keep the version, got %d", p1.Version)
+	}
+}
+
 // what happens if a legacy table has a null value?
 func TestDoubleAddTable(t *testing.T) {
 	dbmap := newDbMap()
@@ -895,6 +1015,81 @@ func TestSavepoint(t *testing.T) {
 	}
 }
 
+func TestNestedTransaction(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	trans, err := dbmap.Begin()
+	if err != nil {
+		panic(err)
+	}
+	nested, err := trans.Nested("nested")
+	if err != nil {
+		panic(err)
+	}
+	if err = nested.Insert(&Invoice{0, 100, 200, "committed", 0, false}); err != nil {
+		panic(err)
+	}
+	if err = nested.Commit(); err != nil {
+		t.Errorf("Failed to release savepoint: %s", err)
+	}
+
+	nested, err = trans.Nested("nested")
+	if err != nil {
+		panic(err)
+	}
+	if err = nested.Insert(&Invoice{0, 100, 200, "rolled back", 0, false}); err != nil {
+		panic(err)
+	}
+	if err = nested.Rollback(); err != nil {
+		t.Errorf("Failed to roll back to savepoint: %s", err)
+	}
+
+	count, err := trans.SelectInt("select count(*) from invoice_test")
+	if err != nil {
+		panic(err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 invoice in the outer transaction, got %d", count)
+	}
+	if err = trans.Rollback(); err != nil {
+		panic(err)
+	}
+	if count, _ = dbmap.SelectInt("select count(*) from invoice_test"); count != 0 {
+		t.Errorf("Expected the outer rollback to remove every invoice, got %d", count)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	inv1 := &Invoice{0, 100, 200, "kept", 0, false}
+	inv2 := &Invoice{0, 100, 200, "changed", 0, false}
+	_insert(dbmap, inv1, inv2)
+
+	snapshot, err := dbmap.Snapshot(Invoice{})
+	if err != nil {
+		panic(err)
+	}
+	inv2.Memo = "updated"
+	_update(dbmap, inv2)
+	_del(dbmap, inv1)
+	_insert(dbmap, &Invoice{0, 100, 200, "added", 0, false})
+
+	if err = snapshot.Restore(); err != nil {
+		t.Fatalf("Failed to restore snapshot: %s", err)
+	}
+	var invoices []*Invoice
+	_, err = dbmap.Select(&invoices, "select * from invoice_test order by id")
+	if err != nil {
+		panic(err)
+	}
+	if len(invoices) != 2 || invoices[0].Id != inv1.Id || invoices[0].Memo != "kept" || invoices[1].Memo != "changed" {
+		t.Errorf("Expected the snapshot's invoices to be restored, got %v", invoices)
+	}
+}
+
 func TestMultiple(t *testing.T) {
 	dbmap := initDbMap()
 	defer dropAndClose(dbmap)
@@ -1766,3 +1961,856 @@ func _rawselect(dbmap *DbMap, i interface{}, query string, args ...interface{})
 	}
 	return list
 }
+
+func TestGenerateFieldAccessors(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+	dbmap.AddTableWithName(WithIgnoredColumn{}, "ignored_column_test").SetKeys(true, "Id")
+
+	buffer := bytes.Buffer{}
+	err := dbmap.GenerateFieldAccessors(&buffer, "models")
+	if err != nil {
+		t.Fatalf("Failed to generate field accessors: %s", err)
+	}
+	source := buffer.String()
+	for _, expected := range []string{
+		"package models",
+		"func (m *Invoice) FieldTargets(fields []string, targets []interface{}) bool {",
+		"func (m *Invoice) FieldValue(field string) (interface{}, bool) {",
+		"targets[i] = &m.Memo",
+		"return m.IsPaid, true",
+		"func (m *WithIgnoredColumn) FieldTargets(",
+	} {
+		if !strings.Contains(source, expected) {
+			t.Errorf("Expected generated source to contain %q:\n%s", expected, source)
+		}
+	}
+	if strings.Contains(source, "m.internal") {
+		t.Errorf("Expected transient fields to be skipped:\n%s", source)
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	invoiceType := reflect.TypeOf(Invoice{})
+	first := CacheKey(invoiceType, "select * from invoice_test where Id=?", []interface{}{int64(1)})
+	second := CacheKey(invoiceType, "select * from invoice_test where Id=?", []interface{}{int64(1)})
+	if first != second {
+		t.Errorf("Expected identical parts to produce identical keys: %q != %q", first, second)
+	}
+	for _, other := range []string{
+		CacheKey(invoiceType, "select * from invoice_test where Id=?", []interface{}{int64(2)}),
+		CacheKey(invoiceType, "select * from invoice_test where Id=?", []interface{}{"1"}),
+		CacheKey(reflect.TypeOf(Person{}), "select * from invoice_test where Id=?", []interface{}{int64(1)}),
+	} {
+		if other == first {
+			t.Errorf("Expected different parts to produce different keys: %q", other)
+		}
+	}
+}
+
+func TestLoader(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	inv1 := &Invoice{0, 100, 200, "loader 1", 0, false}
+	inv2 := &Invoice{0, 100, 200, "loader 2", 0, false}
+	_insert(dbmap, inv1, inv2)
+
+	loader, err := LoaderFor(dbmap, Invoice{})
+	if err != nil {
+		t.Fatalf("Failed to create loader: %s", err)
+	}
+	loader.Wait = 10 * time.Millisecond
+
+	keys := []interface{}{inv1.Id, int(inv2.Id), inv2.Id + 100}
+	results := make([]interface{}, len(keys))
+	errs := make([]error, len(keys))
+	var wg sync.WaitGroup
+	for index, key := range keys {
+		wg.Add(1)
+		go func(index int, key interface{}) {
+			defer wg.Done()
+			results[index], errs[index] = loader.Load(key)
+		}(index, key)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("Failed to load: %s", err)
+		}
+	}
+	if inv, ok := results[0].(*Invoice); !ok || inv.Memo != "loader 1" {
+		t.Errorf("Expected first invoice, got %#v", results[0])
+	}
+	if inv, ok := results[1].(*Invoice); !ok || inv.Memo != "loader 2" {
+		t.Errorf("Expected second invoice, got %#v", results[1])
+	}
+	if results[2] != nil {
+		t.Errorf("Expected nil for a missing key, got %#v", results[2])
+	}
+}
+
+func TestLoadPolymorphic(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+	dbmap.AddTableWithName(PolymorphicComment{}, "polymorphic_comment_test").SetKeys(true, "Id").
+		Polymorphic("commentable", "CommentableType", "CommentableId", "Commentable").
+		Type("invoice", Invoice{}).
+		Type("person", Person{})
+	if err := dbmap.CreateTablesIfNotExists(); err != nil {
+		panic(err)
+	}
+
+	inv := &Invoice{0, 100, 200, "commented", 0, false}
+	p := &Person{0, 0, 0, "bob", "smith", 0}
+	_insert(dbmap, inv, p)
+	_insert(dbmap,
+		&PolymorphicComment{Body: "on invoice", CommentableType: "invoice", CommentableId: inv.Id},
+		&PolymorphicComment{Body: "on person", CommentableType: "person", CommentableId: p.Id},
+		&PolymorphicComment{Body: "on nothing"})
+
+	var comments []*PolymorphicComment
+	_, err := dbmap.Select(&comments, "select * from polymorphic_comment_test order by Id")
+	if err != nil {
+		t.Fatalf("Failed to select: %s", err)
+	}
+	if err = dbmap.LoadPolymorphic(comments, "commentable"); err != nil {
+		t.Fatalf("Failed to load polymorphic association: %s", err)
+	}
+	if loaded, ok := comments[0].Commentable.(*Invoice); !ok || loaded.Memo != "commented" {
+		t.Errorf("Expected comment to be attached to invoice, got %#v", comments[0].Commentable)
+	}
+	if loaded, ok := comments[1].Commentable.(*Person); !ok || loaded.FName != "bob" {
+		t.Errorf("Expected comment to be attached to person, got %#v", comments[1].Commentable)
+	}
+	if comments[2].Commentable != nil {
+		t.Errorf("Expected comment without a type to be skipped, got %#v", comments[2].Commentable)
+	}
+}
+
+func TestTreeQueries(t *testing.T) {
+	dbmap := newDbMap()
+	dbmap.TraceOn("", log.New(os.Stdout, "gorptest: ", log.Lmicroseconds))
+	dbmap.AddTableWithName(TreeNode{}, "tree_node_test").SetKeys(true, "Id")
+	if err := dbmap.CreateTablesIfNotExists(); err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	root := &TreeNode{Name: "root"}
+	_insert(dbmap, root)
+	child := &TreeNode{ParentId: root.Id, Name: "child"}
+	_insert(dbmap, child)
+	grandchild := &TreeNode{ParentId: child.Id, Name: "grandchild"}
+	_insert(dbmap, grandchild)
+
+	node := new(TreeNode)
+	descendants, err := dbmap.Descendants(node, &node.ParentId, Equal(&node.Id, root.Id), 0)
+	if err != nil {
+		t.Fatalf("Failed to load descendants: %s", err)
+	}
+	if len(descendants) != 2 || descendants[0].(*TreeNode).Name != "child" || descendants[1].(*TreeNode).Name != "grandchild" {
+		t.Errorf("Expected child and grandchild, got %#v", descendants)
+	}
+
+	descendants, err = dbmap.Descendants(node, &node.ParentId, Equal(&node.Id, root.Id), 1)
+	if err != nil {
+		t.Fatalf("Failed to load descendants: %s", err)
+	}
+	if len(descendants) != 1 {
+		t.Errorf("Expected only one level of descendants, got %#v", descendants)
+	}
+
+	ancestors, err := dbmap.Ancestors(node, &node.ParentId, Equal(&node.Id, grandchild.Id), 0)
+	if err != nil {
+		t.Fatalf("Failed to load ancestors: %s", err)
+	}
+	if len(ancestors) != 2 || ancestors[0].(*TreeNode).Name != "child" || ancestors[1].(*TreeNode).Name != "root" {
+		t.Errorf("Expected child and root, got %#v", ancestors)
+	}
+}
+
+func TestClosureTable(t *testing.T) {
+	dbmap := newDbMap()
+	dbmap.TraceOn("", log.New(os.Stdout, "gorptest: ", log.Lmicroseconds))
+	dbmap.AddTableWithName(TreeNode{}, "tree_node_test").SetKeys(true, "Id").
+		SetClosureTable("ParentId", "tree_node_closure_test")
+	if err := dbmap.CreateTablesIfNotExists(); err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	root := &TreeNode{Name: "root"}
+	_insert(dbmap, root)
+	child := &TreeNode{ParentId: root.Id, Name: "child"}
+	_insert(dbmap, child)
+	grandchild := &TreeNode{ParentId: child.Id, Name: "grandchild"}
+	_insert(dbmap, grandchild)
+
+	node := new(TreeNode)
+	descendants, err := dbmap.Descendants(node, &node.ParentId, Equal(&node.Id, root.Id), 0)
+	if err != nil {
+		t.Fatalf("Failed to load descendants: %s", err)
+	}
+	if len(descendants) != 2 || descendants[0].(*TreeNode).Name != "child" || descendants[1].(*TreeNode).Name != "grandchild" {
+		t.Errorf("Expected child and grandchild, got %#v", descendants)
+	}
+
+	// Moving the child to a new root should move its subtree with it.
+	other := &TreeNode{Name: "other"}
+	_insert(dbmap, other)
+	child.ParentId = other.Id
+	_update(dbmap, child)
+
+	descendants, err = dbmap.Descendants(node, &node.ParentId, Equal(&node.Id, root.Id), 0)
+	if err != nil {
+		t.Fatalf("Failed to load descendants: %s", err)
+	}
+	if len(descendants) != 0 {
+		t.Errorf("Expected no descendants after moving the child, got %#v", descendants)
+	}
+	ancestors, err := dbmap.Ancestors(node, &node.ParentId, Equal(&node.Id, grandchild.Id), 0)
+	if err != nil {
+		t.Fatalf("Failed to load ancestors: %s", err)
+	}
+	if len(ancestors) != 2 || ancestors[0].(*TreeNode).Name != "child" || ancestors[1].(*TreeNode).Name != "other" {
+		t.Errorf("Expected child and other, got %#v", ancestors)
+	}
+
+	_del(dbmap, child)
+	ancestors, err = dbmap.Ancestors(node, &node.ParentId, Equal(&node.Id, grandchild.Id), 0)
+	if err != nil {
+		t.Fatalf("Failed to load ancestors: %s", err)
+	}
+	if len(ancestors) != 0 {
+		t.Errorf("Expected no ancestors after deleting the parent, got %#v", ancestors)
+	}
+}
+
+func TestStateTransitions(t *testing.T) {
+	dbmap := newDbMap()
+	dbmap.TraceOn("", log.New(os.Stdout, "gorptest: ", log.Lmicroseconds))
+	dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id").
+		ColMap("Memo").
+		AllowTransition("draft", "sent").
+		AllowTransition("sent", "paid").
+		SetCompareAndSwap(true)
+	if err := dbmap.CreateTablesIfNotExists(); err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	inv := &Invoice{0, 100, 200, "draft", 0, false}
+	_insert(dbmap, inv)
+
+	inv.Memo = "sent"
+	if _, err := dbmap.Update(inv); err != nil {
+		t.Fatalf("Expected draft -> sent to be allowed: %s", err)
+	}
+
+	inv.Memo = "draft"
+	_, err := dbmap.Update(inv)
+	transitionErr, ok := err.(InvalidTransition)
+	if !ok {
+		t.Fatalf("Expected InvalidTransition for sent -> draft, got %v", err)
+	}
+	if transitionErr.From != "sent" || transitionErr.To != "draft" || transitionErr.Stale {
+		t.Errorf("Unexpected transition error: %#v", transitionErr)
+	}
+
+	inv.Memo = "sent"
+	inv.IsPaid = true
+	if _, err = dbmap.Update(inv); err != nil {
+		t.Errorf("Expected updates that don't change state to be allowed: %s", err)
+	}
+}
+
+func TestCounterCache(t *testing.T) {
+	dbmap := newDbMap()
+	dbmap.TraceOn("", log.New(os.Stdout, "gorptest: ", log.Lmicroseconds))
+	dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+	dbmap.AddTableWithName(CountedPerson{}, "counted_person_test").SetKeys(true, "Id").
+		HasMany(Invoice{}, "PersonId").
+		CountedBy("InvoiceCount")
+	if err := dbmap.CreateTablesIfNotExists(); err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	p := &CountedPerson{Name: "bob"}
+	_insert(dbmap, p)
+	inv1 := &Invoice{0, 100, 200, "first", p.Id, false}
+	inv2 := &Invoice{0, 100, 200, "second", p.Id, false}
+	_insert(dbmap, inv1, inv2)
+
+	count, err := dbmap.SelectInt("select InvoiceCount from counted_person_test where Id = "+dbmap.Dialect.BindVar(0), p.Id)
+	if err != nil {
+		t.Fatalf("Failed to select counter: %s", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected counter to be 2 after inserts, got %d", count)
+	}
+
+	_del(dbmap, inv1)
+	count, err = dbmap.SelectInt("select InvoiceCount from counted_person_test where Id = "+dbmap.Dialect.BindVar(0), p.Id)
+	if err != nil {
+		t.Fatalf("Failed to select counter: %s", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected counter to be 1 after delete, got %d", count)
+	}
+}
+
+func TestDenormalize(t *testing.T) {
+	dbmap := newDbMap()
+	dbmap.TraceOn("", log.New(os.Stdout, "gorptest: ", log.Lmicroseconds))
+	dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+	dbmap.AddTableWithName(CountedPerson{}, "counted_person_test").SetKeys(true, "Id").
+		HasMany(Invoice{}, "PersonId").
+		Denormalize("Name", "Memo")
+	if err := dbmap.CreateTablesIfNotExists(); err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	p := &CountedPerson{Name: "bob"}
+	_insert(dbmap, p)
+	inv := &Invoice{0, 100, 200, "bob", p.Id, false}
+	other := &Invoice{0, 100, 200, "someone else", p.Id + 1, false}
+	_insert(dbmap, inv, other)
+
+	p.Name = "robert"
+	_update(dbmap, p)
+
+	obj, err := dbmap.Get(Invoice{}, inv.Id)
+	if err != nil {
+		t.Fatalf("Failed to get invoice: %s", err)
+	}
+	if memo := obj.(*Invoice).Memo; memo != "robert" {
+		t.Errorf("Expected denormalized name to be copied to invoice, got %s", memo)
+	}
+	obj, err = dbmap.Get(Invoice{}, other.Id)
+	if err != nil {
+		t.Fatalf("Failed to get invoice: %s", err)
+	}
+	if memo := obj.(*Invoice).Memo; memo != "someone else" {
+		t.Errorf("Expected other invoices to be left alone, got %s", memo)
+	}
+}
+
+func TestPeriodicRunner(t *testing.T) {
+	dbmap := newDbMap()
+	defer dbmap.Db.Close()
+	if _, ok := dbmap.Dialect.(AdvisoryLocker); !ok {
+		if _, err := dbmap.StartPeriodic("gorp_test", time.Millisecond, nil, nil); err == nil {
+			t.Errorf("Expected an error for a dialect without advisory locks")
+		}
+		return
+	}
+
+	var mu sync.Mutex
+	runs := make(map[int]int)
+	runners := make([]*PeriodicRunner, 2)
+	for i := range runners {
+		i := i
+		runner, err := dbmap.StartPeriodic("gorp_test", 10*time.Millisecond, func(m *DbMap) error {
+			mu.Lock()
+			defer mu.Unlock()
+			runs[i]++
+			return nil
+		}, func(err error) {
+			t.Errorf("Runner %d failed: %s", i, err)
+		})
+		if err != nil {
+			t.Fatalf("Failed to start runner: %s", err)
+		}
+		runners[i] = runner
+	}
+	time.Sleep(100 * time.Millisecond)
+	leaders := 0
+	for _, runner := range runners {
+		if runner.IsLeader() {
+			leaders++
+		}
+	}
+	for i, runner := range runners {
+		if err := runner.Stop(); err != nil {
+			t.Errorf("Failed to stop runner %d: %s", i, err)
+		}
+	}
+	if leaders != 1 {
+		t.Errorf("Expected exactly one leader, got %d", leaders)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(runs) != 1 {
+		t.Errorf("Expected jobs to run on exactly one runner, got %v", runs)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	dbmap := newDbMap()
+	dbmap.AddTable(PolicyVersion{}).SetKeys(true, "Id").
+		SetRetention("EffectiveFrom", 24*time.Hour, "PolicyVersionArchive")
+	dbmap.DropTablesIfExists()
+	if err := dbmap.CreateTables(); err != nil {
+		t.Fatalf("Failed to create tables: %s", err)
+	}
+	defer dropAndClose(dbmap)
+	archiveTable := dbmap.Dialect.QuotedTableForQuery("", "PolicyVersionArchive")
+	dbmap.Exec("drop table if exists " + archiveTable)
+	_, err := dbmap.Exec(fmt.Sprintf("create table %s as select * from %s where 1 = 0",
+		archiveTable, dbmap.Dialect.QuotedTableForQuery("", "PolicyVersion")))
+	if err != nil {
+		t.Fatalf("Failed to create archive table: %s", err)
+	}
+	defer dbmap.Exec("drop table " + archiveTable)
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		policy := &PolicyVersion{Premium: int64(i), EffectiveFrom: now.Add(-time.Duration(i) * 13 * time.Hour)}
+		if err := dbmap.Insert(policy); err != nil {
+			t.Fatalf("Failed to insert policy: %s", err)
+		}
+	}
+
+	moved, err := dbmap.Archive(PolicyVersion{}, 2)
+	if err != nil {
+		t.Fatalf("Failed to archive: %s", err)
+	}
+	if moved != 3 {
+		t.Errorf("Expected 3 rows to be archived, got %d", moved)
+	}
+	if count, _ := dbmap.SelectInt("select count(*) from " + archiveTable); count != 3 {
+		t.Errorf("Expected 3 rows in the archive table, got %d", count)
+	}
+	if count, _ := dbmap.SelectInt("select count(*) from " + dbmap.Dialect.QuotedTableForQuery("", "PolicyVersion")); count != 2 {
+		t.Errorf("Expected 2 rows to be kept, got %d", count)
+	}
+	if err = dbmap.ArchiveJob(10)(dbmap); err != nil {
+		t.Errorf("Failed to run archive job: %s", err)
+	}
+}
+
+func TestWithTransaction(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	failed := errors.New("failed")
+	count := func(memo string) int64 {
+		n, err := dbmap.SelectInt("select count(*) from invoice_test where memo = "+dbmap.Dialect.BindVar(0), memo)
+		if err != nil {
+			panic(err)
+		}
+		return n
+	}
+	insert := func(propagation Propagation, memo string, result error) func(context.Context, *Transaction) error {
+		return func(ctx context.Context, tx *Transaction) error {
+			return dbmap.WithTransaction(ctx, propagation, func(ctx context.Context, tx *Transaction) error {
+				if err := tx.Insert(&Invoice{0, 100, 200, memo, 0, false}); err != nil {
+					return err
+				}
+				return result
+			})
+		}
+	}
+
+	err := dbmap.WithTransaction(context.Background(), PropagationRequired, func(ctx context.Context, tx *Transaction) error {
+		if TransactionFromContext(ctx) != tx {
+			t.Errorf("Expected the context to carry the transaction")
+		}
+		if err := insert(PropagationRequired, "joined", nil)(ctx, tx); err != nil {
+			return err
+		}
+		if err := insert(PropagationRequiresNew, "new", nil)(ctx, tx); err != nil {
+			return err
+		}
+		if err := insert(PropagationNested, "nested", failed)(ctx, tx); err != failed {
+			t.Errorf("Expected the nested error to be returned, got %v", err)
+		}
+		return failed
+	})
+	if err != failed {
+		t.Errorf("Expected the outer error to be returned, got %v", err)
+	}
+	if count("joined") != 0 {
+		t.Errorf("Expected the joined insert to be rolled back with the outer transaction")
+	}
+	if count("new") != 1 {
+		t.Errorf("Expected the insert in a new transaction to be committed")
+	}
+
+	err = dbmap.WithTransaction(context.Background(), PropagationRequired, func(ctx context.Context, tx *Transaction) error {
+		insert(PropagationNested, "nested", failed)(ctx, tx)
+		return insert(PropagationNested, "kept", nil)(ctx, tx)
+	})
+	if err != nil {
+		t.Errorf("Failed to commit: %s", err)
+	}
+	if count("nested") != 0 || count("kept") != 1 {
+		t.Errorf("Expected only the failed savepoint to be rolled back")
+	}
+}
+
+func TestImport(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	rows := func(n int) RowIterator {
+		i := 0
+		return RowIteratorFunc(func(ctx context.Context) (interface{}, error) {
+			if i == n {
+				return nil, io.EOF
+			}
+			i++
+			return &Invoice{0, int64(i), 0, "imported", 0, false}, nil
+		})
+	}
+	var progress []ImportProgress
+	opts := ImportOptions{BatchSize: 2, Total: 5, Progress: func(p ImportProgress) {
+		progress = append(progress, p)
+	}}
+	n, err := dbmap.Import(context.Background(), Invoice{}, rows(5), opts)
+	if err != nil {
+		t.Fatalf("Failed to import: %s", err)
+	}
+	if n != 5 || len(progress) != 3 || progress[2].Rows != 5 || progress[2].Batches != 3 {
+		t.Errorf("Expected 5 rows in 3 batches, got %d rows and progress %v", n, progress)
+	}
+
+	opts = ImportOptions{BatchSize: 2, ResumeAfter: 3}
+	if n, err = dbmap.Import(context.Background(), Invoice{}, rows(5), opts); err != nil || n != 5 {
+		t.Errorf("Expected to resume after 3 rows, got %d, %v", n, err)
+	}
+	count, err := dbmap.SelectInt("select count(*) from invoice_test where memo = "+dbmap.Dialect.BindVar(0), "imported")
+	if err != nil {
+		t.Fatalf("Failed to count rows: %s", err)
+	}
+	if count != 7 {
+		t.Errorf("Expected 7 imported rows, got %d", count)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if n, err = dbmap.Import(ctx, Invoice{}, rows(5), ImportOptions{}); err != context.Canceled || n != 0 {
+		t.Errorf("Expected a canceled import, got %d, %v", n, err)
+	}
+}
+
+func TestVerifyMemos(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	var diffs []*ResultDiff
+	dbmap.VerifyMemos(1, func(query string, args []interface{}, diff *ResultDiff) {
+		diffs = append(diffs, diff)
+	})
+	inv := &Invoice{0, 100, 200, "memo", 0, false}
+	if err := dbmap.Insert(inv); err != nil {
+		t.Fatalf("Failed to insert: %s", err)
+	}
+
+	ctx := WithMemo(context.Background())
+	selectMemo := func() {
+		q := new(Invoice)
+		if _, err := dbmap.QueryContext(ctx, q).Where().Equal(&q.Id, inv.Id).Select(); err != nil {
+			t.Fatalf("Failed to select: %s", err)
+		}
+	}
+	selectMemo()
+	selectMemo()
+	if len(diffs) != 0 {
+		t.Errorf("Expected no mismatches for an unchanged row, got %v", diffs)
+	}
+
+	// Raw SQL doesn't clear the memo.
+	_, err := dbmap.Exec("update invoice_test set memo = "+dbmap.Dialect.BindVar(0), "changed")
+	if err != nil {
+		t.Fatalf("Failed to update: %s", err)
+	}
+	selectMemo()
+	if len(diffs) != 1 || len(diffs[0].Changed) != 1 {
+		t.Fatalf("Expected one changed row, got %v", diffs)
+	}
+	if diffs[0].Changed[0].Left.(*Invoice).Memo != "memo" || diffs[0].Changed[0].Right.(*Invoice).Memo != "changed" {
+		t.Errorf("Expected the memoized and changed rows, got %#v", diffs[0].Changed[0])
+	}
+}
+
+func TestHydrate(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+	dbmap.AddTableWithName(InvoiceWithPerson{}, "invoice_test").SetKeys(true, "Id")
+
+	p1 := &Person{0, 0, 0, "alice", "smith", 0}
+	p2 := &Person{0, 0, 0, "bob", "jones", 0}
+	err := dbmap.Insert(p1, p2)
+	if err != nil {
+		panic(err)
+	}
+	inv1 := &Invoice{0, 0, 0, "first", p1.Id, false}
+	inv2 := &Invoice{0, 0, 0, "second", p2.Id, false}
+	err = dbmap.Insert(inv1, inv2)
+	if err != nil {
+		panic(err)
+	}
+
+	inv := new(InvoiceWithPerson)
+	person := new(Person)
+	var invoices []*InvoiceWithPerson
+	err = dbmap.Query(inv).
+		Join(person).On(Equal(&inv.PersonId, &person.Id)).
+		Where().
+		OrderBy(&inv.Id).
+		Hydrate(&inv.Person).
+		SelectToTarget(&invoices)
+	if err != nil {
+		t.Fatalf("Failed to select: %s", err)
+	}
+	if len(invoices) != 2 {
+		t.Fatalf("Expected 2 invoices, got %d", len(invoices))
+	}
+	for i, expected := range []*Person{p1, p2} {
+		got := invoices[i]
+		if got.Person == nil || got.Person.Id != expected.Id || got.Person.FName != expected.FName {
+			t.Errorf("Expected invoice %d to be joined to %v, got %v", got.Id, expected, got.Person)
+		}
+	}
+	if invoices[0].Memo != "first" {
+		t.Errorf("Expected memo first, got %s", invoices[0].Memo)
+	}
+
+	results, err := dbmap.Query(inv).
+		Join(person).On(Equal(&inv.PersonId, &person.Id)).
+		Where().
+		Equal(&person.FName, "bob").
+		Hydrate(&inv.Person).
+		Select()
+	if err != nil {
+		t.Fatalf("Failed to select: %s", err)
+	}
+	if len(results) != 1 || results[0].(*InvoiceWithPerson).Person.LName != "jones" {
+		t.Errorf("Expected bob's invoice with bob's last name, got %v", results)
+	}
+}
+
+func TestPreload(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+	dbmap.AddTableWithName(InvoiceWithPerson{}, "invoice_test").SetKeys(true, "Id").
+		ColMap("Person").BelongsTo("PersonId")
+	dbmap.AddTableWithName(PersonWithInvoices{}, "person_test").SetKeys(true, "Id").
+		ColMap("Invoices").HasMany("PersonId")
+
+	p1 := &Person{0, 0, 0, "alice", "smith", 0}
+	p2 := &Person{0, 0, 0, "bob", "jones", 0}
+	err := dbmap.Insert(p1, p2)
+	if err != nil {
+		panic(err)
+	}
+	inv1 := &Invoice{0, 0, 0, "first", p1.Id, false}
+	inv2 := &Invoice{0, 0, 0, "second", p1.Id, false}
+	inv3 := &Invoice{0, 0, 0, "third", p2.Id, false}
+	err = dbmap.Insert(inv1, inv2, inv3)
+	if err != nil {
+		panic(err)
+	}
+
+	inv := new(InvoiceWithPerson)
+	var invoices []*InvoiceWithPerson
+	err = dbmap.Query(inv).
+		Where().
+		OrderBy(&inv.Id).
+		Preload(&inv.Person).
+		SelectToTarget(&invoices)
+	if err != nil {
+		t.Fatalf("Failed to select: %s", err)
+	}
+	if len(invoices) != 3 {
+		t.Fatalf("Expected 3 invoices, got %d", len(invoices))
+	}
+	for i, expected := range []*Person{p1, p1, p2} {
+		got := invoices[i]
+		if got.Person == nil || got.Person.Id != expected.Id || got.Person.FName != expected.FName {
+			t.Errorf("Expected invoice %d to belong to %v, got %v", got.Id, expected, got.Person)
+		}
+	}
+
+	person := new(PersonWithInvoices)
+	results, err := dbmap.Query(person).
+		Where().
+		OrderBy(&person.Id).
+		Preload(&person.Invoices).
+		Select()
+	if err != nil {
+		t.Fatalf("Failed to select: %s", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 people, got %d", len(results))
+	}
+	for i, expected := range []int{2, 1} {
+		got := results[i].(*PersonWithInvoices)
+		if len(got.Invoices) != expected {
+			t.Errorf("Expected %s to have %d invoices, got %d", got.FName, expected, len(got.Invoices))
+		}
+		for _, invoice := range got.Invoices {
+			if invoice.PersonId != got.Id {
+				t.Errorf("Expected invoice %d to belong to %s", invoice.Id, got.FName)
+			}
+		}
+	}
+}
+
+func TestSelectInto(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	p1 := &Person{0, 0, 0, "alice", "smith", 0}
+	p2 := &Person{0, 0, 0, "bob", "jones", 0}
+	err := dbmap.Insert(p1, p2)
+	if err != nil {
+		panic(err)
+	}
+	inv1 := &Invoice{0, 0, 0, "first", p2.Id, false}
+	inv2 := &Invoice{0, 0, 0, "second", p1.Id, true}
+	err = dbmap.Insert(inv1, inv2)
+	if err != nil {
+		panic(err)
+	}
+
+	inv := new(Invoice)
+	person := new(Person)
+	var invoices []*Invoice
+	var people []Person
+	err = dbmap.Query(inv).
+		Join(person).On(Equal(&inv.PersonId, &person.Id)).
+		Where().
+		OrderBy(&inv.Id).
+		SelectInto(&invoices, &people)
+	if err != nil {
+		t.Fatalf("Failed to select: %s", err)
+	}
+	if len(invoices) != 2 || len(people) != 2 {
+		t.Fatalf("Expected 2 invoices and 2 people, got %d and %d", len(invoices), len(people))
+	}
+	if invoices[0].Id != inv1.Id || people[0].Id != p2.Id || people[0].FName != "bob" {
+		t.Errorf("Expected the first invoice to be joined to bob, got %v and %v", invoices[0], people[0])
+	}
+	if invoices[1].Id != inv2.Id || people[1].Id != p1.Id || !invoices[1].IsPaid {
+		t.Errorf("Expected the second invoice to be joined to alice, got %v and %v", invoices[1], people[1])
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dbmap := newDbMap()
+	dbmap.TraceOn("", log.New(os.Stdout, "gorptest: ", log.Lmicroseconds))
+	table := dbmap.AddTableWithName(Document{}, "document_test").SetKeys(true, "Id")
+	table.ColMap("Body").Validate(Length(1, 10))
+	err := dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	d := &Document{0, "", ""}
+	if _, ok := dbmap.Insert(d).(ValidationError); !ok {
+		t.Errorf("Expected a ValidationError for an empty body")
+	}
+	d.Body = "draft"
+	_insert(dbmap, d)
+	d.Body = "much too long"
+	if _, err = dbmap.Update(d); err == nil {
+		t.Errorf("Expected a ValidationError for a long body")
+	}
+	if got := _get(dbmap, Document{}, d.Id).(*Document); got.Body != "draft" {
+		t.Errorf("Expected the invalid update to be skipped, got body %s", got.Body)
+	}
+}
+
+func TestVersionStrategy(t *testing.T) {
+	dbmap := newDbMap()
+	dbmap.TraceOn("", log.New(os.Stdout, "gorptest: ", log.Lmicroseconds))
+	table := dbmap.AddTableWithName(Document{}, "document_test").SetKeys(true, "Id")
+	table.SetVersionCol("ETag")
+	table.SetVersionStrategy(UUIDVersion{})
+	err := dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	d1 := &Document{0, "draft", ""}
+	_insert(dbmap, d1)
+	if d1.ETag == "" {
+		t.Fatalf("Insert didn't set ETag")
+	}
+	obj, err := dbmap.Get(Document{}, d1.Id)
+	if err != nil {
+		panic(err)
+	}
+	d2 := obj.(*Document)
+	d2.Body = "final"
+	if _, err = dbmap.Update(d2); err != nil {
+		t.Fatalf("Failed to update: %s", err)
+	}
+	if d2.ETag == d1.ETag {
+		t.Errorf("Update didn't change ETag %s", d2.ETag)
+	}
+
+	d1.Body = "conflict"
+	_, err = dbmap.Update(d1)
+	lockErr, ok := err.(OptimisticLockError)
+	if !ok {
+		t.Fatalf("Expected OptimisticLockError, got: %v", err)
+	}
+	if !lockErr.RowExists || lockErr.LocalVersionValue != d1.ETag {
+		t.Errorf("Expected a stale ETag %s for an existing row, got %+v", d1.ETag, lockErr)
+	}
+	if _, err = dbmap.Delete(d1); err == nil {
+		t.Errorf("Expected delete with a stale ETag to fail")
+	}
+	if count, err := dbmap.Delete(d2); err != nil || count != 1 {
+		t.Errorf("Expected delete with the current ETag to succeed, got %d, %v", count, err)
+	}
+}
+
+func TestRelated(t *testing.T) {
+	dbmap := newDbMap()
+	dbmap.TraceOn("", log.New(os.Stdout, "gorptest: ", log.Lmicroseconds))
+	dbmap.AddTableWithName(Label{}, "label_test").SetKeys(true, "Id")
+	dbmap.AddTableWithName(InvoiceLabel{}, "invoice_label_test").SetKeys(false, "InvoiceId", "LabelId")
+	dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id").
+		ManyToMany(Label{}, InvoiceLabel{}, "InvoiceId", "LabelId")
+	err := dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	urgent := &Label{0, "urgent"}
+	disputed := &Label{0, "disputed"}
+	inv1 := &Invoice{0, 0, 0, "first", 0, false}
+	inv2 := &Invoice{0, 0, 0, "second", 0, false}
+	_insert(dbmap, urgent, disputed, inv1, inv2)
+	_insert(dbmap, &InvoiceLabel{inv1.Id, urgent.Id}, &InvoiceLabel{inv1.Id, disputed.Id}, &InvoiceLabel{inv2.Id, urgent.Id})
+
+	var labels []*Label
+	if err = dbmap.Related(inv1, &labels); err != nil {
+		t.Fatalf("Failed to load labels: %s", err)
+	}
+	if len(labels) != 2 {
+		t.Errorf("Expected 2 labels for the first invoice, got %d", len(labels))
+	}
+
+	var invoices []Invoice
+	if err = dbmap.Related(urgent, &invoices); err != nil {
+		t.Fatalf("Failed to load invoices: %s", err)
+	}
+	if len(invoices) != 2 {
+		t.Errorf("Expected 2 invoices labeled urgent, got %d", len(invoices))
+	}
+	invoices = nil
+	if err = dbmap.Related(disputed, &invoices); err != nil || len(invoices) != 1 || invoices[0].Id != inv1.Id {
+		t.Errorf("Expected the first invoice to be disputed, got %v, %v", invoices, err)
+	}
+}
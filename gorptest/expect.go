@@ -0,0 +1,194 @@
+package gorptest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/coopernurse/gorp"
+)
+
+// filterOps maps comparison operators to the ops used by
+// gorp.FilterDefinition.
+var filterOps = map[string]string{
+	"=":  "eq",
+	"<>": "ne",
+	"!=": "ne",
+	"<":  "lt",
+	"<=": "le",
+	">":  "gt",
+	">=": "ge",
+}
+
+// Expectations answers the selects run by a DbMap's query plans with
+// canned rows, matching them on the structure of each plan instead of
+// on its SQL.  Create them with Expect.
+type Expectations struct {
+	t testing.TB
+
+	mu       sync.Mutex
+	selects  []*SelectExpectation
+	recorded []*gorp.PlanDefinition
+}
+
+// Expect intercepts the selects run by dbmap's query plans (see
+// gorp.DbMap.InterceptPlans), so that code under test can be run
+// without a database:
+//
+//     expect := gorptest.Expect(t, dbmap)
+//     expect.Select("invoice").WithFilter("Memo", "=", "x").Return(&Invoice{Id: 1, Memo: "x"})
+//     ... run the code under test ...
+//     expect.Verify()
+//
+// Each expectation answers a single select, in the order they were
+// set.  Selects that don't match any remaining expectation fail the
+// test and return an error.
+func Expect(t testing.TB, dbmap *gorp.DbMap) *Expectations {
+	e := &Expectations{t: t}
+	dbmap.InterceptPlans(e)
+	return e
+}
+
+// Select expects a select from the named table.
+func (e *Expectations) Select(table string) *SelectExpectation {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s := &SelectExpectation{table: table}
+	e.selects = append(e.selects, s)
+	return s
+}
+
+// Recorded returns the definitions of the plans that have been
+// intercepted so far, in order.  Plans that can't be defined are
+// recorded as nil.
+func (e *Expectations) Recorded() []*gorp.PlanDefinition {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*gorp.PlanDefinition(nil), e.recorded...)
+}
+
+// Verify fails the test if any expected select hasn't been run.
+func (e *Expectations) Verify() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, s := range e.selects {
+		if !s.used {
+			e.t.Errorf("gorptest: Expected select was not run: %s", s)
+		}
+	}
+}
+
+// InterceptSelect implements gorp.PlanInterceptor.
+func (e *Expectations) InterceptSelect(table *gorp.TableMap, def *gorp.PlanDefinition) ([]interface{}, bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.recorded = append(e.recorded, def)
+	for _, s := range e.selects {
+		if !s.used && s.matches(table, def) {
+			s.used = true
+			return s.rows, true, s.err
+		}
+	}
+	where := []byte("null")
+	if def != nil {
+		where, _ = json.Marshal(def.Where)
+	}
+	err := fmt.Errorf("gorptest: Unexpected select from %s where %s", table.TableName, where)
+	e.t.Error(err)
+	return nil, true, err
+}
+
+// A SelectExpectation is a select that is expected to be run, and
+// the rows to return for it.
+type SelectExpectation struct {
+	table   string
+	filters []expectedFilter
+	rows    []interface{}
+	err     error
+	used    bool
+}
+
+type expectedFilter struct {
+	column string
+	op     string
+	value  interface{}
+}
+
+// WithFilter expects the select's where clause to compare column
+// (a column or field name) to value using op, which is either a
+// comparison operator (=, <>, !=, <, <=, >, >=) or the op of a
+// gorp.FilterDefinition (e.g. "eqFold").  Comparisons that are inside
+// or and not filters don't count.
+func (s *SelectExpectation) WithFilter(column, op string, value interface{}) *SelectExpectation {
+	if defOp, ok := filterOps[op]; ok {
+		op = defOp
+	}
+	s.filters = append(s.filters, expectedFilter{column, op, value})
+	return s
+}
+
+// Return sets the rows returned for the select, which should be
+// pointers to values of the table's type.
+func (s *SelectExpectation) Return(rows ...interface{}) *SelectExpectation {
+	s.rows = rows
+	return s
+}
+
+// ReturnError makes the select return err.
+func (s *SelectExpectation) ReturnError(err error) *SelectExpectation {
+	s.err = err
+	return s
+}
+
+// String describes the expected select.
+func (s *SelectExpectation) String() string {
+	desc := "from " + s.table
+	for i, filter := range s.filters {
+		if i == 0 {
+			desc += " where "
+		} else {
+			desc += " and "
+		}
+		desc += fmt.Sprintf("%s %s %v", filter.column, filter.op, filter.value)
+	}
+	return desc
+}
+
+func (s *SelectExpectation) matches(table *gorp.TableMap, def *gorp.PlanDefinition) bool {
+	if !strings.EqualFold(table.TableName, s.table) {
+		return false
+	}
+	if len(s.filters) == 0 {
+		return true
+	}
+	if def == nil || def.Where == nil {
+		return false
+	}
+	for _, filter := range s.filters {
+		if !filter.matches(*def.Where) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches returns true if def is, or is a filter anded into, a
+// comparison matching this filter.
+func (filter expectedFilter) matches(def gorp.FilterDefinition) bool {
+	if def.Op == "and" {
+		for _, sub := range def.Filters {
+			if filter.matches(sub) {
+				return true
+			}
+		}
+		return false
+	}
+	if def.Op != filter.op || !strings.EqualFold(def.Column, filter.column) {
+		return false
+	}
+	value, err := json.Marshal(filter.value)
+	return err == nil && bytes.Equal(value, def.Value)
+}
@@ -0,0 +1,65 @@
+package gorptest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/coopernurse/gorp"
+)
+
+// recordingT records the errors reported by expectations.
+type recordingT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *recordingT) Error(args ...interface{}) {
+	t.errors = append(t.errors, "error")
+}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, format)
+}
+
+func TestExpectSelect(t *testing.T) {
+	dbmap := &gorp.DbMap{Dialect: gorp.SqliteDialect{}}
+	dbmap.AddTableWithName(Invoice{}, "invoice").SetKeys(true, "Id")
+	rt := &recordingT{TB: t}
+	expect := Expect(rt, dbmap)
+	expect.Select("invoice").WithFilter("Memo", "=", "x").Return(&Invoice{Id: 7, Memo: "x"})
+	expect.Select("invoice").WithFilter("Id", ">", 3).ReturnError(errors.New("boom"))
+
+	inv := new(Invoice)
+	rows, err := dbmap.Query(inv).Where().Equal(&inv.Memo, "x").Select()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(rows) != 1 || rows[0].(*Invoice).Id != 7 {
+		t.Errorf("Expected the canned row, got %v", rows)
+	}
+
+	var invoices []Invoice
+	err = dbmap.Query(inv).Where().Greater(&inv.Id, 3).Equal(&inv.IsPaid, true).SelectToTarget(&invoices)
+	if err == nil || err.Error() != "boom" {
+		t.Errorf("Expected the canned error, got %v", err)
+	}
+	if len(rt.errors) != 0 {
+		t.Errorf("Expected no failures, got %v", rt.errors)
+	}
+
+	if _, err := dbmap.Query(inv).Where().Equal(&inv.Memo, "x").Select(); err == nil {
+		t.Errorf("Expected an error for a select that was already answered")
+	}
+	if len(rt.errors) != 1 {
+		t.Errorf("Expected the unexpected select to fail the test, got %v", rt.errors)
+	}
+	if len(expect.Recorded()) != 3 {
+		t.Errorf("Expected 3 recorded plans, got %d", len(expect.Recorded()))
+	}
+
+	expect.Select("invoice")
+	expect.Verify()
+	if len(rt.errors) != 2 {
+		t.Errorf("Expected Verify to report the unmet expectation, got %v", rt.errors)
+	}
+}
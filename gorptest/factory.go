@@ -0,0 +1,248 @@
+package gorptest
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+// factoryEpoch is the time that generated time values start from.
+var factoryEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// A ModelFactory builds and inserts rows of a single table, filling
+// every column that hasn't been set with With using deterministic fake
+// data.  Create one with Factory.
+type ModelFactory struct {
+	exec      gorp.SqlExecutor
+	table     *gorp.TableMap
+	model     reflect.Value
+	overrides map[string]interface{}
+	sequence  int
+	err       error
+}
+
+// Factory returns a factory for rows of the table that model's type is
+// registered as in dbmap, which will be inserted using dbmap (see
+// Using).  model must be a pointer to a struct; like the target of
+// gorp's query plans, it is used as a reference for the field pointers
+// passed to With:
+//
+//     inv := new(Invoice)
+//     invoices, err := gorptest.Factory(dbmap, inv).With(&inv.Memo, "x").CreateN(10)
+//
+// Each row gets the next number in the factory's sequence, starting
+// at 1, and the table's columns that haven't been set with With are
+// filled from that number: strings are "<field name>-<n>", shortened
+// to the column's MaxSize by cutting the field name, numbers are n,
+// starting over at 1 past the largest value of their type, bools
+// alternate, and times are n hours after 2000-01-01 UTC.  Pointers to
+// those types point to the same values, and fields of other types are
+// left alone.  Transient columns, auto-increment keys, and version
+// columns aren't filled.  Panics if model's type isn't registered.
+func Factory(dbmap *gorp.DbMap, model interface{}) *ModelFactory {
+	v := reflect.ValueOf(model)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("gorptest: Factory needs a pointer to a struct, got %T", model))
+	}
+	table, err := dbmap.TableFor(v.Elem().Type(), false)
+	if err != nil {
+		panic(fmt.Sprintf("gorptest: Factory: %s", err))
+	}
+	return &ModelFactory{
+		exec:      dbmap,
+		table:     table,
+		model:     v.Elem(),
+		overrides: make(map[string]interface{}),
+	}
+}
+
+// Using makes the factory insert rows using exec, e.g. a transaction
+// started by WithRollback, instead of the DbMap.
+func (f *ModelFactory) Using(exec gorp.SqlExecutor) *ModelFactory {
+	f.exec = exec
+	return f
+}
+
+// With sets the field that fieldPtr points to (in the factory's
+// model) to value in every row.  value is converted to the field's
+// type if it isn't assignable to it, e.g. an untyped constant to an
+// int32 field; if it can't be, the override is ignored and the error
+// is returned by Err, Create, and CreateN.  Panics if fieldPtr
+// doesn't point to a field of the model.
+func (f *ModelFactory) With(fieldPtr interface{}, value interface{}) *ModelFactory {
+	ptr := reflect.ValueOf(fieldPtr)
+	if ptr.Kind() != reflect.Ptr {
+		panic("gorptest: With needs a pointer to a field of the factory's model")
+	}
+	path, ok := fieldPath(f.model, ptr.Pointer(), "")
+	if !ok {
+		panic("gorptest: With needs a pointer to a field of the factory's model")
+	}
+	if value != nil {
+		converted, err := assignable(reflect.ValueOf(value), ptr.Type().Elem())
+		if err != nil {
+			if f.err == nil {
+				f.err = fmt.Errorf("gorptest: With: cannot set field %s: %s", path, err)
+			}
+			return f
+		}
+		value = converted.Interface()
+	}
+	f.overrides[path] = value
+	return f
+}
+
+// Err returns the first error recorded by With, if any.
+func (f *ModelFactory) Err() error {
+	return f.err
+}
+
+// assignable returns v, converted to t if it isn't assignable to t, or
+// an error if it can't be.  Integers aren't converted to strings,
+// since Go converts them to the character with that code point.
+func assignable(v reflect.Value, t reflect.Type) (reflect.Value, error) {
+	if v.Type().AssignableTo(t) {
+		return v, nil
+	}
+	isString := t.Kind() == reflect.String
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if isString {
+			return v, fmt.Errorf("%s is not assignable to %s", v.Type(), t)
+		}
+	}
+	if !v.Type().ConvertibleTo(t) {
+		return v, fmt.Errorf("%s is not assignable to %s", v.Type(), t)
+	}
+	return v.Convert(t), nil
+}
+
+// Build returns a pointer to a new row, without inserting it.
+func (f *ModelFactory) Build() interface{} {
+	f.sequence++
+	row := reflect.New(f.model.Type())
+	f.fill(row.Elem(), f.sequence)
+	return row.Interface()
+}
+
+// Create builds a row and inserts it.
+func (f *ModelFactory) Create() (interface{}, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	row := f.Build()
+	if err := f.exec.Insert(row); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+// CreateN builds n rows and inserts them, returning pointers to the
+// inserted rows.
+func (f *ModelFactory) CreateN(n int) ([]interface{}, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	rows := make([]interface{}, n)
+	for i := range rows {
+		rows[i] = f.Build()
+	}
+	if err := f.exec.Insert(rows...); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// fill sets the fields of v, a struct, for row n of the sequence.
+func (f *ModelFactory) fill(v reflect.Value, n int) {
+	for _, col := range f.table.Columns() {
+		if col.Transient || col.Generated() {
+			continue
+		}
+		if field := v.FieldByName(col.FieldName()); field.IsValid() && field.CanSet() {
+			fakeValue(field, col.FieldName(), col.MaxSize, n)
+		}
+	}
+	for path, value := range f.overrides {
+		field := v
+		for _, name := range strings.Split(path, ".") {
+			field = field.FieldByName(name)
+		}
+		if value == nil {
+			field.Set(reflect.Zero(field.Type()))
+		} else {
+			field.Set(reflect.ValueOf(value))
+		}
+	}
+}
+
+// fakeValue sets v, the value of the named field, for row n of the
+// sequence.  Strings are kept to maxSize characters, if it isn't 0.
+func fakeValue(v reflect.Value, name string, maxSize int, n int) {
+	if v.Type() == reflect.TypeOf(time.Time{}) {
+		v.Set(reflect.ValueOf(factoryEpoch.Add(time.Duration(n) * time.Hour)))
+		return
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(fakeString(name, maxSize, n))
+	case reflect.Bool:
+		v.SetBool(n%2 == 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		max := int64(1)<<uint(v.Type().Bits()-1) - 1
+		v.SetInt((int64(n)-1)%max + 1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		max := uint64(1)<<uint(v.Type().Bits()) - 1
+		v.SetUint((uint64(n)-1)%max + 1)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(float64(n))
+	case reflect.Ptr:
+		elem := reflect.New(v.Type().Elem())
+		fakeValue(elem.Elem(), name, maxSize, n)
+		if !elem.Elem().IsZero() {
+			v.Set(elem)
+		}
+	}
+}
+
+// fakeString returns "<name>-<n>", with name cut short so that it has
+// at most maxSize characters, if maxSize isn't 0.  If even "-<n>" is
+// too long, only its last maxSize characters are kept.
+func fakeString(name string, maxSize int, n int) string {
+	suffix := fmt.Sprintf("-%d", n)
+	runes := []rune(name)
+	if maxSize <= 0 || len(runes)+len(suffix) <= maxSize {
+		return name + suffix
+	}
+	if len(suffix) >= maxSize {
+		return suffix[len(suffix)-maxSize:]
+	}
+	return string(runes[:maxSize-len(suffix)]) + suffix
+}
+
+// fieldPath returns the dotted path of the field of v, a struct, at
+// address addr.
+func fieldPath(v reflect.Value, addr uintptr, prefix string) (string, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		path := prefix + field.Name
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if found, ok := fieldPath(v.Field(i), addr, path+"."); ok {
+				return found, true
+			}
+			continue
+		}
+		if v.Field(i).Addr().Pointer() == addr {
+			return path, true
+		}
+	}
+	return "", false
+}
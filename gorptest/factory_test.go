@@ -0,0 +1,138 @@
+package gorptest
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+type Invoice struct {
+	Id       int64
+	Memo     string
+	IsPaid   bool
+	Due      time.Time
+	Note     *string
+	Internal string `db:"-"`
+}
+
+type OverriddenInvoice struct {
+	Invoice
+	Code string
+}
+
+// factoryDbMap returns a DbMap with Invoice and OverriddenInvoice
+// tables whose keys are set by the factory.
+func factoryDbMap() *gorp.DbMap {
+	dbmap := &gorp.DbMap{Dialect: gorp.SqliteDialect{}}
+	dbmap.AddTable(Invoice{}).SetKeys(false, "Id")
+	dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
+	return dbmap
+}
+
+func TestFactoryBuild(t *testing.T) {
+	dbmap := factoryDbMap()
+	inv := new(Invoice)
+	factory := Factory(dbmap, inv).With(&inv.Memo, "x")
+	first := factory.Build().(*Invoice)
+	second := factory.Build().(*Invoice)
+
+	if first.Id != 1 || second.Id != 2 {
+		t.Errorf("Expected sequential ids, got %d and %d", first.Id, second.Id)
+	}
+	if first.Memo != "x" || second.Memo != "x" {
+		t.Errorf("Expected Memo to be overridden, got %q and %q", first.Memo, second.Memo)
+	}
+	if first.IsPaid == second.IsPaid {
+		t.Errorf("Expected IsPaid to alternate")
+	}
+	if !first.Due.Equal(factoryEpoch.Add(time.Hour)) {
+		t.Errorf("Expected a deterministic time, got %s", first.Due)
+	}
+	if first.Note == nil || *first.Note != "Note-1" {
+		t.Errorf("Expected Note to point to a generated string, got %v", first.Note)
+	}
+	if first.Internal != "" {
+		t.Errorf("Expected ignored fields to be left alone, got %q", first.Internal)
+	}
+
+	over := new(OverriddenInvoice)
+	row := Factory(dbmap, over).With(&over.Code, "c").Build().(*OverriddenInvoice)
+	if row.Code != "c" || row.Id != 1 {
+		t.Errorf("Expected embedded fields to be filled, got %+v", row)
+	}
+}
+
+func TestFactoryWithConversion(t *testing.T) {
+	dbmap := factoryDbMap()
+	inv := new(Invoice)
+	factory := Factory(dbmap, inv).With(&inv.Id, 7)
+	if row := factory.Build().(*Invoice); row.Id != 7 {
+		t.Errorf("Expected an int to be converted to int64, got %d", row.Id)
+	}
+	if err := factory.Err(); err != nil {
+		t.Errorf("Expected no error, got %s", err)
+	}
+
+	factory = Factory(dbmap, inv).With(&inv.Memo, 7).With(&inv.IsPaid, "yes")
+	if err := factory.Err(); err == nil || !strings.Contains(err.Error(), "Memo") {
+		t.Errorf("Expected an error for the first unassignable value, got %v", err)
+	}
+	if row := factory.Build().(*Invoice); row.Memo != "Memo-1" {
+		t.Errorf("Expected an unassignable override to be ignored, got %q", row.Memo)
+	}
+	if _, err := factory.Create(); err != factory.Err() {
+		t.Errorf("Expected Create to return the recorded error, got %v", err)
+	}
+	if _, err := factory.CreateN(2); err != factory.Err() {
+		t.Errorf("Expected CreateN to return the recorded error, got %v", err)
+	}
+}
+
+func TestFactoryColumns(t *testing.T) {
+	type Event struct {
+		Id          int64
+		Description string `db:"description_text"`
+		Code        string
+		Level       int8
+		Flags       uint8
+		Cached      string
+		Version     int64
+	}
+	dbmap := &gorp.DbMap{Dialect: gorp.SqliteDialect{}}
+	table := dbmap.AddTable(Event{}).SetKeys(true, "Id")
+	table.ColMap("Description").SetMaxSize(10)
+	table.ColMap("Code").SetMaxSize(3)
+	table.ColMap("Cached").SetTransient(true)
+	e := new(Event)
+	factory := Factory(dbmap, e)
+	for i := 1; i < 1000; i++ {
+		factory.Build()
+	}
+	row := factory.Build().(*Event)
+	if row.Id != 0 || row.Version != 0 {
+		t.Errorf("Expected the auto-increment key and version to be left alone, got %+v", row)
+	}
+	if row.Description != "Descr-1000" {
+		t.Errorf("Expected a column with a custom name to be filled within its MaxSize, got %q", row.Description)
+	}
+	if row.Code != "000" {
+		t.Errorf("Expected the end of the number within the MaxSize, got %q", row.Code)
+	}
+	if row.Cached != "" {
+		t.Errorf("Expected a transient column to be left alone, got %q", row.Cached)
+	}
+	if row.Level != 1000%127 || row.Flags != 1000%255 {
+		t.Errorf("Expected small integers to start over at 1, got %d and %d", row.Level, row.Flags)
+	}
+
+	panicked := func() (panicked bool) {
+		defer func() { panicked = recover() != nil }()
+		Factory(dbmap, new(Invoice))
+		return false
+	}
+	if !panicked() {
+		t.Errorf("Expected Factory to panic for a type without a table")
+	}
+}
@@ -0,0 +1,67 @@
+// Package gorptest provides helpers for tests that use gorp with a
+// real database.
+package gorptest
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/coopernurse/gorp"
+)
+
+// WithRollback runs fn in a transaction that is always rolled back,
+// so tests don't leave rows behind and can share a database without
+// interfering with each other:
+//
+//     func TestCreateInvoice(t *testing.T) {
+//         gorptest.WithRollback(t, dbmap, func(tx *gorp.Transaction) {
+//             if err := CreateInvoice(tx, 42); err != nil {
+//                 t.Fatal(err)
+//             }
+//             ...
+//         })
+//     }
+//
+// fn receives a transaction nested in the outer transaction (see
+// gorp.Transaction.Nested), so code under test that commits or rolls
+// back the transaction it is given only releases or rolls back to a
+// savepoint.  The dialect's database must support savepoints.
+func WithRollback(t testing.TB, dbmap *gorp.DbMap, fn func(tx *gorp.Transaction)) {
+	tx, err := dbmap.Begin()
+	if err != nil {
+		t.Fatalf("gorptest: Failed to begin transaction: %s", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			t.Errorf("gorptest: Failed to roll back transaction: %s", err)
+		}
+	}()
+	nested, err := tx.Nested("gorptest")
+	if err != nil {
+		t.Fatalf("gorptest: Failed to create savepoint: %s", err)
+	}
+	fn(nested)
+}
+
+// Snapshot copies the rows of the tables for models (or of every
+// registered table, if no models are passed in) and returns a
+// function that restores them, for tests that can't run inside a
+// single rolled-back transaction:
+//
+//     func TestNightlyBilling(t *testing.T) {
+//         defer gorptest.Snapshot(t, dbmap, Invoice{})()
+//         ...
+//     }
+//
+// See gorp.DbMap.Snapshot.
+func Snapshot(t testing.TB, dbmap *gorp.DbMap, models ...interface{}) (restore func()) {
+	snapshot, err := dbmap.Snapshot(models...)
+	if err != nil {
+		t.Fatalf("gorptest: Failed to snapshot tables: %s", err)
+	}
+	return func() {
+		if err := snapshot.Restore(); err != nil {
+			t.Errorf("gorptest: Failed to restore tables: %s", err)
+		}
+	}
+}
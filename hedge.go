@@ -0,0 +1,216 @@
+package gorp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Hedge hedges the plan's select statements against slow responses:
+// if a statement hasn't returned within delay, the same statement is
+// sent again, on another connection, and the first response is used.
+// Strong reads are sent to the primary database both times; eventual
+// reads (see Consistency) are sent again to another of the DbMap's
+// replicas (see SetReplicas), if there is one.  The other attempt is
+// canceled:
+//
+//     plan.Where().Equal(&inv.Id, id).Consistency(gorp.Eventual).Hedge(50 * time.Millisecond).Select()
+//
+// A statement responds once the database has started returning its
+// rows; if the first response is an error, the other attempt's
+// response is used instead.  Only select statements are hedged, since
+// they can safely run twice, and plans created from a Transaction
+// can't be hedged.  Both attempts run within the plan's context (see
+// QueryContext), so canceling it, or reaching its deadline, cancels
+// them.
+func (plan *QueryPlan) Hedge(delay time.Duration) SelectQuery {
+	switch executor := withoutContext(plan.executor).(type) {
+	case *Transaction:
+		plan.Errors = append(plan.Errors, errors.New("gorp: Hedge cannot be used in a transaction"))
+	case *hedgedExecutor:
+		executor.delay = delay
+	default:
+		plan.executor = &hedgedExecutor{dbmap: plan.dbMap, first: plan.executor, delay: delay}
+	}
+	return plan
+}
+
+// A hedgedExecutor is a SqlExecutor that hedges its queries (see
+// QueryPlan.Hedge), and runs everything else on first.
+type hedgedExecutor struct {
+	dbmap *DbMap
+	first SqlExecutor
+	delay time.Duration
+}
+
+// hedgedResult is the response to an attempt to run a query.
+type hedgedResult struct {
+	attempt int
+	rows    *sql.Rows
+	err     error
+}
+
+// dbs returns the databases to send the first and second attempts of
+// a query to.  Strong reads (see Consistency) are only hedged on the
+// primary database; eventual reads are hedged on another replica, if
+// there is one.
+func (h *hedgedExecutor) dbs() (first, second *sql.DB) {
+	replica, ok := withoutContext(h.first).(*replicaExecutor)
+	if !ok {
+		return h.dbmap.Db, h.dbmap.Db
+	}
+	first, second = replica.db, replica.db
+	for range h.dbmap.replicas {
+		if other := h.dbmap.replica(); other.db != first {
+			second = other.db
+			break
+		}
+	}
+	return first, second
+}
+
+// A hedgeContext is the context of one attempt to run a hedged query.
+// It is done once the attempt has lost, or once its parent, the
+// context of the query, is done.  Unlike a context created with
+// context.WithCancel, the winning attempt's context (which its rows
+// keep using) holds nothing that must be released, other than a
+// goroutine waiting for a parent that can be canceled to be done.
+type hedgeContext struct {
+	context.Context
+	done chan struct{}
+	once sync.Once
+}
+
+func newHedgeContext(parent context.Context) *hedgeContext {
+	c := &hedgeContext{Context: parent, done: make(chan struct{})}
+	if parent.Done() != nil {
+		go func() {
+			select {
+			case <-parent.Done():
+				c.lose()
+			case <-c.done:
+			}
+		}()
+	}
+	return c
+}
+
+func (c *hedgeContext) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *hedgeContext) Err() error {
+	select {
+	case <-c.done:
+		if err := c.Context.Err(); err != nil {
+			return err
+		}
+		return context.Canceled
+	default:
+		return nil
+	}
+}
+
+// lose cancels the attempt.
+func (c *hedgeContext) lose() {
+	c.once.Do(func() { close(c.done) })
+}
+
+func (h *hedgedExecutor) query(query string, args ...interface{}) (*sql.Rows, error) {
+	h.dbmap.trace(query, args...)
+	defer h.dbmap.traceSlow(time.Now(), query, args)
+
+	first, second := h.dbs()
+	parent := executorContext(h.first)
+	var attempts [2]*hedgeContext
+	results := make(chan hedgedResult, 2)
+	run := func(attempt int, db *sql.DB) {
+		ctx := newHedgeContext(parent)
+		attempts[attempt] = ctx
+		go func() {
+			rows, err := db.QueryContext(ctx, query, args...)
+			results <- hedgedResult{attempt: attempt, rows: rows, err: err}
+		}()
+	}
+
+	run(0, first)
+	timer := time.NewTimer(h.delay)
+	defer timer.Stop()
+	select {
+	case result := <-results:
+		return result.rows, result.err
+	case <-timer.C:
+	}
+	run(1, second)
+
+	result := <-results
+	if result.err != nil {
+		result = <-results
+	} else {
+		attempts[1-result.attempt].lose()
+		go func() {
+			if other := <-results; other.rows != nil {
+				other.rows.Close()
+			}
+		}()
+	}
+	return result.rows, result.err
+}
+
+func (h *hedgedExecutor) queryRow(query string, args ...interface{}) *sql.Row {
+	return h.first.queryRow(query, args...)
+}
+
+func (h *hedgedExecutor) Get(i interface{}, keys ...interface{}) (interface{}, error) {
+	return get(h.dbmap, h, i, keys...)
+}
+
+func (h *hedgedExecutor) Insert(list ...interface{}) error {
+	return h.first.Insert(list...)
+}
+
+func (h *hedgedExecutor) Update(list ...interface{}) (int64, error) {
+	return h.first.Update(list...)
+}
+
+func (h *hedgedExecutor) Delete(list ...interface{}) (int64, error) {
+	return h.first.Delete(list...)
+}
+
+func (h *hedgedExecutor) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return h.first.Exec(query, args...)
+}
+
+func (h *hedgedExecutor) Select(i interface{}, query string, args ...interface{}) ([]interface{}, error) {
+	return hookedselect(h.dbmap, h, i, query, args...)
+}
+
+func (h *hedgedExecutor) SelectInt(query string, args ...interface{}) (int64, error) {
+	return SelectInt(h, query, args...)
+}
+
+func (h *hedgedExecutor) SelectNullInt(query string, args ...interface{}) (sql.NullInt64, error) {
+	return SelectNullInt(h, query, args...)
+}
+
+func (h *hedgedExecutor) SelectFloat(query string, args ...interface{}) (float64, error) {
+	return SelectFloat(h, query, args...)
+}
+
+func (h *hedgedExecutor) SelectNullFloat(query string, args ...interface{}) (sql.NullFloat64, error) {
+	return SelectNullFloat(h, query, args...)
+}
+
+func (h *hedgedExecutor) SelectStr(query string, args ...interface{}) (string, error) {
+	return SelectStr(h, query, args...)
+}
+
+func (h *hedgedExecutor) SelectNullStr(query string, args ...interface{}) (sql.NullString, error) {
+	return SelectNullStr(h, query, args...)
+}
+
+func (h *hedgedExecutor) SelectOne(holder interface{}, query string, args ...interface{}) error {
+	return SelectOne(h.dbmap, h, holder, query, args...)
+}
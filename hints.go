@@ -0,0 +1,77 @@
+package gorp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Hint adds optimizer hints to this plan's select statement, for the
+// rare queries where the database's planner needs help.  The dialect
+// must implement OptimizerHinter; on MySQL, hints are written in a
+// /*+ ... */ comment after the select keyword:
+//
+//     plan.Where().Equal(&inv.PersonId, id).Hint("NO_RANGE_OPTIMIZATION(invoice)")
+//
+// Hints are added to the statement as is, so they must never come
+// from user input.
+func (plan *QueryPlan) Hint(hints ...string) SelectQuery {
+	if _, ok := plan.table.dbmap.Dialect.(OptimizerHinter); !ok {
+		plan.Errors = append(plan.Errors, fmt.Errorf("gorp: Hint: dialect %T does not support optimizer hints", plan.table.dbmap.Dialect))
+		return plan
+	}
+	for _, hint := range hints {
+		if strings.Contains(hint, "*/") {
+			plan.Errors = append(plan.Errors, fmt.Errorf("gorp: Hint: hint %q may not end the hint comment", hint))
+			return plan
+		}
+	}
+	plan.hints = append(plan.hints, hints...)
+	return plan
+}
+
+// UseIndex tells the database to use one of the named indexes when
+// reading this plan's table.  The dialect must implement IndexHinter
+// (e.g. MySQL, which generates a use index clause).  Index names are
+// quoted, and index hints only apply to selects.
+func (plan *QueryPlan) UseIndex(indexes ...string) SelectQuery {
+	if _, ok := plan.table.dbmap.Dialect.(IndexHinter); !ok {
+		plan.Errors = append(plan.Errors, fmt.Errorf("gorp: UseIndex: dialect %T does not support index hints", plan.table.dbmap.Dialect))
+		return plan
+	}
+	for _, index := range indexes {
+		plan.indexes = append(plan.indexes, plan.table.dbmap.Dialect.QuoteField(index))
+	}
+	return plan
+}
+
+// Comment tags the statements generated by this plan with a SQL
+// comment (in the style of Rails' marginalia), so DBAs can attribute
+// slow queries in the database's logs to the code that ran them:
+//
+//     dbmap.Query(inv).Comment("service=billing, handler=ListInvoices").
+//         Where().Equal(&inv.PersonId, id).Select()
+//
+// generates "select ... /*service=billing, handler=ListInvoices*/".
+// Comment delimiters are removed from comment, so it can't end the
+// comment early.  Comments aren't part of cached statements, so they
+// may hold per-request values like trace ids.
+func (plan *QueryPlan) Comment(comment string) Query {
+	for {
+		sanitized := strings.NewReplacer("/*", "", "*/", "").Replace(comment)
+		if sanitized == comment {
+			break
+		}
+		comment = sanitized
+	}
+	plan.comment = strings.TrimSpace(comment)
+	return plan
+}
+
+// commentClause returns the comment to append to this plan's
+// statements, if it has one.
+func (plan *QueryPlan) commentClause() string {
+	if plan.comment == "" {
+		return ""
+	}
+	return " /*" + plan.comment + "*/"
+}
@@ -0,0 +1,186 @@
+package gorp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// A hydratedField is a field of a plan's target that is loaded from
+// the columns of a joined table.
+type hydratedField struct {
+	name        string
+	index       []int
+	table       *TableMap
+	quotedTable string
+}
+
+// Hydrate selects the columns of joined tables into fieldPtrs,
+// pointers to transient fields (tagged with `db:"-"`) of the query's
+// target whose type is a joined table's type or a pointer to it.  The
+// joined columns are selected with aliases, so one query loads the
+// rows and the rows they are joined to:
+//
+//     type InvoiceWithPerson struct {
+//         Invoice
+//         Person *Person `db:"-"`
+//     }
+//
+//     inv := new(InvoiceWithPerson)
+//     person := new(Person)
+//     var invoices []*InvoiceWithPerson
+//     err := dbmap.Query(inv).
+//         Join(person).On(gorp.Equal(&inv.PersonId, &person.Id)).
+//         Where().
+//         Equal(&inv.IsPaid, false).
+//         Hydrate(&inv.Person).
+//         SelectToTarget(&invoices)
+//
+// Nil pointer fields are allocated for each row.  The joined tables'
+// column access rules and masks are applied for the plan's role, but
+// their PostGet hooks aren't run.  Hydrated plans can only be run
+// with Select, SelectToTarget, and SelectEach, and aren't cached or
+// memoized.
+func (plan *QueryPlan) Hydrate(fieldPtrs ...interface{}) SelectQuery {
+	plan.storeJoin()
+	for _, fieldPtr := range fieldPtrs {
+		field, err := plan.hydratedField(fieldPtr)
+		if err != nil {
+			plan.Errors = append(plan.Errors, err)
+			return plan
+		}
+		plan.hydrated = append(plan.hydrated, field)
+	}
+	return plan
+}
+
+// hydratedField finds the joined table that fieldPtr should be loaded
+// from.
+func (plan *QueryPlan) hydratedField(fieldPtr interface{}) (hydratedField, error) {
+	for _, fieldMap := range plan.colMap {
+		if fieldMap.addr != fieldPtr {
+			continue
+		}
+		name := fieldMap.column.fieldName
+		if !fieldMap.column.Transient {
+			return hydratedField{}, fmt.Errorf("gorp: Hydrate: field %s is not transient", name)
+		}
+		structField, ok := plan.table.gotype.FieldByName(name)
+		if !ok {
+			return hydratedField{}, fmt.Errorf("gorp: Hydrate: field %s does not belong to table %s", name, plan.table.TableName)
+		}
+		t := structField.Type
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		for _, join := range plan.joins {
+			if join.table != nil && join.table.gotype == t {
+				return hydratedField{name: name, index: structField.Index, table: join.table, quotedTable: join.quotedJoinTable}, nil
+			}
+		}
+		return hydratedField{}, fmt.Errorf("gorp: Hydrate: no joined table has the type of field %s", name)
+	}
+	return hydratedField{}, errors.New("gorp: Hydrate: cannot find a field matching the passed in pointer")
+}
+
+// hydratedColumns returns the select list entries for the plan's
+// hydrated fields.
+func (plan *QueryPlan) hydratedColumns() string {
+	dialect := plan.table.dbmap.Dialect
+	buffer := bytes.Buffer{}
+	for _, field := range plan.hydrated {
+		for _, col := range field.table.columns {
+			if !col.inSchema() || !plan.readable(col) {
+				continue
+			}
+			buffer.WriteString(",")
+			buffer.WriteString(field.quotedTable)
+			buffer.WriteString(".")
+			buffer.WriteString(dialect.QuoteField(col.ColumnName))
+			buffer.WriteString(" as ")
+			buffer.WriteString(dialect.QuoteField(field.name + "__" + col.ColumnName))
+		}
+	}
+	return buffer.String()
+}
+
+// hydratedScanner returns a rowScanner for the columns selected by a
+// hydrated plan, which maps them to fields by position instead of by
+// name.
+func (plan *QueryPlan) hydratedScanner() *rowScanner {
+	t := plan.table.gotype
+	var indexes [][]int
+	for _, col := range plan.selectColumns() {
+		field, _ := t.FieldByName(col.fieldName)
+		indexes = append(indexes, field.Index)
+	}
+	for _, hydrated := range plan.hydrated {
+		for _, col := range hydrated.table.columns {
+			if !col.inSchema() || !plan.readable(col) {
+				continue
+			}
+			field, _ := hydrated.table.gotype.FieldByName(col.fieldName)
+			index := append(append([]int(nil), hydrated.index...), field.Index...)
+			indexes = append(indexes, index)
+		}
+	}
+	for _, computed := range plan.computed {
+		field, _ := t.FieldByName(computed.alias)
+		indexes = append(indexes, field.Index)
+	}
+	return positionalScanner(plan.dbMap, indexes)
+}
+
+// positionalScanner returns a rowScanner that scans each column into
+// the field with the corresponding index path.
+func positionalScanner(m *DbMap, indexes [][]int) *rowScanner {
+	cols := make([]string, len(indexes))
+	return &rowScanner{cols: cols, intoStruct: true, colToFieldIndex: indexes, conv: m.TypeConverter}
+}
+
+// eachHydrated runs a hydrated plan's select statement, passing each
+// row to handler as a pointer to a new value of the plan's type.
+func (plan *QueryPlan) eachHydrated(handler func(v reflect.Value) error) error {
+	if len(plan.Errors) > 0 {
+		return plan.Errors[0]
+	}
+	query, err := plan.buildSelectQuery()
+	if err != nil {
+		return err
+	}
+	scanner := plan.hydratedScanner()
+	rows, err := plan.executor.query(query, plan.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	role := RoleFromContext(plan.ctx)
+	masked := plan.maskedColumns()
+	for rows.Next() {
+		v := reflect.New(plan.table.gotype)
+		for _, hydrated := range plan.hydrated {
+			field := v.Elem().FieldByIndex(hydrated.index)
+			if field.Kind() == reflect.Ptr && field.IsNil() {
+				field.Set(reflect.New(field.Type().Elem()))
+			}
+		}
+		if err = scanner.scan(rows, v); err != nil {
+			return err
+		}
+		maskRow(masked, v)
+		for _, hydrated := range plan.hydrated {
+			maskRow(hydrated.table.maskedColumns(role), v.Elem().FieldByIndex(hydrated.index))
+		}
+		if hook, ok := v.Interface().(HasPostGet); ok {
+			if err = hook.PostGet(plan.executor); err != nil {
+				return err
+			}
+		}
+		if err = handler(v); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,207 @@
+package gorp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// A RowIterator produces the rows imported by DbMap.Import.
+type RowIterator interface {
+	// Next returns the next row, a pointer to a struct of the
+	// imported table's type, or io.EOF if there are no more rows.
+	Next(ctx context.Context) (interface{}, error)
+}
+
+// RowIteratorFunc is a function that implements RowIterator.
+type RowIteratorFunc func(ctx context.Context) (interface{}, error)
+
+// Next calls f(ctx).
+func (f RowIteratorFunc) Next(ctx context.Context) (interface{}, error) {
+	return f(ctx)
+}
+
+// ImportOptions configure DbMap.Import.
+type ImportOptions struct {
+	// BatchSize is the number of rows inserted in each transaction.
+	// It defaults to 1000.
+	BatchSize int
+
+	// Total is the number of rows the iterator is expected to
+	// produce, including resumed rows, or 0 if it isn't known.  It
+	// is only used to estimate the time remaining.
+	Total int64
+
+	// ResumeAfter is the number of rows committed by a previous,
+	// interrupted import of the same rows.  That many rows are read
+	// from the iterator and skipped.
+	ResumeAfter int64
+
+	// Progress, if not nil, is called after each batch is committed.
+	Progress func(ImportProgress)
+}
+
+// ImportProgress reports the progress of DbMap.Import.
+type ImportProgress struct {
+	// Rows is the number of rows committed so far, including
+	// resumed rows.  Pass it as ImportOptions.ResumeAfter to resume
+	// the import after this batch.
+	Rows int64
+
+	// Batches is the number of batches committed so far.
+	Batches int
+
+	// Elapsed is the time since the import started.
+	Elapsed time.Duration
+
+	// Remaining is the estimated time until the import finishes,
+	// based on the rate so far, or 0 if ImportOptions.Total is 0.
+	Remaining time.Duration
+}
+
+// Import inserts the rows produced by rows into model's table, in
+// batches of opts.BatchSize rows that are each inserted (using
+// Insert, so hooks are run) in a transaction of their own.  Rows are
+// only read from the iterator while there is room in the current
+// batch, so a slow database slows down reading instead of buffering
+// rows in memory:
+//
+//     n, err := dbmap.Import(ctx, Person{}, csvRows, gorp.ImportOptions{
+//         BatchSize:   500,
+//         Total:       lineCount,
+//         ResumeAfter: checkpoint.Load(),
+//         Progress: func(p gorp.ImportProgress) {
+//             checkpoint.Save(p.Rows)
+//             log.Printf("imported %d rows, %s left", p.Rows, p.Remaining)
+//         },
+//     })
+//
+// Import returns the number of rows committed, including resumed rows,
+// which is where the import can be resumed if it fails.  It stops
+// with ctx.Err() if ctx is canceled, rolling back the current batch.
+// If ctx has a deadline, Import stops with ErrDeadline before a batch
+// when the deadline is closer than the slowest batch so far took, so
+// that batches finish instead of being canceled.
+func (m *DbMap) Import(ctx context.Context, model interface{}, rows RowIterator, opts ImportOptions) (int64, error) {
+	t, err := toType(model)
+	if err != nil {
+		return 0, err
+	}
+	table, err := m.tableFor(t, false)
+	if err != nil {
+		return 0, err
+	}
+	if opts.BatchSize == 0 {
+		opts.BatchSize = 1000
+	}
+	if opts.BatchSize < 0 {
+		return 0, fmt.Errorf("gorp: Import: invalid batch size %d", opts.BatchSize)
+	}
+
+	imp := &importer{dbmap: m, table: table, rows: rows, opts: opts, start: time.Now()}
+	for skipped := int64(0); skipped < opts.ResumeAfter; skipped++ {
+		if _, err := imp.next(ctx); err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("gorp: Import: cannot resume after %d rows, the iterator only has %d", opts.ResumeAfter, skipped)
+			}
+			return 0, err
+		}
+	}
+	imp.committed = opts.ResumeAfter
+	var timer batchTimer
+	for {
+		if err := timer.begin(ctx); err != nil {
+			return imp.committed, err
+		}
+		done, err := imp.importBatch(ctx)
+		if err != nil || done {
+			return imp.committed, err
+		}
+		timer.end()
+	}
+}
+
+// An importer holds the state of a running Import.
+type importer struct {
+	dbmap     *DbMap
+	table     *TableMap
+	rows      RowIterator
+	opts      ImportOptions
+	start     time.Time
+	committed int64
+	batches   int
+}
+
+// next returns the next row from the iterator, checking that it
+// belongs to the imported table.
+func (imp *importer) next(ctx context.Context) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	row, err := imp.rows.Next(ctx)
+	if err != nil {
+		return nil, err
+	}
+	table, _, err := imp.dbmap.tableForPointer(row, false)
+	if err != nil {
+		return nil, err
+	}
+	if table != imp.table {
+		return nil, fmt.Errorf("gorp: Import: row of type %T does not belong to table %s", row, imp.table.TableName)
+	}
+	return row, nil
+}
+
+// importBatch inserts and commits the next batch of rows, and returns
+// true if the iterator has no more rows.
+func (imp *importer) importBatch(ctx context.Context) (bool, error) {
+	batch := make([]interface{}, 0, imp.opts.BatchSize)
+	done := false
+	for len(batch) < imp.opts.BatchSize {
+		row, err := imp.next(ctx)
+		if err == io.EOF {
+			done = true
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+		batch = append(batch, row)
+	}
+	if len(batch) == 0 {
+		return true, nil
+	}
+
+	tx, err := imp.dbmap.Begin()
+	if err != nil {
+		return false, err
+	}
+	if err = insert(imp.dbmap, tx, batch...); err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	imp.committed += int64(len(batch))
+	imp.batches++
+	if imp.opts.Progress != nil {
+		imp.opts.Progress(imp.progress())
+	}
+	return done, nil
+}
+
+// progress returns the progress of the import after a batch has been
+// committed.
+func (imp *importer) progress() ImportProgress {
+	p := ImportProgress{Rows: imp.committed, Batches: imp.batches, Elapsed: time.Since(imp.start)}
+	imported := imp.committed - imp.opts.ResumeAfter
+	if imp.opts.Total > imp.committed && imported > 0 {
+		p.Remaining = time.Duration(float64(p.Elapsed) / float64(imported) * float64(imp.opts.Total-imp.committed))
+	}
+	return p
+}
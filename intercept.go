@@ -0,0 +1,57 @@
+package gorp
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// A PlanInterceptor answers selects run by query plans instead of the
+// database, e.g. so unit tests can set expectations on the structure
+// of a query rather than on its SQL (see the gorptest package).
+//
+// InterceptSelect is called with the plan's table and its definition
+// (see QueryPlan.Definition), which is nil if the plan can't be
+// defined.  If handled is false, the select is run as usual;
+// otherwise rows (pointers to values of the table's type) and err are
+// returned to the caller.
+type PlanInterceptor interface {
+	InterceptSelect(table *TableMap, def *PlanDefinition) (rows []interface{}, handled bool, err error)
+}
+
+// InterceptPlans makes this DbMap pass the selects run by its query
+// plans (using Select or SelectToTarget) to interceptor first.  Pass
+// nil to stop intercepting.
+func (m *DbMap) InterceptPlans(interceptor PlanInterceptor) {
+	m.interceptor = interceptor
+}
+
+// intercept passes the plan to the DbMap's interceptor, if it has one.
+func (plan *QueryPlan) intercept() ([]interface{}, bool, error) {
+	if plan.dbMap.interceptor == nil || len(plan.Errors) > 0 {
+		return nil, false, nil
+	}
+	def, err := plan.Definition()
+	if err != nil {
+		def = nil
+	}
+	return plan.dbMap.interceptor.InterceptSelect(plan.table, def)
+}
+
+// appendRows appends rows, pointers to structs, to target, a pointer
+// to a slice of structs or of pointers to structs.
+func appendRows(target interface{}, rows []interface{}) error {
+	slice := reflect.ValueOf(target).Elem()
+	elemType := slice.Type().Elem()
+	for _, row := range rows {
+		v := reflect.ValueOf(row)
+		switch {
+		case v.Type() == elemType:
+		case v.Kind() == reflect.Ptr && v.Elem().Type() == elemType:
+			v = v.Elem()
+		default:
+			return fmt.Errorf("gorp: Cannot append a row of type %T to %s", row, slice.Type())
+		}
+		slice.Set(reflect.Append(slice, v))
+	}
+	return nil
+}
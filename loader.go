@@ -0,0 +1,206 @@
+package gorp
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// DefaultLoaderWait is the default amount of time that a Loader waits
+// for more keys before running a batch.
+const DefaultLoaderWait = time.Millisecond
+
+// A Loader coalesces lookups of individual rows by primary key into a
+// single "where key in (...)" select, for use by GraphQL resolvers and
+// other code that fans out into many lookups by key.  Create one with
+// LoaderFor; a Loader is normally created per request, so that rows
+// aren't cached between requests.
+//
+// Batch has the shape of a dataloader batch function, so a Loader can
+// also be plugged into an existing dataloader library instead of
+// using Load.
+type Loader struct {
+	// Wait is the amount of time that Load waits for more keys before
+	// running a batch.
+	Wait time.Duration
+
+	// MaxBatch is the maximum number of keys in a single batch.  A
+	// batch is run as soon as it is full.  Zero means no limit.
+	MaxBatch int
+
+	dbmap   *DbMap
+	exec    SqlExecutor
+	table   *TableMap
+	keyType reflect.Type
+
+	mu    sync.Mutex
+	batch *loaderBatch
+}
+
+// A loaderBatch is a set of keys waiting to be loaded together.
+type loaderBatch struct {
+	keys    []interface{}
+	once    sync.Once
+	done    chan struct{}
+	results []interface{}
+	errs    []error
+}
+
+// LoaderFor returns a Loader for rows of model's table, which must
+// have a single primary key column.
+func LoaderFor(m *DbMap, model interface{}) (*Loader, error) {
+	return loaderFor(m, m, model)
+}
+
+func loaderFor(m *DbMap, exec SqlExecutor, model interface{}) (*Loader, error) {
+	t, err := toType(model)
+	if err != nil {
+		return nil, err
+	}
+	table, err := m.tableFor(t, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(table.keys) != 1 {
+		return nil, fmt.Errorf("gorp: LoaderFor: table %s must have exactly one primary key column", table.TableName)
+	}
+	field, _ := t.FieldByName(table.keys[0].fieldName)
+	return &Loader{
+		Wait:    DefaultLoaderWait,
+		dbmap:   m,
+		exec:    exec,
+		table:   table,
+		keyType: field.Type,
+	}, nil
+}
+
+// Load returns the row with the passed in primary key, or nil if
+// there is no such row.  Keys passed to Load by other goroutines
+// within the loader's wait time are loaded using the same query.
+func (l *Loader) Load(key interface{}) (interface{}, error) {
+	l.mu.Lock()
+	b := l.batch
+	if b == nil {
+		b = &loaderBatch{done: make(chan struct{})}
+		l.batch = b
+		time.AfterFunc(l.Wait, func() { l.dispatch(b) })
+	}
+	index := len(b.keys)
+	b.keys = append(b.keys, key)
+	full := l.MaxBatch > 0 && len(b.keys) >= l.MaxBatch
+	l.mu.Unlock()
+
+	if full {
+		l.dispatch(b)
+	}
+	<-b.done
+	return b.results[index], b.errs[index]
+}
+
+// dispatch runs b, if it hasn't already been run.
+func (l *Loader) dispatch(b *loaderBatch) {
+	l.mu.Lock()
+	if l.batch == b {
+		l.batch = nil
+	}
+	l.mu.Unlock()
+	b.once.Do(func() {
+		b.results, b.errs = l.Batch(b.keys)
+		close(b.done)
+	})
+}
+
+// Batch loads the rows for all of the passed in keys using a single
+// query.  The returned slices are the same length as keys, with each
+// result matching the key at the same index; keys with no matching row
+// have a nil result and a nil error.
+func (l *Loader) Batch(keys []interface{}) ([]interface{}, []error) {
+	results := make([]interface{}, len(keys))
+	errs := make([]error, len(keys))
+	if len(keys) == 0 {
+		return results, errs
+	}
+
+	seen := make(map[interface{}]bool, len(keys))
+	args := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		normalized := l.normalizeKey(key)
+		if !seen[normalized] {
+			seen[normalized] = true
+			args = append(args, normalized)
+		}
+	}
+
+	rows, err := l.exec.Select(reflect.New(l.table.gotype).Interface(), selectInQuery(l.table, l.table.keys[0], len(args)), args...)
+	if err != nil {
+		for index := range errs {
+			errs[index] = err
+		}
+		return results, errs
+	}
+	byKey := make(map[interface{}]interface{}, len(rows))
+	keyField := l.table.keys[0].fieldName
+	for _, row := range rows {
+		byKey[reflect.ValueOf(row).Elem().FieldByName(keyField).Interface()] = row
+	}
+	for index, key := range keys {
+		results[index] = byKey[l.normalizeKey(key)]
+	}
+	return results, errs
+}
+
+// selectInQuery returns a statement selecting all of table's columns
+// from rows where col is in a list of count bind variables.
+func selectInQuery(table *TableMap, col *ColumnMap, count int) string {
+	dialect := table.dbmap.Dialect
+	s := bytes.Buffer{}
+	s.WriteString("select ")
+	x := 0
+	for _, col := range table.columns {
+		if col.inSchema() {
+			if x > 0 {
+				s.WriteString(",")
+			}
+			s.WriteString(dialect.QuoteField(col.ColumnName))
+			x++
+		}
+	}
+	s.WriteString(" from ")
+	s.WriteString(dialect.QuotedTableForQuery(table.SchemaName, table.TableName))
+	s.WriteString(" where ")
+	s.WriteString(dialect.QuoteField(col.ColumnName))
+	s.WriteString(" in (")
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			s.WriteString(",")
+		}
+		s.WriteString(dialect.BindVar(i))
+	}
+	s.WriteString(")")
+	return s.String()
+}
+
+// normalizeKey converts numeric keys to the type of the primary key
+// field, so that e.g. an int key matches an int64 primary key.
+func (l *Loader) normalizeKey(key interface{}) interface{} {
+	value := reflect.ValueOf(key)
+	if !value.IsValid() || value.Type() == l.keyType {
+		return key
+	}
+	if isNumericKind(value.Kind()) && isNumericKind(l.keyType.Kind()) {
+		return value.Convert(l.keyType).Interface()
+	}
+	return key
+}
+
+func isNumericKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package gorp
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// A ManyToManyMap describes a many-to-many association between two
+// tables through a join table, whose rows hold the primary keys of a
+// row in each table.  Create one with TableMap.ManyToMany.
+type ManyToManyMap struct {
+	left     *TableMap
+	right    *TableMap
+	join     *TableMap
+	leftKey  *ColumnMap
+	rightKey *ColumnMap
+}
+
+// ManyToMany adds a many-to-many association between this table and
+// other's table, through joinTable's table.  keyField is the name of
+// the field in joinTable that holds the primary key of a row in this
+// table, and otherKeyField the name of the field that holds the
+// primary key of a row in other's table:
+//
+//     dbmap.AddTable(Tag{}).SetKeys(true, "Id")
+//     dbmap.AddTable(PostTag{}).SetKeys(false, "PostId", "TagId")
+//     dbmap.AddTable(Post{}).SetKeys(true, "Id").
+//         ManyToMany(Tag{}, PostTag{}, "PostId", "TagId")
+//
+// Both tables must have a single primary key column, and other's and
+// joinTable's tables must already be registered.  Panics if any of the
+// tables or fields can't be found.  The association can be loaded from
+// either side with DbMap.Related.
+func (t *TableMap) ManyToMany(other interface{}, joinTable interface{}, keyField, otherKeyField string) *ManyToManyMap {
+	otherTable := t.dbmap.mustTableFor(other)
+	joinMap := t.dbmap.mustTableFor(joinTable)
+	for _, table := range []*TableMap{t, otherTable} {
+		if len(table.keys) != 1 {
+			panic(fmt.Sprintf("gorp: ManyToMany: table %s must have exactly one primary key column", table.TableName))
+		}
+	}
+	assoc := &ManyToManyMap{
+		left:     t,
+		right:    otherTable,
+		join:     joinMap,
+		leftKey:  joinMap.ColMap(keyField),
+		rightKey: joinMap.ColMap(otherKeyField),
+	}
+	t.manyToMany = append(t.manyToMany, assoc)
+	return assoc
+}
+
+// mustTableFor returns the table for model's type, panicking if it
+// isn't registered.
+func (m *DbMap) mustTableFor(model interface{}) *TableMap {
+	t, err := toType(model)
+	if err != nil {
+		panic(err.Error())
+	}
+	table, err := m.tableFor(t, false)
+	if err != nil {
+		panic(err.Error())
+	}
+	return table
+}
+
+// Related loads the rows associated with parent through a
+// many-to-many association (see TableMap.ManyToMany) into children,
+// which must be a pointer to a slice of the associated table's type
+// (or of pointers to it):
+//
+//     var tags []*Tag
+//     err := dbmap.Related(post, &tags)
+//
+// The rows are loaded with a query plan that joins the join table, so
+// column access rules, masks, and memos apply as they do for Query.
+func (m *DbMap) Related(parent interface{}, children interface{}) error {
+	return related(m, m, parent, children)
+}
+
+// Related has the same behavior as DbMap.Related(), but runs in a
+// transaction.
+func (t *Transaction) Related(parent interface{}, children interface{}) error {
+	return related(t.dbmap, t, parent, children)
+}
+
+func related(m *DbMap, exec SqlExecutor, parent interface{}, children interface{}) error {
+	parentTable, parentVal, err := m.tableForPointer(parent, false)
+	if err != nil {
+		return err
+	}
+	childType, err := toSliceType(children)
+	if err != nil {
+		return err
+	}
+	if childType == nil {
+		return errors.New("gorp: Related must be run with a pointer to a slice as its target")
+	}
+	if childType.Kind() == reflect.Ptr {
+		childType = childType.Elem()
+	}
+	childTable, err := m.tableFor(childType, false)
+	if err != nil {
+		return err
+	}
+	assoc, parentKey, childKey := m.manyToManyBetween(parentTable, childTable)
+	if assoc == nil {
+		return fmt.Errorf("gorp: Related: no many-to-many association between tables %s and %s", parentTable.TableName, childTable.TableName)
+	}
+
+	child := reflect.New(childTable.gotype)
+	join := reflect.New(assoc.join.gotype)
+	field := func(v reflect.Value, col *ColumnMap) interface{} {
+		return v.Elem().FieldByName(col.fieldName).Addr().Interface()
+	}
+	key := parentVal.FieldByName(parentTable.keys[0].fieldName).Interface()
+	return query(m, exec, child.Interface()).
+		Join(join.Interface()).On(Equal(field(join, childKey), field(child, childTable.keys[0]))).
+		Where().
+		Equal(field(join, parentKey), key).
+		SelectToTarget(children)
+}
+
+// manyToManyBetween returns the many-to-many association between the
+// parent and child tables, along with the join table's columns that
+// hold their keys.
+func (m *DbMap) manyToManyBetween(parent, child *TableMap) (*ManyToManyMap, *ColumnMap, *ColumnMap) {
+	for _, table := range m.tables {
+		for _, assoc := range table.manyToMany {
+			if assoc.left == parent && assoc.right == child {
+				return assoc, assoc.leftKey, assoc.rightKey
+			}
+			if assoc.right == parent && assoc.left == child {
+				return assoc, assoc.rightKey, assoc.leftKey
+			}
+		}
+	}
+	return nil, nil, nil
+}
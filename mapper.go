@@ -0,0 +1,51 @@
+package gorp
+
+import (
+	"context"
+	"database/sql"
+)
+
+// DbMapper covers the query generation and CRUD operations of a
+// DbMap, so that application code can depend on it instead of on
+// *DbMap and tests can substitute a fake:
+//
+//     type InvoiceStore struct {
+//         db gorp.DbMapper
+//     }
+//
+//     func (s *InvoiceStore) Unpaid() ([]interface{}, error) {
+//         inv := new(Invoice)
+//         return s.db.Query(inv).Where().Equal(&inv.IsPaid, false).Select()
+//     }
+//
+// Unlike SqlExecutor, DbMapper has no unexported methods, so it can be
+// implemented outside of this package.  Both *DbMap and *Transaction
+// implement it, so code written against it can also be run in a
+// transaction.  Table mapping, schema management, and transaction
+// control are left to *DbMap.
+type DbMapper interface {
+	Query(target interface{}) Query
+	QueryContext(ctx context.Context, target interface{}) Query
+	QueryDefinition(target interface{}, def *PlanDefinition) SelectQuery
+	QueryFrom(sub SelectQuery, target interface{}, columns ...ResultColumn) Query
+	CountWhere(model interface{}, filters ...Filter) (int64, error)
+	ExistsWhere(model interface{}, filters ...Filter) (bool, error)
+
+	Get(i interface{}, keys ...interface{}) (interface{}, error)
+	Insert(list ...interface{}) error
+	Update(list ...interface{}) (int64, error)
+	Delete(list ...interface{}) (int64, error)
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Select(i interface{}, query string, args ...interface{}) ([]interface{}, error)
+	SelectInt(query string, args ...interface{}) (int64, error)
+	SelectNullInt(query string, args ...interface{}) (sql.NullInt64, error)
+	SelectFloat(query string, args ...interface{}) (float64, error)
+	SelectNullFloat(query string, args ...interface{}) (sql.NullFloat64, error)
+	SelectStr(query string, args ...interface{}) (string, error)
+	SelectNullStr(query string, args ...interface{}) (sql.NullString, error)
+	SelectOne(holder interface{}, query string, args ...interface{}) error
+}
+
+// Compile-time check that DbMap and Transaction implement the DbMapper
+// interface.
+var _, _ DbMapper = &DbMap{}, &Transaction{}
@@ -0,0 +1,163 @@
+package gorp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"strings"
+)
+
+type roleContextKey struct{}
+
+// WithRole returns a copy of ctx carrying role.  Query plans created
+// with DbMap.QueryContext (or Transaction.QueryContext) using the
+// returned context decide which columns to mask (see ColumnMap.SetMask)
+// based on role.
+func WithRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, roleContextKey{}, role)
+}
+
+// RoleFromContext returns the role attached to ctx by WithRole, or ""
+// if there is none.
+func RoleFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	role, _ := ctx.Value(roleContextKey{}).(string)
+	return role
+}
+
+// A Mask obfuscates a value loaded from the database.  v is the
+// settable field (or slice element, or map value) that was loaded.
+type Mask func(v reflect.Value)
+
+// MaskNull replaces values with their type's zero value.
+func MaskNull(v reflect.Value) {
+	v.Set(reflect.Zero(v.Type()))
+}
+
+// MaskLast replaces all but the last n characters of string values
+// with '*', e.g. MaskLast(4) turns "4111111111111111" into
+// "************1111".  Values that aren't strings or byte slices are
+// replaced with their zero value.
+func MaskLast(n int) Mask {
+	return textMask(func(s string) string {
+		runes := []rune(s)
+		if len(runes) <= n {
+			return s
+		}
+		return strings.Repeat("*", len(runes)-n) + string(runes[len(runes)-n:])
+	})
+}
+
+// MaskHash replaces string values with the hex encoded SHA-256 hash
+// of the value, so masked values can still be compared with each
+// other.  Values that aren't strings or byte slices are replaced with
+// their zero value.
+var MaskHash = textMask(func(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+})
+
+// textMask returns a Mask that applies mask to string, *string, and
+// []byte values, and zeroes all other values.
+func textMask(mask func(string) string) Mask {
+	return func(v reflect.Value) {
+		switch {
+		case v.Kind() == reflect.String:
+			v.SetString(mask(v.String()))
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			if !v.IsNil() {
+				v.SetBytes([]byte(mask(string(v.Bytes()))))
+			}
+		case v.Kind() == reflect.Ptr && !v.IsNil() && v.Elem().Kind() == reflect.String:
+			masked := reflect.New(v.Type().Elem())
+			masked.Elem().SetString(mask(v.Elem().String()))
+			v.Set(masked)
+		default:
+			MaskNull(v)
+		}
+	}
+}
+
+// SetMask masks this column's values when they are loaded by query
+// plans, unless the plan's context carries one of unmaskedRoles (see
+// WithRole).  This lets lower-privilege code paths run the same
+// queries as everyone else and automatically receive masked values:
+//
+//     table.ColMap("CardNumber").SetMask(gorp.MaskLast(4), "billing")
+//     table.ColMap("Email").SetMask(gorp.MaskHash, "support", "billing")
+//
+// Masks are applied by Select, SelectToTarget, SelectEach,
+// SelectPooled, SelectRows, SelectColumn, and SelectMaps.  For
+// SelectToTarget, the mask is applied to the target's field with the
+// same name as the column's field, if there is one.  Get() and raw SQL
+// queries are not masked.  Pass a nil mask to remove masking.
+func (c *ColumnMap) SetMask(mask Mask, unmaskedRoles ...string) *ColumnMap {
+	c.mask = mask
+	c.unmaskedRoles = unmaskedRoles
+	return c
+}
+
+// masked returns true if col's values should be masked for role.
+func (c *ColumnMap) masked(role string) bool {
+	if c.mask == nil {
+		return false
+	}
+	for _, unmasked := range c.unmaskedRoles {
+		if unmasked == role {
+			return false
+		}
+	}
+	return true
+}
+
+// maskedColumns returns the columns of the plan's table that must be
+// masked for the role in the plan's context.
+func (plan *QueryPlan) maskedColumns() []*ColumnMap {
+	return plan.table.maskedColumns(RoleFromContext(plan.ctx))
+}
+
+// maskedColumns returns the columns of this table that must be masked
+// for role.
+func (t *TableMap) maskedColumns(role string) []*ColumnMap {
+	var cols []*ColumnMap
+	for _, col := range t.columns {
+		if col.masked(role) {
+			cols = append(cols, col)
+		}
+	}
+	return cols
+}
+
+// maskValue applies col's mask to value, a value returned by the
+// driver.
+func maskValue(col *ColumnMap, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	v := reflect.New(reflect.TypeOf(value)).Elem()
+	v.Set(reflect.ValueOf(value))
+	col.mask(v)
+	return v.Interface()
+}
+
+// maskRow applies the masks of cols to v, a struct or a pointer to a
+// struct.
+func maskRow(cols []*ColumnMap, v reflect.Value) {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	for _, col := range cols {
+		if field := v.FieldByName(col.fieldName); field.IsValid() && field.CanSet() {
+			col.mask(field)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package gorp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+)
+
+type memoContextKey struct{}
+
+// A Memo caches the results of select statements run by query plans
+// for the lifetime of a single request.  Create one with WithMemo.
+type Memo struct {
+	mu      sync.Mutex
+	results map[string]interface{}
+}
+
+// WithMemo returns a copy of ctx with a new, empty Memo attached.
+// Query plans created with DbMap.QueryContext (or
+// Transaction.QueryContext) using the returned context will cache
+// the results of Select() and SelectToTarget(), and return the cached
+// results when an identical select is run again using the same
+// context.  This is a cheap fix for layered code that issues the same
+// lookups more than once per request.
+//
+// Results are cached separately for each role (see WithRole), so
+// masked results are never returned to a role that may see the
+// unmasked values.  Callers get their own copies of the cached rows,
+// so they (and Preload) may set the rows' fields, but the copies are
+// shallow: slices, maps, and pointers held by fields are shared.  Any
+// insert, update, or delete run by a query plan with the same context
+// clears the memo.
+func WithMemo(ctx context.Context) context.Context {
+	return context.WithValue(ctx, memoContextKey{}, &Memo{results: make(map[string]interface{})})
+}
+
+// MemoFromContext returns the Memo attached to ctx by WithMemo, or nil
+// if there is none.
+func MemoFromContext(ctx context.Context) *Memo {
+	if ctx == nil {
+		return nil
+	}
+	memo, _ := ctx.Value(memoContextKey{}).(*Memo)
+	return memo
+}
+
+// Clear removes all cached results from the memo.
+func (memo *Memo) Clear() {
+	memo.mu.Lock()
+	defer memo.mu.Unlock()
+	memo.results = make(map[string]interface{})
+}
+
+func (memo *Memo) get(key string) (interface{}, bool) {
+	memo.mu.Lock()
+	defer memo.mu.Unlock()
+	result, ok := memo.results[key]
+	return result, ok
+}
+
+func (memo *Memo) set(key string, result interface{}) {
+	memo.mu.Lock()
+	defer memo.mu.Unlock()
+	memo.results[key] = result
+}
+
+// CacheKey builds a composite cache key out of parts (e.g. a type, a
+// query string, and its bind arguments).  Parts are formatted with
+// %#v (reflect.Type values are formatted by package and name), so two
+// sets of parts with the same types and values produce the same key.
+func CacheKey(parts ...interface{}) string {
+	buffer := bytes.Buffer{}
+	for index, part := range parts {
+		if index > 0 {
+			buffer.WriteString("\x00")
+		}
+		if t, ok := part.(reflect.Type); ok {
+			buffer.WriteString(t.PkgPath() + " " + t.String())
+			continue
+		}
+		fmt.Fprintf(&buffer, "%#v", part)
+	}
+	return buffer.String()
+}
+
+// copyRows returns shallow copies of rows, which are pointers to
+// structs, so that the memoized rows aren't changed by callers.
+func copyRows(rows []interface{}) []interface{} {
+	copies := make([]interface{}, len(rows))
+	for i, row := range rows {
+		copies[i] = copyRow(reflect.ValueOf(row)).Interface()
+	}
+	return copies
+}
+
+// copySlice returns a new slice holding shallow copies of the rows
+// of slice, whose elements are structs or pointers to structs.
+func copySlice(slice reflect.Value) reflect.Value {
+	copies := reflect.MakeSlice(slice.Type(), slice.Len(), slice.Len())
+	for i := 0; i < slice.Len(); i++ {
+		copies.Index(i).Set(copyRow(slice.Index(i)))
+	}
+	return copies
+}
+
+// copyRow returns a shallow copy of row if it is a pointer, or row
+// itself, which is copied when it is stored, otherwise.
+func copyRow(row reflect.Value) reflect.Value {
+	if row.Kind() != reflect.Ptr || row.IsNil() {
+		return row
+	}
+	copied := reflect.New(row.Type().Elem())
+	copied.Elem().Set(row.Elem())
+	return copied
+}
+
+// clearMemo clears the memo attached to the plan's context, if any.
+func (plan *QueryPlan) clearMemo() {
+	if memo := MemoFromContext(plan.ctx); memo != nil {
+		memo.Clear()
+	}
+}
+
+// A MemoMismatchFunc is called by VerifyMemos when a select answered
+// from a Memo returned different rows than the database.  diff
+// compares the memoized rows (left) with the database's rows (right).
+type MemoMismatchFunc func(query string, args []interface{}, diff *ResultDiff)
+
+// VerifyMemos makes query plans run a sample of the selects that they
+// answer from a Memo (see WithMemo) against the database as well, and
+// compare the two results using row checksums (see CompareResults).
+// Any differences are passed to onMismatch, so that writes that
+// should have cleared a memo but didn't (e.g. raw SQL run with Exec)
+// are detected automatically:
+//
+//     dbmap.VerifyMemos(0.01, func(query string, args []interface{}, diff *gorp.ResultDiff) {
+//         log.Printf("stale memo for %s %v: %d rows changed", query, args, len(diff.Changed))
+//     })
+//
+// sampleRate is the fraction of memoized selects to verify, from 0
+// (the default, which turns verification off) to 1.  Callers still
+// receive the memoized results, and errors from the verifying select
+// are ignored.  SelectToTarget is only verified when the target is a
+// slice of the table's type, or of pointers to it.
+func (m *DbMap) VerifyMemos(sampleRate float64, onMismatch MemoMismatchFunc) {
+	m.updateRuntimeConfig(func(config *RuntimeConfig) {
+		config.MemoSampleRate = sampleRate
+		config.OnMemoMismatch = onMismatch
+	})
+}
+
+// verifyMemo compares rows, the memoized results of query, with the
+// database's results if this select is sampled.
+func (plan *QueryPlan) verifyMemo(query string, rows []interface{}) {
+	config := plan.dbMap.runtimeConfig()
+	if config.OnMemoMismatch == nil || config.MemoSampleRate <= 0 || rand.Float64() >= config.MemoSampleRate {
+		return
+	}
+	fresh, err := plan.maskedSelect(plan.target.Interface(), query)
+	if err != nil {
+		return
+	}
+	if diff := plan.table.diffRows(rows, fresh); !diff.Equal() {
+		config.OnMemoMismatch(query, plan.args, diff)
+	}
+}
+
+// verifyMemoSlice is verifyMemo for the memoized results of
+// SelectToTarget.
+func (plan *QueryPlan) verifyMemoSlice(query string, slice reflect.Value) {
+	elemType := slice.Type().Elem()
+	if elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	if elemType != plan.table.gotype {
+		return
+	}
+	rows := make([]interface{}, slice.Len())
+	for i := range rows {
+		rows[i] = slice.Index(i).Interface()
+	}
+	plan.verifyMemo(query, rows)
+}
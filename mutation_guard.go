@@ -0,0 +1,115 @@
+package gorp
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrMissingWhere is returned by Update() and Delete() on query plans
+// without any filters when the DbMap requires a where clause for
+// mutations.
+var ErrMissingWhere = errors.New("gorp: update or delete without a where clause")
+
+// ErrAppendOnly is returned by updates and deletes when the DbMap's
+// dialect is append-only (see AppendOnlyDialect).
+var ErrAppendOnly = errors.New("gorp: the dialect does not support updates or deletes")
+
+// ErrReadOnly is returned by inserts, updates, deletes, and the other
+// statements that change the database when the DbMap's dialect is
+// read-only (see ReadOnlyDialect).
+var ErrReadOnly = errors.New("gorp: the dialect is read-only")
+
+// RequireWhereForMutations makes Update() and Delete() on query plans
+// return ErrMissingWhere unless the plan has at least one filter or
+// join, so that a forgotten Where() can't wipe out a whole table.
+// Call AllRows() on a plan to update or delete every row on purpose:
+//
+//     dbmap.RequireWhereForMutations(true)
+//     _, err := dbmap.Query(inv).Delete()           // ErrMissingWhere
+//     _, err = dbmap.Query(inv).AllRows().Delete()  // deletes everything
+//
+func (m *DbMap) RequireWhereForMutations(b bool) {
+	m.updateRuntimeConfig(func(config *RuntimeConfig) {
+		config.RequireWhere = b
+	})
+}
+
+// AllRows allows this plan to update or delete every row in its
+// table, even if the DbMap requires a where clause for mutations.
+func (plan *QueryPlan) AllRows() WhereQuery {
+	plan.allRows = true
+	return plan
+}
+
+// AllRows allows this plan to update every row in its table, even if
+// the DbMap requires a where clause for mutations.
+func (plan *AssignQueryPlan) AllRows() UpdateQuery {
+	plan.allRows = true
+	return plan
+}
+
+// checkScoped returns ErrMissingWhere if the DbMap requires a where
+// clause for mutations and this plan has no filters or joins.
+func (plan *QueryPlan) checkScoped() error {
+	if !plan.dbMap.runtimeConfig().RequireWhere || plan.allRows {
+		return nil
+	}
+	if plan.scoped() {
+		return nil
+	}
+	return ErrMissingWhere
+}
+
+// scoped returns true if this plan has any filters or joins.
+func (plan *QueryPlan) scoped() bool {
+	plan.storeJoin()
+	if len(plan.joins) > 0 {
+		return true
+	}
+	filter, ok := plan.filters.(*andFilter)
+	return ok && len(filter.subFilters) > 0
+}
+
+// checkWritable returns ErrReadOnly if this DbMap's dialect is
+// read-only.
+func (m *DbMap) checkWritable() error {
+	if readOnly, ok := m.Dialect.(ReadOnlyDialect); ok && readOnly.ReadOnly() {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// checkMutable returns ErrReadOnly or ErrAppendOnly if this DbMap's
+// dialect doesn't support updates and deletes.
+func (m *DbMap) checkMutable() error {
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+	if appendOnly, ok := m.Dialect.(AppendOnlyDialect); ok && appendOnly.AppendOnly() {
+		return ErrAppendOnly
+	}
+	return nil
+}
+
+// checkKeyPredicate returns an error if the dialect requires updates
+// and deletes to filter on a primary key column (see
+// KeyPredicateRequirer) and this plan doesn't.
+func (plan *QueryPlan) checkKeyPredicate() error {
+	requirer, ok := plan.dbMap.Dialect.(KeyPredicateRequirer)
+	if !ok || !requirer.RequiresKeyPredicates() {
+		return nil
+	}
+	plan.storeJoin()
+	if filter, ok := plan.filters.(*andFilter); ok {
+		for _, subFilter := range filter.subFilters {
+			comparison, ok := subFilter.(*comparisonFilter)
+			if !ok || comparison.comparison != "=" {
+				continue
+			}
+			if fieldMap, err := plan.colMap.fieldMapForPointer(comparison.left); err == nil && fieldMap.column.isPK {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("gorp: Dialect %T requires updates and deletes of table %s to filter on a primary key column", plan.dbMap.Dialect, plan.table.TableName)
+}
@@ -0,0 +1,79 @@
+package gorp
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// filterType is the reflect.Type of the Filter interface.
+var filterType = reflect.TypeOf((*Filter)(nil)).Elem()
+
+// Scope registers a named scope: a reusable bundle of filters, so that
+// common conditions are written once instead of at every query.  scope
+// must be a func taking a pointer to a mapped struct, the plan's
+// target, and returning the Filter to add to the plan's where clause:
+//
+//     dbmap.Scope("unpaid", func(inv *Invoice) gorp.Filter {
+//         return gorp.Equal(&inv.IsPaid, false)
+//     })
+//     dbmap.Scope("recent", func(inv *Invoice) gorp.Filter {
+//         return gorp.Greater(&inv.Created, time.Now().Add(-30*24*time.Hour).UnixNano())
+//     })
+//     ...
+//     results, err := dbmap.Query(inv).Scoped("unpaid", "recent").Select()
+//
+// Scope names belong to the struct's table, so tables may have scopes
+// with the same name.  A scope is called each time it is applied.
+// Like AddTable, Scope must be called before the DbMap is used.
+// Panics if scope isn't such a func, or if its struct's table isn't
+// registered.
+func (m *DbMap) Scope(name string, scope interface{}) *DbMap {
+	f := reflect.ValueOf(scope)
+	t := f.Type()
+	if t.Kind() != reflect.Func || t.NumIn() != 1 || t.In(0).Kind() != reflect.Ptr || t.NumOut() != 1 || t.Out(0) != filterType {
+		panic(fmt.Sprintf("gorp: Scope %s must be a func(*T) gorp.Filter, not %s", name, t))
+	}
+	table := tableOrNil(m, t.In(0).Elem())
+	if table == nil {
+		panic(fmt.Sprintf("gorp: Scope %s: no table found for type %s", name, t.In(0).Elem()))
+	}
+	if table.scopes == nil {
+		table.scopes = make(map[string]reflect.Value)
+	}
+	table.scopes[name] = f
+	return m
+}
+
+// Scoped adds the filters of the named scopes of the plan's table (see
+// DbMap.Scope) to the where clause.  Like other filters, they may only
+// refer to columns the plan may read (see ColumnMap.SetReadAccess).
+func (plan *QueryPlan) Scoped(names ...string) WhereQuery {
+	if len(plan.Errors) > 0 {
+		return plan
+	}
+	filters := make([]Filter, 0, len(names))
+	for _, name := range names {
+		scope, ok := plan.table.scopes[name]
+		if !ok {
+			plan.Errors = append(plan.Errors, fmt.Errorf("gorp: No scope %s for table %s", name, plan.table.TableName))
+			return plan
+		}
+		if filter, _ := scope.Call([]reflect.Value{plan.target})[0].Interface().(Filter); filter != nil {
+			filters = append(filters, filter)
+		}
+	}
+	plan.checkReadable(filters)
+	plan.storeJoin()
+	if plan.filters == nil {
+		plan.filters = new(andFilter)
+	}
+	plan.filters.Add(filters...)
+	return plan
+}
+
+// Scoped adds the filters of the named scopes of the plan's table (see
+// DbMap.Scope) to the where clause.
+func (plan *AssignQueryPlan) Scoped(names ...string) UpdateQuery {
+	plan.QueryPlan.Scoped(names...)
+	return plan
+}
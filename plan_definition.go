@@ -0,0 +1,356 @@
+package gorp
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// A PlanDefinition is a portable description of a select query plan,
+// which refers to columns by name instead of by field pointer.  It can
+// be encoded as JSON, stored (e.g. as a saved search or a report's
+// filters), and later turned back into a query plan with
+// DbMap.QueryDefinition.
+type PlanDefinition struct {
+	Where   *FilterDefinition `json:"where,omitempty"`
+	OrderBy []OrderDefinition `json:"orderBy,omitempty"`
+	Limit   int64             `json:"limit,omitempty"`
+	Offset  int64             `json:"offset,omitempty"`
+}
+
+// A FilterDefinition is a portable description of a Filter.  Op is
+// one of:
+//
+//     and, or           Filters combined with AND or OR
+//     not               the negation of the single entry in Filters
+//     null, notNull     Column IS NULL, Column IS NOT NULL
+//     eq, ne, lt, le,   Column compared to Value
+//     gt, ge, eqFold
+//
+// Column may be a column name or a field name.  Value is decoded into
+// the column's Go type when the definition is loaded.
+type FilterDefinition struct {
+	Op      string             `json:"op"`
+	Column  string             `json:"column,omitempty"`
+	Value   json.RawMessage    `json:"value,omitempty"`
+	Filters []FilterDefinition `json:"filters,omitempty"`
+}
+
+// An OrderDefinition is a portable description of an Order.  Nulls
+// may be empty, "first", or "last".
+type OrderDefinition struct {
+	Column string `json:"column"`
+	Desc   bool   `json:"desc,omitempty"`
+	Nulls  string `json:"nulls,omitempty"`
+}
+
+// comparisonOps maps the comparisons used by comparisonFilter to
+// their FilterDefinition ops.
+var comparisonOps = map[string]string{
+	"=":  "eq",
+	"<>": "ne",
+	"<":  "lt",
+	"<=": "le",
+	">":  "gt",
+	">=": "ge",
+}
+
+// columnOps are the FilterDefinition ops that apply to a column.
+var columnOps = map[string]bool{
+	"null": true, "notNull": true,
+	"eq": true, "ne": true, "lt": true, "le": true, "gt": true, "ge": true,
+	"eqFold": true,
+}
+
+// A definedFilter is a filter that can describe itself as a
+// FilterDefinition.
+type definedFilter interface {
+	define(structMap ColumnResolver) (FilterDefinition, error)
+}
+
+func defineFilter(filter Filter, structMap ColumnResolver) (FilterDefinition, error) {
+	definer, ok := filter.(definedFilter)
+	if !ok {
+		return FilterDefinition{}, fmt.Errorf("gorp: Filter of type %T cannot be defined", filter)
+	}
+	return definer.define(structMap)
+}
+
+// definedColumn returns the name of the column for fieldPtr.
+func definedColumn(structMap ColumnResolver, fieldPtr interface{}) (string, error) {
+	if _, ok := fieldPtr.(Expression); ok {
+		return "", fmt.Errorf("gorp: Expressions cannot be defined")
+	}
+	fieldMap, err := structMap.fieldMapForPointer(fieldPtr)
+	if err != nil {
+		return "", err
+	}
+	return fieldMap.column.ColumnName, nil
+}
+
+func (filter *combinedFilter) defineFilters(op string, structMap ColumnResolver) (FilterDefinition, error) {
+	def := FilterDefinition{Op: op, Filters: make([]FilterDefinition, 0, len(filter.subFilters))}
+	for _, subFilter := range filter.subFilters {
+		subDef, err := defineFilter(subFilter, structMap)
+		if err != nil {
+			return FilterDefinition{}, err
+		}
+		def.Filters = append(def.Filters, subDef)
+	}
+	return def, nil
+}
+
+func (filter *andFilter) define(structMap ColumnResolver) (FilterDefinition, error) {
+	return filter.defineFilters("and", structMap)
+}
+
+func (filter *orFilter) define(structMap ColumnResolver) (FilterDefinition, error) {
+	return filter.defineFilters("or", structMap)
+}
+
+func (filter *comparisonFilter) define(structMap ColumnResolver) (FilterDefinition, error) {
+	if _, ok := filter.right.(Expression); ok {
+		return FilterDefinition{}, fmt.Errorf("gorp: Expressions cannot be defined")
+	}
+	if reflect.ValueOf(filter.right).Kind() == reflect.Ptr {
+		return FilterDefinition{}, fmt.Errorf("gorp: Comparisons between columns cannot be defined")
+	}
+	column, err := definedColumn(structMap, filter.left)
+	if err != nil {
+		return FilterDefinition{}, err
+	}
+	value, err := json.Marshal(filter.right)
+	if err != nil {
+		return FilterDefinition{}, err
+	}
+	return FilterDefinition{Op: comparisonOps[filter.comparison], Column: column, Value: value}, nil
+}
+
+func (filter *foldFilter) define(structMap ColumnResolver) (FilterDefinition, error) {
+	def, err := filter.comparisonFilter.define(structMap)
+	def.Op = "eqFold"
+	return def, err
+}
+
+func (filter *notFilter) define(structMap ColumnResolver) (FilterDefinition, error) {
+	def, err := defineFilter(filter.filter, structMap)
+	if err != nil {
+		return FilterDefinition{}, err
+	}
+	return FilterDefinition{Op: "not", Filters: []FilterDefinition{def}}, nil
+}
+
+func (filter *nullFilter) define(structMap ColumnResolver) (FilterDefinition, error) {
+	column, err := definedColumn(structMap, filter.addr)
+	return FilterDefinition{Op: "null", Column: column}, err
+}
+
+func (filter *notNullFilter) define(structMap ColumnResolver) (FilterDefinition, error) {
+	column, err := definedColumn(structMap, filter.addr)
+	return FilterDefinition{Op: "notNull", Column: column}, err
+}
+
+// Definition returns a portable definition of this plan's filters,
+// ordering, limit, and offset.  Plans with joins, group by clauses,
+// AsOf, derived tables (see QueryFrom), or filters defined outside of
+// gorp cannot be defined.
+func (plan *QueryPlan) Definition() (*PlanDefinition, error) {
+	if len(plan.Errors) > 0 {
+		return nil, plan.Errors[0]
+	}
+	plan.storeJoin()
+	if len(plan.joins) > 0 || len(plan.groupBy) > 0 || plan.asOf != nil || plan.from != nil {
+		return nil, fmt.Errorf("gorp: Definition: plans with joins, group by, AsOf, or derived tables cannot be defined")
+	}
+	def := &PlanDefinition{Limit: plan.limit, Offset: plan.offset}
+	if plan.filters != nil {
+		where, err := defineFilter(plan.filters, plan.colMap)
+		if err != nil {
+			return nil, err
+		}
+		if where.Op != "and" || len(where.Filters) > 0 {
+			def.Where = &where
+		}
+	}
+	for _, order := range plan.orders {
+		column, err := definedColumn(plan.colMap, order.fieldPtr)
+		if err != nil {
+			return nil, err
+		}
+		orderDef := OrderDefinition{Column: column, Desc: order.direction == Descending}
+		switch order.nulls {
+		case nullsFirst:
+			orderDef.Nulls = "first"
+		case nullsLast:
+			orderDef.Nulls = "last"
+		}
+		def.OrderBy = append(def.OrderBy, orderDef)
+	}
+	return def, nil
+}
+
+// QueryDefinition creates a select query plan for target from def.
+// Column names are checked against target's table, and values are
+// decoded into their column's Go type, so definitions from untrusted
+// sources can't reference other tables or inject SQL.  Any problems
+// with def are returned when the plan is run.
+func (m *DbMap) QueryDefinition(target interface{}, def *PlanDefinition) SelectQuery {
+	return queryDefinition(m, m, target, def)
+}
+
+// QueryDefinition has the same behavior as DbMap.QueryDefinition, but
+// runs in a transaction.
+func (t *Transaction) QueryDefinition(target interface{}, def *PlanDefinition) SelectQuery {
+	return queryDefinition(t.dbmap, t, target, def)
+}
+
+func queryDefinition(m *DbMap, exec SqlExecutor, target interface{}, def *PlanDefinition) SelectQuery {
+	plan := query(m, exec, target).(*QueryPlan)
+	if len(plan.Errors) > 0 {
+		return plan
+	}
+	plan.Where()
+	if def.Where != nil {
+		filter, err := def.Where.filter(plan)
+		if err != nil {
+			plan.Errors = append(plan.Errors, err)
+			return plan
+		}
+		plan.Filter(filter)
+	}
+	for _, orderDef := range def.OrderBy {
+		fieldPtr, err := plan.definedField(orderDef.Column)
+		if err != nil {
+			plan.Errors = append(plan.Errors, err)
+			return plan
+		}
+		order := Order{fieldPtr: fieldPtr}
+		if orderDef.Desc {
+			order = Desc(fieldPtr)
+		}
+		switch orderDef.Nulls {
+		case "":
+		case "first":
+			order = order.NullsFirst()
+		case "last":
+			order = order.NullsLast()
+		default:
+			plan.Errors = append(plan.Errors, fmt.Errorf("gorp: Invalid nulls placement %q", orderDef.Nulls))
+			return plan
+		}
+		plan.OrderBy(order)
+	}
+	if def.Limit < 0 || def.Offset < 0 {
+		plan.Errors = append(plan.Errors, fmt.Errorf("gorp: Invalid limit %d or offset %d", def.Limit, def.Offset))
+		return plan
+	}
+	plan.Limit(def.Limit)
+	plan.Offset(def.Offset)
+	return plan
+}
+
+// definedColumnMap returns the column of the plan's table named
+// name, matching either column names or field names and ignoring
+// case.
+func (plan *QueryPlan) definedColumnMap(name string) (*ColumnMap, error) {
+	for _, col := range plan.table.columns {
+		if !col.inSchema() {
+			continue
+		}
+		if strings.EqualFold(col.ColumnName, name) || strings.EqualFold(col.fieldName, name) {
+			return col, nil
+		}
+	}
+	return nil, fmt.Errorf("gorp: No column %s in table %s", name, plan.table.TableName)
+}
+
+// definedField returns the pointer to the plan's field for the column
+// named name.
+func (plan *QueryPlan) definedField(name string) (interface{}, error) {
+	col, err := plan.definedColumnMap(name)
+	if err != nil {
+		return nil, err
+	}
+	return plan.colMap.pointerForColumn(col)
+}
+
+// filter converts def to a Filter for plan's table.
+func (def *FilterDefinition) filter(plan *QueryPlan) (Filter, error) {
+	switch def.Op {
+	case "and", "or", "not":
+		filters := make([]Filter, 0, len(def.Filters))
+		for i := range def.Filters {
+			filter, err := def.Filters[i].filter(plan)
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, filter)
+		}
+		switch def.Op {
+		case "and":
+			return And(filters...), nil
+		case "or":
+			return Or(filters...), nil
+		}
+		if len(filters) != 1 {
+			return nil, fmt.Errorf("gorp: A not filter must have exactly one sub-filter, got %d", len(filters))
+		}
+		return Not(filters[0]), nil
+	}
+	if !columnOps[def.Op] {
+		return nil, fmt.Errorf("gorp: Unknown filter op %q", def.Op)
+	}
+
+	col, err := plan.definedColumnMap(def.Column)
+	if err != nil {
+		return nil, err
+	}
+	return plan.columnFilter(col, def.Op, def.Value)
+}
+
+// columnFilter returns a filter comparing col to value, which is
+// decoded into col's Go type.  op must be one of columnOps.
+func (plan *QueryPlan) columnFilter(col *ColumnMap, op string, value json.RawMessage) (Filter, error) {
+	fieldPtr, err := plan.colMap.pointerForColumn(col)
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case "null":
+		return Null(fieldPtr), nil
+	case "notNull":
+		return NotNull(fieldPtr), nil
+	}
+
+	if len(value) == 0 {
+		return nil, fmt.Errorf("gorp: Filter %s on column %s needs a value", op, col.ColumnName)
+	}
+	target := reflect.New(col.gotype)
+	if err = json.Unmarshal(value, target.Interface()); err != nil {
+		return nil, fmt.Errorf("gorp: Invalid value for column %s: %s", col.ColumnName, err)
+	}
+	v := reflect.Indirect(target)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, fmt.Errorf("gorp: Use a null filter to compare column %s to null", col.ColumnName)
+		}
+		v = v.Elem()
+	}
+	switch op {
+	case "eq":
+		return Equal(fieldPtr, v.Interface()), nil
+	case "ne":
+		return NotEqual(fieldPtr, v.Interface()), nil
+	case "lt":
+		return Less(fieldPtr, v.Interface()), nil
+	case "le":
+		return LessOrEqual(fieldPtr, v.Interface()), nil
+	case "gt":
+		return Greater(fieldPtr, v.Interface()), nil
+	case "ge":
+		return GreaterOrEqual(fieldPtr, v.Interface()), nil
+	default:
+		return EqualFold(fieldPtr, v.Interface()), nil
+	}
+}
@@ -0,0 +1,124 @@
+package gorp
+
+import (
+	"reflect"
+)
+
+// runPreHook runs the PreInsert, PreUpdate, or PreDelete hook of the
+// plan's target, if it has one, for a write of type op.  The values
+// that the plan assigns are copied to the target first, so the hook
+// sees them.  Insert and update plans then assign the columns whose
+// fields the hook changed, unless they assign them already, so that
+// hooks which fill in fields work the same way for query plans as for
+// Insert() and Update().
+func (plan *QueryPlan) runPreHook(op writeOp) error {
+	target := plan.target.Interface()
+	var hook func(SqlExecutor) error
+	switch op {
+	case writeInsert:
+		if v, ok := target.(HasPreInsert); ok {
+			hook = v.PreInsert
+		}
+	case writeUpdate:
+		if v, ok := target.(HasPreUpdate); ok {
+			hook = v.PreUpdate
+		}
+	case writeDelete:
+		if v, ok := target.(HasPreDelete); ok {
+			hook = v.PreDelete
+		}
+	}
+	elem := plan.target.Elem()
+	plan.copyAssigned(elem)
+	if hook == nil {
+		return nil
+	}
+	before := reflect.New(elem.Type()).Elem()
+	before.Set(elem)
+	if err := hook(plan.executor); err != nil {
+		return err
+	}
+	if op == writeDelete {
+		return nil
+	}
+	return plan.assignChanged(before, elem)
+}
+
+// copyAssigned sets the fields of target, the plan's target struct, to
+// the values that the plan assigns to their columns.  Values that
+// can't be stored in their field (e.g. a driver.Valuer assigned to a
+// plain field) are left out.
+func (plan *QueryPlan) copyAssigned(target reflect.Value) {
+	for col, value := range plan.assignValues {
+		field := target.FieldByName(col.fieldName)
+		if !field.IsValid() || !field.CanSet() {
+			continue
+		}
+		if value == nil {
+			field.Set(reflect.Zero(field.Type()))
+			continue
+		}
+		v := reflect.ValueOf(value)
+		switch {
+		case v.Type().AssignableTo(field.Type()):
+			field.Set(v)
+		case v.Type().ConvertibleTo(field.Type()) && v.Kind() != reflect.String && field.Kind() != reflect.String:
+			field.Set(v.Convert(field.Type()))
+		case field.Kind() == reflect.Ptr && v.Type().AssignableTo(field.Type().Elem()):
+			ptr := reflect.New(field.Type().Elem())
+			ptr.Elem().Set(v)
+			field.Set(ptr)
+		}
+	}
+}
+
+// assignChanged assigns the columns of the plan's table whose fields
+// differ between before and after, except the version column, which
+// the plan checks instead, and the tenant column, which it assigns
+// itself.
+func (plan *QueryPlan) assignChanged(before, after reflect.Value) error {
+	assigner := &AssignQueryPlan{QueryPlan: plan}
+	errs := len(plan.Errors)
+	for _, col := range plan.table.columns {
+		if !col.inSchema() || col == plan.table.version || col == plan.table.tenant || col.isAutoIncr {
+			continue
+		}
+		value := after.FieldByName(col.fieldName).Interface()
+		if reflect.DeepEqual(before.FieldByName(col.fieldName).Interface(), value) {
+			continue
+		}
+		if plan.assigns(plan.table.dbmap.Dialect.QuoteField(col.ColumnName)) {
+			continue
+		}
+		fieldPtr, err := plan.colMap.pointerForColumn(col)
+		if err != nil {
+			return err
+		}
+		assigner.Assign(fieldPtr, value)
+	}
+	if len(plan.Errors) > errs {
+		return plan.Errors[errs]
+	}
+	return nil
+}
+
+// runPostHook runs the PostInsert, PostUpdate, or PostDelete hook of
+// the plan's target, if it has one, after a write of type op.
+func (plan *QueryPlan) runPostHook(op writeOp) error {
+	target := plan.target.Interface()
+	switch op {
+	case writeInsert:
+		if v, ok := target.(HasPostInsert); ok {
+			return v.PostInsert(plan.executor)
+		}
+	case writeUpdate:
+		if v, ok := target.(HasPostUpdate); ok {
+			return v.PostUpdate(plan.executor)
+		}
+	case writeDelete:
+		if v, ok := target.(HasPostDelete); ok {
+			return v.PostDelete(plan.executor)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package gorp
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// A PolymorphicMap describes a polymorphic association, where a row
+// refers to a row in one of several tables, using a column containing
+// the type of the referenced row (e.g. commentable_type) and a column
+// containing its primary key (e.g. commentable_id).  Create one with
+// TableMap.Polymorphic, and register each possible type with Type.
+type PolymorphicMap struct {
+	// Name is used to refer to this association when loading it.
+	Name string
+
+	table       *TableMap
+	typeField   string
+	idField     string
+	targetField string
+	types       map[string]reflect.Type
+}
+
+// Polymorphic adds a polymorphic association named name to this
+// table.  typeField and idField are the names of the struct fields
+// holding the referenced row's type (which must be a string) and
+// primary key.  targetField is the name of the struct field that
+// referenced rows will be attached to when the association is loaded
+// (see DbMap.LoadPolymorphic); it should normally be transient (db:"-")
+// and have an interface type.  For example:
+//
+//     type Comment struct {
+//         Id              int64
+//         CommentableType string
+//         CommentableId   int64
+//         Commentable     interface{} `db:"-"`
+//     }
+//
+//     dbmap.AddTable(Comment{}).SetKeys(true, "Id").
+//         Polymorphic("commentable", "CommentableType", "CommentableId", "Commentable").
+//         Type("post", Post{}).
+//         Type("photo", Photo{})
+//
+// Calling Polymorphic again with the same name replaces the
+// association.  Panics if any of the fields can't be found.
+func (t *TableMap) Polymorphic(name, typeField, idField, targetField string) *PolymorphicMap {
+	for _, field := range []string{typeField, idField, targetField} {
+		if _, ok := t.gotype.FieldByName(field); !ok {
+			panic(fmt.Sprintf("No field %s in table %s type %s", field, t.TableName, t.gotype.Name()))
+		}
+	}
+	if field, _ := t.gotype.FieldByName(typeField); field.Type.Kind() != reflect.String {
+		panic(fmt.Sprintf("Polymorphic type field %s in table %s must be a string", typeField, t.TableName))
+	}
+	if t.polymorphics == nil {
+		t.polymorphics = make(map[string]*PolymorphicMap)
+	}
+	assoc := &PolymorphicMap{
+		Name:        name,
+		table:       t,
+		typeField:   typeField,
+		idField:     idField,
+		targetField: targetField,
+		types:       make(map[string]reflect.Type),
+	}
+	t.polymorphics[name] = assoc
+	return assoc
+}
+
+// Type registers model as the type of row referred to by rows whose
+// type field is discriminator.  model's table must be registered with
+// the DbMap (in any order) before the association is loaded.
+func (p *PolymorphicMap) Type(discriminator string, model interface{}) *PolymorphicMap {
+	t, err := toType(model)
+	if err != nil {
+		panic(err.Error())
+	}
+	p.types[discriminator] = t
+	return p
+}
+
+// LoadPolymorphic loads the polymorphic association named name (see
+// TableMap.Polymorphic) for every row in rows, which may be a slice
+// returned by Select or a slice (or pointer to a slice) of structs or
+// struct pointers.  One query is run for each referenced type, no
+// matter how many rows there are.
+//
+// Rows with an empty type field are skipped, as are rows that
+// refer to rows that no longer exist.
+func (m *DbMap) LoadPolymorphic(rows interface{}, name string) error {
+	return loadPolymorphic(m, m, rows, name)
+}
+
+// LoadPolymorphic has the same behavior as DbMap.LoadPolymorphic(),
+// but runs in a transaction.
+func (t *Transaction) LoadPolymorphic(rows interface{}, name string) error {
+	return loadPolymorphic(t.dbmap, t, rows, name)
+}
+
+func loadPolymorphic(m *DbMap, exec SqlExecutor, rows interface{}, name string) error {
+	elems, err := structElems(rows)
+	if err != nil || len(elems) == 0 {
+		return err
+	}
+	table, err := m.tableFor(elems[0].Type(), false)
+	if err != nil {
+		return err
+	}
+	assoc, ok := table.polymorphics[name]
+	if !ok {
+		return fmt.Errorf("gorp: No polymorphic association %s in table %s", name, table.TableName)
+	}
+
+	// Group the rows by the type that they refer to, so that each type
+	// can be loaded with a single query.
+	keys := make(map[string][]interface{})
+	targets := make(map[string][]reflect.Value)
+	for _, elem := range elems {
+		discriminator := elem.FieldByName(assoc.typeField).String()
+		if discriminator == "" {
+			continue
+		}
+		if _, ok := assoc.types[discriminator]; !ok {
+			return fmt.Errorf("gorp: Unknown type %q for polymorphic association %s in table %s", discriminator, name, table.TableName)
+		}
+		keys[discriminator] = append(keys[discriminator], elem.FieldByName(assoc.idField).Interface())
+		targets[discriminator] = append(targets[discriminator], elem.FieldByName(assoc.targetField))
+	}
+
+	for discriminator, typeKeys := range keys {
+		loader, err := loaderFor(m, exec, reflect.New(assoc.types[discriminator]).Interface())
+		if err != nil {
+			return err
+		}
+		results, errs := loader.Batch(typeKeys)
+		for index, result := range results {
+			if errs[index] != nil {
+				return errs[index]
+			}
+			if result == nil {
+				continue
+			}
+			target := targets[discriminator][index]
+			resultVal := reflect.ValueOf(result)
+			if !resultVal.Type().AssignableTo(target.Type()) {
+				return fmt.Errorf("gorp: Cannot assign %s to field %s of polymorphic association %s", resultVal.Type(), assoc.targetField, name)
+			}
+			target.Set(resultVal)
+		}
+	}
+	return nil
+}
+
+// structElems returns the (addressable) struct values in rows, which
+// may be a slice or pointer to a slice of structs, struct pointers, or
+// interfaces holding struct pointers.
+func structElems(rows interface{}) ([]reflect.Value, error) {
+	rowsVal := reflect.ValueOf(rows)
+	if rowsVal.Kind() == reflect.Ptr {
+		rowsVal = rowsVal.Elem()
+	}
+	if rowsVal.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("gorp: Expected a slice of rows, got %v", reflect.TypeOf(rows))
+	}
+	elems := make([]reflect.Value, 0, rowsVal.Len())
+	for i := 0; i < rowsVal.Len(); i++ {
+		elem := rowsVal.Index(i)
+		if elem.Kind() == reflect.Interface {
+			elem = elem.Elem()
+		}
+		if elem.Kind() == reflect.Ptr {
+			elem = elem.Elem()
+		}
+		if elem.Kind() != reflect.Struct || !elem.CanAddr() {
+			return nil, fmt.Errorf("gorp: Expected a slice of rows, got %v", reflect.TypeOf(rows))
+		}
+		elems = append(elems, elem)
+	}
+	return elems, nil
+}
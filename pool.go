@@ -0,0 +1,64 @@
+package gorp
+
+import (
+	"reflect"
+)
+
+// A RowPool is a source of reusable values for the rows loaded by
+// SelectPooled.  A *sync.Pool satisfies RowPool.
+//
+// Get should return a pointer to a value of the query's struct type,
+// or nil if no values are available.  Values of any other type are
+// ignored, and a new value is allocated instead.
+type RowPool interface {
+	Get() interface{}
+	Put(interface{})
+}
+
+// A PooledSelector is a query that can load rows into values taken
+// from a RowPool.  It is kept out of Selector, so that other
+// implementations of Selector don't have to provide it; the queries
+// returned by DbMap.Query implement it:
+//
+//     err := query.(gorp.PooledSelector).SelectPooled(pool, handler)
+type PooledSelector interface {
+	// Execute the select statement, allocating each row from pool
+	// and passing it to handler.  Rows are returned to the pool after
+	// handler returns.
+	SelectPooled(pool RowPool, handler func(row interface{}) error) error
+}
+
+// SelectPooled will run this query plan as a SELECT statement, taking
+// a value from pool for each row instead of allocating a new one.
+// Each row is passed to handler and then put back in the pool, so
+// handler must not hold on to the row (or anything it references)
+// after returning.  Values taken from the pool are zeroed before
+// being loaded.
+//
+// This is intended for high throughput read paths, where allocating
+// a new struct per row puts too much pressure on the garbage
+// collector.  If handler returns an error, SelectPooled stops reading
+// rows and returns that error.
+func (plan *QueryPlan) SelectPooled(pool RowPool, handler func(row interface{}) error) error {
+	query, err := plan.selectQuery()
+	if err != nil {
+		return err
+	}
+	t := plan.table.gotype
+	ptrType := reflect.PtrTo(t)
+	alloc := func() reflect.Value {
+		if row := pool.Get(); row != nil {
+			v := reflect.ValueOf(row)
+			if v.Type() == ptrType {
+				v.Elem().Set(reflect.Zero(t))
+				return v
+			}
+		}
+		return reflect.New(t)
+	}
+	return eachRow(plan.dbMap, plan.executor, t, plan.maskedColumns(), query, plan.args, alloc, func(v reflect.Value) error {
+		row := v.Interface()
+		defer pool.Put(row)
+		return handler(row)
+	})
+}
@@ -0,0 +1,199 @@
+package gorp
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+)
+
+// preloadBatchSize is the maximum number of keys bound to a single
+// preload query.
+const preloadBatchSize = 1000
+
+// Preload loads the relations declared on fieldPtrs (see
+// ColumnMap.HasMany, HasOne, and BelongsTo), pointers to fields of the
+// query's target, for every row the query returns.  Each relation is
+// loaded with one follow-up query per batch of up to 1000 keys, using
+// a WHERE ... IN (...) clause, instead of one query per row:
+//
+//     post := new(Post)
+//     var posts []*Post
+//     err := dbmap.Query(post).
+//         Where().
+//         Equal(&post.AuthorId, authorId).
+//         Preload(&post.Comments).
+//         SelectToTarget(&posts)
+//
+// The fields are replaced, not appended to, and are set to their zero
+// value for rows with no related rows.  The related tables' rows are
+// selected by query plans with the plan's context, so their tenants,
+// default scopes, soft-delete columns, column access rules, and masks
+// apply.  Relations are only preloaded by Select and SelectToTarget.
+func (plan *QueryPlan) Preload(fieldPtrs ...interface{}) SelectQuery {
+	plan.storeJoin()
+	for _, fieldPtr := range fieldPtrs {
+		relation, err := plan.preloadRelation(fieldPtr)
+		if err != nil {
+			plan.Errors = append(plan.Errors, err)
+			return plan
+		}
+		plan.preloads = append(plan.preloads, relation)
+	}
+	return plan
+}
+
+// preloadRelation returns the relation declared on the field that
+// fieldPtr points to.
+func (plan *QueryPlan) preloadRelation(fieldPtr interface{}) (Relation, error) {
+	for _, fieldMap := range plan.colMap {
+		if fieldMap.addr != fieldPtr {
+			continue
+		}
+		col := fieldMap.column
+		if col.relation == nil {
+			return Relation{}, fmt.Errorf("gorp: Preload: no relation declared on field %s of table %s", col.fieldName, plan.table.TableName)
+		}
+		return plan.table.resolveRelation(col)
+	}
+	return Relation{}, fmt.Errorf("gorp: Preload: no field found for field pointer of type %T", fieldPtr)
+}
+
+// preload loads the plan's preloaded relations into rows, a slice of
+// the plan's results.
+func (plan *QueryPlan) preload(rows interface{}) error {
+	if len(plan.preloads) == 0 {
+		return nil
+	}
+	elems, err := structElems(rows)
+	if err != nil {
+		return err
+	}
+	if len(elems) == 0 {
+		return nil
+	}
+	for _, relation := range plan.preloads {
+		if err := plan.preloadInto(relation, elems); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// preloadInto loads relation's rows and sets the relation's field of
+// each of elems.
+func (plan *QueryPlan) preloadInto(relation Relation, elems []reflect.Value) error {
+	// The rows' keyField holds the key that the target's matchCol
+	// column is compared to.
+	keyField := relation.Table.keys[0].fieldName
+	matchCol := relation.ForeignKey
+	if relation.Kind == RelationBelongsTo {
+		keyField = relation.ForeignKey.fieldName
+		matchCol = relation.Target.keys[0]
+	}
+
+	seen := make(map[interface{}]bool, len(elems))
+	var keys []interface{}
+	for _, elem := range elems {
+		key := preloadKey(elem.FieldByName(keyField))
+		if key != nil && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	byKey := make(map[interface{}][]reflect.Value)
+	for start := 0; start < len(keys); start += preloadBatchSize {
+		end := start + preloadBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		related, err := plan.preloadQuery(relation, matchCol, keys[start:end]).selectResults()
+		if err != nil {
+			return err
+		}
+		for _, row := range related {
+			v := reflect.ValueOf(row).Elem()
+			key := preloadKey(v.FieldByName(matchCol.fieldName))
+			byKey[key] = append(byKey[key], v)
+		}
+	}
+
+	for _, elem := range elems {
+		field := elem.FieldByName(relation.Field)
+		matches := byKey[preloadKey(elem.FieldByName(keyField))]
+		if relation.Kind == RelationHasMany {
+			field.Set(relatedSlice(field.Type(), matches))
+			continue
+		}
+		field.Set(reflect.Zero(field.Type()))
+		if len(matches) > 0 {
+			field.Set(relatedValue(field.Type(), matches[0]))
+		}
+	}
+	return nil
+}
+
+// preloadQuery returns a plan, with the same context and executor as
+// this plan, that selects the rows of relation's target table whose
+// matchCol column holds one of keys.  The related table's tenant,
+// default scopes, soft-delete column, column access rules, and masks
+// are applied as they are to any other plan.
+func (plan *QueryPlan) preloadQuery(relation Relation, matchCol *ColumnMap, keys []interface{}) *QueryPlan {
+	target := reflect.New(relation.Target.gotype)
+	related := queryContext(plan.ctx, plan.dbMap, plan.executor, target.Interface()).(*QueryPlan)
+	match := target.Elem().FieldByName(matchCol.fieldName).Addr().Interface()
+	related.Where(&inFilter{addr: match, values: keys})
+	return related
+}
+
+// preloadKey returns the key held by v, with pointers dereferenced,
+// driver.Valuers converted, byte slices converted to strings, and
+// integers converted to int64 so that keys of different types match.
+// It returns nil for zero and NULL keys.
+func preloadKey(v reflect.Value) interface{} {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if valuer, ok := v.Interface().(driver.Valuer); ok {
+		value, err := valuer.Value()
+		if err != nil || value == nil {
+			return nil
+		}
+		v = reflect.ValueOf(value)
+	}
+	if v.IsZero() {
+		return nil
+	}
+	if b, ok := v.Interface().([]byte); ok {
+		return string(b)
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint())
+	}
+	return v.Interface()
+}
+
+// relatedSlice returns a slice of type t, whose elements are related
+// rows or pointers to them, holding rows.
+func relatedSlice(t reflect.Type, rows []reflect.Value) reflect.Value {
+	slice := reflect.MakeSlice(t, 0, len(rows))
+	for _, row := range rows {
+		slice = reflect.Append(slice, relatedValue(t.Elem(), row))
+	}
+	return slice
+}
+
+// relatedValue returns row as a value of type t, which is the row's
+// type or a pointer to it.
+func relatedValue(t reflect.Type, row reflect.Value) reflect.Value {
+	if t.Kind() == reflect.Ptr {
+		return row.Addr()
+	}
+	return row
+}
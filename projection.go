@@ -0,0 +1,81 @@
+package gorp
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// SetJSONName sets the key used for this column's value by
+// TableMap.Project.  Pass "-" to leave the column out of projections,
+// or "" to use the column name.
+func (c *ColumnMap) SetJSONName(name string) *ColumnMap {
+	c.jsonName = name
+	return c
+}
+
+// Project returns row, a value or pointer to a value of this table's
+// type, as a map from JSON keys to field values, so rows can be
+// marshaled to API payloads without a parallel set of DTO types:
+//
+//     table.ColMap("PasswordHash").SetJSONName("-")
+//     table.ColMap("Email").SetMask(gorp.MaskHash, "support")
+//     ...
+//     payload, err := table.Project(ctx, user)
+//     json.NewEncoder(w).Encode(payload)
+//
+// Keys are column names (respecting db tags and Rename) unless set
+// with ColumnMap.SetJSONName.  Transient columns are left out, as are
+// columns that may not be read in ctx (see ColumnMap.SetReadAccess),
+// and masked columns (see ColumnMap.SetMask) are masked for the role
+// in ctx.
+func (t *TableMap) Project(ctx context.Context, row interface{}) (map[string]interface{}, error) {
+	v := reflect.ValueOf(row)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Type() != t.gotype {
+		return nil, fmt.Errorf("gorp: Cannot project a %T using the table for %s", row, t.gotype)
+	}
+	role := RoleFromContext(ctx)
+	projection := make(map[string]interface{}, len(t.columns))
+	for _, col := range t.columns {
+		if col.Transient || col.jsonName == "-" {
+			continue
+		}
+		if !col.isPK && col.readAccess != nil && !col.readAccess(ctx) {
+			continue
+		}
+		field := v.FieldByName(col.fieldName)
+		if col.masked(role) {
+			masked := reflect.New(field.Type()).Elem()
+			masked.Set(field)
+			col.mask(masked)
+			field = masked
+		}
+		key := col.jsonName
+		if key == "" {
+			key = col.ColumnName
+		}
+		projection[key] = field.Interface()
+	}
+	return projection, nil
+}
+
+// ProjectAll projects each element of rows, a slice of values or
+// pointers to values of this table's type (see Project).
+func (t *TableMap) ProjectAll(ctx context.Context, rows interface{}) ([]map[string]interface{}, error) {
+	v := reflect.ValueOf(rows)
+	if v.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("gorp: ProjectAll requires a slice, got %T", rows)
+	}
+	projections := make([]map[string]interface{}, v.Len())
+	for i := range projections {
+		projection, err := t.Project(ctx, v.Index(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+		projections[i] = projection
+	}
+	return projections, nil
+}
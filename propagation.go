@@ -0,0 +1,102 @@
+package gorp
+
+import (
+	"context"
+	"fmt"
+)
+
+// A Propagation tells WithTransaction how to run when the context
+// already carries a transaction.
+type Propagation int
+
+const (
+	// PropagationRequired joins the context's transaction, or starts
+	// a new one if there is none.  Errors returned by a joined call
+	// are left for the outer call to handle.
+	PropagationRequired Propagation = iota
+
+	// PropagationRequiresNew always starts a new transaction, which
+	// is committed or rolled back independently of the context's
+	// transaction.
+	PropagationRequiresNew
+
+	// PropagationNested runs in a savepoint of the context's
+	// transaction (see Transaction.Nested), so an error only rolls
+	// back the work done by this call.  It starts a new transaction
+	// if there is none.
+	PropagationNested
+)
+
+type txContextKey struct{}
+
+// TransactionFromContext returns the transaction that WithTransaction
+// attached to ctx, or nil if there is none.
+func TransactionFromContext(ctx context.Context) *Transaction {
+	if ctx == nil {
+		return nil
+	}
+	tx, _ := ctx.Value(txContextKey{}).(*Transaction)
+	return tx
+}
+
+// WithTransaction runs fn in a transaction, committing it if fn
+// returns nil and rolling it back if fn returns an error or panics.
+// fn is passed a context carrying the transaction, so that service
+// calls made by fn can call WithTransaction again and declare how
+// they relate to the caller's transaction:
+//
+//     func (s *Billing) Charge(ctx context.Context, inv *Invoice) error {
+//         return s.dbmap.WithTransaction(ctx, gorp.PropagationRequired, func(ctx context.Context, tx *gorp.Transaction) error {
+//             if err := s.audit.Record(ctx, inv); err != nil {
+//                 return err
+//             }
+//             _, err := tx.Update(inv)
+//             return err
+//         })
+//     }
+//
+// where Record might use PropagationRequiresNew so its row is kept even
+// if the charge is rolled back.  Transactions in ctx that belong to a
+// different DbMap are ignored.
+func (m *DbMap) WithTransaction(ctx context.Context, propagation Propagation, fn func(ctx context.Context, tx *Transaction) error) error {
+	outer := TransactionFromContext(ctx)
+	if outer != nil && (outer.dbmap != m || outer.closed) {
+		outer = nil
+	}
+
+	var tx *Transaction
+	var err error
+	switch {
+	case outer == nil || propagation == PropagationRequiresNew:
+		tx, err = m.Begin()
+	case propagation == PropagationRequired:
+		return fn(ctx, outer)
+	case propagation == PropagationNested:
+		if outer.nestedCount == nil {
+			outer.nestedCount = new(int)
+		}
+		*outer.nestedCount++
+		tx, err = outer.Nested(fmt.Sprintf("gorp_nested_%d", *outer.nestedCount))
+	default:
+		return fmt.Errorf("gorp: Unknown transaction propagation %d", propagation)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.run(context.WithValue(ctx, txContextKey{}, tx), fn)
+}
+
+// run calls fn with t, then commits t, or rolls it back if fn fails.
+func (t *Transaction) run(ctx context.Context, fn func(ctx context.Context, tx *Transaction) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Rollback()
+			panic(r)
+		}
+	}()
+	if err = fn(ctx, t); err != nil {
+		t.Rollback()
+		return err
+	}
+	return t.Commit()
+}
@@ -2,17 +2,17 @@ package gorp
 
 import (
 	"bytes"
-	"reflect"
+	"fmt"
 )
 
 // A Filter is a type that can be used as a sub-section of a where
 // clause.
 type Filter interface {
-	// Where should take a structColumnMap, a dialect, and the index
+	// Where should take a ColumnResolver, a dialect, and the index
 	// to start binding at, and return the string to be added to the
 	// where clause, a slice of query arguments in the where clause,
 	// and any errors encountered.
-	Where(structMap structColumnMap, dialect Dialect, startBindIdx int) (string, []interface{}, error)
+	Where(structMap ColumnResolver, dialect Dialect, startBindIdx int) (string, []interface{}, error)
 }
 
 // A MultiFilter is a filter that can also accept additional filters.
@@ -29,7 +29,7 @@ type combinedFilter struct {
 
 // joinFilters joins all of the sub-filters' where clauses into a
 // single where clause.
-func (filter *combinedFilter) joinFilters(separator string, structMap structColumnMap, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
+func (filter *combinedFilter) joinFilters(separator string, structMap ColumnResolver, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
 	buffer := bytes.Buffer{}
 	args := make([]interface{}, 0, len(filter.subFilters))
 	if len(filter.subFilters) > 1 {
@@ -63,7 +63,7 @@ type andFilter struct {
 	combinedFilter
 }
 
-func (filter *andFilter) Where(structMap structColumnMap, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
+func (filter *andFilter) Where(structMap ColumnResolver, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
 	return filter.joinFilters(" and ", structMap, dialect, startBindIdx)
 }
 
@@ -73,7 +73,7 @@ type orFilter struct {
 	combinedFilter
 }
 
-func (filter *orFilter) Where(structMap structColumnMap, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
+func (filter *orFilter) Where(structMap ColumnResolver, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
 	return filter.joinFilters(" or ", structMap, dialect, startBindIdx)
 }
 
@@ -83,11 +83,12 @@ func (filter *orFilter) Where(structMap structColumnMap, dialect Dialect, startB
 type joinFilter struct {
 	andFilter
 	quotedJoinTable string
+	table           *TableMap
 }
 
 // JoinClause on a joinFilter will return the full join clause for use
 // in a SELECT statement.
-func (filter *joinFilter) JoinClause(structMap structColumnMap, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
+func (filter *joinFilter) JoinClause(structMap ColumnResolver, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
 	join := " inner join " + filter.quotedJoinTable
 	on, args, err := filter.andFilter.Where(structMap, dialect, startBindIdx)
 	if err != nil {
@@ -106,33 +107,95 @@ type comparisonFilter struct {
 	right      interface{}
 }
 
-func (filter *comparisonFilter) Where(structMap structColumnMap, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
-	args := make([]interface{}, 0, 2)
-	comparison := bytes.Buffer{}
-	if reflect.ValueOf(filter.left).Kind() == reflect.Ptr {
-		column, err := structMap.tableColumnForPointer(filter.left)
-		if err != nil {
-			return "", nil, err
+func (filter *comparisonFilter) Where(structMap ColumnResolver, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
+	left, right, args, err := filter.operands(structMap, dialect, startBindIdx)
+	if err != nil {
+		return "", nil, err
+	}
+	return left + filter.comparison + right, args, nil
+}
+
+// operands returns the SQL strings for the left and right side of the
+// comparison, along with any arguments that need to be bound.
+func (filter *comparisonFilter) operands(structMap ColumnResolver, dialect Dialect, startBindIdx int) (left, right string, args []interface{}, err error) {
+	left, args, err = operandExpr(filter.left, structMap, dialect, startBindIdx)
+	if err != nil {
+		return "", "", nil, err
+	}
+	right, rightArgs, err := operandExpr(filter.right, structMap, dialect, startBindIdx+len(args))
+	if err != nil {
+		return "", "", nil, err
+	}
+	args = append(args, rightArgs...)
+	return left, right, args, nil
+}
+
+// A tupleFilter is a filter that compares two row values, in order.
+// comparison must be a strict comparison (< or >), and orEqual
+// includes rows that are equal.
+type tupleFilter struct {
+	left       []interface{}
+	comparison string
+	orEqual    bool
+	right      []interface{}
+}
+
+func (filter *tupleFilter) Where(structMap ColumnResolver, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
+	if len(filter.left) == 0 || len(filter.left) != len(filter.right) {
+		return "", nil, fmt.Errorf("gorp: Tuple comparison needs two tuples of the same, non-zero length; got %d and %d", len(filter.left), len(filter.right))
+	}
+	comparison := filter.comparison
+	if filter.orEqual {
+		comparison += "="
+	}
+	if comparer, ok := dialect.(RowValueComparer); ok {
+		left := make([]string, len(filter.left))
+		right := make([]string, len(filter.right))
+		args := make([]interface{}, 0, len(filter.left))
+		for i := range filter.left {
+			pair := &comparisonFilter{filter.left[i], comparison, filter.right[i]}
+			l, r, pairArgs, err := pair.operands(structMap, dialect, startBindIdx+len(args))
+			if err != nil {
+				return "", nil, err
+			}
+			left[i], right[i] = l, r
+			args = append(args, pairArgs...)
 		}
-		comparison.WriteString(column)
-	} else {
-		bindVar := dialect.BindVar(startBindIdx + len(args))
-		comparison.WriteString(bindVar)
-		args = append(args, filter.left)
-	}
-	comparison.WriteString(filter.comparison)
-	if reflect.ValueOf(filter.right).Kind() == reflect.Ptr {
-		column, err := structMap.tableColumnForPointer(filter.right)
-		if err != nil {
-			return "", nil, err
+		return comparer.RowValue(left) + comparison + comparer.RowValue(right), args, nil
+	}
+
+	// (a, b) > (x, y) is the same as a > x or (a = x and b > y).
+	expanded := &orFilter{}
+	for i := range filter.left {
+		term := &andFilter{}
+		for j := 0; j < i; j++ {
+			term.Add(&comparisonFilter{filter.left[j], "=", filter.right[j]})
+		}
+		last := filter.comparison
+		if i == len(filter.left)-1 {
+			last = comparison
 		}
-		comparison.WriteString(column)
-	} else {
-		bindVar := dialect.BindVar(startBindIdx + len(args))
-		comparison.WriteString(bindVar)
-		args = append(args, filter.right)
+		term.Add(&comparisonFilter{filter.left[i], last, filter.right[i]})
+		expanded.Add(term)
+	}
+	return expanded.Where(structMap, dialect, startBindIdx)
+}
+
+// A foldFilter is a comparisonFilter that compares its values for
+// equality, ignoring case.
+type foldFilter struct {
+	comparisonFilter
+}
+
+func (filter *foldFilter) Where(structMap ColumnResolver, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
+	left, right, args, err := filter.operands(structMap, dialect, startBindIdx)
+	if err != nil {
+		return "", nil, err
+	}
+	if comparer, ok := dialect.(CaseInsensitiveComparer); ok {
+		return comparer.EqualFold(left, right), args, nil
 	}
-	return comparison.String(), args, nil
+	return "lower(" + left + ")=lower(" + right + ")", args, nil
 }
 
 // A notFilter is a filter that inverts another filter.
@@ -140,7 +203,7 @@ type notFilter struct {
 	filter Filter
 }
 
-func (filter *notFilter) Where(structMap structColumnMap, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
+func (filter *notFilter) Where(structMap ColumnResolver, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
 	whereStr, args, err := filter.filter.Where(structMap, dialect, startBindIdx)
 	if err != nil {
 		return "", nil, err
@@ -153,7 +216,7 @@ type nullFilter struct {
 	addr interface{}
 }
 
-func (filter *nullFilter) Where(structMap structColumnMap, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
+func (filter *nullFilter) Where(structMap ColumnResolver, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
 	column, err := structMap.tableColumnForPointer(filter.addr)
 	if err != nil {
 		return "", nil, err
@@ -166,7 +229,7 @@ type notNullFilter struct {
 	addr interface{}
 }
 
-func (filter *notNullFilter) Where(structMap structColumnMap, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
+func (filter *notNullFilter) Where(structMap ColumnResolver, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
 	column, err := structMap.tableColumnForPointer(filter.addr)
 	if err != nil {
 		return "", nil, err
@@ -174,6 +237,30 @@ func (filter *notNullFilter) Where(structMap structColumnMap, dialect Dialect, s
 	return column + " IS NOT NULL", nil, nil
 }
 
+// An inFilter is a filter that compares a field to a list of values.
+type inFilter struct {
+	addr   interface{}
+	values []interface{}
+}
+
+func (filter *inFilter) Where(structMap ColumnResolver, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
+	column, err := structMap.tableColumnForPointer(filter.addr)
+	if err != nil {
+		return "", nil, err
+	}
+	buffer := bytes.Buffer{}
+	buffer.WriteString(column)
+	buffer.WriteString(" in (")
+	for i := range filter.values {
+		if i > 0 {
+			buffer.WriteString(",")
+		}
+		buffer.WriteString(dialect.BindVar(startBindIdx + i))
+	}
+	buffer.WriteString(")")
+	return buffer.String(), filter.values, nil
+}
+
 // Or returns a filter that will OR all passed in filters
 func Or(filters ...Filter) Filter {
 	return &orFilter{combinedFilter{filters}}
@@ -204,6 +291,13 @@ func Equal(fieldPtr interface{}, value interface{}) Filter {
 	return &comparisonFilter{fieldPtr, "=", value}
 }
 
+// EqualFold returns a filter for fieldPtr == value, ignoring case.
+// Dialects that implement CaseInsensitiveComparer may generate their
+// own comparison; all others will use lower(fieldPtr) = lower(value).
+func EqualFold(fieldPtr interface{}, value interface{}) Filter {
+	return &foldFilter{comparisonFilter{fieldPtr, "=", value}}
+}
+
 // NotEqual returns a filter for fieldPtr != value
 func NotEqual(fieldPtr interface{}, value interface{}) Filter {
 	return &comparisonFilter{fieldPtr, "<>", value}
@@ -221,10 +315,44 @@ func LessOrEqual(fieldPtr interface{}, value interface{}) Filter {
 
 // Greater returns a filter for fieldPtr > value
 func Greater(fieldPtr interface{}, value interface{}) Filter {
-	return &comparisonFilter{fieldPtr, "=", value}
+	return &comparisonFilter{fieldPtr, ">", value}
 }
 
 // GreaterOrEqual returns a filter for fieldPtr >= value
 func GreaterOrEqual(fieldPtr interface{}, value interface{}) Filter {
-	return &comparisonFilter{fieldPtr, "=", value}
+	return &comparisonFilter{fieldPtr, ">=", value}
+}
+
+// TupleGreater returns a filter for (fieldPtrs...) > (values...),
+// comparing the tuples in order, as used for keyset pagination over
+// composite sort keys:
+//
+//     query.Where(gorp.TupleGreater(
+//         []interface{}{&m.Created, &m.Id},
+//         []interface{}{last.Created, last.Id},
+//     )).OrderBy(&m.Created, &m.Id)
+//
+// Dialects that implement RowValueComparer compare row values
+// directly; for all others, the comparison is expanded into
+// (created > ?) or (created = ? and id > ?).
+func TupleGreater(fieldPtrs []interface{}, values []interface{}) Filter {
+	return &tupleFilter{fieldPtrs, ">", false, values}
+}
+
+// TupleGreaterOrEqual returns a filter for (fieldPtrs...) >=
+// (values...).  See TupleGreater.
+func TupleGreaterOrEqual(fieldPtrs []interface{}, values []interface{}) Filter {
+	return &tupleFilter{fieldPtrs, ">", true, values}
+}
+
+// TupleLess returns a filter for (fieldPtrs...) < (values...).  See
+// TupleGreater.
+func TupleLess(fieldPtrs []interface{}, values []interface{}) Filter {
+	return &tupleFilter{fieldPtrs, "<", false, values}
+}
+
+// TupleLessOrEqual returns a filter for (fieldPtrs...) <=
+// (values...).  See TupleGreater.
+func TupleLessOrEqual(fieldPtrs []interface{}, values []interface{}) Filter {
+	return &tupleFilter{fieldPtrs, "<", true, values}
 }
@@ -0,0 +1,118 @@
+package gorp
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Directions that can be used for ordering query results.
+const (
+	Ascending  = "asc"
+	Descending = "desc"
+)
+
+// An Order is a single entry in the order by clause of a query.  Use
+// Asc or Desc to create them.  Orders may sort by a field pointer or
+// by an Expression that doesn't bind any values.
+type Order struct {
+	fieldPtr  interface{}
+	direction string
+	nulls     nullsPlacement
+}
+
+type nullsPlacement int
+
+const (
+	nullsDefault nullsPlacement = iota
+	nullsFirst
+	nullsLast
+)
+
+// Asc returns an Order that sorts by fieldPtr in ascending order.
+func Asc(fieldPtr interface{}) Order {
+	return Order{fieldPtr: fieldPtr, direction: Ascending}
+}
+
+// Desc returns an Order that sorts by fieldPtr in descending order.
+func Desc(fieldPtr interface{}) Order {
+	return Order{fieldPtr: fieldPtr, direction: Descending}
+}
+
+// NullsFirst returns a copy of order that places null values before
+// all other values.
+func (order Order) NullsFirst() Order {
+	order.nulls = nullsFirst
+	return order
+}
+
+// NullsLast returns a copy of order that places null values after
+// all other values.
+func (order Order) NullsLast() Order {
+	order.nulls = nullsLast
+	return order
+}
+
+// orderClause returns the SQL string for this Order.  Null placement
+// is emulated with a case expression on dialects that don't
+// implement NullsOrderer.
+func (order Order) orderClause(structMap ColumnResolver, dialect Dialect) (string, error) {
+	column, args, err := operandExpr(order.fieldPtr, structMap, dialect, 0)
+	if err != nil {
+		return "", err
+	}
+	if len(args) > 0 {
+		return "", errors.New("gorp: Order by expressions cannot bind values")
+	}
+	clause := column
+	if order.direction != "" {
+		clause += " " + order.direction
+	}
+	if order.nulls == nullsDefault {
+		return clause, nil
+	}
+	if orderer, ok := dialect.(NullsOrderer); ok {
+		return clause + orderer.NullsOrder(order.nulls == nullsFirst), nil
+	}
+	nullRank, valueRank := "0", "1"
+	if order.nulls == nullsLast {
+		nullRank, valueRank = valueRank, nullRank
+	}
+	return "case when " + column + " is null then " + nullRank + " else " + valueRank + " end, " + clause, nil
+}
+
+// parseOrders converts the arguments passed to OrderBy to a slice of
+// Orders.  Each argument must be either an Order, a field pointer, or
+// an Expression, and field pointers and Expressions may be followed
+// by a direction string.
+func parseOrders(args []interface{}) ([]Order, error) {
+	orders := make([]Order, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i].(type) {
+		case Order:
+			orders = append(orders, arg)
+		case string:
+			return nil, fmt.Errorf("gorp: Order by direction %q must follow a field pointer", arg)
+		default:
+			if _, ok := arg.(Expression); !ok && reflect.ValueOf(arg).Kind() != reflect.Ptr {
+				return nil, fmt.Errorf("gorp: Cannot order by value of type %T", arg)
+			}
+			order := Order{fieldPtr: arg}
+			if i+1 < len(args) {
+				if direction, ok := args[i+1].(string); ok {
+					i++
+					switch strings.ToLower(direction) {
+					case Ascending, Descending:
+						order.direction = strings.ToLower(direction)
+					case "":
+					default:
+						return nil, errors.New(`gorp: Order by direction must be empty string, "asc", or "desc"`)
+					}
+				}
+			}
+			orders = append(orders, order)
+		}
+	}
+	return orders, nil
+}
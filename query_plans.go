@@ -2,9 +2,12 @@ package gorp
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"reflect"
 	"strings"
+	"time"
 )
 
 // An Updater is a query that can execute UPDATE statements.
@@ -15,6 +18,11 @@ type Updater interface {
 // A Deleter is a query that can execute DELETE statements.
 type Deleter interface {
 	Delete() (rowsDeleted int64, err error)
+
+	// Delete the matched rows in batches of up to size rows, stopping
+	// with ErrDeadline before a batch that wouldn't finish before the
+	// plan's deadline.
+	DeleteBatches(size int) (rowsDeleted int64, err error)
 }
 
 // An Inserter is a query that can execute INSERT statements.
@@ -31,20 +39,132 @@ type Selector interface {
 	// Execute the select statement, but use the passed in slice
 	// pointer as the target to append to.
 	SelectToTarget(target interface{}) error
+
+	// Execute the select statement, passing each row to handler as
+	// soon as it is loaded instead of building a slice of results.
+	SelectEach(handler func(row interface{}) error) error
+
+	// Execute the select statement, passing the rows to handler in
+	// column-oriented batches of up to size rows.
+	SelectBatches(size int, handler func(batch *RecordBatch) error) error
+
+	// Execute the select statement, returning an iterator over the
+	// rows.  The caller must close the iterator.
+	SelectRows() (*Rows, error)
+
+	// Execute the select statement, selecting the passed in columns
+	// and expressions into a slice of (non-table) result structs.
+	SelectResults(target interface{}, columns ...ResultColumn) error
+
+	// Execute the select statement, returning each row as a map of
+	// column names to values.
+	SelectMaps() ([]map[string]interface{}, error)
+
+	// Execute the select statement, selecting only the column for
+	// fieldPtr, and append its values to the passed in slice pointer.
+	SelectColumn(fieldPtr interface{}, target interface{}) error
+
+	// Execute the select statement, appending each row's values for
+	// the plan's table and its joined tables to the passed in slice
+	// pointers.
+	SelectInto(targets ...interface{}) error
+
+	// Return the select statement and its arguments without
+	// executing it.
+	SQL() (query string, args []interface{}, err error)
+
+	// Return the database's plan for the select statement.
+	// ExplainAnalyze also runs the statement to report actual timings.
+	Explain() (planText string, err error)
+	ExplainAnalyze() (planText string, err error)
+
+	// Return a portable definition of the query, which can be
+	// stored and run later using DbMap.QueryDefinition.
+	Definition() (*PlanDefinition, error)
 }
 
 // A SelectManipulator is a query that will return a list of results
 // which can be manipulated.
 type SelectManipulator interface {
-	OrderBy(fieldPtr interface{}, direction string) SelectQuery
-	GroupBy(fieldPtr interface{}) SelectQuery
+	OrderBy(orders ...interface{}) SelectQuery
+
+	// OrderByRandom orders rows randomly, and OrderByRandomSeeded
+	// orders them in a random order that is the same for each seed.
+	OrderByRandom() SelectQuery
+	OrderByRandomSeeded(seed int64) SelectQuery
+
+	GroupBy(fieldPtrOrExpr interface{}) SelectQuery
+
+	// Computed selects an expression into a transient field.
+	Computed(fieldPtr interface{}, expr Expression) SelectQuery
 	Limit(int64) SelectQuery
 	Offset(int64) SelectQuery
+
+	// Page sets the limit and offset to select the nth page (starting
+	// at 1) of size rows.
+	Page(n, size int64) SelectQuery
+
+	// LimitBy limits the results to n rows for each distinct value of
+	// the passed in fields.
+	LimitBy(n int64, fieldPtrs ...interface{}) SelectQuery
+
+	// OnlyGroups restricts the columns that are selected to the
+	// primary key columns and the columns in the named column groups.
+	OnlyGroups(groups ...string) SelectQuery
+
+	// OnlyFields restricts the columns that are selected to the
+	// primary key columns and the columns for the named fields.
+	OnlyFields(fields ...string) SelectQuery
+
+	// AsOf queries a system-versioned table as it was at the passed
+	// in point in time.
+	AsOf(t time.Time) SelectQuery
+
+	// Consistency sets the read consistency that the plan requires,
+	// which selects the executor it runs on.
+	Consistency(c Consistency) SelectQuery
+
+	// Hedge sends select statements again if they haven't returned
+	// within delay, using the first response.
+	Hedge(delay time.Duration) SelectQuery
+
+	// EffectiveOn restricts the query to rows whose valid time
+	// includes the passed in point in time.
+	EffectiveOn(t time.Time) SelectQuery
+
+	// Hint adds optimizer hints to the select statement, and
+	// UseIndex tells the database which indexes to use for the
+	// plan's table.
+	Hint(hints ...string) SelectQuery
+	UseIndex(indexes ...string) SelectQuery
+
+	// Hydrate selects the columns of joined tables into fields of
+	// the target.
+	Hydrate(fieldPtrs ...interface{}) SelectQuery
+
+	// Preload loads the relations declared on the passed in fields
+	// for each result, using one query per relation.
+	Preload(fieldPtrs ...interface{}) SelectQuery
+
+	// MapResultColumn selects a column with a different name than
+	// the one mapped to the passed in field into the field.
+	MapResultColumn(column string, fieldPtr interface{}) SelectQuery
 }
 
 // An Assigner is a query that can set columns to values.
 type Assigner interface {
 	Assign(fieldPtr interface{}, value interface{}) AssignQuery
+
+	// AssignAdd and AssignSub increment or decrement a column by
+	// value, e.g. SET counter = counter + 5.  They may only be used
+	// in UPDATE statements.
+	AssignAdd(fieldPtr interface{}, value interface{}) AssignQuery
+	AssignSub(fieldPtr interface{}, value interface{}) AssignQuery
+
+	// AssignExpr sets a column to a raw SQL expression, e.g. now().
+	// Any ? placeholders in the expression will be bound to args (see
+	// Raw).
+	AssignExpr(fieldPtr interface{}, expr string, args ...interface{}) AssignQuery
 }
 
 // A Joiner is a query that can add tables as join clauses.
@@ -94,9 +214,16 @@ type UpdateQuery interface {
 	NotNull(fieldPtr interface{}) UpdateQuery
 	Null(fieldPtr interface{}) UpdateQuery
 
+	// Scoped adds the filters of named scopes (see DbMap.Scope).
+	Scoped(names ...string) UpdateQuery
+
 	// An UpdateQuery has both assignments and a where clause, which
 	// means the only query type it could be is an UPDATE statement.
 	Updater
+
+	// Return the update statement and its arguments without
+	// executing it.
+	SQL() (query string, args []interface{}, err error)
 }
 
 // An AssignQuery is a query that may set values.
@@ -106,6 +233,14 @@ type AssignQuery interface {
 	AssignWherer
 	Inserter
 	Updater
+
+	// Allow an update without a where clause when the DbMap requires
+	// a where clause for mutations.
+	AllRows() UpdateQuery
+
+	// Return the insert statement and its arguments without
+	// executing it.
+	SQL() (query string, args []interface{}, err error)
 }
 
 // An AssignJoinQuery is a clone of JoinQuery, but for UPDATE and
@@ -126,6 +261,10 @@ type AssignJoinQuery interface {
 
 	AssignWherer
 	Updater
+
+	// Return the update statement and its arguments without
+	// executing it.
+	SQL() (query string, args []interface{}, err error)
 }
 
 // A JoinQuery is a query that uses join operations to compare values
@@ -173,6 +312,9 @@ type WhereQuery interface {
 	NotNull(fieldPtr interface{}) WhereQuery
 	Null(fieldPtr interface{}) WhereQuery
 
+	// Scoped adds the filters of named scopes (see DbMap.Scope).
+	Scoped(names ...string) WhereQuery
+
 	// A WhereQuery should be used when a where clause was requested
 	// right off the bat, which means there have been no calls to
 	// Assign.  Only delete and select statements can have a where
@@ -217,11 +359,31 @@ type Query interface {
 	// delete statements can be called without any where clause, so
 	// they are allowed here.
 	//
-	// We should probably have a configuration variable to determine
-	// whether delete statements without a where clause are allowed,
-	// to prevent people from just deleting everything in their table.
-	// On the other hand, they should be checking the count they get
-	// back to ensure they deleted exactly what they wanted to delete.
+	// Delete statements without a where clause are rejected if the
+	// DbMap requires a where clause for mutations (see
+	// DbMap.RequireWhereForMutations), unless AllRows is called.
+	AllRows() WhereQuery
+
+	// Unscoped leaves the named default scopes of the table out of
+	// the plan, or, without names, every default scope and the
+	// soft-delete filter.
+	Unscoped(names ...string) Query
+
+	// WithDeleted includes soft-deleted rows in selects, and makes
+	// deletes remove rows instead of marking them as deleted.
+	WithDeleted() Query
+
+	// Scoped starts a where clause with the filters of named scopes
+	// (see DbMap.Scope).
+	Scoped(names ...string) WhereQuery
+
+	// Comment tags every statement the query generates with a SQL
+	// comment, so slow queries can be attributed to code paths.
+	Comment(comment string) Query
+
+	// Truncate removes every row from the table, so it is only
+	// allowed before any other method has been called.
+	Truncate(options ...TruncateOption) error
 	SelectManipulator
 	Deleter
 	Selector
@@ -245,8 +407,56 @@ type fieldColumnMap struct {
 	quotedColumn string
 }
 
+// A ColumnResolver maps pointers to the fields of a query's target
+// to the columns they reference.  It is passed to Filter.Where and
+// Expression.Expr, so filters defined outside of this package can
+// refer to columns the same way the built-in filters do:
+//
+//     type tenantFilter struct {
+//         field  interface{}
+//         tenant int64
+//     }
+//
+//     func (f tenantFilter) Where(cols gorp.ColumnResolver, dialect gorp.Dialect, startBindIdx int) (string, []interface{}, error) {
+//         column, err := cols.Column(f.field)
+//         if err != nil {
+//             return "", nil, err
+//         }
+//         return column + " = " + dialect.BindVar(startBindIdx), []interface{}{f.tenant}, nil
+//     }
+//
+// ColumnResolvers are only created by query plans.
+type ColumnResolver interface {
+	// Column returns the pre-quoted table.column name for fieldPtr,
+	// a pointer to a field of the query's target (or of a joined
+	// target).
+	Column(fieldPtr interface{}) (string, error)
+
+	// ColumnMap returns the ColumnMap for fieldPtr.
+	ColumnMap(fieldPtr interface{}) (*ColumnMap, error)
+
+	columnForPointer(fieldPtr interface{}) (string, error)
+	tableColumnForPointer(fieldPtr interface{}) (string, error)
+	fieldMapForPointer(fieldPtr interface{}) (*fieldColumnMap, error)
+	pointerForColumn(col *ColumnMap) (interface{}, error)
+}
+
 type structColumnMap []fieldColumnMap
 
+// Column implements ColumnResolver.
+func (structMap structColumnMap) Column(fieldPtr interface{}) (string, error) {
+	return structMap.tableColumnForPointer(fieldPtr)
+}
+
+// ColumnMap implements ColumnResolver.
+func (structMap structColumnMap) ColumnMap(fieldPtr interface{}) (*ColumnMap, error) {
+	fieldMap, err := structMap.fieldMapForPointer(fieldPtr)
+	if err != nil {
+		return nil, err
+	}
+	return fieldMap.column, nil
+}
+
 // columnForPointer takes an interface value (which should be a
 // pointer to one of the fields on the value that is being used as a
 // reference for query construction) and returns the pre-quoted column
@@ -286,6 +496,68 @@ func (structMap structColumnMap) fieldMapForPointer(fieldPtr interface{}) (*fiel
 	return nil, errors.New("gorp: Cannot find a field matching the passed in pointer")
 }
 
+// pointerForColumn is the reverse of fieldMapForPointer: it returns
+// the pointer to the struct field that maps to col.
+func (structMap structColumnMap) pointerForColumn(col *ColumnMap) (interface{}, error) {
+	for _, fieldMap := range structMap {
+		if fieldMap.column == col {
+			return fieldMap.addr, nil
+		}
+	}
+	return nil, fmt.Errorf("gorp: Cannot find a field for column %s", col.ColumnName)
+}
+
+// bindExpr replaces each ? placeholder in a raw SQL expression with
+// the dialect's bind variable, starting at startBindIdx.  Question
+// marks in string literals, quoted identifiers, and comments are not
+// placeholders, nor are Postgres' ?| and ?& operators; ?? is written
+// as a single ?, e.g. for Postgres' ? operator.  It returns an error
+// if the number of placeholders is not argCount.
+func bindExpr(dialect Dialect, expr string, startBindIdx, argCount int) (string, error) {
+	buffer := bytes.Buffer{}
+	count := 0
+	for i := 0; i < len(expr); {
+		rest := expr[i:]
+		switch {
+		case rest[0] == '\'' || rest[0] == '"' || rest[0] == '`':
+			end, err := quoteEnd(expr, i, false)
+			if err != nil {
+				return "", fmt.Errorf("gorp: expression %q has an unterminated quote", expr)
+			}
+			buffer.WriteString(expr[i:end])
+			i = end
+		case strings.HasPrefix(rest, "--"):
+			end := lineEnd(expr, i)
+			buffer.WriteString(expr[i:end])
+			i = end
+		case strings.HasPrefix(rest, "/*"):
+			end := strings.Index(rest[2:], "*/")
+			if end < 0 {
+				return "", fmt.Errorf("gorp: expression %q has an unterminated comment", expr)
+			}
+			buffer.WriteString(rest[:end+4])
+			i += end + 4
+		case strings.HasPrefix(rest, "??"):
+			buffer.WriteByte('?')
+			i += 2
+		case strings.HasPrefix(rest, "?|") || strings.HasPrefix(rest, "?&"):
+			buffer.WriteString(rest[:2])
+			i += 2
+		case rest[0] == '?':
+			buffer.WriteString(dialect.BindVar(startBindIdx + count))
+			count++
+			i++
+		default:
+			buffer.WriteByte(rest[0])
+			i++
+		}
+	}
+	if count != argCount {
+		return "", fmt.Errorf("gorp: expression %q has %d placeholders, but %d arguments were passed", expr, count, argCount)
+	}
+	return buffer.String(), nil
+}
+
 // A QueryPlan is a Query.  It returns itself on most method calls;
 // the one exception is Assign(), which returns an AssignQueryPlan (a type of
 // QueryPlan that implements AssignQuery instead of Query).  The return
@@ -310,7 +582,7 @@ type QueryPlan struct {
 	//         Where().
 	//         Greater(&someModel.CreatedAt, yesterday).
 	//         Less(&someModel.CreatedAt, time.Now()).
-	//         Order(&someModel.CreatedAt, gorp.Descending).
+	//         OrderBy(&someModel.CreatedAt, gorp.Descending).
 	//         Select()
 	//
 	// The first time that a method call returns an error (most likely
@@ -320,6 +592,7 @@ type QueryPlan struct {
 	// returned immediately.
 	Errors []error
 
+	ctx            context.Context
 	table          *TableMap
 	dbMap          *DbMap
 	executor       SqlExecutor
@@ -328,21 +601,81 @@ type QueryPlan struct {
 	joins          []*joinFilter
 	assignCols     []string
 	assignBindVars []string
+	increments     int
+	// assignValues holds the values assigned to columns with Assign,
+	// which are copied to the target before its write hooks run.
+	assignValues   map[*ColumnMap]interface{}
 	filters        MultiFilter
 	orderBy        []string
-	groupBy        []string
+	orders         []Order
+	groupBy        []interface{}
+	computed       []computedColumn
+	hydrated       []hydratedField
+	preloads       []Relation
 	limit          int64
 	offset         int64
+	limitBy        string
+	selectCols     map[*ColumnMap]bool
+	resultColumns  map[*ColumnMap]string
+	asOf           *time.Time
+	consistency    Consistency
+	includeExpired bool
+	unscoped       bool
+	unscopedNames  map[string]bool
+	withDeleted    bool
+	tenancy        Filter
+	hints          []string
+	comment        string
+	allRows        bool
+	indexes        []string
 	args           []interface{}
+
+	// selectArgs are the arguments of the expressions in the select
+	// list, which precede all other arguments of select statements.
+	selectArgs []interface{}
+
+	// from is the derived table that this plan selects from instead
+	// of its table (see QueryFrom).  bindOffset is the index of the
+	// first bind variable of a plan that is being built as a derived
+	// table, and derived is true while it is.
+	from       *derivedTable
+	bindOffset int
+	derived    bool
+
+	// defaultLimited is true while the plan is being run by Select,
+	// SelectToTarget, or SQL, the only statements that the default
+	// limit (see RuntimeConfig.DefaultLimit) applies to.
+	defaultLimited bool
 }
 
 // query generates a Query for a target model.  The target that is
 // passed in must be a pointer to a struct, and will be used as a
 // reference for query construction.
 func query(m *DbMap, exec SqlExecutor, target interface{}) Query {
+	return queryContext(context.Background(), m, exec, target)
+}
+
+// queryContext generates a Query for a target model, which will be
+// run within the passed in context.
+func queryContext(ctx context.Context, m *DbMap, exec SqlExecutor, target interface{}) Query {
+	plan := newQueryPlan(ctx, m, exec, target)
+	if len(plan.Errors) > 0 {
+		return plan
+	}
+	var err error
+	if plan.tenancy, err = plan.tenantFilter(plan.table, plan.target); err != nil {
+		plan.Errors = append(plan.Errors, err)
+	}
+	return plan
+}
+
+// newQueryPlan returns a plan for a target model, which will be run
+// within the passed in context, without the tenant filter of its table.
+func newQueryPlan(ctx context.Context, m *DbMap, exec SqlExecutor, target interface{}) *QueryPlan {
 	plan := &QueryPlan{
 		dbMap:    m,
-		executor: exec,
+		executor: withContext(ctx, m, exec),
+		ctx:      ctx,
 	}
 
 	targetVal := reflect.ValueOf(target)
@@ -356,6 +689,17 @@ func query(m *DbMap, exec SqlExecutor, target interface{}) Query {
 	return plan
 }
 
+// queryPlanner is implemented by all of the query plan types, to
+// allow helpers that accept one of the public query interfaces to get
+// at the underlying QueryPlan.
+type queryPlanner interface {
+	queryPlan() *QueryPlan
+}
+
+func (plan *QueryPlan) queryPlan() *QueryPlan {
+	return plan
+}
+
 func (plan *QueryPlan) mapTable(targetVal reflect.Value) (*TableMap, error) {
 	if targetVal.Kind() != reflect.Ptr || targetVal.Elem().Kind() != reflect.Struct {
 		return nil, errors.New("gorp: Cannot create query plan - target value must be a pointer to a struct")
@@ -408,13 +752,35 @@ func (plan *QueryPlan) mapColumns(table *TableMap, value reflect.Value) (err err
 }
 
 // Assign sets up an assignment operation to assign the passed in
-// value to the passed in field pointer.  This is used for creating
-// UPDATE or INSERT queries.
+// value, which may be an Expression (see Expr), to the passed in field
+// pointer.  This is used for creating UPDATE or INSERT queries.
 func (plan *QueryPlan) Assign(fieldPtr interface{}, value interface{}) AssignQuery {
 	assignPlan := &AssignQueryPlan{QueryPlan: plan}
 	return assignPlan.Assign(fieldPtr, value)
 }
 
+// AssignAdd sets up an assignment operation to increment the passed
+// in field by value.  This is used for creating UPDATE queries.
+func (plan *QueryPlan) AssignAdd(fieldPtr interface{}, value interface{}) AssignQuery {
+	assignPlan := &AssignQueryPlan{QueryPlan: plan}
+	return assignPlan.AssignAdd(fieldPtr, value)
+}
+
+// AssignSub sets up an assignment operation to decrement the passed
+// in field by value.  This is used for creating UPDATE queries.
+func (plan *QueryPlan) AssignSub(fieldPtr interface{}, value interface{}) AssignQuery {
+	assignPlan := &AssignQueryPlan{QueryPlan: plan}
+	return assignPlan.AssignSub(fieldPtr, value)
+}
+
+// AssignExpr sets up an assignment operation to assign the passed in
+// SQL expression to the passed in field pointer.  This is used for
+// creating UPDATE or INSERT queries.
+func (plan *QueryPlan) AssignExpr(fieldPtr interface{}, expr string, args ...interface{}) AssignQuery {
+	assignPlan := &AssignQueryPlan{QueryPlan: plan}
+	return assignPlan.AssignExpr(fieldPtr, expr, args...)
+}
+
 func (plan *QueryPlan) storeJoin() {
 	if lastJoinFilter, ok := plan.filters.(*joinFilter); ok {
 		if plan.joins == nil {
@@ -432,11 +798,18 @@ func (plan *QueryPlan) Join(target interface{}) JoinQuery {
 		plan.Errors = append(plan.Errors, err)
 	}
 	quotedTable := table.dbmap.Dialect.QuotedTableForQuery(table.SchemaName, table.TableName)
-	plan.filters = &joinFilter{quotedJoinTable: quotedTable}
+	plan.filters = &joinFilter{quotedJoinTable: quotedTable, table: table}
+	tenancy, err := plan.tenantFilter(table, reflect.ValueOf(target))
+	if err != nil {
+		plan.Errors = append(plan.Errors, err)
+	} else if tenancy != nil {
+		plan.filters.Add(tenancy)
+	}
 	return &JoinQueryPlan{QueryPlan: plan}
 }
 
 func (plan *QueryPlan) On(filters ...Filter) JoinQuery {
+	plan.checkReadable(filters)
 	plan.filters.Add(filters...)
 	return &JoinQueryPlan{QueryPlan: plan}
 }
@@ -458,6 +831,7 @@ func (plan *QueryPlan) Where(filters ...Filter) WhereQuery {
 //     query.Filter(gorp.Or(gorp.Equal(&field.Id, id), gorp.Less(&field.Priority, 3)))
 //
 func (plan *QueryPlan) Filter(filters ...Filter) WhereQuery {
+	plan.checkReadable(filters)
 	plan.filters.Add(filters...)
 	return plan
 }
@@ -502,29 +876,101 @@ func (plan *QueryPlan) NotNull(fieldPtr interface{}) WhereQuery {
 	return plan.Filter(NotNull(fieldPtr))
 }
 
-// OrderBy adds a column to the order by clause.  The direction is
-// optional - you may pass in an empty string to order in the default
-// direction for the given column.
-func (plan *QueryPlan) OrderBy(fieldPtr interface{}, direction string) SelectQuery {
-	column, err := plan.colMap.tableColumnForPointer(fieldPtr)
+// OrderBy adds one or more columns to the order by clause.  Each
+// argument may be an Order (see Asc and Desc), or a field pointer or
+// Expression optionally followed by a direction string (Ascending,
+// Descending, or an empty string for the default direction).  For
+// example:
+//
+//     query.OrderBy(gorp.Desc(&t.Created), gorp.Asc(&t.Id))
+//     query.OrderBy(&t.Created, gorp.Descending, &t.Id)
+//     query.OrderBy(gorp.Col(&t.Price).Mul(&t.Quantity), gorp.Descending)
+//
+func (plan *QueryPlan) OrderBy(orders ...interface{}) SelectQuery {
+	parsed, err := parseOrders(orders)
 	if err != nil {
 		plan.Errors = append(plan.Errors, err)
 		return plan
 	}
-	switch strings.ToLower(direction) {
-	case "asc", "desc":
-	case "":
+	for _, order := range parsed {
+		clause, err := order.orderClause(plan.readableColumns(), plan.table.dbmap.Dialect)
+		if err != nil {
+			plan.Errors = append(plan.Errors, err)
+			return plan
+		}
+		plan.orderBy = append(plan.orderBy, clause)
+		plan.orders = append(plan.orders, order)
+	}
+	return plan
+}
+
+// OrderByRandom adds a random order to the order by clause, e.g. to
+// sample rows:
+//
+//     query.OrderByRandom().Limit(10)
+//
+// It uses the dialect's random function (see RandomOrderer).
+func (plan *QueryPlan) OrderByRandom() SelectQuery {
+	random := "random()"
+	if orderer, ok := plan.table.dbmap.Dialect.(RandomOrderer); ok {
+		random = orderer.Random()
+	}
+	plan.orderBy = append(plan.orderBy, random)
+	return plan
+}
+
+// OrderByRandomSeeded adds a random order that is the same each time
+// the query is run with the same seed, e.g. for repeatable samples in
+// tests or for rotating content on a schedule.  Dialects without a
+// seeded random function (see RandomOrderer) order by a hash of the
+// table's primary key instead, which must be a single integer column.
+func (plan *QueryPlan) OrderByRandomSeeded(seed int64) SelectQuery {
+	if orderer, ok := plan.table.dbmap.Dialect.(RandomOrderer); ok {
+		if random := orderer.SeededRandom(seed); random != "" {
+			plan.orderBy = append(plan.orderBy, random)
+			return plan
+		}
+	}
+	if len(plan.table.keys) != 1 {
+		plan.Errors = append(plan.Errors, errors.New("gorp: OrderByRandomSeeded requires a single primary key column"))
+		return plan
+	}
+	key := plan.table.keys[0]
+	switch key.gotype.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 	default:
-		plan.Errors = append(plan.Errors, errors.New(`gorp: Order by direction must be empty string, "asc", or "desc"`))
+		plan.Errors = append(plan.Errors, errors.New("gorp: OrderByRandomSeeded requires an integer primary key"))
 		return plan
 	}
-	plan.orderBy = append(plan.orderBy, column)
+	fieldPtr, err := plan.colMap.pointerForColumn(key)
+	if err != nil {
+		plan.Errors = append(plan.Errors, err)
+		return plan
+	}
+	column, err := plan.colMap.tableColumnForPointer(fieldPtr)
+	if err != nil {
+		plan.Errors = append(plan.Errors, err)
+		return plan
+	}
+	// A multiplicative hash, kept below 2^63 so it can't overflow.
+	const modulus = 2147483648
+	plan.orderBy = append(plan.orderBy, fmt.Sprintf("((%s %% %d) * 1103515245 + %d) %% %d", column, modulus, uint64(seed)%modulus, modulus))
 	return plan
 }
 
-// GroupBy adds a column to the group by clause.
-func (plan *QueryPlan) GroupBy(fieldPtr interface{}) SelectQuery {
-	column, err := plan.colMap.tableColumnForPointer(fieldPtr)
+// GroupBy adds a column, or an Expression (see Raw, Func, and Expr),
+// to the group by clause:
+//
+//     query.GroupBy(&inv.PersonId)
+//     query.GroupBy(gorp.Func("date", &inv.Created))
+//
+func (plan *QueryPlan) GroupBy(fieldPtrOrExpr interface{}) SelectQuery {
+	if expr, ok := fieldPtrOrExpr.(Expression); ok {
+		plan.groupBy = append(plan.groupBy, expr)
+		return plan
+	}
+	column, err := plan.readableColumns().tableColumnForPointer(fieldPtrOrExpr)
 	if err != nil {
 		plan.Errors = append(plan.Errors, err)
 		return plan
@@ -539,14 +985,163 @@ func (plan *QueryPlan) Limit(limit int64) SelectQuery {
 	return plan
 }
 
+// effectiveLimit returns the limit of the plan's select statements,
+// which is the DbMap's default limit if the plan has none.
+func (plan *QueryPlan) effectiveLimit() int64 {
+	if plan.limit > 0 || plan.derived || !plan.defaultLimited {
+		return plan.limit
+	}
+	return plan.dbMap.runtimeConfig().DefaultLimit
+}
+
 // Offset sets the offset clause of the query.
 func (plan *QueryPlan) Offset(offset int64) SelectQuery {
 	plan.offset = offset
 	return plan
 }
 
+// Page sets the limit and offset of the query to select page n of
+// the results, where pages have size rows and the first page is 1:
+//
+//     query.OrderBy(&t.Id).Page(3, 20) // rows 41 through 60
+//
+// n must be at least 1 and size must be positive.
+func (plan *QueryPlan) Page(n, size int64) SelectQuery {
+	if n < 1 {
+		plan.Errors = append(plan.Errors, fmt.Errorf("gorp: Page number must be at least 1, got %d", n))
+		return plan
+	}
+	if size < 1 {
+		plan.Errors = append(plan.Errors, fmt.Errorf("gorp: Page size must be positive, got %d", size))
+		return plan
+	}
+	plan.limit = size
+	plan.offset = (n - 1) * size
+	return plan
+}
+
+// LimitBy limits the results to the first n rows (in the query's
+// order) for each distinct combination of the passed in fields' values,
+// e.g. the latest three invoices of each person:
+//
+//     query.OrderBy(&inv.PersonId, &inv.Created, gorp.Descending).
+//         LimitBy(3, &inv.PersonId)
+//
+// The dialect must implement LimitByer (e.g. ClickHouse).
+func (plan *QueryPlan) LimitBy(n int64, fieldPtrs ...interface{}) SelectQuery {
+	limitByer, ok := plan.table.dbmap.Dialect.(LimitByer)
+	if !ok {
+		plan.Errors = append(plan.Errors, fmt.Errorf("gorp: LimitBy: dialect %T does not support limit by", plan.table.dbmap.Dialect))
+		return plan
+	}
+	if n < 1 || len(fieldPtrs) == 0 {
+		plan.Errors = append(plan.Errors, errors.New("gorp: LimitBy requires a positive limit and at least one field"))
+		return plan
+	}
+	columns := make([]string, 0, len(fieldPtrs))
+	for _, fieldPtr := range fieldPtrs {
+		column, err := plan.colMap.tableColumnForPointer(fieldPtr)
+		if err != nil {
+			plan.Errors = append(plan.Errors, err)
+			return plan
+		}
+		columns = append(columns, column)
+	}
+	plan.limitBy = limitByer.LimitBy(n, columns)
+	return plan
+}
+
+// OnlyGroups restricts the select statement to the table's primary
+// key columns and the columns in the named column groups (see
+// TableMap.ColumnGroup).  Other fields will be left as their zero
+// value, and can be loaded later using DbMap.LoadGroup.
+func (plan *QueryPlan) OnlyGroups(groups ...string) SelectQuery {
+	if plan.selectCols == nil {
+		plan.selectCols = make(map[*ColumnMap]bool)
+	}
+	for _, group := range groups {
+		cols, ok := plan.table.columnGroups[group]
+		if !ok {
+			plan.Errors = append(plan.Errors, fmt.Errorf("gorp: No column group %s in table %s", group, plan.table.TableName))
+			return plan
+		}
+		for _, col := range cols {
+			plan.selectCols[col] = true
+		}
+	}
+	return plan
+}
+
+// OnlyFields restricts the select statement to the table's primary
+// key columns and the columns matching the requested field names.
+// This is meant for resolvers that are handed a list of requested
+// fields (e.g. from a GraphQL selection set), to avoid loading columns
+// that will never be read:
+//
+//     plan.Where().Equal(&inv.PersonId, id).OnlyFields("memo", "isPaid")
+//
+// Names are matched against struct field names and column names,
+// ignoring case.  Only the first element of a dotted path is used, and
+// names that don't match any column (e.g. computed fields) are
+// ignored.  Unselected fields are left as their zero value.
+func (plan *QueryPlan) OnlyFields(fields ...string) SelectQuery {
+	if plan.selectCols == nil {
+		plan.selectCols = make(map[*ColumnMap]bool)
+	}
+	for _, field := range fields {
+		if dot := strings.Index(field, "."); dot != -1 {
+			field = field[:dot]
+		}
+		for _, col := range plan.table.columns {
+			if strings.EqualFold(col.fieldName, field) || strings.EqualFold(col.ColumnName, field) {
+				plan.selectCols[col] = true
+			}
+		}
+	}
+	return plan
+}
+
+// AsOf queries the plan's table as it was at time t, for tables that
+// the database keeps row history for (e.g. MariaDB's system-versioned
+// tables).  It is only applied to the plan's table, not to joined
+// tables.  The dialect must implement SystemTimeQuerier.
+//
+//     plan.Where().Equal(&inv.Id, id).AsOf(lastMonth).Select()
+//
+func (plan *QueryPlan) AsOf(t time.Time) SelectQuery {
+	if _, ok := plan.table.dbmap.Dialect.(SystemTimeQuerier); !ok {
+		plan.Errors = append(plan.Errors, fmt.Errorf("gorp: AsOf: dialect %T does not support system-versioned tables", plan.table.dbmap.Dialect))
+		return plan
+	}
+	plan.asOf = &t
+	return plan
+}
+
+// selectColumns returns the columns that should be loaded by a select
+// statement, in table order.
+func (plan *QueryPlan) selectColumns() []*ColumnMap {
+	cols := make([]*ColumnMap, 0, len(plan.table.columns))
+	for _, col := range plan.table.columns {
+		if !col.inSchema() {
+			continue
+		}
+		if plan.selectCols != nil && !col.isPK && !plan.selectCols[col] {
+			continue
+		}
+		if !plan.readable(col) {
+			continue
+		}
+		cols = append(cols, col)
+	}
+	return cols
+}
+
 func (plan *QueryPlan) whereClause() (string, error) {
-	where, whereArgs, err := plan.filters.Where(plan.colMap, plan.table.dbmap.Dialect, len(plan.args))
+	filter := plan.whereFilter()
+	if filter == nil {
+		return "", nil
+	}
+	where, whereArgs, err := filter.Where(plan.colMap, plan.table.dbmap.Dialect, len(plan.args))
 	if err != nil {
 		return "", err
 	}
@@ -572,11 +1167,52 @@ func (plan *QueryPlan) selectJoinClause() (string, error) {
 
 // Select will run this query plan as a SELECT statement.
 func (plan *QueryPlan) Select() ([]interface{}, error) {
+	plan.defaultLimited = true
+	defer func() { plan.defaultLimited = false }()
+	results, err := plan.selectResults()
+	if err != nil {
+		return nil, err
+	}
+	return results, plan.preload(results)
+}
+
+func (plan *QueryPlan) selectResults() ([]interface{}, error) {
+	if rows, handled, err := plan.intercept(); handled {
+		return rows, err
+	}
+	if len(plan.hydrated) > 0 {
+		var results []interface{}
+		err := plan.eachHydrated(func(v reflect.Value) error {
+			results = append(results, v.Interface())
+			return nil
+		})
+		return results, err
+	}
 	query, err := plan.selectQuery()
 	if err != nil {
 		return nil, err
 	}
-	return plan.executor.Select(plan.target.Interface(), query, plan.args...)
+	memo := MemoFromContext(plan.ctx)
+	if memo == nil {
+		return plan.maskedSelect(plan.target.Interface(), query)
+	}
+	key := CacheKey(plan.table.gotype, query, plan.args, RoleFromContext(plan.ctx))
+	if results, ok := memo.get(key); ok {
+		plan.verifyMemo(query, results.([]interface{}))
+		return copyRows(results.([]interface{})), nil
+	}
+	results, err := plan.maskedSelect(plan.target.Interface(), query)
+	if err != nil {
+		return nil, err
+	}
+	memo.set(key, copyRows(results))
+	return results, nil
+}
+
+// maskedSelect runs query into target, applying the plan's column
+// masks to each row before its PostGet hook runs.
+func (plan *QueryPlan) maskedSelect(target interface{}, query string) ([]interface{}, error) {
+	return maskedselect(plan.dbMap, plan.executor, plan.maskedColumns(), target, query, plan.args...)
 }
 
 // SelectToTarget will run this query plan as a SELECT statement, and
@@ -586,78 +1222,383 @@ func (plan *QueryPlan) SelectToTarget(target interface{}) error {
 	if targetType.Kind() != reflect.Ptr || targetType.Elem().Kind() != reflect.Slice {
 		return errors.New("SelectToTarget must be run with a pointer to a slice as its target")
 	}
+	plan.defaultLimited = true
+	defer func() { plan.defaultLimited = false }()
+	sliceValue := reflect.ValueOf(target).Elem()
+	start := sliceValue.Len()
+	if err := plan.selectToTarget(target); err != nil {
+		return err
+	}
+	return plan.preload(sliceValue.Slice(start, sliceValue.Len()).Interface())
+}
+
+func (plan *QueryPlan) selectToTarget(target interface{}) error {
+	if rows, handled, err := plan.intercept(); handled {
+		if err != nil {
+			return err
+		}
+		return appendRows(target, rows)
+	}
+	sliceValue := reflect.ValueOf(target).Elem()
+	if len(plan.hydrated) > 0 {
+		if elem := sliceValue.Type().Elem(); elem != plan.table.gotype && elem != reflect.PtrTo(plan.table.gotype) {
+			return fmt.Errorf("gorp: Hydrate: cannot select rows of type %s into a slice of %s", plan.table.gotype, elem)
+		}
+		return plan.eachHydrated(func(v reflect.Value) error {
+			if sliceValue.Type().Elem().Kind() != reflect.Ptr {
+				v = v.Elem()
+			}
+			sliceValue.Set(reflect.Append(sliceValue, v))
+			return nil
+		})
+	}
 	query, err := plan.selectQuery()
 	if err != nil {
 		return err
 	}
-	_, err = plan.executor.Select(target, query, plan.args...)
-	return err
+	start := sliceValue.Len()
+	memo := MemoFromContext(plan.ctx)
+	if memo == nil {
+		_, err = plan.maskedSelect(target, query)
+		return err
+	}
+	key := CacheKey(sliceValue.Type(), query, plan.args, RoleFromContext(plan.ctx))
+	if results, ok := memo.get(key); ok {
+		plan.verifyMemoSlice(query, results.(reflect.Value))
+		sliceValue.Set(reflect.AppendSlice(sliceValue, copySlice(results.(reflect.Value))))
+		return nil
+	}
+	if _, err = plan.maskedSelect(target, query); err != nil {
+		return err
+	}
+	memo.set(key, copySlice(sliceValue.Slice(start, sliceValue.Len())))
+	return nil
 }
 
-func (plan *QueryPlan) selectQuery() (string, error) {
+// SelectEach will run this query plan as a SELECT statement, passing
+// each row to handler as soon as it has been loaded.  Rows are never
+// accumulated, so memory use doesn't grow with the size of the result
+// set; this is meant for exports and other jobs that read millions of
+// rows.  If handler returns an error, SelectEach stops reading rows
+// and returns that error.
+//
+// The connection (or transaction) is busy until SelectEach returns,
+// so handler should not run other queries on the same transaction.
+func (plan *QueryPlan) SelectEach(handler func(row interface{}) error) error {
+	if len(plan.hydrated) > 0 {
+		return plan.eachHydrated(func(v reflect.Value) error {
+			return handler(v.Interface())
+		})
+	}
+	query, err := plan.selectQuery()
+	if err != nil {
+		return err
+	}
+	t := plan.table.gotype
+	alloc := func() reflect.Value {
+		return reflect.New(t)
+	}
+	return eachRow(plan.dbMap, plan.executor, t, plan.maskedColumns(), query, plan.args, alloc, func(v reflect.Value) error {
+		return handler(v.Interface())
+	})
+}
+
+// SelectColumn will run this query plan as a SELECT statement that
+// only selects the column for fieldPtr, and append the column's values
+// directly to target, which must be a pointer to a slice of the
+// column's type.  For example:
+//
+//     var ids []int64
+//     err := dbmap.Query(inv).Where().Equal(&inv.IsPaid, false).
+//         SelectColumn(&inv.Id, &ids)
+//
+// No structs are allocated for the rows.
+func (plan *QueryPlan) SelectColumn(fieldPtr interface{}, target interface{}) error {
+	targetType := reflect.TypeOf(target)
+	if targetType == nil || targetType.Kind() != reflect.Ptr || targetType.Elem().Kind() != reflect.Slice {
+		return errors.New("gorp: SelectColumn must be run with a pointer to a slice as its target")
+	}
 	if len(plan.Errors) > 0 {
-		return "", plan.Errors[0]
+		return plan.Errors[0]
 	}
-	quotedTable := plan.table.dbmap.Dialect.QuotedTableForQuery(plan.table.SchemaName, plan.table.TableName)
-	buffer := bytes.Buffer{}
-	buffer.WriteString("select ")
-	for index, col := range plan.table.columns {
-		if !col.Transient {
-			if index != 0 {
-				buffer.WriteString(",")
+	fieldMap, err := plan.colMap.fieldMapForPointer(fieldPtr)
+	if err != nil {
+		return err
+	}
+	if !plan.readable(fieldMap.column) {
+		return readAccessError{col: fieldMap.column, table: plan.table.TableName}
+	}
+	column := fieldMap.quotedTable + "." + fieldMap.quotedColumn
+	query, err := plan.cachedQuery("column "+column, func() (string, error) {
+		return plan.buildSelect(column)
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = plan.executor.Select(target, query, plan.args...); err != nil {
+		return err
+	}
+	if fieldMap.column.masked(RoleFromContext(plan.ctx)) {
+		slice := reflect.ValueOf(target).Elem()
+		for i := 0; i < slice.Len(); i++ {
+			fieldMap.column.mask(slice.Index(i))
+		}
+	}
+	return nil
+}
+
+// SelectMaps will run this query plan as a SELECT statement, and
+// return each row as a map of column names to values, rather than as
+// a struct.  This is meant for admin tooling and ad-hoc projections
+// where defining a struct is overkill.
+//
+// Values are returned as they come from the driver, without any type
+// conversion, so their types depend on the driver (e.g. text columns
+// may be returned as []byte).
+func (plan *QueryPlan) SelectMaps() ([]map[string]interface{}, error) {
+	query, err := plan.selectQuery()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := plan.executor.query(query, plan.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	masked := make(map[string]*ColumnMap)
+	for _, col := range plan.maskedColumns() {
+		masked[col.ColumnName] = col
+	}
+	results := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		values := make([]interface{}, len(cols))
+		targets := make([]interface{}, len(cols))
+		for i := range values {
+			targets[i] = &values[i]
+		}
+		if err = rows.Scan(targets...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]interface{}, len(cols))
+		for i, col := range cols {
+			if maskCol, ok := masked[col]; ok {
+				values[i] = maskValue(maskCol, values[i])
 			}
-			buffer.WriteString(quotedTable)
-			buffer.WriteString(".")
-			buffer.WriteString(plan.table.dbmap.Dialect.QuoteField(col.ColumnName))
+			row[col] = values[i]
 		}
+		results = append(results, row)
 	}
+	return results, rows.Err()
+}
+
+// fromWhereClause returns the from clause (including joins) and where
+// clause for select statements.
+func (plan *QueryPlan) fromWhereClause() (string, error) {
+	plan.storeJoin()
+	// Select statements don't have any assignments, so any existing
+	// arguments are from a previous run of this plan.
+	plan.args = append(plan.args[:0], make([]interface{}, plan.bindOffset)...)
+	plan.args = append(plan.args, plan.selectArgs...)
+	buffer := bytes.Buffer{}
 	buffer.WriteString(" from ")
-	buffer.WriteString(quotedTable)
+	if plan.from != nil {
+		if plan.asOf != nil || len(plan.indexes) > 0 {
+			return "", errors.New("gorp: AsOf and UseIndex cannot be used with derived tables")
+		}
+		from, fromArgs, err := plan.from.sql(len(plan.args))
+		if err != nil {
+			return "", err
+		}
+		buffer.WriteString(from)
+		buffer.WriteString(" ")
+		plan.args = append(plan.args, fromArgs...)
+	}
+	buffer.WriteString(plan.table.dbmap.Dialect.QuotedTableForQuery(plan.table.SchemaName, plan.table.TableName))
+	if plan.asOf != nil {
+		querier := plan.table.dbmap.Dialect.(SystemTimeQuerier)
+		buffer.WriteString(querier.AsOf(plan.table.dbmap.Dialect.BindVar(len(plan.args))))
+		plan.args = append(plan.args, *plan.asOf)
+	}
+	if len(plan.indexes) > 0 {
+		buffer.WriteString(plan.table.dbmap.Dialect.(IndexHinter).UseIndex(plan.indexes))
+	}
 	joinClause, err := plan.selectJoinClause()
 	if err != nil {
 		return "", err
 	}
 	buffer.WriteString(joinClause)
+	buffer.WriteString(plan.staleReadClause())
 	whereClause, err := plan.whereClause()
 	if err != nil {
 		return "", err
 	}
 	buffer.WriteString(whereClause)
-	for index, orderBy := range plan.orderBy {
-		if index == 0 {
-			buffer.WriteString(" order by ")
+	conditions := append([]string{plan.unexpiredClause(), plan.undeletedClause()}, plan.undeletedJoinClauses()...)
+	for _, condition := range conditions {
+		if condition == "" {
+			continue
+		}
+		if whereClause == "" {
+			buffer.WriteString(" where ")
+			whereClause = condition
 		} else {
-			buffer.WriteString(", ")
+			buffer.WriteString(" and ")
 		}
-		buffer.WriteString(orderBy)
+		buffer.WriteString(condition)
+	}
+	return buffer.String(), nil
+}
+
+// countQuery returns a select statement that counts the rows matched
+// by this plan.
+func (plan *QueryPlan) countQuery() (string, error) {
+	return plan.cachedQuery("count", func() (string, error) {
+		fromWhere, err := plan.fromWhereClause()
+		if err != nil {
+			return "", err
+		}
+		return "select count(*)" + fromWhere, nil
+	})
+}
+
+// existsQuery returns a select statement that returns 1 if any rows
+// are matched by this plan, or 0 otherwise.
+func (plan *QueryPlan) existsQuery() (string, error) {
+	return plan.cachedQuery("exists", func() (string, error) {
+		fromWhere, err := plan.fromWhereClause()
+		if err != nil {
+			return "", err
+		}
+		return "select case when exists (select 1" + fromWhere + ") then 1 else 0 end", nil
+	})
+}
+
+// selectQuery returns the select statement for this plan.  Generated
+// statements are cached by the plan's table, keyed by the shape of
+// the plan (its columns, filters, ordering, and so on), so that plans
+// which are built the same way each time only need to collect their
+// arguments.
+func (plan *QueryPlan) selectQuery() (string, error) {
+	return plan.cachedQuery("select", plan.buildSelectQuery)
+}
+
+func (plan *QueryPlan) buildSelectQuery() (string, error) {
+	quotedTable := plan.table.dbmap.Dialect.QuotedTableForQuery(plan.table.SchemaName, plan.table.TableName)
+	columns := bytes.Buffer{}
+	for index, col := range plan.selectColumns() {
+		if index != 0 {
+			columns.WriteString(",")
+		}
+		columns.WriteString(plan.selectColumn(quotedTable, col))
+	}
+	columns.WriteString(plan.hydratedColumns())
+	if len(plan.computed) == 0 {
+		return plan.buildSelect(columns.String())
+	}
+	computed, computedArgs, err := plan.computedColumns()
+	if err != nil {
+		return "", err
+	}
+	columns.WriteString(computed)
+	plan.selectArgs = computedArgs
+	defer func() { plan.selectArgs = nil }()
+	return plan.buildSelect(columns.String())
+}
+
+// buildSelect returns a select statement for this plan that selects
+// the passed in (already quoted) column list.
+func (plan *QueryPlan) buildSelect(columns string) (string, error) {
+	buffer := bytes.Buffer{}
+	buffer.WriteString("select ")
+	if len(plan.hints) > 0 {
+		buffer.WriteString(plan.table.dbmap.Dialect.(OptimizerHinter).HintComment(plan.hints))
 	}
+	buffer.WriteString(columns)
+	fromWhere, err := plan.fromWhereClause()
+	if err != nil {
+		return "", err
+	}
+	buffer.WriteString(fromWhere)
 	for index, groupBy := range plan.groupBy {
 		if index == 0 {
 			buffer.WriteString(" group by ")
 		} else {
 			buffer.WriteString(", ")
 		}
-		buffer.WriteString(groupBy)
-	}
-	if plan.offset > 0 {
-		buffer.WriteString(" offset ")
-		buffer.WriteString(plan.table.dbmap.Dialect.BindVar(len(plan.args)))
-		plan.args = append(plan.args, plan.offset)
+		if expr, ok := groupBy.(Expression); ok {
+			sql, args, err := expr.Expr(plan.readableColumns(), plan.table.dbmap.Dialect, len(plan.args))
+			if err != nil {
+				return "", err
+			}
+			buffer.WriteString(sql)
+			plan.args = append(plan.args, args...)
+			continue
+		}
+		buffer.WriteString(groupBy.(string))
 	}
-	if plan.limit > 0 {
-		buffer.WriteString(" fetch next (")
-		buffer.WriteString(plan.table.dbmap.Dialect.BindVar(len(plan.args)))
-		plan.args = append(plan.args, plan.limit)
-		buffer.WriteString(") rows only")
+	for index, orderBy := range plan.orderBy {
+		if index == 0 {
+			buffer.WriteString(" order by ")
+		} else {
+			buffer.WriteString(", ")
+		}
+		buffer.WriteString(orderBy)
 	}
+	buffer.WriteString(plan.limitBy)
+	limitClause, limitArgs := limitClause(plan.table.dbmap.Dialect, plan.effectiveLimit(), plan.offset, len(plan.args))
+	buffer.WriteString(limitClause)
+	plan.args = append(plan.args, limitArgs...)
+	buffer.WriteString(plan.commentClause())
 	return buffer.String(), nil
 }
 
-// Insert will run this query plan as an INSERT statement.
+// Insert will run this query plan as an INSERT statement.  The
+// target's PreInsert and PostInsert hooks, if it has them, are run
+// before and after it, and the columns whose fields PreInsert changes
+// are assigned.  The target's Validate hook is then run, and the plan
+// must assign the table's required columns (see ColumnMap.Validate).
 func (plan *QueryPlan) Insert() error {
 	if len(plan.Errors) > 0 {
 		return plan.Errors[0]
 	}
+	if plan.from != nil {
+		return errDerivedWrite
+	}
+	if err := plan.dbMap.checkWritable(); err != nil {
+		return err
+	}
+	if err := plan.runPreHook(writeInsert); err != nil {
+		return err
+	}
+	if err := plan.validate(writeInsert); err != nil {
+		return err
+	}
+	plan.assignTimestamps(true)
+	plan.assignTenant()
+	query, err := plan.insertQuery()
+	if err != nil {
+		return err
+	}
+	if err = plan.table.throttleWrite(plan.ctx); err != nil {
+		return err
+	}
+	_, err = plan.executor.Exec(query, plan.args...)
+	plan.clearMemo()
+	if err != nil {
+		return err
+	}
+	return plan.runPostHook(writeInsert)
+}
+
+// insertQuery returns the insert statement for this plan.
+func (plan *QueryPlan) insertQuery() (string, error) {
+	if plan.increments > 0 {
+		return "", errors.New("gorp: AssignAdd and AssignSub can only be used in UPDATE statements")
+	}
 	buffer := bytes.Buffer{}
 	buffer.WriteString("insert into ")
 	buffer.WriteString(plan.table.dbmap.Dialect.QuotedTableForQuery(plan.table.SchemaName, plan.table.TableName))
@@ -676,32 +1617,87 @@ func (plan *QueryPlan) Insert() error {
 		buffer.WriteString(bindVar)
 	}
 	buffer.WriteString(")")
-	_, err := plan.executor.Exec(buffer.String(), plan.args...)
-	return err
+	buffer.WriteString(plan.commentClause())
+	return buffer.String(), nil
 }
 
 // joinFromAndWhereClause will return the from and where clauses for
 // joined tables, for use in UPDATE and DELETE statements.
 func (plan *QueryPlan) joinFromAndWhereClause() (from, where string, err error) {
 	fromSlice := make([]string, 0, len(plan.joins))
-	whereBuffer := bytes.Buffer{}
+	whereSlice := make([]string, 0, len(plan.joins))
 	for _, join := range plan.joins {
 		fromSlice = append(fromSlice, join.quotedJoinTable)
 		whereClause, whereArgs, err := join.Where(plan.colMap, plan.table.dbmap.Dialect, len(plan.args))
 		if err != nil {
 			return "", "", err
 		}
-		whereBuffer.WriteString(whereClause)
+		if whereClause != "" {
+			whereSlice = append(whereSlice, whereClause)
+		}
 		plan.args = append(plan.args, whereArgs...)
 	}
-	return strings.Join(fromSlice, ", "), whereBuffer.String(), nil
+	return strings.Join(fromSlice, ", "), strings.Join(whereSlice, " and "), nil
 }
 
-// Update will run this query plan as an UPDATE statement.
+// Update will run this query plan as an UPDATE statement.  The
+// target's PreUpdate and PostUpdate hooks, if it has them, are run
+// before and after it, and the columns whose fields PreUpdate changes
+// are assigned.  The target's Validate hook is then run.
 func (plan *QueryPlan) Update() (int64, error) {
 	if len(plan.Errors) > 0 {
 		return -1, plan.Errors[0]
 	}
+	if plan.from != nil {
+		return -1, errDerivedWrite
+	}
+	if err := plan.dbMap.checkMutable(); err != nil {
+		return -1, err
+	}
+	if err := plan.checkScoped(); err != nil {
+		return -1, err
+	}
+	if err := plan.checkKeyPredicate(); err != nil {
+		return -1, err
+	}
+	if err := plan.runPreHook(writeUpdate); err != nil {
+		return -1, err
+	}
+	if err := plan.validate(writeUpdate); err != nil {
+		return -1, err
+	}
+	// The version check isn't a filter of the caller's.
+	scoped := plan.scoped()
+	current, next, undo, err := plan.checkVersion()
+	if err != nil {
+		return -1, err
+	}
+	defer undo()
+	plan.assignTimestamps(false)
+	query, err := plan.updateQuery()
+	if err != nil {
+		return -1, err
+	}
+	if !scoped {
+		if err = plan.dbMap.audit(query, plan.args); err != nil {
+			return -1, err
+		}
+	}
+	rows, err := plan.execCount(query)
+	if err == nil && current != nil {
+		rows, err = plan.versionedUpdate(rows, current, next)
+	}
+	if err != nil {
+		return rows, err
+	}
+	if err = plan.runPostHook(writeUpdate); err != nil {
+		return -1, err
+	}
+	return rows, nil
+}
+
+// updateQuery returns the update statement for this plan.
+func (plan *QueryPlan) updateQuery() (string, error) {
 	buffer := bytes.Buffer{}
 	buffer.WriteString("update ")
 	buffer.WriteString(plan.table.dbmap.Dialect.QuotedTableForQuery(plan.table.SchemaName, plan.table.TableName))
@@ -715,64 +1711,148 @@ func (plan *QueryPlan) Update() (int64, error) {
 		buffer.WriteString("=")
 		buffer.WriteString(bindVar)
 	}
+	// The where clause comes before the join conditions, so its
+	// arguments must too.
+	whereClause, err := plan.whereClause()
+	if err != nil {
+		return "", err
+	}
 	joinTables, joinWhereClause, err := plan.joinFromAndWhereClause()
 	if err != nil {
-		return -1, nil
+		return "", err
 	}
 	if joinTables != "" {
 		buffer.WriteString(" from ")
 		buffer.WriteString(joinTables)
 	}
-	whereClause, err := plan.whereClause()
-	if err != nil {
+	whereClause = joinWhere(whereClause, joinWhereClause)
+	buffer.WriteString(whereClause)
+	buffer.WriteString(plan.commentClause())
+	return buffer.String(), nil
+}
+
+// Delete will run this query plan as a DELETE statement.  The
+// target's PreDelete and PostDelete hooks, if it has them, are run
+// before and after it.
+func (plan *QueryPlan) Delete() (int64, error) {
+	if len(plan.Errors) > 0 {
+		return -1, plan.Errors[0]
+	}
+	if plan.from != nil {
+		return -1, errDerivedWrite
+	}
+	if err := plan.dbMap.checkMutable(); err != nil {
 		return -1, err
 	}
-	if joinWhereClause != "" {
-		if whereClause == "" {
-			whereClause = " where"
-		}
-		whereClause += " " + joinWhereClause
+	if err := plan.checkScoped(); err != nil {
+		return -1, err
 	}
-	buffer.WriteString(whereClause)
-	res, err := plan.executor.Exec(buffer.String(), plan.args...)
-	if err != nil {
+	if err := plan.checkKeyPredicate(); err != nil {
 		return -1, err
 	}
-	rows, err := res.RowsAffected()
+	if err := plan.runPreHook(writeDelete); err != nil {
+		return -1, err
+	}
+	rows, err := plan.deleteRows()
 	if err != nil {
 		return -1, err
 	}
+	if err = plan.runPostHook(writeDelete); err != nil {
+		return -1, err
+	}
 	return rows, nil
 }
 
-// Delete will run this query plan as a DELETE statement.
-func (plan *QueryPlan) Delete() (int64, error) {
-	if len(plan.Errors) > 0 {
-		return -1, plan.Errors[0]
+// deleteRows runs this plan's delete statement, or its soft delete
+// statement if the plan's table has a soft-delete column.
+func (plan *QueryPlan) deleteRows() (int64, error) {
+	if plan.softDeletes() {
+		return plan.softDelete()
 	}
-	buffer := bytes.Buffer{}
-	buffer.WriteString("delete from ")
-	buffer.WriteString(plan.table.dbmap.Dialect.QuotedTableForQuery(plan.table.SchemaName, plan.table.TableName))
-	joinTables, joinWhereClause, err := plan.joinFromAndWhereClause()
+	query, keys, err := plan.deleteQuery()
 	if err != nil {
 		return -1, err
 	}
+	if !plan.scoped() {
+		if err = plan.dbMap.audit(query, plan.args); err != nil {
+			return -1, err
+		}
+	}
+	if len(plan.table.cascades()) > 0 {
+		return plan.cascadingDelete(query, keys)
+	}
+	return plan.execCount(query)
+}
+
+// deleteQuery returns the delete statement for this plan, along with
+// a query that selects the primary keys of the rows it deletes, for
+// tables with a single primary key column.  Both take the plan's
+// arguments.
+func (plan *QueryPlan) deleteQuery() (query, keys string, err error) {
+	dialect := plan.table.dbmap.Dialect
+	quotedTable := dialect.QuotedTableForQuery(plan.table.SchemaName, plan.table.TableName)
+	joinTables, whereClause, err := plan.deleteWhereClause()
+	if err != nil {
+		return "", "", err
+	}
+
+	buffer := bytes.Buffer{}
+	buffer.WriteString("delete from ")
+	buffer.WriteString(quotedTable)
 	if joinTables != "" {
 		buffer.WriteString(" using ")
 		buffer.WriteString(joinTables)
 	}
-	whereClause, err := plan.whereClause()
+	buffer.WriteString(whereClause)
+	buffer.WriteString(plan.commentClause())
+
+	if len(plan.table.keys) == 1 {
+		from := quotedTable
+		if joinTables != "" {
+			from += ", " + joinTables
+		}
+		keys = fmt.Sprintf("select %s.%s from %s%s", quotedTable, dialect.QuoteField(plan.table.keys[0].ColumnName), from, whereClause)
+	}
+	return buffer.String(), keys, nil
+}
+
+// joinWhere adds the conditions of a plan's joined tables to its where
+// clause.
+func joinWhere(whereClause, joinWhereClause string) string {
+	switch {
+	case joinWhereClause == "":
+		return whereClause
+	case whereClause == "":
+		return " where " + joinWhereClause
+	}
+	return whereClause + " and " + joinWhereClause
+}
+
+// deleteWhereClause returns the tables joined by this plan's delete
+// statement, and its where clause.
+func (plan *QueryPlan) deleteWhereClause() (joinTables, whereClause string, err error) {
+	// The where clause comes before the join conditions, so its
+	// arguments must too.
+	whereClause, err = plan.whereClause()
 	if err != nil {
-		return -1, err
+		return "", "", err
 	}
-	if joinWhereClause != "" {
-		if whereClause == "" {
-			whereClause = " where"
-		}
-		whereClause += " " + joinWhereClause
+	joinTables, joinWhereClause, err := plan.joinFromAndWhereClause()
+	if err != nil {
+		return "", "", err
 	}
-	buffer.WriteString(whereClause)
-	res, err := plan.executor.Exec(buffer.String(), plan.args...)
+	whereClause = joinWhere(whereClause, joinWhereClause)
+	return joinTables, whereClause, nil
+}
+
+// execCount runs an update or delete statement, returning the number
+// of rows affected.
+func (plan *QueryPlan) execCount(query string) (int64, error) {
+	if err := plan.table.throttleWrite(plan.ctx); err != nil {
+		return -1, err
+	}
+	res, err := plan.executor.Exec(query, plan.args...)
+	plan.clearMemo()
 	if err != nil {
 		return -1, err
 	}
@@ -783,6 +1863,22 @@ func (plan *QueryPlan) Delete() (int64, error) {
 	return rows, nil
 }
 
+// SQL returns the select statement that this plan would run, along
+// with its bind arguments, without executing it.  Plans that could
+// run either a select or a delete statement return the select
+// statement.
+func (plan *QueryPlan) SQL() (query string, args []interface{}, err error) {
+	if len(plan.Errors) > 0 {
+		return "", nil, plan.Errors[0]
+	}
+	plan.defaultLimited = true
+	defer func() { plan.defaultLimited = false }()
+	if query, err = plan.selectQuery(); err != nil {
+		return "", nil, err
+	}
+	return query, append([]interface{}(nil), plan.args...), nil
+}
+
 // A JoinQueryPlan is a QueryPlan, except with some return values
 // changed so that it will match the JoinQuery interface.
 type JoinQueryPlan struct {
@@ -842,17 +1938,115 @@ type AssignQueryPlan struct {
 }
 
 func (plan *AssignQueryPlan) Assign(fieldPtr interface{}, value interface{}) AssignQuery {
-	column, err := plan.colMap.columnForPointer(fieldPtr)
+	fieldMap, err := plan.colMap.fieldMapForPointer(fieldPtr)
 	if err != nil {
 		plan.Errors = append(plan.Errors, err)
 		return plan
 	}
-	plan.assignCols = append(plan.assignCols, column)
+	if fieldMap.column.isMissing() {
+		// Optional columns that don't exist yet are silently
+		// skipped.
+		return plan
+	}
+	if !plan.checkWritable(fieldMap.column) {
+		return plan
+	}
+	if expr, ok := value.(Expression); ok {
+		sql, args, err := expr.Expr(plan.readableColumns(), plan.table.dbmap.Dialect, len(plan.args))
+		if err != nil {
+			plan.Errors = append(plan.Errors, err)
+			return plan
+		}
+		plan.assignCols = append(plan.assignCols, fieldMap.quotedColumn)
+		plan.assignBindVars = append(plan.assignBindVars, sql)
+		plan.args = append(plan.args, args...)
+		return plan
+	}
+	if err := fieldMap.column.validateValue(plan.table, value); err != nil {
+		plan.Errors = append(plan.Errors, err)
+		return plan
+	}
+	plan.assignCols = append(plan.assignCols, fieldMap.quotedColumn)
 	plan.assignBindVars = append(plan.assignBindVars, plan.table.dbmap.Dialect.BindVar(len(plan.args)))
 	plan.args = append(plan.args, value)
+	if plan.assignValues == nil {
+		plan.assignValues = make(map[*ColumnMap]interface{})
+	}
+	plan.assignValues[fieldMap.column] = value
 	return plan
 }
 
+func (plan *AssignQueryPlan) AssignAdd(fieldPtr interface{}, value interface{}) AssignQuery {
+	return plan.assignIncrement(fieldPtr, " + ", value)
+}
+
+func (plan *AssignQueryPlan) AssignSub(fieldPtr interface{}, value interface{}) AssignQuery {
+	return plan.assignIncrement(fieldPtr, " - ", value)
+}
+
+// assignIncrement assigns column = column operator value to the
+// column for fieldPtr.
+func (plan *AssignQueryPlan) assignIncrement(fieldPtr interface{}, operator string, value interface{}) AssignQuery {
+	fieldMap, err := plan.colMap.fieldMapForPointer(fieldPtr)
+	if err != nil {
+		plan.Errors = append(plan.Errors, err)
+		return plan
+	}
+	if fieldMap.column.isMissing() {
+		return plan
+	}
+	if !plan.checkWritable(fieldMap.column) {
+		return plan
+	}
+	plan.assignCols = append(plan.assignCols, fieldMap.quotedColumn)
+	plan.assignBindVars = append(plan.assignBindVars, fieldMap.quotedColumn+operator+plan.table.dbmap.Dialect.BindVar(len(plan.args)))
+	plan.args = append(plan.args, value)
+	plan.increments++
+	return plan
+}
+
+func (plan *AssignQueryPlan) AssignExpr(fieldPtr interface{}, expr string, args ...interface{}) AssignQuery {
+	return plan.Assign(fieldPtr, Raw(expr, args...))
+}
+
+// SQL returns the statement that this plan would run, along with its
+// bind arguments, without executing it.  Plans that have a where
+// clause or a join return their update statement, and other plans
+// return their insert statement.
+func (plan *AssignQueryPlan) SQL() (query string, args []interface{}, err error) {
+	if len(plan.Errors) > 0 {
+		return "", nil, plan.Errors[0]
+	}
+	// Building the where clause and assigning timestamps and the
+	// tenant add arguments to the plan, so remove them again to leave the plan
+	// ready to run.
+	assigned, assignedCols := len(plan.args), len(plan.assignCols)
+	defer func() {
+		plan.args = plan.args[:assigned]
+		plan.assignCols = plan.assignCols[:assignedCols]
+		plan.assignBindVars = plan.assignBindVars[:assignedCols]
+	}()
+	insert := plan.filters == nil && len(plan.joins) == 0
+	if !insert {
+		_, _, undo, err := plan.checkVersion()
+		if err != nil {
+			return "", nil, err
+		}
+		defer undo()
+	}
+	plan.assignTimestamps(insert)
+	if insert {
+		plan.assignTenant()
+		query, err = plan.insertQuery()
+	} else {
+		query, err = plan.updateQuery()
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return query, append([]interface{}(nil), plan.args...), nil
+}
+
 func (plan *AssignQueryPlan) Join(table interface{}) AssignJoinQuery {
 	plan.QueryPlan.Join(table)
 	return &AssignJoinQueryPlan{plan}
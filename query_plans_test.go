@@ -1,9 +1,25 @@
 package gorp
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unsafe"
 )
 
 func TestQueryLanguage(t *testing.T) {
@@ -177,6 +193,164 @@ func TestQueryLanguage(t *testing.T) {
 		t.FailNow()
 	}
 
+	invTest, err = dbmap.Query(emptyInv).
+		Where().
+		OrderBy(Desc(&emptyInv.Created), Asc(&emptyInv.Id)).
+		Select()
+	if err != nil {
+		t.Errorf("Failed to select: %s", err)
+		t.FailNow()
+	}
+	if len(invTest) != 4 {
+		t.Errorf("Expected four invoices")
+		t.FailNow()
+	}
+	for index, expectedId := range []string{"2", "4", "1", "3"} {
+		if id := invTest[index].(*OverriddenInvoice).Id; id != expectedId {
+			t.Errorf("Expected invoice %d to have id %s, got %s", index, expectedId, id)
+		}
+	}
+
+	invTest, err = dbmap.Query(emptyInv).
+		Where().
+		Filter(EqualFold(&emptyInv.Memo, "TEST_MEMO")).
+		Select()
+	if err != nil {
+		t.Errorf("Failed to select: %s", err)
+		t.FailNow()
+	}
+	if len(invTest) != 2 {
+		t.Errorf("Expected two invoices for case-insensitive query")
+		t.FailNow()
+	}
+
+	invTest, err = dbmap.Query(emptyInv).
+		Where().
+		Equal(&emptyInv.Id, "3").
+		OnlyFields("memo", "author.name").
+		Select()
+	if err != nil {
+		t.Errorf("Failed to select: %s", err)
+		t.FailNow()
+	}
+	if len(invTest) != 1 {
+		t.Errorf("Expected one invoice")
+		t.FailNow()
+	}
+	if inv := invTest[0].(*OverriddenInvoice); inv.Memo != "test_memo" || inv.Updated != 0 {
+		t.Errorf("Expected only the memo field to be loaded, got %#v", inv)
+	}
+
+	maps, err := dbmap.Query(emptyInv).
+		Where().
+		Equal(&emptyInv.Memo, "test_memo").
+		SelectMaps()
+	if err != nil {
+		t.Errorf("Failed to select maps: %s", err)
+		t.FailNow()
+	}
+	if len(maps) != 2 || len(maps[0]) != 6 {
+		t.Errorf("Expected two rows with six columns each, got %v", maps)
+	}
+
+	var ids []string
+	err = dbmap.Query(emptyInv).
+		Where().
+		Equal(&emptyInv.Memo, "test_memo").
+		OrderBy(&emptyInv.Id).
+		SelectColumn(&emptyInv.Id, &ids)
+	if err != nil {
+		t.Errorf("Failed to select column: %s", err)
+		t.FailNow()
+	}
+	if len(ids) != 2 || ids[0] != "1" || ids[1] != "3" {
+		t.Errorf("Expected ids [1 3], got %v", ids)
+	}
+
+	var streamed []string
+	err = dbmap.Query(emptyInv).
+		Where().
+		Equal(&emptyInv.Memo, "test_memo").
+		OrderBy(&emptyInv.Id).
+		SelectEach(func(row interface{}) error {
+			streamed = append(streamed, row.(*OverriddenInvoice).Id)
+			return nil
+		})
+	if err != nil {
+		t.Errorf("Failed to stream rows: %s", err)
+		t.FailNow()
+	}
+	if len(streamed) != 2 || streamed[0] != "1" || streamed[1] != "3" {
+		t.Errorf("Expected streamed ids [1 3], got %v", streamed)
+	}
+	stop := errors.New("stop")
+	rows := 0
+	err = dbmap.Query(emptyInv).
+		Where().
+		Equal(&emptyInv.Memo, "test_memo").
+		SelectEach(func(row interface{}) error {
+			rows++
+			return stop
+		})
+	if err != stop || rows != 1 {
+		t.Errorf("Expected SelectEach to stop after the first row, got %d rows and %v", rows, err)
+	}
+
+	iter, err := dbmap.Query(emptyInv).
+		Where().
+		Equal(&emptyInv.Memo, "test_memo").
+		OrderBy(&emptyInv.Id).
+		SelectRows()
+	if err != nil {
+		t.Errorf("Failed to select rows: %s", err)
+		t.FailNow()
+	}
+	streamed = streamed[:0]
+	for iter.Next() {
+		inv := new(OverriddenInvoice)
+		if err = iter.Scan(inv); err != nil {
+			t.Errorf("Failed to scan row: %s", err)
+			t.FailNow()
+		}
+		streamed = append(streamed, inv.Id)
+	}
+	if err = iter.Err(); err != nil {
+		t.Errorf("Failed to iterate rows: %s", err)
+	}
+	iter.Close()
+	if len(streamed) != 2 || streamed[0] != "1" || streamed[1] != "3" {
+		t.Errorf("Expected iterated ids [1 3], got %v", streamed)
+	}
+
+	planText, err := dbmap.Query(emptyInv).
+		Where().
+		Equal(&emptyInv.Memo, "test_memo").
+		Explain()
+	if err != nil {
+		t.Errorf("Failed to explain: %s", err)
+		t.FailNow()
+	}
+	if planText == "" {
+		t.Errorf("Expected a query plan")
+	}
+
+	count, err = dbmap.CountWhere(emptyInv, Equal(&emptyInv.Memo, "test_memo"))
+	if err != nil {
+		t.Errorf("Failed to count: %s", err)
+		t.FailNow()
+	}
+	if count != 2 {
+		t.Errorf("Expected to count two invoices, got %d", count)
+	}
+	exists, err := dbmap.ExistsWhere(emptyInv, Equal(&emptyInv.Memo, "missing_memo"))
+	if err != nil {
+		t.Errorf("Failed to check existence: %s", err)
+		t.FailNow()
+	}
+	if exists {
+		t.Errorf("Expected no invoices with a missing memo")
+	}
+
 	count, err = dbmap.Query(emptyInv).
 		Where().
 		Equal(&emptyInv.IsPaid, true).
@@ -202,13 +376,41 @@ func TestQueryLanguage(t *testing.T) {
 		t.Errorf("Expected no paid invoices after deleting all paid invoices")
 		t.FailNow()
 	}
+
+	count, err = dbmap.Query(emptyInv).
+		AssignAdd(&emptyInv.Created, 5).
+		AssignSub(&emptyInv.Updated, 1).
+		Where().
+		Equal(&emptyInv.Id, "1").
+		Update()
+	if err != nil {
+		t.Errorf("Failed to increment: %s", err)
+		t.FailNow()
+	}
+	if count != 1 {
+		t.Errorf("Expected to increment one invoice")
+		t.FailNow()
+	}
+
+	invTest, err = dbmap.Query(emptyInv).
+		Where().
+		Equal(&emptyInv.Created, 6).
+		Equal(&emptyInv.Updated, 0).
+		Select()
+	if err != nil {
+		t.Errorf("Failed to select: %s", err)
+		t.FailNow()
+	}
+	if len(invTest) != 1 {
+		t.Errorf("Expected one invoice after incrementing")
+		t.FailNow()
+	}
 }
 
-func BenchmarkSqlQuerySelect(b *testing.B) {
-	b.StopTimer()
+func TestCompareResults(t *testing.T) {
 	dbmap := newDbMap()
 	dbmap.Exec("drop table if exists OverriddenInvoice")
-	dbmap.TraceOff()
+	dbmap.TraceOn("", log.New(os.Stdout, "gorptest: ", log.Lmicroseconds))
 	dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
 	err := dbmap.CreateTablesIfNotExists()
 	if err != nil {
@@ -216,65 +418,4239 @@ func BenchmarkSqlQuerySelect(b *testing.B) {
 	}
 	defer dropAndClose(dbmap)
 
-	inv := &OverriddenInvoice{
-		Id: "1",
-		Invoice: Invoice{
-			Created:  1,
-			Updated:  1,
-			Memo:     "test_memo",
-			PersonId: 1,
-			IsPaid:   false,
-		},
+	for _, id := range []string{"1", "2"} {
+		inv := &OverriddenInvoice{Id: id, Invoice: Invoice{Memo: "test_memo"}}
+		if err = dbmap.Insert(inv); err != nil {
+			t.Fatalf("Failed to insert: %s", err)
+		}
 	}
-	err = dbmap.Insert(inv)
+
+	tx, err := dbmap.Begin()
 	if err != nil {
-		panic(err)
+		t.Fatalf("Failed to begin: %s", err)
 	}
-	b.StartTimer()
-	for i := 0; i < b.N; i++ {
-		q := "SELECT * FROM overriddeninvoice WHERE memo = $1"
-		_, err = dbmap.Select(inv, q, "test_memo")
+	defer tx.Rollback()
+	changed := &OverriddenInvoice{Id: "2", Invoice: Invoice{Memo: "changed_memo"}}
+	if _, err = tx.Update(changed); err != nil {
+		t.Fatalf("Failed to update: %s", err)
+	}
+
+	ref := new(OverriddenInvoice)
+	diff, err := CompareResults(dbmap.Query(ref).Where(), tx, tx)
+	if err != nil {
+		t.Fatalf("Failed to compare: %s", err)
+	}
+	if !diff.Equal() {
+		t.Errorf("Expected no differences when comparing an executor to itself")
+	}
+
+	ref = new(OverriddenInvoice)
+	diff, err = CompareResults(dbmap.Query(ref).Where(), tx, dbmap)
+	if err != nil {
+		t.Fatalf("Failed to compare: %s", err)
+	}
+	if len(diff.Changed) != 1 || len(diff.Missing) != 0 || len(diff.Extra) != 0 {
+		t.Errorf("Expected exactly one changed row, got %#v", diff)
+	}
+}
+
+func TestRowChecksum(t *testing.T) {
+	type Event struct {
+		Id    *int64
+		Note  *string
+		Label sql.NullString
+		At    time.Time
+	}
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTable(Event{})
+	id1, id2 := int64(1), int64(1)
+	note1, note2 := "a", "a"
+	at := time.Date(2014, 1, 1, 12, 0, 0, 0, time.UTC)
+	left := &Event{Id: &id1, Note: &note1, Label: sql.NullString{String: "x", Valid: true}, At: at}
+	right := &Event{Id: &id2, Note: &note2, Label: sql.NullString{String: "x", Valid: true}, At: at.In(time.FixedZone("EST", -5*3600))}
+	leftSum, err := dbmap.RowChecksum(left)
+	if err != nil {
+		t.Fatalf("Failed to checksum: %s", err)
+	}
+	rightSum, err := dbmap.RowChecksum(right)
+	if err != nil {
+		t.Fatalf("Failed to checksum: %s", err)
+	}
+	if leftSum != rightSum {
+		t.Errorf("Expected equal rows with different pointers and time zones to have equal checksums")
+	}
+
+	right.Label = sql.NullString{String: "x"}
+	if rightSum, _ = dbmap.RowChecksum(right); leftSum == rightSum {
+		t.Errorf("Expected a null value to change the checksum")
+	}
+	right.Label, right.Note = left.Label, nil
+	if rightSum, _ = dbmap.RowChecksum(right); leftSum == rightSum {
+		t.Errorf("Expected a nil pointer to change the checksum")
+	}
+}
+
+func TestSelectQueryCache(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
+
+	var queries []string
+	for _, memo := range []string{"first", "second"} {
+		inv := new(OverriddenInvoice)
+		plan := dbmap.Query(inv).Where().Equal(&inv.Memo, memo).Limit(5).(*QueryPlan)
+		query, err := plan.selectQuery()
 		if err != nil {
-			panic(err)
+			t.Fatalf("Failed to generate select: %s", err)
+		}
+		if len(plan.args) != 2 || plan.args[0] != memo || plan.args[1] != int64(5) {
+			t.Errorf("Expected args [%s 5], got %v", memo, plan.args)
 		}
+		queries = append(queries, query)
+	}
+	if queries[0] != queries[1] {
+		t.Errorf("Expected identical plans to generate identical SQL: %q != %q", queries[0], queries[1])
+	}
+	table, _ := dbmap.tableFor(reflect.TypeOf(OverriddenInvoice{}), false)
+	if len(table.queryCache.queries) != 1 {
+		t.Errorf("Expected one cached query, got %d", len(table.queryCache.queries))
+	}
+
+	inv := new(OverriddenInvoice)
+	query, err := dbmap.Query(inv).Where().NotEqual(&inv.Memo, "first").Limit(5).(*QueryPlan).selectQuery()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	if query == queries[0] {
+		t.Errorf("Expected a different filter to generate different SQL")
 	}
 }
 
-func BenchmarkGorpQuerySelect(b *testing.B) {
-	b.StopTimer()
-	dbmap := newDbMap()
-	dbmap.Exec("drop table if exists OverriddenInvoice")
-	dbmap.TraceOff()
+func TestSQL(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
 	dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
-	err := dbmap.CreateTablesIfNotExists()
+	inv := new(OverriddenInvoice)
+
+	query, args, err := dbmap.Query(inv).Where().Equal(&inv.Memo, "memo").SQL()
 	if err != nil {
-		panic(err)
+		t.Fatalf("Failed to generate select: %s", err)
 	}
-	defer dropAndClose(dbmap)
-
-	inv := &OverriddenInvoice{
-		Id: "1",
-		Invoice: Invoice{
-			Created:  1,
-			Updated:  1,
-			Memo:     "test_memo",
-			PersonId: 1,
-			IsPaid:   false,
-		},
+	if !strings.HasPrefix(query, "select ") || !strings.HasSuffix(query, ` where "OverriddenInvoice"."Memo"=?`) {
+		t.Errorf("Unexpected select: %q", query)
 	}
-	err = dbmap.Insert(inv)
+	if len(args) != 1 || args[0] != "memo" {
+		t.Errorf("Expected args [memo], got %v", args)
+	}
+
+	query, args, err = dbmap.Query(inv).Assign(&inv.Id, "1").Assign(&inv.Memo, "memo").SQL()
 	if err != nil {
-		panic(err)
+		t.Fatalf("Failed to generate insert: %s", err)
 	}
-	b.StartTimer()
-	for i := 0; i < b.N; i++ {
-		t := new(OverriddenInvoice)
-		_, err := dbmap.Query(t).
-			Where().
-			Equal(&t.Memo, "test_memo").
-			Select()
+	if query != `insert into "OverriddenInvoice" ("Id", "Memo") values (?, ?)` {
+		t.Errorf("Unexpected insert: %q", query)
+	}
+	if len(args) != 2 || args[0] != "1" || args[1] != "memo" {
+		t.Errorf("Expected args [1 memo], got %v", args)
+	}
+
+	update := dbmap.Query(inv).Assign(&inv.Memo, "paid").Where().Equal(&inv.Id, "1")
+	for i := 0; i < 2; i++ {
+		query, args, err = update.SQL()
 		if err != nil {
-			panic(err)
+			t.Fatalf("Failed to generate update: %s", err)
+		}
+		if query != `update "OverriddenInvoice" set "Memo"=? where "OverriddenInvoice"."Id"=?` {
+			t.Errorf("Unexpected update: %q", query)
+		}
+		if len(args) != 2 || args[0] != "paid" || args[1] != "1" {
+			t.Errorf("Expected args [paid 1], got %v", args)
 		}
 	}
 }
+
+func TestNullsOrder(t *testing.T) {
+	tests := []struct {
+		dialect  Dialect
+		order    func(inv *Invoice) Order
+		expected string
+	}{
+		{PostgresDialect{}, func(inv *Invoice) Order { return Desc(&inv.Created).NullsLast() },
+			` order by "invoice"."created" desc nulls last, "invoice"."id"`},
+		{PostgresDialect{}, func(inv *Invoice) Order { return Asc(&inv.Created).NullsFirst() },
+			` order by "invoice"."created" asc nulls first, "invoice"."id"`},
+		{SqliteDialect{}, func(inv *Invoice) Order { return Desc(&inv.Created).NullsLast() },
+			` order by case when "invoice"."Created" is null then 1 else 0 end, "invoice"."Created" desc, "invoice"."Id"`},
+		{SqliteDialect{}, func(inv *Invoice) Order { return Asc(&inv.Created).NullsFirst() },
+			` order by case when "invoice"."Created" is null then 0 else 1 end, "invoice"."Created" asc, "invoice"."Id"`},
+	}
+	for _, test := range tests {
+		dbmap := &DbMap{Dialect: test.dialect}
+		dbmap.AddTableWithName(Invoice{}, "invoice").SetKeys(true, "Id")
+		inv := new(Invoice)
+		query, _, err := dbmap.Query(inv).OrderBy(test.order(inv), &inv.Id).SQL()
+		if err != nil {
+			t.Fatalf("Failed to generate select: %s", err)
+		}
+		if !strings.HasSuffix(query, test.expected) {
+			t.Errorf("Expected %T query to end with %q, got %q", test.dialect, test.expected, query)
+		}
+	}
+}
+
+func TestPlanDefinition(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
+	inv := new(OverriddenInvoice)
+
+	original := dbmap.Query(inv).
+		Where().
+		Equal(&inv.IsPaid, false).
+		Filter(Or(EqualFold(&inv.Memo, "memo"), Not(Less(&inv.Created, int64(5))), Null(&inv.PersonId))).
+		OrderBy(Desc(&inv.Created).NullsLast(), &inv.Id).
+		Limit(10)
+	def, err := original.Definition()
+	if err != nil {
+		t.Fatalf("Failed to define plan: %s", err)
+	}
+	encoded, err := json.Marshal(def)
+	if err != nil {
+		t.Fatalf("Failed to encode definition: %s", err)
+	}
+	decoded := new(PlanDefinition)
+	if err = json.Unmarshal(encoded, decoded); err != nil {
+		t.Fatalf("Failed to decode definition: %s", err)
+	}
+
+	expectedQuery, expectedArgs, err := original.SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate original SQL: %s", err)
+	}
+	query, args, err := dbmap.QueryDefinition(new(OverriddenInvoice), decoded).SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate SQL from definition %s: %s", encoded, err)
+	}
+	if query != expectedQuery {
+		t.Errorf("Expected definition to generate %q, got %q", expectedQuery, query)
+	}
+	if !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("Expected definition args %#v, got %#v", expectedArgs, args)
+	}
+
+	for _, bad := range []string{
+		`{"where": {"op": "eq", "column": "Missing", "value": 1}}`,
+		`{"where": {"op": "eq", "column": "Created", "value": "not a number"}}`,
+		`{"where": {"op": "like", "column": "Memo", "value": "%"}}`,
+		`{"orderBy": [{"column": "Memo; drop table OverriddenInvoice"}]}`,
+	} {
+		def := new(PlanDefinition)
+		if err = json.Unmarshal([]byte(bad), def); err != nil {
+			t.Fatalf("Failed to decode definition: %s", err)
+		}
+		if _, _, err = dbmap.QueryDefinition(new(OverriddenInvoice), def).SQL(); err == nil {
+			t.Errorf("Expected an error for definition %s", bad)
+		}
+	}
+
+	for op, comparison := range map[string]string{"gt": ">", "ge": ">="} {
+		def := &PlanDefinition{Where: &FilterDefinition{Op: op, Column: "Created", Value: json.RawMessage("5")}}
+		if query, _, err = dbmap.QueryDefinition(new(OverriddenInvoice), def).SQL(); err != nil || !strings.HasSuffix(query, `."Created"`+comparison+`?`) {
+			t.Errorf("Expected %s to compare with %s, got %q (%v)", op, comparison, query, err)
+		}
+	}
+}
+
+func TestHints(t *testing.T) {
+	dbmap := &DbMap{Dialect: MySQLDialect{}}
+	dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
+	inv := new(OverriddenInvoice)
+	query, _, err := dbmap.Query(inv).
+		Where().
+		Equal(&inv.PersonId, 1).
+		Hint("NO_RANGE_OPTIMIZATION(OverriddenInvoice)").
+		UseIndex("person_idx").
+		SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	if !strings.HasPrefix(query, "select /*+ NO_RANGE_OPTIMIZATION(OverriddenInvoice) */ `OverriddenInvoice`.`Id`") {
+		t.Errorf("Expected an optimizer hint comment, got %q", query)
+	}
+	if !strings.Contains(query, " from `OverriddenInvoice` use index (`person_idx`) where ") {
+		t.Errorf("Expected an index hint, got %q", query)
+	}
+	if _, _, err = dbmap.Query(inv).Where().Hint("*/ drop table x; /*").SQL(); err == nil {
+		t.Errorf("Expected an error for a hint that ends the comment")
+	}
+
+	dbmap = &DbMap{Dialect: SqliteDialect{}}
+	dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
+	if _, _, err = dbmap.Query(inv).Where().UseIndex("person_idx").SQL(); err == nil {
+		t.Errorf("Expected an error for a dialect without index hints")
+	}
+}
+
+func TestComment(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTable(Invoice{}).SetKeys(true, "Id")
+	inv := new(Invoice)
+	query, _, err := dbmap.Query(inv).Comment("service=billing, handler=ListInvoices").Where().Equal(&inv.PersonId, 1).SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	if !strings.HasSuffix(query, `"invoice"."personid"=$1 /*service=billing, handler=ListInvoices*/`) {
+		t.Errorf("Expected the comment at the end of the select, got %q", query)
+	}
+	query, _, err = dbmap.Query(inv).Where().Equal(&inv.PersonId, 1).SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	if strings.Contains(query, "/*") {
+		t.Errorf("Expected the cached statement without a comment to be used, got %q", query)
+	}
+	query, _, err = dbmap.Query(inv).Comment("request=2").Where().Equal(&inv.PersonId, 1).SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	if !strings.HasSuffix(query, `"invoice"."personid"=$1 /*request=2*/`) {
+		t.Errorf("Expected the cached statement with the plan's own comment, got %q", query)
+	}
+	table, _ := dbmap.tableFor(reflect.TypeOf(Invoice{}), false)
+	if cached := len(table.queryCache.queries); cached != 1 {
+		t.Errorf("Expected comments to share a cached statement, got %d statements", cached)
+	}
+
+	plan := dbmap.Query(inv).Comment("x*/; drop table invoice; /**/*/").(*QueryPlan)
+	plan.Assign(&inv.Memo, "m").Where().Equal(&inv.Id, 1)
+	query, err = plan.updateQuery()
+	if err != nil {
+		t.Fatalf("Failed to generate update: %s", err)
+	}
+	if !strings.HasSuffix(query, ` /*x; drop table invoice;*/`) {
+		t.Errorf("Expected a sanitized comment at the end of the update, got %q", query)
+	}
+}
+
+func TestClickHouseDialect(t *testing.T) {
+	dialect := ClickHouseDialect{}
+	if sqlType := dialect.ToSqlType(reflect.TypeOf((*int64)(nil)), 0, false); sqlType != "Nullable(Int64)" {
+		t.Errorf("Expected Nullable(Int64) for *int64, got %s", sqlType)
+	}
+	if suffix := dialect.CreateTableSuffix(); suffix != " engine = MergeTree() order by tuple()" {
+		t.Errorf("Expected the default engine, got %q", suffix)
+	}
+
+	dbmap := &DbMap{Dialect: dialect}
+	dbmap.AddTableWithNameAndSchema(Invoice{}, "analytics", "invoice")
+	inv := new(Invoice)
+	query, args, err := dbmap.Query(inv).
+		Where().
+		Equal(&inv.IsPaid, true).
+		OrderBy(&inv.PersonId, Asc(&inv.Created)).
+		LimitBy(3, &inv.PersonId).
+		Limit(100).
+		SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	expected := " from `analytics`.`invoice` where `analytics`.`invoice`.`IsPaid`=? order by `analytics`.`invoice`.`PersonId`, `analytics`.`invoice`.`Created` asc limit 3 by `analytics`.`invoice`.`PersonId` limit ?"
+	if !strings.HasSuffix(query, expected) {
+		t.Errorf("Expected query to end with %q, got %q", expected, query)
+	}
+	if len(args) != 2 || args[1] != int64(100) {
+		t.Errorf("Expected args [true 100], got %v", args)
+	}
+
+	if _, err = dbmap.Query(inv).Assign(&inv.Memo, "m").Where().Equal(&inv.Id, 1).Update(); err != ErrAppendOnly {
+		t.Errorf("Expected ErrAppendOnly for an update, got %v", err)
+	}
+	if _, err = dbmap.Delete(&Invoice{Id: 1}); err != ErrAppendOnly {
+		t.Errorf("Expected ErrAppendOnly for a delete, got %v", err)
+	}
+
+	dbmap = &DbMap{Dialect: SqliteDialect{}}
+	dbmap.AddTable(Invoice{})
+	if _, _, err = dbmap.Query(inv).LimitBy(3, &inv.PersonId).SQL(); err == nil {
+		t.Errorf("Expected an error for a dialect without limit by")
+	}
+}
+
+// plainDialect only implements the methods of the Dialect interface,
+// like a dialect written before LimitClauser existed.
+type plainDialect struct {
+	Dialect
+}
+
+func TestLimitClause(t *testing.T) {
+	if _, ok := Dialect(plainDialect{SqliteDialect{}}).(LimitClauser); ok {
+		t.Fatalf("Expected plainDialect not to implement LimitClauser")
+	}
+	tests := []struct {
+		dialect       Dialect
+		limit, offset int64
+		expected      string
+	}{
+		{SqliteDialect{}, 10, 0, " limit ?"},
+		{SqliteDialect{}, 10, 20, " limit ? offset ?"},
+		{SqliteDialect{}, 0, 20, " limit -1 offset ?"},
+		{MySQLDialect{}, 0, 20, " limit 18446744073709551615 offset ?"},
+		{PostgresDialect{}, 10, 20, " limit $1 offset $2"},
+		{PostgresDialect{}, 0, 20, " offset $1"},
+		{PostgresDialect{}, 0, 0, ""},
+		{plainDialect{SqliteDialect{}}, 10, 20, " limit ? offset ?"},
+		{plainDialect{SqliteDialect{}}, 0, 20, " offset ?"},
+	}
+	for _, test := range tests {
+		dbmap := &DbMap{Dialect: test.dialect}
+		dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
+		inv := new(OverriddenInvoice)
+		query, _, err := dbmap.Query(inv).Where().Limit(test.limit).Offset(test.offset).SQL()
+		if err != nil {
+			t.Fatalf("Failed to generate select: %s", err)
+		}
+		if !strings.HasSuffix(query, " from "+test.dialect.QuotedTableForQuery("", "OverriddenInvoice")+test.expected) {
+			t.Errorf("%T: Expected limit %d and offset %d to generate %q, got %q", test.dialect, test.limit, test.offset, test.expected, query)
+		}
+	}
+}
+
+func TestPage(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
+	inv := new(OverriddenInvoice)
+	query, args, err := dbmap.Query(inv).Where().Page(3, 20).SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	if !strings.HasSuffix(query, " limit $1 offset $2") || len(args) != 2 || args[0] != int64(20) || args[1] != int64(40) {
+		t.Errorf("Expected limit 20 and offset 40, got %q with %v", query, args)
+	}
+	if _, _, err = dbmap.Query(inv).Where().Page(0, 20).SQL(); err == nil {
+		t.Errorf("Expected an error for page 0")
+	}
+	if _, _, err = dbmap.Query(inv).Where().Page(1, 0).SQL(); err == nil {
+		t.Errorf("Expected an error for an empty page")
+	}
+}
+
+func TestTermFilters(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	table := dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
+	table.ColMap("Memo").SetFilterable(true)
+	table.ColMap("Created").SetFilterable(true)
+	inv := new(OverriddenInvoice)
+
+	var terms []FilterTerm
+	err := json.Unmarshal([]byte(`[{"field": "memo", "op": "eqFold", "value": "MEMO"}, {"field": "Created", "op": "ge", "value": 5}]`), &terms)
+	if err != nil {
+		t.Fatalf("Failed to decode terms: %s", err)
+	}
+	filters, err := dbmap.TermFilters(inv, terms...)
+	if err != nil {
+		t.Fatalf("Failed to convert terms: %s", err)
+	}
+	query, args, err := dbmap.Query(inv).Where(filters...).SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	if !strings.HasSuffix(query, ` where ("OverriddenInvoice"."Memo"=? collate nocase and "OverriddenInvoice"."Created">=?)`) {
+		t.Errorf("Unexpected select for terms: %q", query)
+	}
+	if len(args) != 2 || args[0] != "MEMO" || args[1] != int64(5) {
+		t.Errorf("Expected args [MEMO 5], got %#v", args)
+	}
+
+	for _, bad := range []FilterTerm{
+		{Field: "IsPaid", Op: "eq", Value: json.RawMessage("true")},
+		{Field: "Memo", Op: "like", Value: json.RawMessage(`"%"`)},
+		{Field: "Created", Op: "eq", Value: json.RawMessage(`"5; drop table OverriddenInvoice"`)},
+	} {
+		if _, err = dbmap.TermFilters(inv, bad); err == nil {
+			t.Errorf("Expected an error for term %+v", bad)
+		}
+	}
+}
+
+func TestRequireWhereForMutations(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
+	dbmap.RequireWhereForMutations(true)
+	inv := new(OverriddenInvoice)
+
+	if _, err := dbmap.Query(inv).Delete(); err != ErrMissingWhere {
+		t.Errorf("Expected ErrMissingWhere for a delete without a where clause, got %v", err)
+	}
+	if _, err := dbmap.Query(inv).Where().Delete(); err != ErrMissingWhere {
+		t.Errorf("Expected ErrMissingWhere for a delete with an empty where clause, got %v", err)
+	}
+	if _, err := dbmap.Query(inv).Assign(&inv.IsPaid, true).Update(); err != ErrMissingWhere {
+		t.Errorf("Expected ErrMissingWhere for an update without a where clause, got %v", err)
+	}
+
+	plans := []*QueryPlan{
+		dbmap.Query(inv).Where().Equal(&inv.Id, "1").(*QueryPlan),
+		dbmap.Query(inv).AllRows().(*QueryPlan),
+		dbmap.Query(inv).Assign(&inv.IsPaid, true).AllRows().(*AssignQueryPlan).QueryPlan,
+	}
+	for i, plan := range plans {
+		if err := plan.checkScoped(); err != nil {
+			t.Errorf("Expected plan %d to be allowed, got %s", i, err)
+		}
+	}
+}
+
+func TestColumnAccess(t *testing.T) {
+	type roleKey struct{}
+	isAdmin := func(ctx context.Context) bool {
+		return ctx.Value(roleKey{}) == "admin"
+	}
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	table := dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
+	table.ColMap("Memo").SetReadAccess(isAdmin)
+	table.ColMap("IsPaid").SetWriteAccess(isAdmin)
+	inv := new(OverriddenInvoice)
+
+	admin := context.WithValue(context.Background(), roleKey{}, "admin")
+	query, _, err := dbmap.QueryContext(admin, inv).Where().Equal(&inv.Id, "1").SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	if !strings.Contains(query, `"Memo"`) {
+		t.Errorf("Expected an admin's select to include Memo, got %q", query)
+	}
+	query, _, err = dbmap.Query(inv).Where().Equal(&inv.Id, "1").SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	if strings.Contains(query, `"Memo"`) {
+		t.Errorf("Expected Memo to be left out of the select, got %q", query)
+	}
+	var memos []string
+	if err = dbmap.Query(inv).Where().SelectColumn(&inv.Memo, &memos); err == nil {
+		t.Errorf("Expected an error selecting an unreadable column")
+	}
+	if _, _, err = dbmap.Query(inv).Where().Equal(&inv.Memo, "secret").SQL(); err == nil {
+		t.Errorf("Expected an error filtering on an unreadable column")
+	}
+	if _, _, err = dbmap.Query(inv).Where().Filter(Or(Equal(&inv.Id, "1"), Not(Null(&inv.Memo)))).SQL(); err == nil {
+		t.Errorf("Expected an error filtering on an unreadable column in a nested filter")
+	}
+	if _, _, err = dbmap.Query(inv).Where().OrderBy(&inv.Memo).SQL(); err == nil {
+		t.Errorf("Expected an error ordering by an unreadable column")
+	}
+	if _, _, err = dbmap.Query(inv).Where().GroupBy(&inv.Memo).SQL(); err == nil {
+		t.Errorf("Expected an error grouping by an unreadable column")
+	}
+	if _, _, err = dbmap.Query(inv).Where().GroupBy(Func("lower", &inv.Memo)).SQL(); err == nil {
+		t.Errorf("Expected an error grouping by an expression on an unreadable column")
+	}
+	if _, _, err = dbmap.QueryContext(admin, inv).Where().Equal(&inv.Memo, "secret").OrderBy(Desc(&inv.Memo)).SQL(); err != nil {
+		t.Errorf("Expected an admin to be allowed to filter and order by Memo, got %s", err)
+	}
+	lowerMemo := []ResultColumn{As("Memo", Func("lower", &inv.Memo))}
+	if _, _, err = dbmap.Query(inv).(*QueryPlan).resultsQuery(lowerMemo); err == nil {
+		t.Errorf("Expected an error selecting an expression on an unreadable column")
+	}
+	if _, _, err = dbmap.Query(inv).(*QueryPlan).resultsQuery([]ResultColumn{As("Memo", &inv.Memo)}); err == nil {
+		t.Errorf("Expected an error selecting an unreadable column")
+	} else if _, denied := err.(readAccessError); !denied {
+		t.Errorf("Expected a read access error, got %s", err)
+	}
+	if _, _, err = dbmap.QueryContext(admin, inv).(*QueryPlan).resultsQuery(lowerMemo); err != nil {
+		t.Errorf("Expected an admin to be allowed to select an expression on Memo, got %s", err)
+	}
+
+	if _, _, err = dbmap.QueryContext(admin, inv).Assign(&inv.IsPaid, true).Where().Equal(&inv.Id, "1").SQL(); err != nil {
+		t.Errorf("Expected an admin to be allowed to assign IsPaid, got %s", err)
+	}
+	if _, _, err = dbmap.Query(inv).Assign(&inv.IsPaid, true).Where().Equal(&inv.Id, "1").SQL(); err == nil {
+		t.Errorf("Expected an error assigning an unwritable column")
+	}
+	if _, _, err = dbmap.Query(inv).Assign(&inv.Id, Col(&inv.Memo)).Where().Equal(&inv.Id, "1").SQL(); err == nil {
+		t.Errorf("Expected an error assigning an expression on an unreadable column")
+	}
+	if _, _, err = dbmap.QueryContext(admin, inv).Assign(&inv.Id, Col(&inv.Memo)).Where().Equal(&inv.Id, "1").SQL(); err != nil {
+		t.Errorf("Expected an admin to be allowed to assign an expression on Memo, got %s", err)
+	}
+}
+
+func TestMasking(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	table := dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
+	table.ColMap("Memo").SetMask(MaskLast(4), "billing")
+	table.ColMap("PersonId").SetMask(MaskNull)
+	inv := new(OverriddenInvoice)
+
+	billing := WithRole(context.Background(), "billing")
+	masked := dbmap.QueryContext(billing, inv).(*QueryPlan).maskedColumns()
+	if len(masked) != 1 || masked[0].ColumnName != "PersonId" {
+		t.Errorf("Expected only PersonId to be masked for billing, got %v", masked)
+	}
+
+	masked = dbmap.Query(inv).(*QueryPlan).maskedColumns()
+	row := &OverriddenInvoice{Id: "1", Invoice: Invoice{Memo: "4111111111111111", PersonId: 3}}
+	maskRow(masked, reflect.ValueOf(row))
+	if row.Memo != "************1111" || row.PersonId != 0 || row.Id != "1" {
+		t.Errorf("Expected Memo and PersonId to be masked, got %+v", row)
+	}
+	if value := maskValue(masked[0], []byte("1234567")); string(value.([]byte)) != "***4567" {
+		t.Errorf("Expected a masked driver value, got %q", value)
+	}
+
+	lowerMemo := []ResultColumn{As("Memo", Func("lower", &inv.Memo))}
+	if _, _, err := dbmap.Query(inv).(*QueryPlan).resultsQuery(lowerMemo); err == nil {
+		t.Errorf("Expected an error selecting an expression on a masked column")
+	}
+	if _, _, err := dbmap.QueryContext(billing, inv).(*QueryPlan).resultsQuery(lowerMemo); err != nil {
+		t.Errorf("Expected billing to be allowed to select an expression on Memo, got %s", err)
+	}
+
+	hashed := "secret"
+	MaskHash(reflect.ValueOf(&hashed).Elem())
+	if len(hashed) != 64 || hashed == "secret" {
+		t.Errorf("Expected a SHA-256 hash, got %q", hashed)
+	}
+}
+
+// MaskedCard records the card number that its PostGet hook saw.
+type MaskedCard struct {
+	Id     int64
+	Number string
+	Seen   string `db:"-"`
+}
+
+func (c *MaskedCard) PostGet(SqlExecutor) error {
+	c.Seen = c.Number
+	return nil
+}
+
+func TestMaskedMemo(t *testing.T) {
+	db, err := sql.Open("gorp_fake_test", "")
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	defer db.Close()
+	dbmap := &DbMap{Db: db, Dialect: SqliteDialect{}}
+	dbmap.AddTable(MaskedCard{}).SetKeys(true, "Id").ColMap("Number").SetMask(MaskLast(4), "billing")
+	card := new(MaskedCard)
+	fakeDriver.reset()
+	defer fakeDriver.reset()
+	fakeDriver.returnRows([]string{"Id", "Number"}, []driver.Value{int64(1), "4111111111111111"})
+
+	ctx := WithMemo(context.Background())
+	results, err := dbmap.QueryContext(ctx, card).Where().Equal(&card.Id, 1).Select()
+	if err != nil {
+		t.Fatalf("Failed to select: %s", err)
+	}
+	if row := results[0].(*MaskedCard); row.Number != "************1111" || row.Seen != row.Number {
+		t.Errorf("Expected a masked number before PostGet, got %+v", row)
+	}
+	results, err = dbmap.QueryContext(WithRole(ctx, "billing"), card).Where().Equal(&card.Id, 1).Select()
+	if err != nil {
+		t.Fatalf("Failed to select: %s", err)
+	}
+	if row := results[0].(*MaskedCard); row.Number != "4111111111111111" {
+		t.Errorf("Expected billing not to get the memoized masked row, got %+v", row)
+	}
+	if statements := fakeDriver.reset(); len(statements) != 2 {
+		t.Errorf("Expected each role to run its own select, got %v", statements)
+	}
+	results[0].(*MaskedCard).Number = "changed"
+	results, err = dbmap.QueryContext(WithRole(ctx, "billing"), card).Where().Equal(&card.Id, 1).Select()
+	if err != nil {
+		t.Fatalf("Failed to select: %s", err)
+	}
+	if row := results[0].(*MaskedCard); row.Number != "4111111111111111" {
+		t.Errorf("Expected a copy of the memoized row, got %+v", row)
+	}
+	if statements := fakeDriver.reset(); len(statements) != 0 {
+		t.Errorf("Expected the memoized rows, got %v", statements)
+	}
+
+	fakeDriver.returnRows([]string{"Id", "Number"}, []driver.Value{int64(1), "4111111111111111"})
+	err = dbmap.Query(card).SelectEach(func(row interface{}) error {
+		if row := row.(*MaskedCard); row.Seen != "************1111" {
+			t.Errorf("Expected a masked number before PostGet, got %+v", row)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Failed to select: %s", err)
+	}
+}
+
+func TestQueryContextCancel(t *testing.T) {
+	db, err := sql.Open("gorp_fake_test", "1 10s")
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	defer db.Close()
+	dbmap := &DbMap{Db: db, Dialect: SqliteDialect{}}
+	dbmap.AddTable(MaskedCard{}).SetKeys(true, "Id")
+	card := new(MaskedCard)
+	defer fakeDriver.reset()
+
+	if plan := dbmap.QueryContext(context.Background(), card).(*QueryPlan); plan.executor != dbmap {
+		t.Errorf("Expected plans with a background context to run on the DbMap, got %T", plan.executor)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err = dbmap.QueryContext(ctx, card).Select(); err == nil {
+		t.Errorf("Expected the select to be canceled")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the select to stop at the deadline, took %s", elapsed)
+	}
+	if _, err = dbmap.QueryContext(ctx, card).Where().Equal(&card.Id, 1).Delete(); err == nil {
+		t.Errorf("Expected the delete to fail with the canceled context")
+	}
+}
+
+func TestProject(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	table := dbmap.AddTable(Invoice{}).SetKeys(true, "Id")
+	table.ColMap("Memo").SetMask(MaskLast(4), "billing")
+	table.ColMap("Created").SetJSONName("created_at")
+	table.ColMap("Updated").SetJSONName("-")
+	table.ColMap("IsPaid").SetReadAccess(func(ctx context.Context) bool { return RoleFromContext(ctx) == "billing" })
+
+	rows := []Invoice{{Id: 1, Created: 2, Updated: 3, Memo: "4111111111111111", PersonId: 4, IsPaid: true}}
+	projections, err := table.ProjectAll(context.Background(), rows)
+	if err != nil {
+		t.Fatalf("Failed to project: %s", err)
+	}
+	encoded, _ := json.Marshal(projections)
+	expected := `[{"Id":1,"Memo":"************1111","PersonId":4,"created_at":2}]`
+	if string(encoded) != expected {
+		t.Errorf("Expected %s, got %s", expected, encoded)
+	}
+	if rows[0].Memo != "4111111111111111" {
+		t.Errorf("Expected the row to be left unmasked, got %q", rows[0].Memo)
+	}
+
+	projection, err := table.Project(WithRole(context.Background(), "billing"), &rows[0])
+	if err != nil {
+		t.Fatalf("Failed to project: %s", err)
+	}
+	if projection["Memo"] != "4111111111111111" || projection["IsPaid"] != true {
+		t.Errorf("Expected billing to see Memo and IsPaid, got %v", projection)
+	}
+	if _, err = table.Project(context.Background(), Person{}); err == nil {
+		t.Errorf("Expected an error for a row of another type")
+	}
+}
+
+func TestRecordBatch(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	dbmap.AddTable(Invoice{}).SetKeys(true, "Id")
+	inv := new(Invoice)
+	plan := dbmap.Query(inv).OnlyFields("Memo").(*QueryPlan)
+	batch := newRecordBatch(plan.selectColumns(), 2)
+	batch.append(reflect.ValueOf(&Invoice{Id: 1, Memo: "a"}))
+	batch.append(reflect.ValueOf(&Invoice{Id: 2, Memo: "b"}))
+	if batch.Len != 2 || !reflect.DeepEqual(batch.Names, []string{"Id", "Memo"}) {
+		t.Fatalf("Expected 2 rows of Id and Memo, got %d rows of %v", batch.Len, batch.Names)
+	}
+	if ids, ok := batch.Columns[0].([]int64); !ok || !reflect.DeepEqual(ids, []int64{1, 2}) {
+		t.Errorf("Expected an []int64 of ids, got %#v", batch.Columns[0])
+	}
+	if memos, ok := batch.Columns[1].([]string); !ok || !reflect.DeepEqual(memos, []string{"a", "b"}) {
+		t.Errorf("Expected a []string of memos, got %#v", batch.Columns[1])
+	}
+	if err := plan.SelectBatches(0, nil); err == nil {
+		t.Errorf("Expected an error for an empty batch size")
+	}
+}
+
+// fbTestTable reads a flatbuffers table, to check the messages written
+// by ArrowWriter.
+type fbTestTable struct {
+	buf []byte
+	pos int
+}
+
+// field returns the position of the field with the given id, or -1 if
+// it is absent.
+func (t fbTestTable) field(id int) int {
+	vtable := t.pos - int(int32(binary.LittleEndian.Uint32(t.buf[t.pos:])))
+	if 4+2*id >= int(binary.LittleEndian.Uint16(t.buf[vtable:])) {
+		return -1
+	}
+	if offset := int(binary.LittleEndian.Uint16(t.buf[vtable+4+2*id:])); offset > 0 {
+		return t.pos + offset
+	}
+	return -1
+}
+
+func (t fbTestTable) uint(id, width int) uint64 {
+	pos := t.field(id)
+	if pos < 0 {
+		return 0
+	}
+	if pos%width != 0 {
+		panic(fmt.Sprintf("field %d at %d isn't aligned to %d bytes", id, pos, width))
+	}
+	word := make([]byte, 8)
+	copy(word, t.buf[pos:pos+width])
+	return binary.LittleEndian.Uint64(word)
+}
+
+func (t fbTestTable) ref(id int) int {
+	pos := t.field(id)
+	return pos + int(binary.LittleEndian.Uint32(t.buf[pos:]))
+}
+
+func (t fbTestTable) table(id int) fbTestTable {
+	return fbTestTable{t.buf, t.ref(id)}
+}
+
+// vector returns the position of the first element of a vector and
+// its length.
+func (t fbTestTable) vector(id int) (int, int) {
+	pos := t.ref(id)
+	return pos + 4, int(binary.LittleEndian.Uint32(t.buf[pos:]))
+}
+
+func (t fbTestTable) elem(id, i int) fbTestTable {
+	start, _ := t.vector(id)
+	pos := start + 4*i
+	return fbTestTable{t.buf, pos + int(binary.LittleEndian.Uint32(t.buf[pos:]))}
+}
+
+func (t fbTestTable) string(id int) string {
+	start, n := t.vector(id)
+	return string(t.buf[start : start+n])
+}
+
+// arrowTestMessage is a message read from an Arrow IPC stream.
+type arrowTestMessage struct {
+	headerType uint64
+	header     fbTestTable
+	body       []byte
+}
+
+// readArrowStream splits an Arrow IPC stream into its messages.
+func readArrowStream(t *testing.T, data []byte) []arrowTestMessage {
+	var messages []arrowTestMessage
+	for len(data) >= 8 {
+		if binary.LittleEndian.Uint32(data) != 0xffffffff {
+			t.Fatalf("Expected a continuation marker, got %x", data[:4])
+		}
+		size := int(binary.LittleEndian.Uint32(data[4:]))
+		if size == 0 {
+			if len(data) != 8 {
+				t.Errorf("Expected the stream to end after the end marker")
+			}
+			return messages
+		}
+		if size%8 != 0 {
+			t.Fatalf("Expected metadata padded to 8 bytes, got %d", size)
+		}
+		meta := data[8 : 8+size]
+		message := fbTestTable{meta, int(binary.LittleEndian.Uint32(meta))}
+		if version := message.uint(0, 2); version != 4 {
+			t.Errorf("Expected metadata version V5, got %d", version)
+		}
+		bodyLength := int(message.uint(3, 8))
+		if bodyLength%8 != 0 {
+			t.Errorf("Expected a body padded to 8 bytes, got %d", bodyLength)
+		}
+		messages = append(messages, arrowTestMessage{
+			headerType: message.uint(1, 1),
+			header:     message.table(2),
+			body:       data[8+size : 8+size+bodyLength],
+		})
+		data = data[8+size+bodyLength:]
+	}
+	t.Fatalf("Expected an end marker")
+	return nil
+}
+
+func TestArrowWriter(t *testing.T) {
+	score := 1.5
+	batch := &RecordBatch{
+		Names: []string{"Id", "Small", "Score", "Ratio", "Paid", "Name", "Note", "Data", "When"},
+		Columns: []interface{}{
+			[]int64{1, 2},
+			[]int16{-3, 4},
+			[]*float64{&score, nil},
+			[]float32{0.5, 2},
+			[]bool{false, true},
+			[]string{"a", "bcd"},
+			[]sql.NullString{{}, {String: "x", Valid: true}},
+			[][]byte{{1, 2}, nil},
+			[]time.Time{time.Unix(1, 5000), {}},
+		},
+		Len: 2,
+	}
+	for _, column := range batch.Columns {
+		batch.Types = append(batch.Types, reflect.TypeOf(column).Elem())
+	}
+	var out bytes.Buffer
+	w := NewArrowWriter(&out)
+	if err := w.Write(batch); err != nil {
+		t.Fatalf("Failed to write: %s", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close: %s", err)
+	}
+
+	messages := readArrowStream(t, out.Bytes())
+	if len(messages) != 2 || messages[0].headerType != 1 || messages[1].headerType != 3 {
+		t.Fatalf("Expected a schema and a record batch, got %+v", messages)
+	}
+	schema := messages[0].header
+	if _, n := schema.vector(1); n != len(batch.Names) {
+		t.Fatalf("Expected %d fields, got %d", len(batch.Names), n)
+	}
+	types := []uint64{2, 2, 3, 3, 6, 5, 5, 4, 10}
+	for i, name := range batch.Names {
+		field := schema.elem(1, i)
+		nullable := name == "Score" || name == "Note"
+		if field.string(0) != name || (field.uint(1, 1) == 1) != nullable || field.uint(2, 1) != types[i] {
+			t.Errorf("Expected field %s of type %d, got %s of type %d", name, types[i], field.string(0), field.uint(2, 1))
+		}
+		if _, n := field.vector(5); n != 0 {
+			t.Errorf("Expected field %s to have no children, got %d", name, n)
+		}
+	}
+	if small := schema.elem(1, 1).table(3); small.uint(0, 4) != 16 || small.uint(1, 1) != 1 {
+		t.Errorf("Expected Small to be a signed 16-bit int")
+	}
+	if ratio := schema.elem(1, 3).table(3); ratio.uint(0, 2) != 1 {
+		t.Errorf("Expected Ratio to be a single precision float")
+	}
+	if when := schema.elem(1, 8).table(3); when.uint(0, 2) != 2 || when.string(1) != "UTC" {
+		t.Errorf("Expected When to be a UTC timestamp in microseconds")
+	}
+
+	record, body := messages[1].header, messages[1].body
+	if record.uint(0, 8) != 2 {
+		t.Errorf("Expected 2 rows, got %d", record.uint(0, 8))
+	}
+	nodes, n := record.vector(1)
+	if n != len(batch.Names) || nodes%8 != 0 {
+		t.Fatalf("Expected %d aligned field nodes, got %d at %d", len(batch.Names), n, nodes)
+	}
+	for i, name := range batch.Names {
+		nulls := binary.LittleEndian.Uint64(record.buf[nodes+16*i+8:])
+		if expected := map[string]uint64{"Score": 1, "Note": 1}[name]; nulls != expected {
+			t.Errorf("Expected %d nulls in %s, got %d", expected, name, nulls)
+		}
+	}
+	buffers, n := record.vector(2)
+	if n != 21 {
+		t.Fatalf("Expected 21 buffers, got %d", n)
+	}
+	buffer := func(i int) []byte {
+		offset := binary.LittleEndian.Uint64(record.buf[buffers+16*i:])
+		length := binary.LittleEndian.Uint64(record.buf[buffers+16*i+8:])
+		if offset%8 != 0 {
+			t.Errorf("Expected buffer %d to be aligned, got offset %d", i, offset)
+		}
+		return body[offset : offset+length]
+	}
+	expected := map[int][]byte{
+		0:  {},
+		1:  {1, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0},
+		2:  {},
+		3:  {0xfd, 0xff, 4, 0},
+		4:  {1},
+		5:  {0, 0, 0, 0, 0, 0, 0xf8, 0x3f, 0, 0, 0, 0, 0, 0, 0, 0},
+		7:  {0, 0, 0, 0x3f, 0, 0, 0, 0x40},
+		9:  {2},
+		11: {0, 0, 0, 0, 1, 0, 0, 0, 4, 0, 0, 0},
+		12: []byte("abcd"),
+		13: {2},
+		14: {0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0},
+		15: []byte("x"),
+		17: {0, 0, 0, 0, 2, 0, 0, 0, 2, 0, 0, 0},
+		18: {1, 2},
+	}
+	for i, data := range expected {
+		if !bytes.Equal(buffer(i), data) {
+			t.Errorf("Expected buffer %d to hold %v, got %v", i, data, buffer(i))
+		}
+	}
+	if micros := int64(binary.LittleEndian.Uint64(buffer(20))); micros != 1000005 {
+		t.Errorf("Expected 1000005 microseconds, got %d", micros)
+	}
+
+	batch.Types[0] = reflect.TypeOf(struct{}{})
+	if err := NewArrowWriter(&out).Write(batch); err == nil {
+		t.Errorf("Expected an error for an unsupported column type")
+	}
+
+	db, err := sql.Open("gorp_fake_test", "")
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	defer db.Close()
+	dbmap := &DbMap{Db: db, Dialect: SqliteDialect{}}
+	dbmap.AddTable(Invoice{}).SetKeys(true, "Id")
+	fakeDriver.reset()
+	defer fakeDriver.reset()
+	out.Reset()
+	w = NewArrowWriter(&out)
+	if err = dbmap.Query(new(Invoice)).SelectBatches(10, w.Write); err != nil {
+		t.Fatalf("Failed to select: %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Failed to close: %s", err)
+	}
+	messages = readArrowStream(t, out.Bytes())
+	if len(messages) != 2 || messages[1].header.uint(0, 8) != 0 {
+		t.Errorf("Expected a schema and an empty batch for no rows, got %+v", messages)
+	}
+	if _, n := messages[0].header.vector(1); n != 6 {
+		t.Errorf("Expected the schema to have 6 fields, got %d", n)
+	}
+}
+
+func TestResultsQuery(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	table := dbmap.AddTable(Invoice{}).SetKeys(true, "Id")
+	table.ColMap("Memo").SetMask(MaskNull)
+	inv := new(Invoice)
+	plan := dbmap.Query(inv).(*QueryPlan)
+	plan.Where().Equal(&inv.IsPaid, false).GroupBy(&inv.PersonId)
+	query, masked, err := plan.resultsQuery([]ResultColumn{
+		As("PersonId", &inv.PersonId),
+		As("Invoices", Raw("count(*) + ?", 1)),
+		As("Latest", Func("max", &inv.Created)),
+		As("FirstMemo", &inv.Memo),
+	})
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	expected := `select "invoice"."personid" as "personid",count(*) + $1 as "invoices",max("invoice"."created") as "latest","invoice"."memo" as "firstmemo" from "invoice" where "invoice"."ispaid"=$2 group by "invoice"."personid"`
+	if query != expected {
+		t.Errorf("Expected %q, got %q", expected, query)
+	}
+	if len(plan.args) != 2 || plan.args[0] != 1 || plan.args[1] != false {
+		t.Errorf("Expected args [1 false], got %v", plan.args)
+	}
+	if len(masked) != 1 || masked["FirstMemo"] == nil {
+		t.Errorf("Expected only FirstMemo to be masked, got %v", masked)
+	}
+	if _, _, err = plan.resultsQuery(nil); err == nil {
+		t.Errorf("Expected an error without any columns")
+	}
+	if _, _, err = plan.resultsQuery([]ResultColumn{As("Memo", Func("max", &inv.Memo))}); err == nil {
+		t.Errorf("Expected an error for an expression on a masked column")
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		dialect  Dialect
+		options  []TruncateOption
+		expected string
+	}{
+		{SqliteDialect{}, nil, `delete from "OverriddenInvoice";`},
+		{PostgresDialect{}, nil, `truncate "overriddeninvoice";`},
+		{PostgresDialect{}, []TruncateOption{TruncateCascade, TruncateRestartIdentity}, `truncate "overriddeninvoice" restart identity cascade;`},
+		{MySQLDialect{}, []TruncateOption{TruncateRestartIdentity}, "truncate `OverriddenInvoice`;"},
+	}
+	for _, test := range tests {
+		dbmap := &DbMap{Dialect: test.dialect}
+		table := dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
+		query, err := table.truncateSql(test.options)
+		if err != nil {
+			t.Errorf("%T: Failed to generate truncate: %s", test.dialect, err)
+		} else if query != test.expected {
+			t.Errorf("%T: Expected %q, got %q", test.dialect, test.expected, query)
+		}
+	}
+
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	table := dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
+	if _, err := table.truncateSql([]TruncateOption{TruncateCascade}); err == nil {
+		t.Errorf("Expected an error for an unsupported truncate option")
+	}
+
+	table.SetSoftDelete("Updated")
+	if err := dbmap.Query(new(OverriddenInvoice)).Truncate(); err == nil {
+		t.Errorf("Expected an error truncating a soft-delete table")
+	}
+	person := dbmap.AddTable(Person{}).SetKeys(true, "Id")
+	person.AddDefaultScope("none", func(ctx context.Context, target interface{}) Filter { return nil })
+	if err := dbmap.Query(new(Person)).Truncate(); err == nil {
+		t.Errorf("Expected an error truncating a table with default scopes")
+	}
+	if err := dbmap.Query(new(Person)).WithDeleted().Truncate(); err == nil {
+		t.Errorf("Expected WithDeleted to keep the default scopes")
+	}
+}
+
+func TestTupleFilters(t *testing.T) {
+	inv := new(OverriddenInvoice)
+	tests := []struct {
+		dialect  Dialect
+		filter   Filter
+		expected string
+		args     int
+	}{
+		{PostgresDialect{}, TupleGreater([]interface{}{&inv.Created, &inv.Id}, []interface{}{int64(5), "a"}),
+			`("overriddeninvoice"."created", "overriddeninvoice"."id")>($1, $2)`, 2},
+		{SqliteDialect{}, TupleGreater([]interface{}{&inv.Created, &inv.Id}, []interface{}{int64(5), "a"}),
+			`("OverriddenInvoice"."Created">? or ("OverriddenInvoice"."Created"=? and "OverriddenInvoice"."Id">?))`, 3},
+		{SqliteDialect{}, TupleLessOrEqual([]interface{}{&inv.Created, &inv.Id}, []interface{}{int64(5), "a"}),
+			`("OverriddenInvoice"."Created"<? or ("OverriddenInvoice"."Created"=? and "OverriddenInvoice"."Id"<=?))`, 3},
+	}
+	for _, test := range tests {
+		dbmap := &DbMap{Dialect: test.dialect}
+		dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
+		plan := dbmap.Query(inv).(*QueryPlan)
+		where, args, err := test.filter.Where(plan.colMap, test.dialect, 0)
+		if err != nil {
+			t.Errorf("%T: Failed to generate tuple comparison: %s", test.dialect, err)
+			continue
+		}
+		if where != test.expected {
+			t.Errorf("%T: Expected %q, got %q", test.dialect, test.expected, where)
+		}
+		if len(args) != test.args {
+			t.Errorf("%T: Expected %d args, got %v", test.dialect, test.args, args)
+		}
+	}
+
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
+	plan := dbmap.Query(inv).(*QueryPlan)
+	if _, _, err := TupleGreater([]interface{}{&inv.Created}, nil).Where(plan.colMap, dbmap.Dialect, 0); err == nil {
+		t.Errorf("Expected an error for tuples of different lengths")
+	}
+}
+
+func TestArrayFilters(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
+	inv := new(OverriddenInvoice)
+	plan := dbmap.Query(inv).(*QueryPlan)
+	tests := []struct {
+		filter   Filter
+		expected string
+		arg      string
+	}{
+		{EqualAny(&inv.PersonId, []int64{1, 2}), `"overriddeninvoice"."personid" = ANY($1)`, `{1,2}`},
+		{ArrayHas(&inv.Memo, "go"), `$1 = ANY("overriddeninvoice"."memo")`, `go`},
+		{ArrayContains(&inv.Memo, []string{"go", `a "b"`}), `"overriddeninvoice"."memo" @> $1`, `{"go","a \"b\""}`},
+		{ArrayOverlaps(&inv.Memo, []*string{nil}), `"overriddeninvoice"."memo" && $1`, `{NULL}`},
+	}
+	for _, test := range tests {
+		where, args, err := test.filter.Where(plan.colMap, dbmap.Dialect, 0)
+		if err != nil {
+			t.Errorf("Failed to generate array filter: %s", err)
+			continue
+		}
+		if where != test.expected {
+			t.Errorf("Expected %q, got %q", test.expected, where)
+		}
+		arg := args[0]
+		if valuer, ok := arg.(driver.Valuer); ok {
+			if arg, err = valuer.Value(); err != nil {
+				t.Errorf("Failed to encode array: %s", err)
+			}
+		}
+		if arg != test.arg {
+			t.Errorf("Expected arg %q, got %q", test.arg, arg)
+		}
+	}
+
+	sqlite := &DbMap{Dialect: SqliteDialect{}}
+	sqlite.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
+	if _, _, err := sqlite.Query(inv).Where(ArrayHas(&inv.Memo, "go")).SQL(); err == nil {
+		t.Errorf("Expected an error for a dialect without arrays")
+	}
+}
+
+func TestStatementAuditor(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
+	var audited []string
+	refuse := errors.New("audit log unavailable")
+	dbmap.SetStatementAuditor(AuditFunc(func(query string, args []interface{}) error {
+		audited = append(audited, query)
+		return refuse
+	}))
+	inv := new(OverriddenInvoice)
+
+	// The auditor refuses every statement, so none of these reach the
+	// (missing) database.
+	if _, err := dbmap.Query(inv).Delete(); err == nil || !strings.Contains(err.Error(), refuse.Error()) {
+		t.Errorf("Expected the unscoped delete to fail auditing, got %v", err)
+	}
+	if _, err := dbmap.Query(inv).Assign(&inv.IsPaid, true).Update(); err == nil {
+		t.Errorf("Expected the unscoped update to fail auditing")
+	}
+	if err := dbmap.TruncateTable(inv); err == nil {
+		t.Errorf("Expected the truncate to fail auditing")
+	}
+	expected := []string{
+		`delete from "OverriddenInvoice"`,
+		`update "OverriddenInvoice" set "IsPaid"=?`,
+		`delete from "OverriddenInvoice";`,
+	}
+	if !reflect.DeepEqual(audited, expected) {
+		t.Errorf("Expected audited statements %q, got %q", expected, audited)
+	}
+
+	buffer := bytes.Buffer{}
+	if err := NewWriterAuditor(&buffer).AuditStatement("drop table x;", nil); err != nil {
+		t.Fatalf("Failed to audit statement: %s", err)
+	}
+	if !strings.HasSuffix(buffer.String(), "\tdrop table x;\t[]\n") {
+		t.Errorf("Expected an audit line for the statement, got %q", buffer.String())
+	}
+}
+
+func TestCreationOrder(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	dbmap.AddTable(Invoice{}).SetKeys(true, "Id").ColMap("PersonId").References(Person{})
+	dbmap.AddTable(Person{}).SetKeys(true, "Id")
+	tables, deferred, err := dbmap.creationOrder()
+	if err != nil {
+		t.Fatalf("Failed to order tables: %s", err)
+	}
+	if len(tables) != 2 || tables[0].TableName != "Person" || tables[1].TableName != "Invoice" || len(deferred) != 0 {
+		t.Errorf("Expected Person to be created before Invoice, got %v (deferred %v)", tables, deferred)
+	}
+	fks, err := tables[1].foreignKeys()
+	if err != nil || len(fks) != 1 {
+		t.Fatalf("Expected one foreign key, got %v (%v)", fks, err)
+	}
+	if clause := fks[0].clause(dbmap.Dialect); clause != `, foreign key ("PersonId") references "Person" ("Id")` {
+		t.Errorf("Unexpected foreign key clause %q", clause)
+	}
+
+	dbmap = &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTable(Author{}).SetKeys(true, "Id").ColMap("FavoriteBookId").References(Book{})
+	dbmap.AddTable(Book{}).SetKeys(true, "Id").ColMap("AuthorId").References(Author{})
+	tables, deferred, err = dbmap.creationOrder()
+	if err != nil {
+		t.Fatalf("Failed to order tables: %s", err)
+	}
+	if len(tables) != 2 || tables[0].TableName != "Book" || tables[1].TableName != "Author" {
+		t.Errorf("Expected Book to be created before Author, got %v", tables)
+	}
+	if len(deferred) != 1 || !deferred[tables[0].ColMap("AuthorId")] {
+		t.Errorf("Expected Book.AuthorId to be deferred, got %v", deferred)
+	}
+
+	dbmap = &DbMap{Dialect: SqliteDialect{}}
+	dbmap.AddTable(Invoice{}).SetKeys(true, "Id").ColMap("PersonId").References(Person{})
+	if _, _, err = dbmap.creationOrder(); err == nil {
+		t.Errorf("Expected an error for a reference to an unregistered type")
+	}
+}
+
+func TestTableGroups(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	dbmap.AddTableWithName(Invoice{}, "billing_invoice").SetKeys(true, "Id").Tag("billing")
+	dbmap.AddTable(Person{}).SetKeys(true, "Id").Tag("people", "billing")
+	dbmap.AddTable(Book{}).SetKeys(true, "Id")
+
+	var dropped []string
+	dbmap.SetStatementAuditor(AuditFunc(func(query string, args []interface{}) error {
+		dropped = append(dropped, query)
+		return errors.New("not connected")
+	}))
+	if err := dbmap.DropTablesMatching("billing_"); err == nil || len(dropped) != 1 || dropped[0] != `drop table if exists "billing_invoice";` {
+		t.Errorf("Expected only billing_invoice to be dropped, got %q (%v)", dropped, err)
+	}
+
+	tagged := filterTables(dbmap.tables, func(t *TableMap) bool { return t.HasTag("billing") })
+	if len(tagged) != 2 || tagged[0].TableName != "billing_invoice" || tagged[1].TableName != "Person" {
+		t.Errorf("Expected the billing tables, got %v", tagged)
+	}
+	if tagged = filterTables(dbmap.tables, nil); len(tagged) != 3 {
+		t.Errorf("Expected all tables for a nil match, got %v", tagged)
+	}
+}
+
+func TestGroupByExpression(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
+	inv := new(OverriddenInvoice)
+	query, args, err := dbmap.Query(inv).
+		Where().
+		Equal(&inv.IsPaid, true).
+		GroupBy(&inv.PersonId).
+		GroupBy(Func("date_trunc", "month", Func("to_timestamp", &inv.Created))).
+		GroupBy(Raw("extract(year from now()) - ?", 1)).
+		SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	expected := ` where "overriddeninvoice"."ispaid"=$1 group by "overriddeninvoice"."personid", date_trunc($2, to_timestamp("overriddeninvoice"."created")), extract(year from now()) - $3`
+	if !strings.HasSuffix(query, expected) {
+		t.Errorf("Expected query to end with %q, got %q", expected, query)
+	}
+	if len(args) != 3 || args[1] != "month" || args[2] != 1 {
+		t.Errorf("Expected args [true month 1], got %v", args)
+	}
+	if _, _, err = dbmap.Query(inv).Where().GroupBy(Raw("?")).SQL(); err == nil {
+		t.Errorf("Expected an error for a placeholder without an argument")
+	}
+}
+
+func TestAsOf(t *testing.T) {
+	when := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	dbmap := &DbMap{Dialect: MariaDBDialect{}}
+	dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
+	inv := new(OverriddenInvoice)
+	plan := dbmap.Query(inv).Where().Equal(&inv.Memo, "memo").AsOf(when).(*QueryPlan)
+	query, err := plan.selectQuery()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	if !strings.Contains(query, " from `OverriddenInvoice` for system_time as of timestamp ? where ") {
+		t.Errorf("Expected the table to be queried as of a point in time, got %q", query)
+	}
+	if len(plan.args) != 2 || plan.args[0] != when || plan.args[1] != "memo" {
+		t.Errorf("Expected args [%v memo], got %v", when, plan.args)
+	}
+
+	for _, dialect := range []Dialect{SqliteDialect{}, MySQLDialect{}} {
+		dbmap = &DbMap{Dialect: dialect}
+		dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
+		if _, err = dbmap.Query(inv).Where().AsOf(when).Select(); err == nil {
+			t.Errorf("Expected an error for %T, which has no system-versioned tables", dialect)
+		}
+	}
+}
+
+func TestEffectiveOn(t *testing.T) {
+	claimDate := time.Date(2014, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	dbmap.AddTable(PolicyVersion{}).SetKeys(true, "Id").SetValidTime("EffectiveFrom", "EffectiveTo")
+	policy := new(PolicyVersion)
+	plan := dbmap.Query(policy).Where().Equal(&policy.Premium, 100).EffectiveOn(claimDate).(*QueryPlan)
+	query, err := plan.selectQuery()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	expected := ` where ("PolicyVersion"."Premium"=? and "PolicyVersion"."EffectiveFrom"<=? and ("PolicyVersion"."EffectiveTo" IS NULL or "PolicyVersion"."EffectiveTo">?))`
+	if !strings.HasSuffix(query, expected) {
+		t.Errorf("Expected query to end with %q, got %q", expected, query)
+	}
+	if len(plan.args) != 3 || plan.args[1] != claimDate || plan.args[2] != claimDate {
+		t.Errorf("Expected args [100 %v %v], got %v", claimDate, claimDate, plan.args)
+	}
+
+	dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
+	inv := new(OverriddenInvoice)
+	if _, err = dbmap.Query(inv).Where().EffectiveOn(claimDate).Select(); err == nil {
+		t.Errorf("Expected an error for a table without valid time columns")
+	}
+}
+
+func TestExpiration(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	dbmap.AddTable(PolicyVersion{}).SetKeys(true, "Id").SetExpiration("EffectiveTo")
+	policy := new(PolicyVersion)
+
+	for _, kind := range []string{"first", "cached"} {
+		plan := dbmap.Query(policy).Where().Equal(&policy.Premium, 100).Limit(5).(*QueryPlan)
+		query, err := plan.selectQuery()
+		if err != nil {
+			t.Fatalf("Failed to generate %s select: %s", kind, err)
+		}
+		expected := ` where "PolicyVersion"."Premium"=? and ("PolicyVersion"."EffectiveTo" is null or "PolicyVersion"."EffectiveTo" > ?) limit ?`
+		if !strings.HasSuffix(query, expected) {
+			t.Errorf("Expected %s query to end with %q, got %q", kind, expected, query)
+		}
+		if len(plan.args) != 3 || plan.args[0] != 100 || plan.args[2] != int64(5) {
+			t.Errorf("Expected %s args [100 <now> 5], got %v", kind, plan.args)
+		}
+		if _, ok := plan.args[1].(time.Time); !ok {
+			t.Errorf("Expected %s plan to compare expiration to the current time, got %v", kind, plan.args[1])
+		}
+	}
+
+	query, err := dbmap.Query(policy).Where().(*QueryPlan).countQuery()
+	if err != nil {
+		t.Fatalf("Failed to generate count: %s", err)
+	}
+	if !strings.HasSuffix(query, ` from "PolicyVersion" where ("PolicyVersion"."EffectiveTo" is null or "PolicyVersion"."EffectiveTo" > ?)`) {
+		t.Errorf("Expected count to skip expired rows, got %q", query)
+	}
+
+	dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
+	if _, err = dbmap.SweepExpired(OverriddenInvoice{}, 10); err == nil {
+		t.Errorf("Expected an error sweeping a table without an expiration column")
+	}
+
+	type Session struct {
+		Id        int64
+		ExpiresAt *time.Time
+	}
+	db, err := sql.Open("gorp_fake_test", "")
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	defer db.Close()
+	dbmap = &DbMap{Db: db, Dialect: PostgresDialect{}}
+	table := dbmap.AddTable(Session{}).SetKeys(false, "Id").SetExpiration("ExpiresAt")
+	fakeDriver.reset()
+	fakeDriver.returnRows([]string{"id"}, []driver.Value{int64(1)}, []driver.Value{int64(2)})
+	if count, err := dbmap.SweepExpired(Session{}, 10); err != nil || count != 1 {
+		t.Fatalf("Expected a single batch delete, got %d, %v", count, err)
+	}
+	statements := fakeDriver.reset()
+	expected := `delete from "session" where "id" in ($1,$2)`
+	if len(statements) != 2 || statements[1] != expected {
+		t.Errorf("Expected a select and %q, got %q", expected, statements)
+	}
+
+	table.SetShadow(nil, "", "session_v2", ShadowBestEffort)
+	fakeDriver.returnRows([]string{"id"}, []driver.Value{int64(1)}, []driver.Value{int64(2)})
+	if count, err := dbmap.SweepExpired(Session{}, 10); err != nil || count != 2 {
+		t.Fatalf("Expected each row to be deleted, got %d, %v", count, err)
+	}
+	statements = fakeDriver.reset()
+	expectedRows := []string{
+		`select "id","expiresat" from "session" where "id" in ($1,$2)`,
+		`delete from "session" where "id"=$1;`,
+		`delete from "session_v2" where "id"=$1;`,
+		`delete from "session" where "id"=$1;`,
+		`delete from "session_v2" where "id"=$1;`,
+	}
+	if len(statements) != 6 || !reflect.DeepEqual(statements[1:], expectedRows) {
+		t.Errorf("Expected rows to be loaded and deleted one at a time, got %q", statements)
+	}
+
+	type TenantSession struct {
+		Id        int64
+		AccountId int64
+		ExpiresAt *time.Time
+	}
+	dbmap.AddTable(TenantSession{}).SetKeys(false, "Id").SetExpiration("ExpiresAt").SetTenantColumn("AccountId").
+		AddDefaultScope("recent", func(ctx context.Context, target interface{}) Filter {
+			return Greater(&target.(*TenantSession).Id, 100)
+		})
+	fakeDriver.returnRows([]string{"id"}, []driver.Value{int64(1)})
+	if count, err := dbmap.SweepExpired(TenantSession{}, 10); err != nil || count != 1 {
+		t.Fatalf("Expected the expired rows of every tenant to be swept, got %d, %v", count, err)
+	}
+	statements = fakeDriver.reset()
+	expected = `select "tenantsession"."id" from "tenantsession" where "tenantsession"."expiresat"<=$1 limit $2`
+	if len(statements) != 2 || statements[0] != expected {
+		t.Errorf("Expected %q without a tenant filter or default scopes, got %q", expected, statements)
+	}
+}
+
+func TestArchiveQuery(t *testing.T) {
+	type Event struct {
+		Id        int64
+		AccountId int64
+		Created   time.Time
+		DeletedAt *time.Time
+	}
+	db, err := sql.Open("gorp_fake_test", "")
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	defer db.Close()
+	dbmap := &DbMap{Db: db, Dialect: PostgresDialect{}}
+	dbmap.AddTable(Event{}).SetKeys(true, "Id").
+		SetTenantColumn("AccountId").
+		SetRetention("Created", time.Hour, "").
+		SetSoftDelete("DeletedAt").
+		AddDefaultScope("recent", func(ctx context.Context, target interface{}) Filter {
+			return Greater(&target.(*Event).Id, 100)
+		})
+	fakeDriver.reset()
+	fakeDriver.returnRows([]string{"id"}, []driver.Value{int64(1)})
+	if count, err := dbmap.Archive(Event{}, 10); err != nil || count != 1 {
+		t.Fatalf("Expected the old rows of every tenant to be archived, got %d, %v", count, err)
+	}
+	expected := []string{
+		`select "event"."id" from "event" where "event"."created"<$1 order by "event"."created" asc limit $2`,
+		"begin",
+		`delete from "event" where "id" in ($1)`,
+		"commit",
+	}
+	if statements := fakeDriver.reset(); !reflect.DeepEqual(statements, expected) {
+		t.Errorf("Expected statements %q, got %q", expected, statements)
+	}
+}
+
+func BenchmarkSqlQuerySelect(b *testing.B) {
+	b.StopTimer()
+	dbmap := newDbMap()
+	dbmap.Exec("drop table if exists OverriddenInvoice")
+	dbmap.TraceOff()
+	dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
+	err := dbmap.CreateTablesIfNotExists()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	inv := &OverriddenInvoice{
+		Id: "1",
+		Invoice: Invoice{
+			Created:  1,
+			Updated:  1,
+			Memo:     "test_memo",
+			PersonId: 1,
+			IsPaid:   false,
+		},
+	}
+	err = dbmap.Insert(inv)
+	if err != nil {
+		panic(err)
+	}
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		q := "SELECT * FROM overriddeninvoice WHERE memo = $1"
+		_, err = dbmap.Select(inv, q, "test_memo")
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func BenchmarkGorpQuerySelect(b *testing.B) {
+	b.StopTimer()
+	dbmap := newDbMap()
+	dbmap.Exec("drop table if exists OverriddenInvoice")
+	dbmap.TraceOff()
+	dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
+	err := dbmap.CreateTablesIfNotExists()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	inv := &OverriddenInvoice{
+		Id: "1",
+		Invoice: Invoice{
+			Created:  1,
+			Updated:  1,
+			Memo:     "test_memo",
+			PersonId: 1,
+			IsPaid:   false,
+		},
+	}
+	err = dbmap.Insert(inv)
+	if err != nil {
+		panic(err)
+	}
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		t := new(OverriddenInvoice)
+		_, err := dbmap.Query(t).
+			Where().
+			Equal(&t.Memo, "test_memo").
+			Select()
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// tenantFilter is a Filter implemented using only the exported
+// ColumnResolver API.
+type tenantFilter struct {
+	field  interface{}
+	tenant int64
+}
+
+func (f tenantFilter) Where(cols ColumnResolver, dialect Dialect, startBindIdx int) (string, []interface{}, error) {
+	col, err := cols.ColumnMap(f.field)
+	if err != nil {
+		return "", nil, err
+	}
+	if col.ColumnName != "PersonId" {
+		return "", nil, fmt.Errorf("unexpected column %s", col.ColumnName)
+	}
+	column, err := cols.Column(f.field)
+	if err != nil {
+		return "", nil, err
+	}
+	return column + " = " + dialect.BindVar(startBindIdx), []interface{}{f.tenant}, nil
+}
+
+func TestCustomFilter(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
+	inv := new(OverriddenInvoice)
+	query, args, err := dbmap.Query(inv).
+		Where().
+		Equal(&inv.IsPaid, true).
+		Filter(tenantFilter{&inv.PersonId, 7}).
+		SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	expected := ` where ("overriddeninvoice"."ispaid"=$1 and "overriddeninvoice"."personid" = $2)`
+	if !strings.HasSuffix(query, expected) {
+		t.Errorf("Expected query to end with %q, got %q", expected, query)
+	}
+	if len(args) != 2 || args[1] != int64(7) {
+		t.Errorf("Expected args [true 7], got %v", args)
+	}
+	if _, _, err = dbmap.Query(inv).Where().Filter(tenantFilter{new(int64), 7}).SQL(); err == nil {
+		t.Errorf("Expected an error for a pointer that isn't a field")
+	}
+}
+
+func TestAddTablesFromTags(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	tables := dbmap.AddTablesFromTags(TaggedInvoice{}, &TaggedLine{})
+	if len(tables) != 2 {
+		t.Fatalf("Expected 2 tables, got %d", len(tables))
+	}
+	invoices, lines := tables[0], tables[1]
+	if invoices.TableName != "tagged_invoices" || len(invoices.keys) != 1 || !invoices.keys[0].isAutoIncr {
+		t.Errorf("Expected tagged_invoices with an auto-increment key, got %+v", invoices)
+	}
+	if lines.SchemaName != "billing" || lines.TableName != "tagged_lines" {
+		t.Errorf("Expected billing.tagged_lines, got %s.%s", lines.SchemaName, lines.TableName)
+	}
+	if len(lines.keys) != 2 || lines.keys[0].fieldName != "InvoiceId" || lines.keys[1].fieldName != "Line" || lines.keys[0].isAutoIncr {
+		t.Errorf("Expected a composite key from the embedded struct's tag, got %+v", lines.keys)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Expected a panic for a model without a gorp tag")
+			}
+		}()
+		dbmap.AddTablesFromTags(Invoice{})
+	}()
+}
+
+func TestOrderByRandom(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTable(Invoice{}).SetKeys(true, "Id")
+	inv := new(Invoice)
+	query, _, err := dbmap.Query(inv).OrderByRandom().Limit(5).SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	if !strings.Contains(query, " order by random() limit ") {
+		t.Errorf("Expected an order by random(), got %q", query)
+	}
+	query, _, err = dbmap.Query(inv).OrderByRandomSeeded(42).SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	expected := ` order by (("invoice"."id" % 2147483648) * 1103515245 + 42) % 2147483648`
+	if !strings.HasSuffix(query, expected) {
+		t.Errorf("Expected query to end with %q, got %q", expected, query)
+	}
+
+	dbmap = &DbMap{Dialect: MySQLDialect{}}
+	dbmap.AddTable(Invoice{}).SetKeys(true, "Id")
+	query, _, err = dbmap.Query(inv).OrderByRandom().OrderByRandomSeeded(7).SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	if !strings.HasSuffix(query, " order by rand(), rand(7)") {
+		t.Errorf("Expected MySQL's rand(), got %q", query)
+	}
+
+	dbmap = &DbMap{Dialect: SqliteDialect{}}
+	dbmap.AddTable(OverriddenInvoice{}).SetKeys(false, "Id")
+	overridden := new(OverriddenInvoice)
+	if _, _, err = dbmap.Query(overridden).OrderByRandomSeeded(7).SQL(); err == nil {
+		t.Errorf("Expected an error for a non-integer primary key")
+	}
+}
+
+func TestStringAgg(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTableWithName(PersonWithMemos{}, "person").SetKeys(true, "Id")
+	dbmap.AddTable(Invoice{}).SetKeys(true, "Id")
+	person := new(PersonWithMemos)
+	inv := new(Invoice)
+	query, _, err := dbmap.Query(person).
+		Join(inv).On(Equal(&inv.PersonId, &person.Id)).
+		Where().
+		GroupBy(&person.Id).
+		GroupBy(&person.FName).
+		Computed(&person.Memos, StringAgg(&inv.Memo, "', ")).
+		SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	expected := `select "person"."id","person"."fname",string_agg(cast("invoice"."memo" as text), ''', ') as "memos" from "person"`
+	if !strings.HasPrefix(query, expected) {
+		t.Errorf("Expected query to start with %q, got %q", expected, query)
+	}
+
+	if sql := (MySQLDialect{}).StringAgg("`memo`", `\`); sql != "group_concat(`memo` separator '\\\\')" {
+		t.Errorf("Expected backslashes to be escaped for MySQL, got %s", sql)
+	}
+	if sql := (SqliteDialect{}).StringAgg(`"memo"`, ", "); sql != `group_concat("memo", ', ')` {
+		t.Errorf("Expected group_concat for sqlite, got %s", sql)
+	}
+	if _, _, err = dbmap.Query(person).Computed(&person.FName, Raw("1")).SQL(); err == nil {
+		t.Errorf("Expected an error for a field that isn't transient")
+	}
+
+	dbmap.tables[1].ColMap("Memo").SetReadAccess(func(context.Context) bool { return false })
+	_, _, err = dbmap.Query(person).
+		Join(inv).On(Equal(&inv.PersonId, &person.Id)).
+		Where().
+		GroupBy(&person.Id).
+		Computed(&person.Memos, StringAgg(&inv.Memo, ", ")).
+		SQL()
+	if _, denied := err.(readAccessError); !denied {
+		t.Errorf("Expected a read access error computing from an unreadable column, got %v", err)
+	}
+}
+
+func TestHydrateSQL(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTableWithName(InvoiceWithPerson{}, "invoice").SetKeys(true, "Id")
+	dbmap.AddTable(Person{}).SetKeys(true, "Id")
+	inv := new(InvoiceWithPerson)
+	person := new(Person)
+	query, _, err := dbmap.Query(inv).
+		Join(person).On(Equal(&inv.PersonId, &person.Id)).
+		Where().
+		Hydrate(&inv.Person).
+		SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	expected := `select "invoice"."id","invoice"."created","invoice"."updated","invoice"."memo","invoice"."personid","invoice"."ispaid",` +
+		`"person"."id" as "person__id","person"."created" as "person__created","person"."updated" as "person__updated",` +
+		`"person"."fname" as "person__fname","person"."lname" as "person__lname","person"."version" as "person__version" ` +
+		`from "invoice" inner join "person" on "invoice"."personid"="person"."id"`
+	if query != expected {
+		t.Errorf("Expected %q, got %q", expected, query)
+	}
+
+	if _, _, err = dbmap.Query(inv).Where().Hydrate(&inv.Person).SQL(); err == nil {
+		t.Errorf("Expected an error for a field without a joined table")
+	}
+	if _, _, err = dbmap.Query(inv).Join(person).On(Equal(&inv.PersonId, &person.Id)).Where().Hydrate(&inv.Memo).SQL(); err == nil {
+		t.Errorf("Expected an error for a field that isn't transient")
+	}
+}
+
+func TestSelectIntoErrors(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTable(Invoice{}).SetKeys(true, "Id")
+	dbmap.AddTable(Person{}).SetKeys(true, "Id")
+	inv := new(Invoice)
+	person := new(Person)
+	var invoices []Invoice
+	var people []*Person
+	query := dbmap.Query(inv).Join(person).On(Equal(&inv.PersonId, &person.Id)).Where()
+	if err := query.SelectInto(); err == nil {
+		t.Errorf("Expected an error without targets")
+	}
+	if err := query.SelectInto(invoices); err == nil {
+		t.Errorf("Expected an error for a target that isn't a slice pointer")
+	}
+	var names []string
+	if err := query.SelectInto(&invoices, &people, &names); err == nil {
+		t.Errorf("Expected an error for a target that isn't one of the plan's tables")
+	}
+	if err := dbmap.Query(inv).Where().SelectInto(&invoices, &people); err == nil {
+		t.Errorf("Expected an error for a table that isn't joined")
+	}
+}
+
+func TestVersionStrategies(t *testing.T) {
+	type Revision struct {
+		Id        int64
+		UpdatedAt time.Time
+	}
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	table := dbmap.AddTable(Revision{}).SetKeys(true, "Id")
+	table.SetVersionCol("UpdatedAt")
+	table.SetVersionStrategy(TimestampVersion{Precision: time.Second})
+
+	rev := &Revision{Id: 1}
+	bi, err := table.bindInsert(reflect.ValueOf(rev).Elem())
+	if err != nil {
+		t.Fatalf("Failed to bind insert: %s", err)
+	}
+	if rev.UpdatedAt.IsZero() || rev.UpdatedAt != rev.UpdatedAt.Truncate(time.Second) {
+		t.Errorf("Expected insert to set a version truncated to seconds, got %s", rev.UpdatedAt)
+	}
+	if bi.versionChecked() {
+		t.Errorf("Expected the version of a new row not to be checked")
+	}
+
+	rev.UpdatedAt = time.Now().Add(time.Hour).Truncate(time.Second)
+	bi, err = table.bindUpdate(reflect.ValueOf(rev).Elem())
+	if err != nil {
+		t.Fatalf("Failed to bind update: %s", err)
+	}
+	expected := `update "revision" set "updatedat"=$1 where "id"=$2 and "updatedat"=$3;`
+	if bi.query != expected {
+		t.Errorf("Expected %q, got %q", expected, bi.query)
+	}
+	if next := bi.args[0].(time.Time); !next.Equal(rev.UpdatedAt.Add(time.Second)) {
+		t.Errorf("Expected a version after a version in the future, got %s", next)
+	}
+	if !bi.versionChecked() || bi.args[2] != rev.UpdatedAt {
+		t.Errorf("Expected the current version to be checked, got %v", bi.args)
+	}
+
+	uuid, err := UUIDVersion{}.NextVersion("")
+	if err != nil || !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(uuid.(string)) {
+		t.Errorf("Expected a random UUID, got %v, %v", uuid, err)
+	}
+	if _, err = (UUIDVersion{}).NextVersion(int64(1)); err == nil {
+		t.Errorf("Expected an error for a version field that isn't a string")
+	}
+	if next, err := (IntegerVersion{}).NextVersion(int32(4)); err != nil || next != int64(5) {
+		t.Errorf("Expected 5, got %v, %v", next, err)
+	}
+
+	table.SetVersionStrategy(IntegerVersion{})
+	if _, err = table.bindUpdate(reflect.ValueOf(rev).Elem()); err == nil {
+		t.Errorf("Expected an error for an integer strategy on a time field")
+	}
+}
+
+func TestManyToMany(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTable(Label{}).SetKeys(true, "Id")
+	dbmap.AddTable(InvoiceLabel{}).SetKeys(false, "InvoiceId", "LabelId")
+	dbmap.AddTable(Person{}).SetKeys(true, "Id")
+	dbmap.AddTable(Invoice{}).SetKeys(true, "Id").
+		ManyToMany(Label{}, InvoiceLabel{}, "InvoiceId", "LabelId")
+
+	labels := dbmap.mustTableFor(Label{})
+	invoices := dbmap.mustTableFor(Invoice{})
+	assoc, parentKey, childKey := dbmap.manyToManyBetween(labels, invoices)
+	if assoc == nil || parentKey.ColumnName != "LabelId" || childKey.ColumnName != "InvoiceId" {
+		t.Errorf("Expected the association to be found from the label side, got %v %v %v", assoc, parentKey, childKey)
+	}
+
+	graph, err := dbmap.SchemaGraph()
+	if err != nil {
+		t.Fatalf("Failed to build schema graph: %s", err)
+	}
+	relations := graph.RelationsOf("InvoiceLabel")
+	if len(relations) != 2 || relations[0].Kind != RelationManyToMany || relations[0].Parent != "Invoice" || relations[1].Parent != "Label" {
+		t.Errorf("Expected the join table to relate invoices and labels, got %+v", relations)
+	}
+
+	var people []Person
+	if err = dbmap.Related(&Label{Id: 1}, &people); err == nil {
+		t.Errorf("Expected an error for tables without an association")
+	}
+	if err = dbmap.Related(&Label{Id: 1}, people); err == nil {
+		t.Errorf("Expected an error for a target that isn't a slice pointer")
+	}
+}
+
+func TestSplitScript(t *testing.T) {
+	tests := []struct {
+		dialect  Dialect
+		script   string
+		expected []string
+	}{
+		{SqliteDialect{}, "create table a (x text default ';');\n-- done; really\ninsert into a values ('it''s; here'); /* ; */", []string{
+			"create table a (x text default ';')",
+			"-- done; really\ninsert into a values ('it''s; here')",
+		}},
+		{SqliteDialect{}, "select 1;;\n-- trailing comment;\n", []string{"select 1"}},
+		{PostgresDialect{}, "create function f() returns int as $body$ begin return 1; end; $body$ language plpgsql;\nselect $1, $$;$$", []string{
+			"create function f() returns int as $body$ begin return 1; end; $body$ language plpgsql",
+			"select $1, $$;$$",
+		}},
+		{MySQLDialect{}, "set @a = 'x\\';';\nDELIMITER //\ncreate procedure p() begin select 1; select `a;b`; end//\ndelimiter ;\nselect 2;", []string{
+			"set @a = 'x\\';'",
+			"create procedure p() begin select 1; select `a;b`; end",
+			"select 2",
+		}},
+	}
+	for _, test := range tests {
+		dbmap := &DbMap{Dialect: test.dialect}
+		statements, err := dbmap.SplitScript(test.script)
+		if err != nil {
+			t.Errorf("Failed to split %q: %s", test.script, err)
+			continue
+		}
+		if !reflect.DeepEqual(statements, test.expected) {
+			t.Errorf("Expected %q to be split into %q, got %q", test.script, test.expected, statements)
+		}
+	}
+
+	for _, script := range []string{"select 'unterminated;", "select 1; /* unterminated", "select $a$ unterminated"} {
+		if _, err := (&DbMap{Dialect: PostgresDialect{}}).SplitScript(script); err == nil {
+			t.Errorf("Expected an error for %q", script)
+		}
+	}
+}
+
+func TestFailoverConnector(t *testing.T) {
+	var failovers [][2]int
+	connector, err := NewFailoverConnector("gorp_fake_test", []string{"primary", "standby"}, FailoverOptions{
+		Attempts:   2,
+		RetryDelay: time.Millisecond,
+		OnFailover: func(from, to int) { failovers = append(failovers, [2]int{from, to}) },
+	})
+	if err != nil {
+		t.Fatalf("Failed to create connector: %s", err)
+	}
+	ctx := context.Background()
+	if _, err = connector.Connect(ctx); err != nil || connector.Active() != 0 {
+		t.Errorf("Expected a connection to the primary, got %d, %v", connector.Active(), err)
+	}
+
+	fakeDriver.setDown("primary", true)
+	if _, err = connector.Connect(ctx); err != nil || connector.Active() != 1 {
+		t.Errorf("Expected a connection to the standby, got %d, %v", connector.Active(), err)
+	}
+
+	fakeDriver.setDown("standby", true)
+	fakeDriver.mu.Lock()
+	fakeDriver.opens = 0
+	fakeDriver.mu.Unlock()
+	if _, err = connector.Connect(ctx); err == nil {
+		t.Errorf("Expected an error when all DSNs are down")
+	}
+	if fakeDriver.opens != 4 {
+		t.Errorf("Expected each DSN to be tried twice, got %d attempts", fakeDriver.opens)
+	}
+
+	fakeDriver.setDown("primary", false)
+	fakeDriver.setDown("standby", false)
+	dbmap := &DbMap{Db: sql.OpenDB(connector), Dialect: PostgresDialect{}}
+	defer dbmap.Db.Close()
+	if err = dbmap.Db.Ping(); err != nil || connector.Active() != 0 {
+		t.Errorf("Expected to reconnect to the primary, got %d, %v", connector.Active(), err)
+	}
+	if !reflect.DeepEqual(failovers, [][2]int{{0, 1}, {1, 0}}) {
+		t.Errorf("Expected a failover to the standby and back, got %v", failovers)
+	}
+
+	if _, err = NewFailoverConnector("gorp_fake_test", nil, FailoverOptions{}); err == nil {
+		t.Errorf("Expected an error without DSNs")
+	}
+	if _, err = OpenFailover(PostgresDialect{}, "no_such_driver", []string{"primary"}, FailoverOptions{}); err == nil {
+		t.Errorf("Expected an error for an unknown driver")
+	}
+}
+
+func TestRelations(t *testing.T) {
+	type Comment struct {
+		Id     int64
+		PostId int64
+		Body   string
+	}
+	type Summary struct {
+		Id     int64
+		PostId int64
+	}
+	type Post struct {
+		Id       int64
+		AuthorId int64
+		Author   *Person
+		Comments []*Comment
+		Summary  Summary
+	}
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	posts := dbmap.AddTable(Post{}).SetKeys(true, "Id")
+	posts.ColMap("Author").BelongsTo("AuthorId")
+	posts.ColMap("Comments").HasMany("PostId")
+	posts.ColMap("Summary").HasOne("PostId")
+
+	if _, err := posts.Relations(); err == nil {
+		t.Errorf("Expected an error for unregistered related types")
+	}
+	dbmap.AddTable(Person{}).SetKeys(true, "Id")
+	dbmap.AddTable(Comment{}).SetKeys(true, "Id")
+	dbmap.AddTable(Summary{}).SetKeys(true, "Id")
+
+	relations, err := posts.Relations()
+	if err != nil {
+		t.Fatalf("Failed to resolve relations: %s", err)
+	}
+	expected := []struct {
+		kind          RelationKind
+		field         string
+		parent, child string
+		foreignKey    string
+	}{
+		{RelationBelongsTo, "Author", "Person", "Post", "AuthorId"},
+		{RelationHasMany, "Comments", "Post", "Comment", "PostId"},
+		{RelationHasOne, "Summary", "Post", "Summary", "PostId"},
+	}
+	if len(relations) != len(expected) {
+		t.Fatalf("Expected %d relations, got %d", len(expected), len(relations))
+	}
+	for i, e := range expected {
+		r := relations[i]
+		if r.Kind != e.kind || r.Field != e.field || r.Parent().TableName != e.parent || r.Child().TableName != e.child || r.ForeignKey.ColumnName != e.foreignKey {
+			t.Errorf("Expected relation %v, got %s %s %s %s %s", e, r.Kind, r.Field, r.Parent().TableName, r.Child().TableName, r.ForeignKey.ColumnName)
+		}
+	}
+	for _, col := range posts.columns {
+		if col.inSchema() && (col.fieldName == "Author" || col.fieldName == "Comments") {
+			t.Errorf("Expected relation field %s to be transient", col.fieldName)
+		}
+	}
+
+	graph, err := dbmap.SchemaGraph()
+	if err != nil {
+		t.Fatalf("Failed to build schema graph: %s", err)
+	}
+	comments := graph.RelationsOf("Comment")
+	if len(comments) != 1 || comments[0].Kind != RelationHasMany || comments[0].Name != "Post.Comments" || comments[0].Parent != "Post" {
+		t.Errorf("Expected a has-many relation from posts to comments, got %+v", comments)
+	}
+
+	posts.ColMap("AuthorId").HasMany("PostId")
+	if _, err = posts.Relations(); err == nil {
+		t.Errorf("Expected an error for a has-many field that isn't a slice")
+	}
+}
+
+func TestVitessDialect(t *testing.T) {
+	dialect := VitessDialect{MySQLDialect: MySQLDialect{"InnoDB", "UTF8"}, Keyspace: "commerce"}
+	dbmap := &DbMap{Dialect: dialect}
+	dbmap.AddTable(Author{}).SetKeys(true, "Id").ColMap("FavoriteBookId").References(Book{})
+	dbmap.AddTable(Book{}).SetKeys(true, "Id").ColMap("AuthorId").References(Author{})
+	if _, deferred, err := dbmap.creationOrder(); err != nil || len(deferred) != 0 {
+		t.Errorf("Expected no deferred foreign keys, got %v (%v)", deferred, err)
+	}
+
+	book := new(Book)
+	query, _, err := dbmap.Query(book).Where().Equal(&book.AuthorId, 1).Hint("SCATTER_ERRORS_AS_WARNINGS").SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	if !strings.HasPrefix(query, "select /*vt+ SCATTER_ERRORS_AS_WARNINGS */ `commerce`.`Book`.`Id`") {
+		t.Errorf("Expected a vt+ directive and a keyspace qualifier, got %q", query)
+	}
+
+	if _, err = dbmap.Query(book).Where().Equal(&book.AuthorId, 1).Delete(); err == nil {
+		t.Errorf("Expected an error for a delete without a primary key predicate")
+	}
+	plan := dbmap.Query(book).(*QueryPlan)
+	plan.Where().Equal(&book.Id, 1).Equal(&book.AuthorId, 1)
+	if err = plan.checkKeyPredicate(); err != nil {
+		t.Errorf("Expected a primary key predicate to be accepted, got %s", err)
+	}
+}
+
+func TestBigQueryDialect(t *testing.T) {
+	dbmap := &DbMap{Dialect: BigQueryDialect{}}
+	dbmap.AddTableWithNameAndSchema(Invoice{}, "billing", "invoice").SetKeys(false, "Id")
+	inv := new(Invoice)
+	query, args, err := dbmap.Query(inv).Where().Equal(&inv.PersonId, 1).Offset(20).SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	expected := " from `billing.invoice` where `billing.invoice`.`PersonId`=? limit 9223372036854775807 offset ?"
+	if !strings.HasSuffix(query, expected) {
+		t.Errorf("Expected query to end with %q, got %q", expected, query)
+	}
+	if len(args) != 2 || args[1] != int64(20) {
+		t.Errorf("Expected args [1 20], got %v", args)
+	}
+	if sqlType := dbmap.Dialect.ToSqlType(reflect.TypeOf(time.Time{}), 0, false); sqlType != "TIMESTAMP" {
+		t.Errorf("Expected TIMESTAMP for time.Time, got %s", sqlType)
+	}
+
+	if err = dbmap.Insert(&Invoice{}); err != ErrReadOnly {
+		t.Errorf("Expected ErrReadOnly for an insert, got %v", err)
+	}
+	if err = dbmap.Query(inv).Assign(&inv.Memo, "m").Insert(); err != ErrReadOnly {
+		t.Errorf("Expected ErrReadOnly for a plan insert, got %v", err)
+	}
+	if _, err = dbmap.Query(inv).Where().Equal(&inv.Id, 1).Delete(); err != ErrReadOnly {
+		t.Errorf("Expected ErrReadOnly for a delete, got %v", err)
+	}
+	writes := map[string]func() error{
+		"TruncateTable":   func() error { return dbmap.TruncateTable(Invoice{}) },
+		"TruncateTables":  dbmap.TruncateTables,
+		"Truncate":        func() error { return dbmap.Query(inv).Truncate() },
+		"CreateTables":    dbmap.CreateTables,
+		"DropTables":      dbmap.DropTables,
+		"ExecScript":      func() error { return dbmap.ExecScript(context.Background(), "select 1;") },
+		"RestoreSnapshot": func() error { return dbmap.RestoreSnapshot(strings.NewReader("{}")) },
+		"Archive": func() error {
+			_, err := dbmap.Archive(Invoice{}, 10)
+			return err
+		},
+		"SweepExpired": func() error {
+			_, err := dbmap.SweepExpired(Invoice{}, 10)
+			return err
+		},
+	}
+	for name, write := range writes {
+		if err = write(); err != ErrReadOnly {
+			t.Errorf("Expected ErrReadOnly for %s, got %v", name, err)
+		}
+	}
+
+	query, _, err = dbmap.Query(inv).OrderByRandom().SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	if !strings.HasSuffix(query, " order by rand()") {
+		t.Errorf("Expected a random order using rand(), got %q", query)
+	}
+}
+
+func TestExpr(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTable(Invoice{}).SetKeys(true, "Id")
+	inv := new(Invoice)
+	query, args, err := dbmap.Query(inv).
+		Where().
+		Greater(Col(&inv.Created).Sub(&inv.Updated), 60).
+		Equal(Val(2).Mul(&inv.PersonId), Func("abs", &inv.Id)).
+		OrderBy(Desc(Col(&inv.Updated).Div(&inv.Created))).
+		GroupBy(Col(&inv.PersonId).Add(1)).
+		SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	expected := ` where (("invoice"."created" - "invoice"."updated")>$1 and ($2 * "invoice"."personid")=abs("invoice"."id")) group by ("invoice"."personid" + $3) order by ("invoice"."updated" / "invoice"."created") desc`
+	if !strings.HasSuffix(query, expected) {
+		t.Errorf("Expected query to end with %q, got %q", expected, query)
+	}
+	if len(args) != 3 || args[0] != 60 || args[1] != 2 || args[2] != 1 {
+		t.Errorf("Expected args [60 2 1], got %v", args)
+	}
+
+	query, args, err = dbmap.Query(inv).
+		Assign(&inv.Updated, Col(&inv.Updated).Add(Val(10))).
+		Assign(&inv.Memo, "late").
+		Where().
+		Equal(&inv.Id, 5).
+		SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate update: %s", err)
+	}
+	expected = `update "invoice" set "updated"=("invoice"."updated" + $1), "memo"=$2 where "invoice"."id"=$3`
+	if query != expected {
+		t.Errorf("Expected %q, got %q", expected, query)
+	}
+	if len(args) != 3 || args[0] != 10 || args[1] != "late" || args[2] != 5 {
+		t.Errorf("Expected args [10 late 5], got %v", args)
+	}
+
+	if _, _, err = dbmap.Query(inv).Where().OrderBy(Col(&inv.Id).Add(1)).SQL(); err == nil {
+		t.Errorf("Expected an error ordering by an expression with a bound value")
+	}
+	if _, err = dbmap.Query(inv).Where().Equal(Col(&inv.Id).Add(1), 2).Definition(); err == nil {
+		t.Errorf("Expected an error defining a filter on an expression")
+	}
+}
+
+func TestBindExpr(t *testing.T) {
+	tests := []struct {
+		expr     string
+		args     int
+		expected string
+	}{
+		{`coalesce(?, 'a?') || "b?"`, 1, `coalesce($1, 'a?') || "b?"`},
+		{`'it''s ?' || ?`, 1, `'it''s ?' || $1`},
+		{"? -- why?\n+ ? /* or? */", 2, "$1 -- why?\n+ $2 /* or? */"},
+		{`tags ?? ? and tags ?| ? and tags ?& ?`, 3, `tags ? $1 and tags ?| $2 and tags ?& $3`},
+	}
+	for _, test := range tests {
+		expr, err := bindExpr(PostgresDialect{}, test.expr, 0, test.args)
+		if err != nil {
+			t.Errorf("Failed to bind %q: %s", test.expr, err)
+		} else if expr != test.expected {
+			t.Errorf("Expected %q to bind to %q, got %q", test.expr, test.expected, expr)
+		}
+	}
+	if _, err := bindExpr(PostgresDialect{}, `'?`, 0, 0); err == nil {
+		t.Errorf("Expected an error for an unterminated quote")
+	}
+	if _, err := bindExpr(PostgresDialect{}, `? = '?'`, 0, 2); err == nil {
+		t.Errorf("Expected an error for quoted placeholders counted as arguments")
+	}
+
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTable(Invoice{}).SetKeys(true, "Id")
+	inv := new(Invoice)
+	query, args, err := dbmap.Query(inv).AssignExpr(&inv.Memo, "concat(memo, '?', ?)", "x").Where().Equal(&inv.Id, 1).SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate update: %s", err)
+	}
+	expected := `update "invoice" set "memo"=concat(memo, '?', $1) where "invoice"."id"=$2`
+	if query != expected || len(args) != 2 {
+		t.Errorf("Expected %q, got %q with args %v", expected, query, args)
+	}
+}
+
+func TestArgScrubber(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	logBuffer := &bytes.Buffer{}
+	dbmap.TraceOn("", log.New(logBuffer, "", 0))
+	var audited []interface{}
+	dbmap.SetStatementAuditor(AuditFunc(func(query string, args []interface{}) error {
+		audited = args
+		return nil
+	}))
+	dbmap.SetArgScrubber(Scrubbers(
+		ScrubPattern(regexp.MustCompile(`\d{4}(\d{4})`), "****$1"),
+		AllowTypes(int64(0), ""),
+	))
+
+	args := []interface{}{int64(7), "card 12345678", 3.5, nil}
+	dbmap.trace("update t", args...)
+	expected := "update t [7 card ****5678 [scrubbed] <nil>]\n"
+	if logBuffer.String() != expected {
+		t.Errorf("Expected log %q, got %q", expected, logBuffer.String())
+	}
+	if err := dbmap.audit("update t", args); err != nil {
+		t.Fatalf("Failed to audit: %s", err)
+	}
+	if fmt.Sprint(audited) != "[7 card ****5678 [scrubbed] <nil>]" {
+		t.Errorf("Expected audited args to be scrubbed, got %v", audited)
+	}
+	if args[1] != "card 12345678" || args[2] != 3.5 {
+		t.Errorf("Expected the original args to be unchanged, got %v", args)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTable(Invoice{}).SetKeys(true, "Id")
+	dbmap.AddTable(Person{}).SetKeys(true, "Id")
+	rows := func(rows ...interface{}) RowIterator {
+		return RowIteratorFunc(func(ctx context.Context) (interface{}, error) {
+			if len(rows) == 0 {
+				return nil, io.EOF
+			}
+			row := rows[0]
+			rows = rows[1:]
+			return row, nil
+		})
+	}
+	if _, err := dbmap.Import(context.Background(), Invoice{}, rows(&Person{}), ImportOptions{}); err == nil {
+		t.Errorf("Expected an error importing a row of another table")
+	}
+	opts := ImportOptions{ResumeAfter: 2}
+	if _, err := dbmap.Import(context.Background(), Invoice{}, rows(&Invoice{}), opts); err == nil {
+		t.Errorf("Expected an error resuming after more rows than the iterator has")
+	}
+	if _, err := dbmap.Import(context.Background(), Invoice{}, rows(), ImportOptions{BatchSize: -1}); err == nil {
+		t.Errorf("Expected an error for a negative batch size")
+	}
+	if n, err := dbmap.Import(context.Background(), Invoice{}, rows(), ImportOptions{}); n != 0 || err != nil {
+		t.Errorf("Expected an empty import to succeed, got %d, %v", n, err)
+	}
+}
+
+func TestQueryFrom(t *testing.T) {
+	type PersonTotal struct {
+		PersonId int64
+		Total    int64
+	}
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTable(Invoice{}).SetKeys(true, "Id")
+	inv := new(Invoice)
+	totals := dbmap.Query(inv).
+		Where().
+		Equal(&inv.IsPaid, false).
+		GroupBy(&inv.PersonId)
+	total := new(PersonTotal)
+	query, args, err := dbmap.QueryFrom(totals, total,
+		As("PersonId", &inv.PersonId),
+		As("Total", Func("sum", Col(&inv.Updated).Sub(Val(5))))).
+		Where().
+		Greater(&total.Total, 1000).
+		OrderBy(&total.Total, Descending).
+		Limit(10).
+		SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	expected := `select "persontotal"."personid","persontotal"."total" from (select "invoice"."personid" as "personid",sum(("invoice"."updated" - $1)) as "total" from "invoice" where "invoice"."ispaid"=$2 group by "invoice"."personid") "persontotal" where "persontotal"."total">$3 order by "persontotal"."total" desc limit $4`
+	if query != expected {
+		t.Errorf("Expected %q, got %q", expected, query)
+	}
+	if len(args) != 4 || args[0] != 5 || args[1] != false || args[2] != 1000 || args[3] != int64(10) {
+		t.Errorf("Expected args [5 false 1000 10], got %v", args)
+	}
+
+	// Arguments of the outer select list are bound before the
+	// derived table's.
+	outer := dbmap.QueryFrom(totals, total).(*QueryPlan)
+	query, _, err = outer.resultsQuery([]ResultColumn{As("Total", Val(2).Mul(&total.Total))})
+	if err != nil {
+		t.Fatalf("Failed to generate results select: %s", err)
+	}
+	expected = `select ($1 * "persontotal"."total") as "total" from (select "invoice"."id","invoice"."created","invoice"."updated","invoice"."memo","invoice"."personid","invoice"."ispaid" from "invoice" where "invoice"."ispaid"=$2 group by "invoice"."personid") "persontotal"`
+	if query != expected {
+		t.Errorf("Expected %q, got %q", expected, query)
+	}
+	if len(outer.args) != 2 || outer.args[0] != 2 || outer.args[1] != false {
+		t.Errorf("Expected args [2 false], got %v", outer.args)
+	}
+
+	if err = dbmap.QueryFrom(totals, total).Assign(&total.Total, 1).Insert(); err != errDerivedWrite {
+		t.Errorf("Expected a derived write error, got %v", err)
+	}
+	if _, err = dbmap.QueryFrom(totals, total).Where().Definition(); err == nil {
+		t.Errorf("Expected an error defining a plan with a derived table")
+	}
+}
+
+func TestRuntimeConfig(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTable(Invoice{}).SetKeys(true, "Id")
+	inv := new(Invoice)
+
+	dbmap.RequireWhereForMutations(true)
+	config := dbmap.RuntimeConfig()
+	if !config.RequireWhere {
+		t.Errorf("Expected RequireWhereForMutations to update the runtime config")
+	}
+	config.DefaultLimit = 50
+	var slow []string
+	config.SlowQueryThreshold = time.Second
+	config.OnSlowQuery = func(query string, args []interface{}, elapsed time.Duration) {
+		slow = append(slow, query)
+	}
+	dbmap.SetRuntimeConfig(config)
+
+	query, args, err := dbmap.Query(inv).Where().Equal(&inv.IsPaid, false).SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	if !strings.HasSuffix(query, " limit $2") || len(args) != 2 || args[1] != int64(50) {
+		t.Errorf("Expected the default limit, got %q %v", query, args)
+	}
+	query, args, err = dbmap.Query(inv).Where().Equal(&inv.IsPaid, false).Limit(5).SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	if len(args) != 2 || args[1] != int64(5) {
+		t.Errorf("Expected the plan's limit to override the default, got %q %v", query, args)
+	}
+	query, err = dbmap.Query(inv).Where().Equal(&inv.IsPaid, false).(*QueryPlan).selectQuery()
+	if err != nil || strings.Contains(query, "limit") {
+		t.Errorf("Expected no default limit outside of Select and SelectToTarget, got %q, %v", query, err)
+	}
+	if _, err = dbmap.Query(inv).Delete(); err != ErrMissingWhere {
+		t.Errorf("Expected ErrMissingWhere, got %v", err)
+	}
+
+	dbmap.traceSlow(time.Now(), "fast", nil)
+	dbmap.traceSlow(time.Now().Add(-2*time.Second), "slow", nil)
+	if len(slow) != 1 || slow[0] != "slow" {
+		t.Errorf("Expected only the slow query to be reported, got %v", slow)
+	}
+
+	dbmap.SetRuntimeConfig(RuntimeConfig{})
+	if query, _, err = dbmap.Query(inv).Where().SQL(); err != nil || strings.Contains(query, "limit") {
+		t.Errorf("Expected no limit after the config was replaced, got %q, %v", query, err)
+	}
+}
+
+func TestSchemaGraph(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTableWithNameAndSchema(Book{}, "library", "book").SetKeys(true, "Id").
+		ColMap("AuthorId").References(Author{})
+	dbmap.AddTableWithName(Author{}, "author").SetKeys(true, "Id").Tag("library").
+		HasMany(Book{}, "AuthorId")
+	dbmap.AddTableWithName(TreeNode{}, "tree_node").SetKeys(true, "Id").
+		SetClosureTable("ParentId", "tree_node_closure")
+	dbmap.AddTableWithName(PolymorphicComment{}, "comment").SetKeys(true, "Id").
+		Polymorphic("commentable", "CommentableType", "CommentableId", "Commentable").
+		Type("book", Book{}).
+		Type("author", Author{})
+
+	graph, err := dbmap.SchemaGraph()
+	if err != nil {
+		t.Fatalf("Failed to build the schema graph: %s", err)
+	}
+	if len(graph.Tables) != 4 {
+		t.Fatalf("Expected 4 tables, got %d", len(graph.Tables))
+	}
+	book := graph.Table("library.book")
+	if book == nil || book.GoType != "gorp.Book" || len(book.Columns) != 3 || !book.Columns[0].PrimaryKey || !book.Columns[0].AutoIncrement {
+		t.Errorf("Expected the book table with an autoincrement key, got %+v", book)
+	}
+	if comment := graph.Table("comment"); comment == nil || len(comment.Columns) != 4 {
+		t.Errorf("Expected the comment table without its transient field, got %+v", comment)
+	}
+	if author := graph.Table("author"); author == nil || len(author.Tags) != 1 || author.Tags[0] != "library" {
+		t.Errorf("Expected the author table with its tag, got %+v", author)
+	}
+
+	var relations []string
+	for _, rel := range graph.Relations {
+		relations = append(relations, fmt.Sprintf("%s %s %s%v -> %s%v", rel.Kind, rel.Name, rel.Table, rel.Columns, rel.Parent, rel.ParentColumns))
+	}
+	expected := []string{
+		"references  library.book[AuthorId] -> author[Id]",
+		"hasMany  library.book[AuthorId] -> author[Id]",
+		"tree  tree_node[ParentId] -> tree_node[Id]",
+		"polymorphic commentable:author comment[CommentableType CommentableId] -> author[Id]",
+		"polymorphic commentable:book comment[CommentableType CommentableId] -> library.book[Id]",
+	}
+	if !reflect.DeepEqual(relations, expected) {
+		t.Errorf("Expected relations %q, got %q", expected, relations)
+	}
+	if related := graph.RelationsOf("author"); len(related) != 3 {
+		t.Errorf("Expected 3 relations of author, got %v", related)
+	}
+
+	dbmap.AddTable(Invoice{}).SetKeys(true, "Id").ColMap("PersonId").References(Person{})
+	if _, err = dbmap.SchemaGraph(); err == nil {
+		t.Errorf("Expected an error for a reference to an unregistered table")
+	}
+}
+
+func TestPreloadErrors(t *testing.T) {
+	type Comment struct {
+		Id     int64
+		PostId int64
+	}
+	type Post struct {
+		Id       int64
+		Comments []Comment
+		Notes    []Comment `db:"-"`
+	}
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTable(Post{}).SetKeys(true, "Id").
+		ColMap("Comments").HasMany("PostId")
+
+	post := new(Post)
+	plan := dbmap.Query(post).Where().Preload(&post.Comments).(*QueryPlan)
+	if len(plan.Errors) == 0 {
+		t.Errorf("Expected an error for an unregistered related type")
+	}
+
+	dbmap.AddTable(Comment{}).SetKeys(true, "Id")
+	post = new(Post)
+	plan = dbmap.Query(post).Where().Preload(&post.Comments).(*QueryPlan)
+	if len(plan.Errors) != 0 || len(plan.preloads) != 1 {
+		t.Errorf("Expected one preloaded relation, got %v (errors %v)", plan.preloads, plan.Errors)
+	}
+	plan = dbmap.Query(post).Where().Preload(&post.Notes).(*QueryPlan)
+	if len(plan.Errors) == 0 {
+		t.Errorf("Expected an error for a field without a relation")
+	}
+
+	one, zero := int32(1), int64(0)
+	for _, c := range []struct {
+		value    interface{}
+		expected interface{}
+	}{
+		{int64(1), int64(1)},
+		{uint8(1), int64(1)},
+		{&one, int64(1)},
+		{&zero, nil},
+		{(*int64)(nil), nil},
+		{sql.NullInt64{Int64: 1, Valid: true}, int64(1)},
+		{sql.NullInt64{}, nil},
+		{"a", "a"},
+	} {
+		if got := preloadKey(reflect.ValueOf(c.value)); got != c.expected {
+			t.Errorf("Expected key %v for %v, got %v", c.expected, c.value, got)
+		}
+	}
+}
+
+// staleReadDialect is a dialect that supports stale reads.
+type staleReadDialect struct {
+	PostgresDialect
+}
+
+func (staleReadDialect) StaleRead() string {
+	return " as of system time follower_read_timestamp()"
+}
+
+func TestConsistency(t *testing.T) {
+	replicas := make([]*sql.DB, 2)
+	for i := range replicas {
+		db, err := sql.Open("gorp_fake_test", fmt.Sprintf("replica%d", i))
+		if err != nil {
+			t.Fatalf("Failed to open replica: %s", err)
+		}
+		defer db.Close()
+		replicas[i] = db
+	}
+	dbmap := &DbMap{Dialect: staleReadDialect{}}
+	dbmap.AddTable(Invoice{}).SetKeys(true, "Id")
+	inv := new(Invoice)
+
+	plan := dbmap.Query(inv).Where().Consistency(Eventual).(*QueryPlan)
+	if plan.executor != dbmap {
+		t.Errorf("Expected eventual reads to use the DbMap without replicas")
+	}
+	query, _, err := plan.Equal(&inv.Memo, "memo").SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	if !strings.Contains(query, `from "invoice" as of system time follower_read_timestamp() where`) {
+		t.Errorf("Expected a stale read clause, got %s", query)
+	}
+	query, _, err = dbmap.Query(inv).Where().Consistency(Strong).SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	if strings.Contains(query, "follower_read_timestamp") {
+		t.Errorf("Expected no stale read clause for strong reads, got %s", query)
+	}
+
+	dbmap.SetReplicas(replicas...)
+	used := make(map[*sql.DB]bool)
+	for i := 0; i < 2; i++ {
+		plan = dbmap.Query(inv).Where().Consistency(Eventual).(*QueryPlan)
+		replica, ok := plan.executor.(*replicaExecutor)
+		if !ok {
+			t.Fatalf("Expected eventual reads to use a replica, got %T", plan.executor)
+		}
+		used[replica.db] = true
+	}
+	if len(used) != 2 {
+		t.Errorf("Expected eventual reads to use each replica in turn, used %d", len(used))
+	}
+	if _, err := plan.Assign(&inv.Memo, "memo").Update(); err != errReplicaWrite {
+		t.Errorf("Expected writes to a replica to fail, got %v", err)
+	}
+	plan.Consistency(Strong)
+	if plan.executor != dbmap {
+		t.Errorf("Expected strong reads to use the DbMap, got %T", plan.executor)
+	}
+}
+
+func TestValidators(t *testing.T) {
+	type Item struct {
+		Id       int64
+		Quantity int32
+		Sku      string
+		Note     *string
+	}
+	for _, c := range []struct {
+		dialect Dialect
+		checks  []string
+	}{
+		{PostgresDialect{}, []string{`"quantity" >= 1`, `"quantity" <= 99.5`, `length("sku") between 2 and 4`, `"sku" ~ '^[A-Z]\d+$'`, `length("note") <= 3`}},
+		{MySQLDialect{}, []string{"`Quantity` >= 1", "`Quantity` <= 99.5", "char_length(`Sku`) between 2 and 4", "`Sku` regexp '^[A-Z]\\\\d+$'", "char_length(`Note`) <= 3"}},
+		{SqliteDialect{}, []string{`"Quantity" >= 1`, `"Quantity" <= 99.5`, `length("Sku") between 2 and 4`, `length("Note") <= 3`}},
+	} {
+		dbmap := &DbMap{Dialect: c.dialect}
+		table := dbmap.AddTable(Item{}).SetKeys(true, "Id")
+		table.ColMap("Quantity").Validate(MinValue(1), MaxValue(99.5))
+		table.ColMap("Sku").Validate(Length(2, 4), Pattern(`^[A-Z]\d+$`))
+		table.ColMap("Note").Validate(Length(0, 3))
+		if checks := table.checkConstraints(c.dialect); !reflect.DeepEqual(checks, c.checks) {
+			t.Errorf("Expected checks %q for %T, got %q", c.checks, c.dialect, checks)
+		}
+	}
+
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	table := dbmap.AddTable(Item{}).SetKeys(true, "Id")
+	table.ColMap("Quantity").Validate(MinValue(1), MaxValue(99.5))
+	table.ColMap("Sku").Validate(Length(2, 4), Pattern(`^[A-Z]\d+$`))
+	table.ColMap("Note").Validate(Length(0, 3))
+	long := "long"
+	for _, c := range []struct {
+		item  Item
+		valid bool
+	}{
+		{Item{Quantity: 1, Sku: "A1"}, true},
+		{Item{Quantity: 99, Sku: "Ü123", Note: &long}, false},
+		{Item{Quantity: 0, Sku: "A1"}, false},
+		{Item{Quantity: 100, Sku: "A1"}, false},
+		{Item{Quantity: 1, Sku: "A12345"}, false},
+		{Item{Quantity: 1, Sku: "a1"}, false},
+	} {
+		err := table.validate(reflect.ValueOf(&c.item).Elem())
+		if c.valid && err != nil {
+			t.Errorf("Expected %v to be valid, got %s", c.item, err)
+		}
+		if _, ok := err.(ValidationError); !c.valid && !ok {
+			t.Errorf("Expected a ValidationError for %v, got %v", c.item, err)
+		}
+	}
+
+	item := new(Item)
+	plan := dbmap.Query(item).Assign(&item.Quantity, 0).(*AssignQueryPlan)
+	if len(plan.Errors) == 0 {
+		t.Errorf("Expected an error for an invalid assignment")
+	}
+	plan = dbmap.Query(item).Assign(&item.Quantity, 5).Assign(&item.Note, nil).(*AssignQueryPlan)
+	if len(plan.Errors) != 0 {
+		t.Errorf("Expected valid assignments, got %v", plan.Errors)
+	}
+}
+
+// fakeTestDriver is a driver that records the statements it runs and
+// the transactions it starts, instead of running them.  Queries return
+// the rows set by returnRows, if any.  Opening a DSN that is marked as
+// down fails, and connections to DSNs holding a number and a duration,
+// e.g. "2 1s", answer every query with the number after sleeping for
+// the duration, unless the query is canceled first.
+type fakeTestDriver struct {
+	mu         sync.Mutex
+	statements []string
+	columns    []string
+	rows       [][]driver.Value
+	down       map[string]bool
+	opens      int
+	canceled   int32
+}
+
+type fakeTestConn struct {
+	driver *fakeTestDriver
+	value  int64
+	sleep  time.Duration
+	fixed  bool
+}
+
+type fakeTestRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (d *fakeTestDriver) Open(dsn string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.opens++
+	if d.down[dsn] {
+		return nil, fmt.Errorf("%s is down", dsn)
+	}
+	conn := fakeTestConn{driver: d}
+	var sleep string
+	if _, err := fmt.Sscan(dsn, &conn.value, &sleep); err == nil {
+		conn.fixed = true
+		if conn.sleep, err = time.ParseDuration(sleep); err != nil {
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// record adds statement to the driver's log.
+func (d *fakeTestDriver) record(statement string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.statements = append(d.statements, statement)
+}
+
+// reset returns the driver's log and clears it, along with the rows
+// set by returnRows.
+func (d *fakeTestDriver) reset() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	statements := d.statements
+	d.statements = nil
+	d.columns = nil
+	d.rows = nil
+	return statements
+}
+
+// returnRows makes every query return rows, with the given columns,
+// until the next reset.
+func (d *fakeTestDriver) returnRows(columns []string, rows ...[]driver.Value) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.columns = columns
+	d.rows = rows
+}
+
+// setDown marks dsn as down, or as up again.
+func (d *fakeTestDriver) setDown(dsn string, down bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.down[dsn] = down
+}
+
+func (c fakeTestConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+func (c fakeTestConn) Close() error { return nil }
+func (c fakeTestConn) Begin() (driver.Tx, error) {
+	c.driver.record("begin")
+	return c, nil
+}
+func (c fakeTestConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.Begin()
+}
+func (c fakeTestConn) Commit() error {
+	c.driver.record("commit")
+	return nil
+}
+func (c fakeTestConn) Rollback() error {
+	c.driver.record("rollback")
+	return nil
+}
+func (c fakeTestConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.driver.record(query)
+	return driver.RowsAffected(1), nil
+}
+func (c fakeTestConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	d := c.driver
+	d.record(query)
+	if c.fixed {
+		select {
+		case <-time.After(c.sleep):
+			return &fakeTestRows{columns: []string{"n"}, rows: [][]driver.Value{{c.value}}}, nil
+		case <-ctx.Done():
+			atomic.AddInt32(&d.canceled, 1)
+			return nil, ctx.Err()
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return &fakeTestRows{columns: d.columns, rows: d.rows}, nil
+}
+
+func (r *fakeTestRows) Columns() []string { return r.columns }
+func (r *fakeTestRows) Close() error      { return nil }
+func (r *fakeTestRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+var fakeDriver = &fakeTestDriver{down: make(map[string]bool)}
+
+func init() {
+	sql.Register("gorp_fake_test", fakeDriver)
+}
+
+func TestCascadeDelete(t *testing.T) {
+	type Post struct {
+		Id int64
+	}
+	type Comment struct {
+		Id     int64
+		PostId int64
+	}
+	type Reply struct {
+		Id        int64
+		CommentId sql.NullInt64
+	}
+	db, err := sql.Open("gorp_fake_test", "")
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	defer db.Close()
+	dbmap := &DbMap{Db: db, Dialect: PostgresDialect{}}
+	dbmap.AddTable(Reply{}).SetKeys(true, "Id")
+	dbmap.AddTable(Comment{}).SetKeys(true, "Id").
+		HasMany(Reply{}, "CommentId").
+		OnDelete(CascadeNullify)
+	dbmap.AddTable(Post{}).SetKeys(true, "Id").
+		HasMany(Comment{}, "PostId").
+		OnDelete(CascadeDelete)
+	fakeDriver.reset()
+
+	if _, err = dbmap.Delete(&Post{Id: 1}); err != nil {
+		t.Fatalf("Failed to delete: %s", err)
+	}
+	expected := []string{
+		"begin",
+		`update "reply" set "commentid" = null where "commentid" in (select "id" from "comment" where "postid" in ($1))`,
+		`delete from "comment" where "postid" in ($1)`,
+		`delete from "post" where "id"=$1;`,
+		"commit",
+	}
+	if statements := fakeDriver.reset(); !reflect.DeepEqual(statements, expected) {
+		t.Errorf("Expected statements %q, got %q", expected, statements)
+	}
+
+	post := new(Post)
+	if _, err = dbmap.Query(post).Where().Equal(&post.Id, 1).Delete(); err != nil {
+		t.Fatalf("Failed to delete: %s", err)
+	}
+	keys := `select "post"."id" from "post" where "post"."id"=$1`
+	expected = []string{
+		"begin",
+		`update "reply" set "commentid" = null where "commentid" in (select "id" from "comment" where "postid" in (` + keys + `))`,
+		`delete from "comment" where "postid" in (` + keys + `)`,
+		`delete from "post" where "post"."id"=$1`,
+		"commit",
+	}
+	if statements := fakeDriver.reset(); !reflect.DeepEqual(statements, expected) {
+		t.Errorf("Expected statements %q, got %q", expected, statements)
+	}
+
+	// Actions on foreign keys are left to the database.
+	comments, _ := dbmap.tableFor(reflect.TypeOf(Comment{}), false)
+	comments.ColMap("PostId").References(Post{})
+	posts, _ := dbmap.tableFor(reflect.TypeOf(Post{}), false)
+	if len(posts.cascades()) != 0 {
+		t.Errorf("Expected the cascade to be left to the foreign key")
+	}
+	fks, err := comments.foreignKeys()
+	if err != nil {
+		t.Fatalf("Failed to get foreign keys: %s", err)
+	}
+	if clause := fks[0].clause(dbmap.Dialect); !strings.HasSuffix(clause, " on delete cascade") {
+		t.Errorf("Expected an on delete clause, got %s", clause)
+	}
+
+	// Delete actions can be declared on relations, before the child's
+	// table is registered.
+	type Tag struct {
+		Id     int64
+		NoteId int64
+	}
+	type Note struct {
+		Id   int64
+		Tags []Tag
+	}
+	notes := dbmap.AddTable(Note{}).SetKeys(true, "Id")
+	notes.ColMap("Tags").HasMany("NoteId").OnDelete(CascadeDelete)
+	dbmap.AddTable(Tag{}).SetKeys(true, "Id")
+	if assoc := notes.HasMany(Tag{}, "NoteId"); len(notes.hasMany) != 1 || assoc != notes.hasMany[0] || assoc.onDelete != CascadeDelete {
+		t.Errorf("Expected the relation and the association to be the same, got %v", notes.hasMany)
+	}
+	fakeDriver.reset()
+	if _, err = dbmap.Delete(&Note{Id: 1}); err != nil {
+		t.Fatalf("Failed to delete: %s", err)
+	}
+	expected = []string{
+		"begin",
+		`delete from "tag" where "noteid" in ($1)`,
+		`delete from "note" where "id"=$1;`,
+		"commit",
+	}
+	if statements := fakeDriver.reset(); !reflect.DeepEqual(statements, expected) {
+		t.Errorf("Expected statements %q, got %q", expected, statements)
+	}
+
+	type Pair struct {
+		A, B int64
+		Tags []Tag
+	}
+	dbmap.AddTable(Pair{}).SetKeys(false, "A", "B").
+		ColMap("Tags").HasMany("NoteId").OnDelete(CascadeDelete)
+	pair := new(Pair)
+	if _, err = dbmap.Query(pair).Where().Equal(&pair.A, 1).Delete(); err == nil {
+		t.Errorf("Expected an error for cascading deletes from a composite key")
+	}
+	if _, err = dbmap.Delete(&Pair{A: 1, B: 2}); err == nil {
+		t.Errorf("Expected an error for cascading deletes from a composite key")
+	}
+	for _, statement := range fakeDriver.reset() {
+		if strings.HasPrefix(statement, "delete") {
+			t.Errorf("Expected no deletes, got %q", statement)
+		}
+	}
+}
+
+func TestHedge(t *testing.T) {
+	open := func(dsn string) *sql.DB {
+		db, err := sql.Open("gorp_fake_test", dsn)
+		if err != nil {
+			t.Fatalf("Failed to open database: %s", err)
+		}
+		return db
+	}
+	primary, slow, fast := open("0 0s"), open("1 10s"), open("2 0s")
+	defer primary.Close()
+	defer slow.Close()
+	defer fast.Close()
+	dbmap := &DbMap{Db: primary, Dialect: PostgresDialect{}}
+	dbmap.AddTable(Invoice{}).SetKeys(true, "Id")
+	dbmap.SetReplicas(slow, fast)
+	inv := new(Invoice)
+
+	plan := dbmap.Query(inv).Where().Hedge(10 * time.Millisecond).(*QueryPlan)
+	hedged, ok := plan.executor.(*hedgedExecutor)
+	if !ok || hedged.first != dbmap {
+		t.Fatalf("Expected a hedged executor for the DbMap, got %T", plan.executor)
+	}
+	if first, second := hedged.dbs(); first != primary || second != primary {
+		t.Errorf("Expected strong reads to be hedged on the primary only")
+	}
+
+	plan.Consistency(Eventual)
+	if _, ok := hedged.first.(*replicaExecutor); !ok {
+		t.Fatalf("Expected eventual reads to be hedged from a replica, got %T", hedged.first)
+	}
+	hedged.first = &replicaExecutor{dbmap: dbmap, db: slow}
+	start := time.Now()
+	n, err := hedged.SelectInt("select n")
+	if err != nil {
+		t.Fatalf("Failed to select: %s", err)
+	}
+	if n != 2 {
+		t.Errorf("Expected the other replica's response, got %d", n)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the hedged attempt to respond first, took %s", elapsed)
+	}
+	for i := 0; i < 100 && atomic.LoadInt32(&fakeDriver.canceled) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if atomic.LoadInt32(&fakeDriver.canceled) == 0 {
+		t.Errorf("Expected the slow attempt to be canceled")
+	}
+	fakeDriver.reset()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	dbmap.Db = slow
+	plan = dbmap.QueryContext(ctx, inv).Where().Hedge(5 * time.Millisecond).(*QueryPlan)
+	start = time.Now()
+	if _, err = plan.executor.SelectInt("select n"); err != context.DeadlineExceeded {
+		t.Errorf("Expected the plan's deadline to cancel both attempts, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the attempts to stop at the plan's deadline, took %s", elapsed)
+	}
+	dbmap.Db = primary
+	fakeDriver.reset()
+
+	plan = query(dbmap, &Transaction{dbmap: dbmap}, inv).Where().Hedge(time.Millisecond).(*QueryPlan)
+	if len(plan.Errors) == 0 {
+		t.Errorf("Expected an error for hedging in a transaction")
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	type Post struct {
+		Id        int64
+		Title     string
+		DeletedAt *time.Time
+	}
+	db, err := sql.Open("gorp_fake_test", "")
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	defer db.Close()
+	dbmap := &DbMap{Db: db, Dialect: PostgresDialect{}}
+	dbmap.AddTable(Post{}).SetKeys(true, "Id").SetSoftDelete("DeletedAt")
+	fakeDriver.reset()
+
+	post := new(Post)
+	if _, err = dbmap.Query(post).Where().Equal(&post.Id, 1).Delete(); err != nil {
+		t.Fatalf("Failed to delete: %s", err)
+	}
+	expected := []string{`update "post" set "deletedat" = $1 where "post"."id"=$2 and "post"."deletedat" is null`}
+	if statements := fakeDriver.reset(); !reflect.DeepEqual(statements, expected) {
+		t.Errorf("Expected statements %q, got %q", expected, statements)
+	}
+
+	post = new(Post)
+	if _, err = dbmap.Query(post).Unscoped().Where().Equal(&post.Id, 1).Delete(); err != nil {
+		t.Fatalf("Failed to delete: %s", err)
+	}
+	expected = []string{`delete from "post" where "post"."id"=$1`}
+	if statements := fakeDriver.reset(); !reflect.DeepEqual(statements, expected) {
+		t.Errorf("Expected statements %q, got %q", expected, statements)
+	}
+
+	post = new(Post)
+	query, _, err := dbmap.Query(post).Where().Equal(&post.Title, "a").SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate SQL: %s", err)
+	}
+	if !strings.HasSuffix(query, `where "post"."title"=$1 and "post"."deletedat" is null`) {
+		t.Errorf("Expected select to skip deleted rows, got %s", query)
+	}
+
+	post = new(Post)
+	query, _, err = dbmap.Query(post).Unscoped().SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate SQL: %s", err)
+	}
+	if strings.Contains(query, "deletedat\" is null") {
+		t.Errorf("Expected unscoped select to include deleted rows, got %s", query)
+	}
+
+	type Comment struct {
+		Id     int64
+		PostId int64
+		Body   string
+	}
+	dbmap.AddTable(Comment{}).SetKeys(true, "Id")
+	post = new(Post)
+	comment := new(Comment)
+	query, _, err = dbmap.Query(comment).Join(post).On().Equal(&post.Id, &comment.PostId).Where().Equal(&post.Title, "a").SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate SQL: %s", err)
+	}
+	if !strings.HasSuffix(query, `where "post"."title"=$1 and "post"."deletedat" is null`) {
+		t.Errorf("Expected select to skip deleted rows of joined tables, got %s", query)
+	}
+	query, _, err = dbmap.Query(comment).WithDeleted().Join(post).On().Equal(&post.Id, &comment.PostId).Where().Equal(&post.Title, "a").SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate SQL: %s", err)
+	}
+	if strings.Contains(query, "deletedat\" is null") {
+		t.Errorf("Expected select with deleted rows to include deleted rows of joined tables, got %s", query)
+	}
+
+	dbmap = &DbMap{Db: db, Dialect: PostgresDialect{}}
+	table := dbmap.AddTable(Post{}).SetKeys(true, "Id")
+	post = new(Post)
+	if _, err = dbmap.Query(post).Where().Equal(&post.Title, "a").Select(); err != nil {
+		t.Fatalf("Failed to select: %s", err)
+	}
+	table.SetSoftDelete("DeletedAt")
+	fakeDriver.reset()
+	if _, err = dbmap.Query(post).Where().Equal(&post.Title, "a").Select(); err != nil {
+		t.Fatalf("Failed to select: %s", err)
+	}
+	if statements := fakeDriver.reset(); len(statements) != 1 || !strings.HasSuffix(statements[0], `"post"."deletedat" is null`) {
+		t.Errorf("Expected selects cached before SetSoftDelete to be discarded, got %q", statements)
+	}
+}
+
+func TestShadow(t *testing.T) {
+	type Post struct {
+		Id    int64
+		Title string
+	}
+	db, err := sql.Open("gorp_fake_test", "")
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	defer db.Close()
+	dbmap := &DbMap{Db: db, Dialect: PostgresDialect{}}
+	table := dbmap.AddTable(Post{}).SetKeys(false, "Id").SetShadow(nil, "", "post_v2", ShadowBestEffort)
+	fakeDriver.reset()
+
+	post := &Post{Id: 1, Title: "a"}
+	if err = dbmap.Insert(post); err != nil {
+		t.Fatalf("Failed to insert: %s", err)
+	}
+	if _, err = dbmap.Delete(post); err != nil {
+		t.Fatalf("Failed to delete: %s", err)
+	}
+	expected := []string{
+		`insert into "post" ("id","title") values ($1,$2);`,
+		`insert into "post_v2" ("id","title") values ($1,$2);`,
+		`delete from "post" where "id"=$1;`,
+		`delete from "post_v2" where "id"=$1;`,
+	}
+	if statements := fakeDriver.reset(); !reflect.DeepEqual(statements, expected) {
+		t.Errorf("Expected statements %q, got %q", expected, statements)
+	}
+
+	table.ResetSql()
+	if table.shadow.table != nil {
+		t.Errorf("Expected ResetSql to discard the shadow table")
+	}
+
+	shadowDb, err := sql.Open("gorp_fake_test", "shadow")
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	defer shadowDb.Close()
+	fakeDriver.setDown("shadow", true)
+	defer fakeDriver.setDown("shadow", false)
+	target := &DbMap{Db: shadowDb, Dialect: PostgresDialect{}}
+	var handled []error
+	table.SetShadow(target, "", "", ShadowBestEffort).OnShadowError(func(table *TableMap, err error) {
+		handled = append(handled, err)
+	})
+	if err = dbmap.Insert(&Post{Id: 2}); err != nil {
+		t.Errorf("Expected best effort shadow errors to be handled, got %s", err)
+	}
+	table.shadow.policy = ShadowStrict
+	if err = dbmap.Insert(&Post{Id: 3}); err == nil {
+		t.Errorf("Expected strict shadow errors to be returned")
+	}
+	if len(handled) != 2 {
+		t.Errorf("Expected the error handler to be called for both writes, got %v", handled)
+	}
+	expected = []string{
+		`insert into "post" ("id","title") values ($1,$2);`,
+		`insert into "post" ("id","title") values ($1,$2);`,
+	}
+	if statements := fakeDriver.reset(); !reflect.DeepEqual(statements, expected) {
+		t.Errorf("Expected only primary writes, got %q", statements)
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	type Post struct {
+		Id    int64
+		Title string
+		Slug  string
+	}
+	db, err := sql.Open("gorp_fake_test", "")
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	defer db.Close()
+	dbmap := &DbMap{Db: db, Dialect: PostgresDialect{}}
+	table := dbmap.AddTable(Post{}).SetKeys(false, "Id")
+	table.ColMap("Slug").SetOptional(true)
+	fakeDriver.reset()
+
+	fakeDriver.returnRows([]string{"id", "title"})
+	if err = dbmap.ValidateSchema(); err != nil {
+		t.Fatalf("Expected a missing optional column to be allowed, got %s", err)
+	}
+	fakeDriver.reset()
+	if err = dbmap.Insert(&Post{Id: 1}); err != nil {
+		t.Fatalf("Failed to insert: %s", err)
+	}
+	expected := []string{`insert into "post" ("id","title") values ($1,$2);`}
+	if statements := fakeDriver.reset(); !reflect.DeepEqual(statements, expected) {
+		t.Errorf("Expected the missing column to be left out, got %q", statements)
+	}
+
+	table.ColMap("Slug").SetOptional(false)
+	if err = dbmap.Insert(&Post{Id: 2}); err != nil {
+		t.Fatalf("Failed to insert: %s", err)
+	}
+	expected = []string{`insert into "post" ("id","title","slug") values ($1,$2,$3);`}
+	if statements := fakeDriver.reset(); !reflect.DeepEqual(statements, expected) {
+		t.Errorf("Expected a required column to be included again, got %q", statements)
+	}
+
+	fakeDriver.returnRows([]string{"id", "title"})
+	err = dbmap.ValidateSchema()
+	schemaErr, ok := err.(SchemaError)
+	if !ok || !reflect.DeepEqual(schemaErr.MissingColumns, map[string][]string{"Post": {"Slug"}}) {
+		t.Errorf("Expected a SchemaError for the missing column, got %v", err)
+	}
+	fakeDriver.reset()
+}
+
+// listPool is a RowPool that hands out the values put back in it.
+type listPool struct {
+	free []interface{}
+	puts int
+}
+
+func (p *listPool) Get() interface{} {
+	if len(p.free) == 0 {
+		return nil
+	}
+	row := p.free[len(p.free)-1]
+	p.free = p.free[:len(p.free)-1]
+	return row
+}
+
+func (p *listPool) Put(row interface{}) {
+	p.puts++
+	p.free = append(p.free, row)
+}
+
+func TestSelectPooled(t *testing.T) {
+	type Post struct {
+		Id    int64
+		Title string
+		Views int64
+	}
+	db, err := sql.Open("gorp_fake_test", "")
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	defer db.Close()
+	dbmap := &DbMap{Db: db, Dialect: PostgresDialect{}}
+	dbmap.AddTable(Post{}).SetKeys(false, "Id")
+	fakeDriver.reset()
+
+	pool := &listPool{free: []interface{}{"not a post", &Post{Views: 10}}}
+	fakeDriver.returnRows([]string{"Id", "Title"}, []driver.Value{int64(1), "a"}, []driver.Value{int64(2), "b"})
+	var loaded []Post
+	var rows []*Post
+	selector, ok := dbmap.Query(new(Post)).(PooledSelector)
+	if !ok {
+		t.Fatalf("Expected query plans to implement PooledSelector")
+	}
+	err = selector.SelectPooled(pool, func(row interface{}) error {
+		post := row.(*Post)
+		loaded = append(loaded, *post)
+		rows = append(rows, post)
+		return nil
+	})
+	fakeDriver.reset()
+	if err != nil {
+		t.Fatalf("Failed to select: %s", err)
+	}
+	expected := []Post{{Id: 1, Title: "a"}, {Id: 2, Title: "b"}}
+	if !reflect.DeepEqual(loaded, expected) {
+		t.Errorf("Expected zeroed pooled rows %v, got %v", expected, loaded)
+	}
+	if len(rows) != 2 || rows[0] != rows[1] {
+		t.Errorf("Expected the pooled row to be reused")
+	}
+	if pool.puts != 2 || pool.free[len(pool.free)-1] != rows[0] {
+		t.Errorf("Expected every row to be put back in the pool, got %d puts", pool.puts)
+	}
+}
+
+func TestSetInterned(t *testing.T) {
+	type Post struct {
+		Id     int64
+		Status string
+		Label  sql.NullString
+		Title  string
+	}
+	db, err := sql.Open("gorp_fake_test", "")
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	defer db.Close()
+	dbmap := &DbMap{Db: db, Dialect: PostgresDialect{}}
+	table := dbmap.AddTable(Post{}).SetKeys(false, "Id")
+	table.ColMap("Status").SetInterned(true)
+	table.ColMap("Label").SetInterned(true)
+	fakeDriver.reset()
+
+	// Byte slices are copied into each row's strings by database/sql.
+	fakeDriver.returnRows([]string{"Id", "Status", "Label", "Title"},
+		[]driver.Value{int64(1), []byte("open"), []byte("bug"), []byte("hello")},
+		[]driver.Value{int64(2), []byte("open"), []byte("bug"), []byte("hello")})
+	results, err := dbmap.Query(new(Post)).Select()
+	fakeDriver.reset()
+	if err != nil {
+		t.Fatalf("Failed to select: %s", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 rows, got %d", len(results))
+	}
+	first, second := results[0].(*Post), results[1].(*Post)
+	if first.Status != "open" || first.Label.String != "bug" || !first.Label.Valid {
+		t.Errorf("Expected values to be loaded, got %+v", first)
+	}
+	if unsafe.StringData(first.Status) != unsafe.StringData(second.Status) {
+		t.Errorf("Expected interned strings to share their data")
+	}
+	if unsafe.StringData(first.Label.String) != unsafe.StringData(second.Label.String) {
+		t.Errorf("Expected interned null strings to share their data")
+	}
+	if unsafe.StringData(first.Title) == unsafe.StringData(second.Title) {
+		t.Errorf("Expected strings that aren't interned to be copied")
+	}
+}
+
+func TestColumnGroups(t *testing.T) {
+	type Post struct {
+		Id      int64
+		Title   string
+		Body    string
+		Summary string
+	}
+	db, err := sql.Open("gorp_fake_test", "")
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	defer db.Close()
+	dbmap := &DbMap{Db: db, Dialect: PostgresDialect{}}
+	dbmap.AddTable(Post{}).SetKeys(false, "Id").
+		ColumnGroup("list", "Title").
+		ColumnGroup("content", "Body", "Summary")
+	fakeDriver.reset()
+
+	post := new(Post)
+	query, _, err := dbmap.Query(post).OnlyGroups("list").SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate select: %s", err)
+	}
+	if expected := `select "post"."id","post"."title" from "post"`; query != expected {
+		t.Errorf("Expected %q, got %q", expected, query)
+	}
+	if _, _, err = dbmap.Query(post).OnlyGroups("list", "missing").SQL(); err == nil {
+		t.Errorf("Expected an error for an unknown column group")
+	}
+
+	loaded := &Post{Id: 7, Title: "a"}
+	fakeDriver.returnRows([]string{"body", "summary"}, []driver.Value{"text", "short"})
+	if err = dbmap.LoadGroup(loaded, "content"); err != nil {
+		t.Fatalf("Failed to load group: %s", err)
+	}
+	expected := []string{`select "body","summary" from "post" where "id"=$1`}
+	if statements := fakeDriver.reset(); !reflect.DeepEqual(statements, expected) {
+		t.Errorf("Expected statements %q, got %q", expected, statements)
+	}
+	if *loaded != (Post{Id: 7, Title: "a", Body: "text", Summary: "short"}) {
+		t.Errorf("Expected the group's fields to be loaded, got %+v", loaded)
+	}
+	if err = dbmap.LoadGroup(loaded, "missing"); err == nil {
+		t.Errorf("Expected an error for an unknown column group")
+	}
+}
+
+func TestAutoTimestamps(t *testing.T) {
+	type Post struct {
+		Id      int64
+		Title   string
+		Created time.Time
+		Updated time.Time
+	}
+	db, err := sql.Open("gorp_fake_test", "")
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	defer db.Close()
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	dbmap := &DbMap{Db: db, Dialect: PostgresDialect{}}
+	dbmap.SetClock(func() time.Time { return now })
+	table := dbmap.AddTable(Post{}).SetKeys(true, "Id")
+	table.ColMap("Created").SetAutoCreateTime(true)
+	table.ColMap("Updated").SetAutoUpdateTime(true)
+
+	post := new(Post)
+	query, args, err := dbmap.Query(post).Assign(&post.Title, "a").SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate SQL: %s", err)
+	}
+	expected := `insert into "post" ("title", "created", "updated") values ($1, $2, $3)`
+	if query != expected {
+		t.Errorf("Expected %s, got %s", expected, query)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"a", now, now}) {
+		t.Errorf("Expected the clock's time to be assigned, got %v", args)
+	}
+
+	post = new(Post)
+	plan := dbmap.Query(post).Assign(&post.Title, "a").Where().Equal(&post.Id, 1)
+	fakeDriver.reset()
+	if _, err = plan.Update(); err != nil {
+		t.Fatalf("Failed to update: %s", err)
+	}
+	statements := []string{`update "post" set "title"=$1, "updated"=$2 where "post"."id"=$3`}
+	if recorded := fakeDriver.reset(); !reflect.DeepEqual(recorded, statements) {
+		t.Errorf("Expected statements %q, got %q", statements, recorded)
+	}
+
+	post = new(Post)
+	earlier := now.Add(-time.Hour)
+	query, args, err = dbmap.Query(post).Assign(&post.Updated, earlier).Where().Equal(&post.Id, 1).SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate SQL: %s", err)
+	}
+	expected = `update "post" set "updated"=$1 where "post"."id"=$2`
+	if query != expected || args[0] != earlier {
+		t.Errorf("Expected explicit assignments to be kept, got %s %v", query, args)
+	}
+}
+
+func TestExportSnapshot(t *testing.T) {
+	type Owner struct {
+		Id   int64
+		Name string
+	}
+	type Pet struct {
+		Id      int64
+		OwnerId int64
+		Photo   []byte
+		Born    time.Time
+	}
+	db, err := sql.Open("gorp_fake_test", "")
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	defer db.Close()
+	taken := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	dbmap := &DbMap{Db: db, Dialect: PostgresDialect{}}
+	dbmap.SetClock(func() time.Time { return taken })
+	dbmap.AddTable(Pet{}).SetKeys(true, "Id").ColMap("OwnerId").References(Owner{})
+	dbmap.AddTable(Owner{}).SetKeys(true, "Id")
+	fakeDriver.reset()
+
+	var out bytes.Buffer
+	err = dbmap.ExportSnapshot(&out,
+		SnapshotSpec{Model: Pet{}, Where: `"ownerid" = ?`, Args: []interface{}{1}},
+		SnapshotSpec{Model: Owner{}, Where: `"id" = ?`, Args: []interface{}{1}})
+	if err != nil {
+		t.Fatalf("Failed to export snapshot: %s", err)
+	}
+	expected := []string{
+		"begin",
+		`select "id", "name" from "owner" where "id" = $1`,
+		`select "id", "ownerid", "photo", "born" from "pet" where "ownerid" = $1`,
+		"rollback",
+	}
+	if statements := fakeDriver.reset(); !reflect.DeepEqual(statements, expected) {
+		t.Errorf("Expected statements %q, got %q", expected, statements)
+	}
+	expectedOut := `{"format":"gorp-snapshot/1","taken_at":"2020-01-02T03:04:05Z"}
+{"table":"Owner","columns":["Id","Name"]}
+{"table":"Pet","columns":["Id","OwnerId","Photo","Born"]}
+`
+	if out.String() != expectedOut {
+		t.Errorf("Expected snapshot %q, got %q", expectedOut, out.String())
+	}
+
+	snapshot := expectedOut[:strings.Index(expectedOut, "\n{\"table\":\"Pet\"")+1] +
+		`[1,"Alice"]
+{"table":"Pet","columns":["Id","OwnerId","Photo","Born"]}
+[7,1,{"$bytes":"/w=="},"2019-05-06T00:00:00Z"]
+`
+	if err = dbmap.RestoreSnapshot(strings.NewReader(snapshot)); err != nil {
+		t.Fatalf("Failed to restore snapshot: %s", err)
+	}
+	expected = []string{
+		"begin",
+		`delete from "pet" where "id" = $1`,
+		`delete from "owner" where "id" = $1`,
+		`insert into "owner" ("id", "name") values ($1, $2)`,
+		`insert into "pet" ("id", "ownerid", "photo", "born") values ($1, $2, $3, $4)`,
+		"commit",
+	}
+	if statements := fakeDriver.reset(); !reflect.DeepEqual(statements, expected) {
+		t.Errorf("Expected statements %q, got %q", expected, statements)
+	}
+
+	tables, err := dbmap.readSnapshot(strings.NewReader(snapshot))
+	if err != nil {
+		t.Fatalf("Failed to read snapshot: %s", err)
+	}
+	row := tables[1].rows[0]
+	expectedRow := []interface{}{int64(7), int64(1), []byte{0xff}, time.Date(2019, 5, 6, 0, 0, 0, 0, time.UTC)}
+	if !reflect.DeepEqual(row, expectedRow) {
+		t.Errorf("Expected row %#v, got %#v", expectedRow, row)
+	}
+
+	if err = dbmap.RestoreSnapshot(strings.NewReader(`{"format":"other"}`)); err == nil {
+		t.Errorf("Expected an error for an unknown snapshot format")
+	}
+
+	type Account struct {
+		Id        int64
+		TenantId  int64
+		Card      string
+		Salary    int64
+		DeletedAt *time.Time
+	}
+	dbmap = &DbMap{Db: db, Dialect: PostgresDialect{}}
+	dbmap.SetClock(func() time.Time { return taken })
+	accounts := dbmap.AddTable(Account{}).SetKeys(true, "Id").SetTenantColumn("TenantId").SetSoftDelete("DeletedAt")
+	accounts.ColMap("Card").SetMask(MaskLast(4), "billing")
+	accounts.ColMap("Salary").SetReadAccess(func(ctx context.Context) bool { return false })
+	if err = dbmap.ExportSnapshot(&out, SnapshotSpec{Model: Account{}}); err == nil {
+		t.Errorf("Expected an error for a tenant table without a tenant")
+	}
+	fakeDriver.reset()
+	fakeDriver.returnRows([]string{"id", "tenantid", "card", "deletedat"}, []driver.Value{int64(1), int64(5), "4111111111111111", nil})
+	out.Reset()
+	ctx := WithTenant(context.Background(), 5)
+	if err = dbmap.ExportSnapshotContext(ctx, &out, SnapshotSpec{Model: Account{}, Where: `"id" = ?`, Args: []interface{}{1}}); err != nil {
+		t.Fatalf("Failed to export snapshot: %s", err)
+	}
+	expected = []string{
+		"begin",
+		`select "id", "tenantid", "card", "deletedat" from "account" where "tenantid" = $1 and "deletedat" is null and ("id" = $2)`,
+		"rollback",
+	}
+	if statements := fakeDriver.reset(); !reflect.DeepEqual(statements, expected) {
+		t.Errorf("Expected statements %q, got %q", expected, statements)
+	}
+	expectedOut = `{"format":"gorp-snapshot/1","taken_at":"2020-01-02T03:04:05Z"}
+{"table":"Account","columns":["Id","TenantId","Card","DeletedAt"]}
+[1,5,"************1111",null]
+`
+	if out.String() != expectedOut {
+		t.Errorf("Expected snapshot %q, got %q", expectedOut, out.String())
+	}
+
+	fakeDriver.returnRows([]string{"id", "tenantid", "card", "salary", "deletedat"}, []driver.Value{int64(1), int64(5), "4111111111111111", int64(100), nil})
+	out.Reset()
+	if err = dbmap.ExportSnapshotContext(ctx, &out, SnapshotSpec{Model: Account{}, WithDeleted: true, Unmasked: true}); err != nil {
+		t.Fatalf("Failed to export snapshot: %s", err)
+	}
+	expected = []string{
+		"begin",
+		`select "id", "tenantid", "card", "salary", "deletedat" from "account" where "tenantid" = $1`,
+		"rollback",
+	}
+	if statements := fakeDriver.reset(); !reflect.DeepEqual(statements, expected) {
+		t.Errorf("Expected statements %q, got %q", expected, statements)
+	}
+	if !strings.Contains(out.String(), `[1,5,"4111111111111111",100,null]`) {
+		t.Errorf("Expected unmasked values, got %q", out.String())
+	}
+}
+
+func TestMapResultColumn(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTableWithName(Invoice{}, "legacy_invoices").SetKeys(true, "Id")
+	inv := new(Invoice)
+	query, _, err := dbmap.Query(inv).
+		Where().
+		Equal(&inv.Memo, "a").
+		MapResultColumn("memo_text", &inv.Memo).
+		SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate SQL: %s", err)
+	}
+	if !strings.Contains(query, `"legacy_invoices"."memo_text" as "memo"`) {
+		t.Errorf("Expected the mapped column to be aliased, got %s", query)
+	}
+	if !strings.HasSuffix(query, `where "legacy_invoices"."memo_text"=$1`) {
+		t.Errorf("Expected filters to use the mapped column, got %s", query)
+	}
+
+	inv = new(Invoice)
+	query, _, err = dbmap.Query(inv).Where().Equal(&inv.Memo, "a").SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate SQL: %s", err)
+	}
+	if strings.Contains(query, "memo_text") {
+		t.Errorf("Expected other plans not to use the mapped column, got %s", query)
+	}
+
+	var other int
+	if _, _, err = dbmap.Query(inv).Where().MapResultColumn("x", &other).SQL(); err == nil {
+		t.Errorf("Expected an error for a pointer to another value")
+	}
+}
+
+func TestQueryPlanVersionCheck(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTable(Person{}).SetKeys(true, "Id").SetVersionCol("Version")
+	p := &Person{Id: 1, Version: 3}
+	query, args, err := dbmap.Query(p).Assign(&p.LName, "a").Where().Equal(&p.Id, 1).SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate SQL: %s", err)
+	}
+	expected := `update "person" set "lname"=$1, "version"=$2 where ("person"."id"=$3 and "person"."version"=$4)`
+	if query != expected {
+		t.Errorf("Expected %s, got %s", expected, query)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"a", int64(4), 1, int64(3)}) {
+		t.Errorf("Expected the next and current versions, got %v", args)
+	}
+
+	p = &Person{Id: 1}
+	query, _, err = dbmap.Query(p).Assign(&p.LName, "a").Where().Equal(&p.Id, 1).SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate SQL: %s", err)
+	}
+	expected = `update "person" set "lname"=$1 where "person"."id"=$2`
+	if query != expected {
+		t.Errorf("Expected targets without a version not to be checked, got %s", query)
+	}
+
+	p = &Person{Id: 1, Version: 3}
+	query, _, err = dbmap.Query(p).Assign(&p.LName, "a").Where().Equal(&p.LName, "b").SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate SQL: %s", err)
+	}
+	expected = `update "person" set "lname"=$1 where "person"."lname"=$2`
+	if query != expected {
+		t.Errorf("Expected updates that don't select the target's row not to be checked, got %s", query)
+	}
+	query, _, err = dbmap.Query(p).Assign(&p.LName, "a").Where().Equal(&p.Id, 2).SQL()
+	if err != nil || strings.Contains(query, `"version"`) {
+		t.Errorf("Expected updates of another row not to be checked, got %s (%v)", query, err)
+	}
+
+	db, err := sql.Open("gorp_fake_test", "")
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	defer db.Close()
+	defer fakeDriver.reset()
+	dbmap.Db = db
+	fakeDriver.reset()
+	plan := dbmap.Query(p).Assign(&p.LName, "a").Where().Equal(&p.Id, 1)
+	for i := 0; i < 2; i++ {
+		if _, err = plan.Update(); err != nil {
+			t.Fatalf("Failed to update: %s", err)
+		}
+	}
+	statements := fakeDriver.reset()
+	if len(statements) != 2 || statements[0] != statements[1] || !strings.Contains(statements[1], `"person"."version"=$`) {
+		t.Errorf("Expected each update to check the version, got %q", statements)
+	}
+	if p.Version != 5 {
+		t.Errorf("Expected version 5 after two updates, got %d", p.Version)
+	}
+}
+
+func TestStrictMapping(t *testing.T) {
+	type Valid struct {
+		Id       int64
+		Tags     map[string]bool `db:"-"`
+		Callback func()          `db:"-"`
+	}
+	type Duplicate struct {
+		Id   int64
+		Name string
+		Nmae string `db:"name"`
+	}
+	type BadTag struct {
+		Id   int64
+		Name string `db:"name,omitempty"`
+	}
+	type BadType struct {
+		Id    int64
+		Attrs map[string]string
+	}
+	addTable := func(i interface{}) (message string) {
+		defer func() {
+			if r := recover(); r != nil {
+				message = fmt.Sprint(r)
+			}
+		}()
+		dbmap := &DbMap{Dialect: PostgresDialect{}}
+		dbmap.SetStrict(true).AddTable(i)
+		return ""
+	}
+	if message := addTable(Valid{}); message != "" {
+		t.Errorf("Expected a valid struct to be mapped, got %s", message)
+	}
+	for i, test := range []struct {
+		model    interface{}
+		contains string
+	}{
+		{Duplicate{}, "fields Name and Nmae both map to column name"},
+		{BadTag{}, `field Name has db tag "name,omitempty"`},
+		{BadType{}, "field Attrs of type map[string]string can't be stored"},
+	} {
+		if message := addTable(test.model); !strings.Contains(message, test.contains) {
+			t.Errorf("%d: Expected a panic containing %q, got %q", i, test.contains, message)
+		}
+	}
+
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	table := dbmap.AddTable(Valid{})
+	unmapped := table.unmappedColumns(map[string]struct{}{"id": {}, "tags": {}, "legacy": {}})
+	if !reflect.DeepEqual(unmapped, []string{"legacy", "tags"}) {
+		t.Errorf("Expected unmapped columns legacy and tags, got %v", unmapped)
+	}
+}
+
+func TestWriteLimit(t *testing.T) {
+	bucket := &tokenBucket{rate: 10, burst: 2, tokens: 2}
+	start := time.Now()
+	for i, expected := range []time.Duration{0, 0, 100 * time.Millisecond, 200 * time.Millisecond} {
+		if wait := bucket.reserve(start); wait != expected {
+			t.Errorf("%d: Expected to wait %s, got %s", i, expected, wait)
+		}
+	}
+	if wait := bucket.reserve(start.Add(time.Second)); wait != 0 {
+		t.Errorf("Expected the bucket to refill, got a wait of %s", wait)
+	}
+
+	db, err := sql.Open("gorp_fake_test", "")
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	defer db.Close()
+	dbmap := &DbMap{Db: db, Dialect: PostgresDialect{}}
+	dbmap.AddTable(Invoice{}).SetKeys(true, "Id").SetWriteLimit(100, 1)
+	inv := new(Invoice)
+	start = time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err = dbmap.Query(inv).Assign(&inv.Memo, "a").Where().Equal(&inv.Id, 1).Update(); err != nil {
+			t.Fatalf("Failed to update: %s", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf("Expected writes over the limit to wait, took %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	dbmap.Query(inv).Assign(&inv.Memo, "a").Where().Equal(&inv.Id, 1).Update()
+	_, err = dbmap.QueryContext(ctx, inv).Assign(&inv.Memo, "a").Where().Equal(&inv.Id, 1).Update()
+	if err != context.Canceled {
+		t.Errorf("Expected writes to stop waiting when the context is done, got %v", err)
+	}
+
+	tx, err := dbmap.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin: %s", err)
+	}
+	defer tx.Rollback()
+	if err = withContext(ctx, dbmap, tx).Insert(&Invoice{Memo: "a"}); err != context.Canceled {
+		t.Errorf("Expected transaction writes to stop waiting when the context is done, got %v", err)
+	}
+	fakeDriver.reset()
+}
+
+type hookedPost struct {
+	Id      int64
+	Title   string
+	Updated int64
+	calls   []string
+}
+
+func (p *hookedPost) PreInsert(SqlExecutor) error {
+	p.calls = append(p.calls, "PreInsert "+p.Title)
+	p.Updated = 10
+	return nil
+}
+
+func (p *hookedPost) PostInsert(SqlExecutor) error {
+	p.calls = append(p.calls, "PostInsert")
+	return nil
+}
+
+func (p *hookedPost) PreUpdate(SqlExecutor) error {
+	p.calls = append(p.calls, "PreUpdate "+p.Title)
+	p.Updated = 20
+	p.Title = "ignored"
+	return nil
+}
+
+func (p *hookedPost) PostUpdate(SqlExecutor) error {
+	p.calls = append(p.calls, "PostUpdate")
+	return nil
+}
+
+func (p *hookedPost) PreDelete(SqlExecutor) error {
+	p.calls = append(p.calls, "PreDelete")
+	return nil
+}
+
+func (p *hookedPost) PostDelete(SqlExecutor) error {
+	p.calls = append(p.calls, "PostDelete")
+	if p.Title == "fail" {
+		return errors.New("post delete failed")
+	}
+	return nil
+}
+
+func TestQueryPlanHooks(t *testing.T) {
+	db, err := sql.Open("gorp_fake_test", "")
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	defer db.Close()
+	dbmap := &DbMap{Db: db, Dialect: PostgresDialect{}}
+	table := dbmap.AddTableWithName(hookedPost{}, "post").SetKeys(true, "Id")
+	table.ColMap("calls").SetTransient(true)
+	fakeDriver.reset()
+
+	post := new(hookedPost)
+	if err = dbmap.Query(post).Assign(&post.Title, "a").Insert(); err != nil {
+		t.Fatalf("Failed to insert: %s", err)
+	}
+	post.Title = ""
+	if _, err = dbmap.Query(post).Assign(&post.Title, "b").Where().Equal(&post.Id, 1).Update(); err != nil {
+		t.Fatalf("Failed to update: %s", err)
+	}
+	if _, err = dbmap.Query(post).Where().Equal(&post.Id, 1).Delete(); err != nil {
+		t.Fatalf("Failed to delete: %s", err)
+	}
+	expected := []string{
+		`insert into "post" ("title", "updated") values ($1, $2)`,
+		`update "post" set "title"=$1, "updated"=$2 where "post"."id"=$3`,
+		`delete from "post" where "post"."id"=$1`,
+	}
+	if statements := fakeDriver.reset(); !reflect.DeepEqual(statements, expected) {
+		t.Errorf("Expected statements %q, got %q", expected, statements)
+	}
+	calls := []string{"PreInsert a", "PostInsert", "PreUpdate b", "PostUpdate", "PreDelete", "PostDelete"}
+	if !reflect.DeepEqual(post.calls, calls) {
+		t.Errorf("Expected hooks %v, got %v", calls, post.calls)
+	}
+
+	post = &hookedPost{Title: "fail"}
+	if _, err = dbmap.Query(post).Where().Equal(&post.Id, 1).Delete(); err == nil || err.Error() != "post delete failed" {
+		t.Errorf("Expected the PostDelete hook's error, got %v", err)
+	}
+	fakeDriver.reset()
+}
+
+func TestAuditTriggers(t *testing.T) {
+	columns := []string{"id", "memo"}
+	tests := []struct {
+		dialect  AuditTriggerer
+		expected []string
+	}{
+		{PostgresDialect{}, []string{
+			`create table "invoice_audit" (audit_id bigserial primary key`,
+			`create or replace function "invoice_audit_trigger"() returns trigger`,
+			`('update', to_jsonb(old), to_jsonb(new))`,
+			`create trigger "invoice_audit" after insert or update or delete on "invoice" for each row execute procedure "invoice_audit_trigger"();`,
+		}},
+		{MySQLDialect{Engine: "InnoDB", Encoding: "UTF8"}, []string{
+			"create table `invoice_audit` (audit_id bigint not null auto_increment primary key",
+			"create trigger `invoice_audit_update` after update on `invoice` for each row insert into `invoice_audit` (operation, old_row, new_row) values ('update', json_object('id', old.`id`, 'memo', old.`memo`), json_object('id', new.`id`, 'memo', new.`memo`))",
+			"values ('delete', json_object('id', old.`id`, 'memo', old.`memo`), null)",
+		}},
+		{SqliteDialect{}, []string{
+			`create table "invoice_audit" (audit_id integer primary key autoincrement`,
+			`create trigger "invoice_audit_insert" after insert on "invoice" for each row begin insert into "invoice_audit" (operation, old_row, new_row) values ('insert', null, json_object('id', new."id", 'memo', new."memo")); end;`,
+		}},
+	}
+	for _, test := range tests {
+		statements := strings.Join(test.dialect.AuditTriggers("", "invoice", "invoice_audit", columns), "\n")
+		for _, expected := range test.expected {
+			if !strings.Contains(statements, expected) {
+				t.Errorf("%T: Expected statements to contain %q, got:\n%s", test.dialect, expected, statements)
+			}
+		}
+	}
+
+	dbmap := &DbMap{Dialect: BigQueryDialect{}}
+	dbmap.AddTableWithName(Invoice{}, "invoice").SetKeys(true, "Id").SetAuditTrigger(true)
+	if err := dbmap.CreateAuditTriggers(); err == nil {
+		t.Errorf("Expected an error for a dialect without audit triggers")
+	}
+
+	db, err := sql.Open("gorp_fake_test", "")
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	defer db.Close()
+	dbmap = &DbMap{Db: db, Dialect: PostgresDialect{}}
+	dbmap.AddTableWithName(Invoice{}, "invoice").SetKeys(true, "Id").SetAuditTrigger(true)
+	fakeDriver.reset()
+	if err = dbmap.DropTablesIfExists(); err != nil {
+		t.Fatalf("Failed to drop tables: %s", err)
+	}
+	expected := []string{`drop table if exists "invoice";`, `drop function if exists "invoice_audit_trigger"();`}
+	if statements := fakeDriver.reset(); !reflect.DeepEqual(statements, expected) {
+		t.Errorf("Expected the audit table to be kept, got %q", statements)
+	}
+	if err = dbmap.DropAuditTables(); err != nil {
+		t.Fatalf("Failed to drop audit tables: %s", err)
+	}
+	expected = []string{`drop table if exists "invoice_audit";`}
+	if statements := fakeDriver.reset(); !reflect.DeepEqual(statements, expected) {
+		t.Errorf("Expected the audit table to be dropped, got %q", statements)
+	}
+}
+
+type validatedPost struct {
+	Id    int64
+	Title string  `validate:"required,maxsize"`
+	Body  *string `validate:"required"`
+	Draft bool    `validate:"omitempty"`
+}
+
+func (p *validatedPost) Validate(exec SqlExecutor) error {
+	if p.Draft {
+		return errors.New("drafts can't be saved")
+	}
+	return nil
+}
+
+func TestValidationRules(t *testing.T) {
+	db, err := sql.Open("gorp_fake_test", "")
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	defer db.Close()
+	defer fakeDriver.reset()
+	dbmap := &DbMap{Db: db, Dialect: PostgresDialect{}}
+	table := dbmap.AddTableWithName(validatedPost{}, "post").SetKeys(true, "Id")
+	table.ColMap("Title").SetMaxSize(5)
+
+	err = dbmap.Insert(&validatedPost{Title: "much too long"})
+	verr, ok := err.(ValidationError)
+	if !ok || len(verr.Errors) != 2 || verr.ColumnName != "Title" || verr.Errors[1].ColumnName != "Body" || verr.Errors[1].Err != errRequired {
+		t.Errorf("Expected errors for the title and body, got %v", err)
+	}
+	body := ""
+	if _, ok := dbmap.Insert(&validatedPost{Title: "title", Body: &body}).(ValidationError); !ok {
+		t.Errorf("Expected a ValidationError for an empty body")
+	}
+	body = "body"
+	if _, err = dbmap.Update(&validatedPost{Id: 1, Title: "title", Body: &body, Draft: true}); err == nil || err.Error() != "drafts can't be saved" {
+		t.Errorf("Expected the Validate hook's error, got %v", err)
+	}
+	if statements := fakeDriver.reset(); len(statements) != 0 {
+		t.Errorf("Expected invalid rows not to be written, got %q", statements)
+	}
+
+	post := new(validatedPost)
+	if err = dbmap.Query(post).Assign(&post.Title, "title").Insert(); err == nil {
+		t.Errorf("Expected an error for a plan that doesn't assign a required column")
+	}
+	if err = dbmap.Query(post).Assign(&post.Title, "title").Assign(&post.Body, &body).Insert(); err != nil {
+		t.Errorf("Failed to insert: %s", err)
+	}
+	post.Draft = true
+	if _, err = dbmap.Query(post).Assign(&post.Title, "title").Where().Equal(&post.Id, 1).Update(); err == nil {
+		t.Errorf("Expected the Validate hook to run for query plan updates")
+	}
+	post = new(validatedPost)
+	if _, err = dbmap.Query(post).Assign(&post.Draft, true).Where().Equal(&post.Id, 1).Update(); err == nil || err.Error() != "drafts can't be saved" {
+		t.Errorf("Expected the Validate hook to see the assigned values, got %v", err)
+	}
+	if plan := dbmap.Query(post).Assign(&post.Title, "").(*AssignQueryPlan); len(plan.Errors) == 0 {
+		t.Errorf("Expected an error for assigning an empty required column")
+	}
+}
+
+func TestDefaultScopes(t *testing.T) {
+	type Post struct {
+		Id        int64
+		AccountId int64
+		Title     string
+	}
+	type accountKey struct{}
+	db, err := sql.Open("gorp_fake_test", "")
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	defer db.Close()
+	defer fakeDriver.reset()
+	dbmap := &DbMap{Db: db, Dialect: PostgresDialect{}}
+	dbmap.AddTable(Post{}).SetKeys(true, "Id").AddDefaultScope("account", func(ctx context.Context, target interface{}) Filter {
+		account, ok := ctx.Value(accountKey{}).(int64)
+		if !ok {
+			return nil
+		}
+		return Equal(&target.(*Post).AccountId, account)
+	})
+	ctx := context.WithValue(context.Background(), accountKey{}, int64(7))
+
+	post := new(Post)
+	query, args, err := dbmap.QueryContext(ctx, post).Where().Equal(&post.Title, "a").SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate SQL: %s", err)
+	}
+	if !strings.HasSuffix(query, `where ("post"."title"=$1 and "post"."accountid"=$2)`) || !reflect.DeepEqual(args, []interface{}{"a", int64(7)}) {
+		t.Errorf("Expected select to include the default scope, got %s %v", query, args)
+	}
+	post = new(Post)
+	if query, _, err = dbmap.QueryContext(ctx, post).SQL(); err != nil || !strings.HasSuffix(query, ` where "post"."accountid"=$1`) {
+		t.Errorf("Expected a select without filters to include the default scope, got %s (%v)", query, err)
+	}
+	post = new(Post)
+	if query, _, err = dbmap.Query(post).SQL(); err != nil || strings.Contains(query, "where") {
+		t.Errorf("Expected a nil scope to filter nothing, got %s (%v)", query, err)
+	}
+
+	fakeDriver.reset()
+	post = new(Post)
+	if _, err = dbmap.QueryContext(ctx, post).Assign(&post.Title, "b").Where().Equal(&post.Id, 1).Update(); err != nil {
+		t.Fatalf("Failed to update: %s", err)
+	}
+	post = new(Post)
+	if _, err = dbmap.QueryContext(ctx, post).Unscoped().Where().Equal(&post.Id, 1).Delete(); err != nil {
+		t.Fatalf("Failed to delete: %s", err)
+	}
+	expected := []string{
+		`update "post" set "title"=$1 where ("post"."id"=$2 and "post"."accountid"=$3)`,
+		`delete from "post" where "post"."id"=$1`,
+	}
+	if statements := fakeDriver.reset(); !reflect.DeepEqual(statements, expected) {
+		t.Errorf("Expected statements %q, got %q", expected, statements)
+	}
+
+	type Draft struct {
+		Id        int64
+		AccountId int64
+		DeletedAt *time.Time
+	}
+	dbmap.AddTable(Draft{}).SetKeys(true, "Id").SetSoftDelete("DeletedAt").
+		AddDefaultScope("account", func(ctx context.Context, target interface{}) Filter {
+			return Equal(&target.(*Draft).AccountId, ctx.Value(accountKey{}))
+		})
+	draft := new(Draft)
+	query, _, err = dbmap.QueryContext(ctx, draft).WithDeleted().SQL()
+	if err != nil || !strings.Contains(query, `"accountid"=`) || strings.Contains(query, `"deletedat" is null`) {
+		t.Errorf("Expected WithDeleted to include the account's deleted rows, got %s (%v)", query, err)
+	}
+	draft = new(Draft)
+	query, _, err = dbmap.QueryContext(ctx, draft).Unscoped("account").SQL()
+	if err != nil || strings.Contains(query, `"accountid"=`) || !strings.Contains(query, `"deletedat" is null`) {
+		t.Errorf("Expected Unscoped(\"account\") to only leave out the account scope, got %s (%v)", query, err)
+	}
+	draft = new(Draft)
+	if query, _, err = dbmap.QueryContext(ctx, draft).Unscoped().SQL(); err != nil || strings.Contains(query, "where") {
+		t.Errorf("Expected Unscoped to leave out every scope, got %s (%v)", query, err)
+	}
+	if plan := dbmap.Query(new(Draft)).Unscoped("missing").(*QueryPlan); len(plan.Errors) == 0 {
+		t.Errorf("Expected an error for an unknown default scope")
+	}
+}
+
+func TestDefaultSchema(t *testing.T) {
+	db, err := sql.Open("gorp_fake_test", "")
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	defer db.Close()
+	defer fakeDriver.reset()
+	dbmap := &DbMap{Db: db, Dialect: PostgresDialect{}}
+	dbmap.SetDefaultSchema("billing")
+	dbmap.AddTableWithName(Invoice{}, "invoice").SetKeys(true, "Id")
+	dbmap.AddTableWithNameAndSchema(Person{}, "crm", "person").SetKeys(true, "Id")
+	if table := dbmap.AddTableWithName(Invoice{}, "invoice"); table.SchemaName != "billing" {
+		t.Errorf("Expected the default schema, got %q", table.SchemaName)
+	}
+
+	inv := new(Invoice)
+	person := new(Person)
+	query, _, err := dbmap.Query(inv).Join(person).On().Equal(&inv.PersonId, &person.Id).Where().Equal(&person.FName, "a").SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate SQL: %s", err)
+	}
+	if !strings.Contains(query, `from billing."invoice" inner join crm."person" on billing."invoice"."personid"=crm."person"."id" where crm."person"."fname"=$1`) {
+		t.Errorf("Expected tables qualified by their own schemas, got %s", query)
+	}
+
+	fakeDriver.reset()
+	inv = new(Invoice)
+	person = new(Person)
+	if _, err = dbmap.Query(inv).Assign(&inv.IsPaid, true).Join(person).On().Equal(&inv.PersonId, &person.Id).Where().Update(); err != nil {
+		t.Fatalf("Failed to update: %s", err)
+	}
+	inv = new(Invoice)
+	person = new(Person)
+	if _, err = dbmap.Query(inv).Join(person).On().Equal(&inv.PersonId, &person.Id).Where().Delete(); err != nil {
+		t.Fatalf("Failed to delete: %s", err)
+	}
+	expected := []string{
+		`update billing."invoice" set "ispaid"=$1 from crm."person" where billing."invoice"."personid"=crm."person"."id"`,
+		`delete from billing."invoice" using crm."person" where billing."invoice"."personid"=crm."person"."id"`,
+	}
+	if statements := fakeDriver.reset(); !reflect.DeepEqual(statements, expected) {
+		t.Errorf("Expected statements %q, got %q", expected, statements)
+	}
+}
+
+func TestDeadlineBatches(t *testing.T) {
+	timer := batchTimer{slowest: time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := timer.begin(ctx); err != ErrDeadline {
+		t.Errorf("Expected ErrDeadline for a batch slower than the time left, got %v", err)
+	}
+	if err := timer.begin(context.Background()); err != nil {
+		t.Errorf("Expected batches without a deadline to start, got %s", err)
+	}
+
+	db, err := sql.Open("gorp_fake_test", "")
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	defer db.Close()
+	defer fakeDriver.reset()
+	dbmap := &DbMap{Db: db, Dialect: SqliteDialect{}}
+	dbmap.AddTableWithName(Invoice{}, "invoice").SetKeys(false, "Id")
+	rows := RowIteratorFunc(func(ctx context.Context) (interface{}, error) {
+		time.Sleep(30 * time.Millisecond)
+		return &Invoice{}, nil
+	})
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	n, err := dbmap.Import(ctx, Invoice{}, rows, ImportOptions{BatchSize: 1})
+	if err != ErrDeadline || n == 0 {
+		t.Errorf("Expected the import to stop with ErrDeadline after committing rows, got %d, %v", n, err)
+	}
+	if ctx.Err() != nil {
+		t.Errorf("Expected the import to stop before the deadline")
+	}
+
+	fakeDriver.reset()
+	inv := new(Invoice)
+	if n, err = dbmap.Query(inv).Where().Equal(&inv.IsPaid, true).DeleteBatches(100); n != 0 || err != nil {
+		t.Errorf("Expected nothing to delete, got %d, %v", n, err)
+	}
+	expected := []string{`select "invoice"."Id" from "invoice" where "invoice"."IsPaid"=? limit ?`}
+	if statements := fakeDriver.reset(); !reflect.DeepEqual(statements, expected) {
+		t.Errorf("Expected statements %q, got %q", expected, statements)
+	}
+	if _, err = dbmap.Query(inv).Where().Equal(&inv.IsPaid, true).DeleteBatches(0); err == nil {
+		t.Errorf("Expected an error for a batch size of 0")
+	}
+}
+
+func TestNamedScopes(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTableWithName(Invoice{}, "invoice").SetKeys(true, "Id")
+	dbmap.Scope("unpaid", func(inv *Invoice) Filter {
+		return Equal(&inv.IsPaid, false)
+	}).Scope("memo", func(inv *Invoice) Filter {
+		return NotNull(&inv.Memo)
+	})
+
+	inv := new(Invoice)
+	query, args, err := dbmap.Query(inv).Scoped("unpaid", "memo").Equal(&inv.PersonId, 1).SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate SQL: %s", err)
+	}
+	if !strings.HasSuffix(query, ` where ("invoice"."ispaid"=$1 and "invoice"."memo" IS NOT NULL and "invoice"."personid"=$2)`) || !reflect.DeepEqual(args, []interface{}{false, 1}) {
+		t.Errorf("Expected the scopes' filters, got %s %v", query, args)
+	}
+
+	inv = new(Invoice)
+	query, _, err = dbmap.Query(inv).Assign(&inv.Memo, "a").Where().Scoped("unpaid").SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate SQL: %s", err)
+	}
+	if !strings.HasSuffix(query, ` where "invoice"."ispaid"=$2`) {
+		t.Errorf("Expected the scope's filter in the update, got %s", query)
+	}
+
+	inv = new(Invoice)
+	if _, _, err = dbmap.Query(inv).Scoped("missing").SQL(); err == nil {
+		t.Errorf("Expected an error for an unknown scope")
+	}
+	dbmap.tables[0].ColMap("Memo").SetReadAccess(func(context.Context) bool { return false })
+	if _, _, err = dbmap.Query(inv).Scoped("memo").SQL(); err == nil {
+		t.Errorf("Expected an error for a scope on an unreadable column")
+	} else if _, denied := err.(readAccessError); !denied {
+		t.Errorf("Expected a read access error, got %s", err)
+	}
+	register := func(scope interface{}) (panicked bool) {
+		defer func() { panicked = recover() != nil }()
+		dbmap.Scope("bad", scope)
+		return false
+	}
+	if !register(func(inv Invoice) Filter { return nil }) || !register(func(p *Person) Filter { return nil }) {
+		t.Errorf("Expected Scope to panic for invalid scopes")
+	}
+}
+
+func TestTenancy(t *testing.T) {
+	db, err := sql.Open("gorp_fake_test", "")
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	defer db.Close()
+	defer fakeDriver.reset()
+	dbmap := &DbMap{Db: db, Dialect: PostgresDialect{}}
+	dbmap.AddTableWithName(Invoice{}, "invoice").SetKeys(true, "Id").SetTenantColumn("PersonId")
+	dbmap.AddTableWithName(Person{}, "person").SetKeys(true, "Id")
+	ctx := WithTenant(context.Background(), int64(7))
+
+	inv := new(Invoice)
+	if _, _, err = dbmap.Query(inv).Where().Equal(&inv.IsPaid, false).SQL(); err == nil {
+		t.Errorf("Expected an error for a plan without a tenant")
+	}
+	inv = new(Invoice)
+	query, args, err := dbmap.QueryContext(ctx, inv).Unscoped().Where().Equal(&inv.IsPaid, false).SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate SQL: %s", err)
+	}
+	if !strings.HasSuffix(query, ` where ("invoice"."ispaid"=$1 and "invoice"."personid"=$2)`) || !reflect.DeepEqual(args, []interface{}{false, int64(7)}) {
+		t.Errorf("Expected the select to be restricted to the tenant, got %s %v", query, args)
+	}
+	person := new(Person)
+	inv = new(Invoice)
+	query, _, err = dbmap.QueryContext(ctx, person).Join(inv).On().Equal(&inv.PersonId, &person.Id).SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate SQL: %s", err)
+	}
+	if !strings.Contains(query, ` on ("invoice"."personid"=$1 and "invoice"."personid"="person"."id")`) {
+		t.Errorf("Expected the join to be restricted to the tenant, got %s", query)
+	}
+
+	fakeDriver.reset()
+	inv = new(Invoice)
+	if err = dbmap.QueryContext(ctx, inv).Assign(&inv.Memo, "a").Insert(); err != nil {
+		t.Fatalf("Failed to insert: %s", err)
+	}
+	inv = new(Invoice)
+	if _, err = dbmap.QueryContext(ctx, inv).Assign(&inv.Memo, "b").Where().Equal(&inv.Id, 1).Update(); err != nil {
+		t.Fatalf("Failed to update: %s", err)
+	}
+	inv = new(Invoice)
+	if _, err = dbmap.QueryContext(ctx, inv).Where().Equal(&inv.Id, 1).Delete(); err != nil {
+		t.Fatalf("Failed to delete: %s", err)
+	}
+	expected := []string{
+		`insert into "invoice" ("memo", "personid") values ($1, $2)`,
+		`update "invoice" set "memo"=$1 where ("invoice"."id"=$2 and "invoice"."personid"=$3)`,
+		`delete from "invoice" where ("invoice"."id"=$1 and "invoice"."personid"=$2)`,
+	}
+	if statements := fakeDriver.reset(); !reflect.DeepEqual(statements, expected) {
+		t.Errorf("Expected statements %q, got %q", expected, statements)
+	}
+
+	inv = new(Invoice)
+	if _, err = dbmap.QueryContext(ctx, inv).Assign(&inv.PersonId, 8).Where().Equal(&inv.Id, 1).Update(); err == nil {
+		t.Errorf("Expected an error for assigning the tenant column")
+	}
+	if err = dbmap.QueryContext(ctx, inv).Unscoped().Truncate(); err == nil {
+		t.Errorf("Expected an error truncating a tenant table")
+	}
+
+	type Comment struct {
+		Id        int64
+		PostId    int64
+		AccountId int64
+	}
+	type Post struct {
+		Id       int64
+		Comments []Comment
+	}
+	dbmap.AddTableWithName(Comment{}, "comment").SetKeys(true, "Id").SetTenantColumn("AccountId")
+	dbmap.AddTableWithName(Post{}, "post").SetKeys(true, "Id").ColMap("Comments").HasMany("PostId")
+	post := new(Post)
+	plan := dbmap.QueryContext(ctx, post).Preload(&post.Comments).(*QueryPlan)
+	relation := plan.preloads[0]
+	query, args, err = plan.preloadQuery(relation, relation.ForeignKey, []interface{}{int64(1), int64(2)}).SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate SQL: %s", err)
+	}
+	if !strings.HasSuffix(query, ` where ("comment"."postid" in ($1,$2) and "comment"."accountid"=$3)`) || !reflect.DeepEqual(args, []interface{}{int64(1), int64(2), int64(7)}) {
+		t.Errorf("Expected preloaded rows to be restricted to the tenant, got %s %v", query, args)
+	}
+}
+
+func TestJoinedWriteConditions(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	dbmap.AddTableWithName(Invoice{}, "invoice").SetKeys(true, "Id")
+	dbmap.AddTableWithName(Person{}, "person").SetKeys(true, "Id")
+
+	inv := new(Invoice)
+	person := new(Person)
+	query, args, err := dbmap.Query(inv).Assign(&inv.IsPaid, true).
+		Join(person).On().Equal(&inv.PersonId, &person.Id).Equal(&person.LName, "b").
+		Where().Equal(&inv.Memo, "a").SQL()
+	if err != nil {
+		t.Fatalf("Failed to generate SQL: %s", err)
+	}
+	expected := `update "invoice" set "IsPaid"=? from "person" where "invoice"."Memo"=? and ("invoice"."PersonId"="person"."Id" and "person"."LName"=?)`
+	if query != expected || !reflect.DeepEqual(args, []interface{}{true, "a", "b"}) {
+		t.Errorf("Expected %s [true a b], got %s %v", expected, query, args)
+	}
+
+	inv = new(Invoice)
+	person = new(Person)
+	plan := dbmap.Query(inv).Join(person).On().Equal(&inv.PersonId, &person.Id).Equal(&person.LName, "b").
+		Where().Equal(&inv.Memo, "a").(*QueryPlan)
+	query, _, err = plan.deleteQuery()
+	if err != nil {
+		t.Fatalf("Failed to generate SQL: %s", err)
+	}
+	expected = `delete from "invoice" using "person" where "invoice"."Memo"=? and ("invoice"."PersonId"="person"."Id" and "person"."LName"=?)`
+	if query != expected || !reflect.DeepEqual(plan.args, []interface{}{"a", "b"}) {
+		t.Errorf("Expected %s [a b], got %s %v", expected, query, plan.args)
+	}
+}
+
+func TestNestedSavepointNames(t *testing.T) {
+	db, err := sql.Open("gorp_fake_test", "")
+	if err != nil {
+		t.Fatalf("Failed to open database: %s", err)
+	}
+	defer db.Close()
+	dbmap := &DbMap{Db: db, Dialect: PostgresDialect{}}
+	fakeDriver.reset()
+
+	nested := func(fn func(ctx context.Context, tx *Transaction) error) func(ctx context.Context, tx *Transaction) error {
+		return func(ctx context.Context, tx *Transaction) error {
+			return dbmap.WithTransaction(ctx, PropagationNested, fn)
+		}
+	}
+	noop := func(ctx context.Context, tx *Transaction) error { return nil }
+	err = dbmap.WithTransaction(context.Background(), PropagationRequired, func(ctx context.Context, tx *Transaction) error {
+		if err := nested(nested(noop))(ctx, tx); err != nil {
+			return err
+		}
+		return nested(noop)(ctx, tx)
+	})
+	if err != nil {
+		t.Fatalf("Failed to run transaction: %s", err)
+	}
+	var savepoints []string
+	for _, statement := range fakeDriver.reset() {
+		if strings.HasPrefix(statement, "savepoint ") {
+			savepoints = append(savepoints, statement)
+		}
+	}
+	expected := []string{`savepoint "gorp_nested_1"`, `savepoint "gorp_nested_2"`, `savepoint "gorp_nested_3"`}
+	if !reflect.DeepEqual(savepoints, expected) {
+		t.Errorf("Expected savepoints %q, got %q", expected, savepoints)
+	}
+}
@@ -0,0 +1,193 @@
+package gorp
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// A fieldRelation is a relation declared on a field with
+// ColumnMap.HasMany, HasOne, or BelongsTo.
+type fieldRelation struct {
+	kind       RelationKind
+	foreignKey string
+}
+
+// HasMany declares that this column's field, a slice of another
+// table's type (or of pointers to it), holds the rows of that table
+// whose foreignKeyField holds this row's primary key:
+//
+//     dbmap.AddTable(Post{}).SetKeys(true, "Id").
+//         ColMap("Comments").HasMany("PostId").
+//         OnDelete(gorp.CascadeDelete)
+//
+// The field is made transient.  Relations are resolved when they are
+// used (see TableMap.Relations), so the other table doesn't need to be
+// registered yet.  The returned association is the same one that
+// TableMap.HasMany returns for the other table and foreignKeyField,
+// so counter caches, denormalized columns, and delete actions can be
+// declared on either.
+func (c *ColumnMap) HasMany(foreignKeyField string) *HasManyMap {
+	c.relate(RelationHasMany, foreignKeyField)
+	t := c.table
+	assoc := &HasManyMap{parent: t, field: c, foreignKeyField: foreignKeyField}
+	if child := tableOrNil(t.dbmap, assoc.childType()); child != nil {
+		if existing := t.hasManyFor(child, colMapOrNil(child, foreignKeyField)); existing != nil {
+			existing.field = c
+			return existing
+		}
+		assoc.resolve(child)
+	}
+	t.hasMany = append(t.hasMany, assoc)
+	return assoc
+}
+
+// childType returns the type of the children held by the field of an
+// association declared with ColumnMap.HasMany, or nil if the field
+// isn't a slice.
+func (h *HasManyMap) childType() reflect.Type {
+	t := h.field.gotype
+	if t.Kind() != reflect.Slice {
+		return nil
+	}
+	if t = t.Elem(); t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// resolve sets the child table of an association declared with
+// ColumnMap.HasMany and runs what waits for it, if the child has the
+// foreign key field.
+func (h *HasManyMap) resolve(child *TableMap) {
+	foreignKey := colMapOrNil(child, h.foreignKeyField)
+	if foreignKey == nil {
+		return
+	}
+	h.child, h.foreignKey = child, foreignKey
+	for _, f := range h.pending {
+		f()
+	}
+	h.pending = nil
+}
+
+// resolveHasMany resolves the associations declared with
+// ColumnMap.HasMany whose children are held in table, which was just
+// registered.
+func (m *DbMap) resolveHasMany(table *TableMap) {
+	for _, parent := range m.tables {
+		for _, assoc := range parent.hasMany {
+			if assoc.child == nil && assoc.childType() == table.gotype {
+				assoc.resolve(table)
+			}
+		}
+	}
+}
+
+// HasOne declares that this column's field, another table's type or a
+// pointer to it, holds the row of that table whose foreignKeyField
+// holds this row's primary key.  See HasMany.
+func (c *ColumnMap) HasOne(foreignKeyField string) *ColumnMap {
+	return c.relate(RelationHasOne, foreignKeyField)
+}
+
+// BelongsTo declares that this column's field, another table's type or
+// a pointer to it, holds the row of that table whose primary key is
+// held by this table's foreignKeyField:
+//
+//     dbmap.AddTable(Comment{}).SetKeys(true, "Id").
+//         ColMap("Post").BelongsTo("PostId")
+//
+// See HasMany.
+func (c *ColumnMap) BelongsTo(foreignKeyField string) *ColumnMap {
+	return c.relate(RelationBelongsTo, foreignKeyField)
+}
+
+func (c *ColumnMap) relate(kind RelationKind, foreignKeyField string) *ColumnMap {
+	c.Transient = true
+	c.relation = &fieldRelation{kind: kind, foreignKey: foreignKeyField}
+	return c
+}
+
+// A Relation is a relation declared on a field of a table's struct
+// with ColumnMap.HasMany, HasOne, or BelongsTo.
+type Relation struct {
+	// Kind is RelationHasMany, RelationHasOne, or RelationBelongsTo.
+	Kind RelationKind
+
+	// Field is the name of the field in Table's struct that holds the
+	// related rows of Target.
+	Field  string
+	Table  *TableMap
+	Target *TableMap
+
+	// ForeignKey is the column holding the key of the other table:
+	// a column of Target for has-many and has-one relations, and a
+	// column of Table for belongs-to relations.
+	ForeignKey *ColumnMap
+}
+
+// Parent returns the table whose primary key the relation's foreign
+// key holds.
+func (r Relation) Parent() *TableMap {
+	if r.Kind == RelationBelongsTo {
+		return r.Target
+	}
+	return r.Table
+}
+
+// Child returns the table with the relation's foreign key column.
+func (r Relation) Child() *TableMap {
+	if r.Kind == RelationBelongsTo {
+		return r.Table
+	}
+	return r.Target
+}
+
+// Relations returns the relations declared on the fields of this
+// table's struct, in field order.  An error is returned if a field's
+// type doesn't fit its relation, the related type isn't registered,
+// or a foreign key field can't be found.
+func (t *TableMap) Relations() ([]Relation, error) {
+	var relations []Relation
+	for _, col := range t.columns {
+		if col.relation == nil {
+			continue
+		}
+		relation, err := t.resolveRelation(col)
+		if err != nil {
+			return nil, err
+		}
+		relations = append(relations, relation)
+	}
+	return relations, nil
+}
+
+// resolveRelation resolves the relation declared on col.
+func (t *TableMap) resolveRelation(col *ColumnMap) (Relation, error) {
+	kind := col.relation.kind
+	targetType := col.gotype
+	if kind == RelationHasMany {
+		if targetType.Kind() != reflect.Slice {
+			return Relation{}, fmt.Errorf("gorp: Field %s of table %s must be a slice to have many rows", col.fieldName, t.TableName)
+		}
+		targetType = targetType.Elem()
+	}
+	if targetType.Kind() == reflect.Ptr {
+		targetType = targetType.Elem()
+	}
+	target, err := t.dbmap.tableFor(targetType, false)
+	if err != nil {
+		return Relation{}, fmt.Errorf("gorp: Field %s of table %s is related to an unregistered type %s", col.fieldName, t.TableName, targetType)
+	}
+	relation := Relation{Kind: kind, Field: col.fieldName, Table: t, Target: target}
+
+	child := relation.Child()
+	relation.ForeignKey = colMapOrNil(child, col.relation.foreignKey)
+	if relation.ForeignKey == nil || !relation.ForeignKey.inSchema() {
+		return Relation{}, fmt.Errorf("gorp: Field %s of table %s has no foreign key column %s in table %s", col.fieldName, t.TableName, col.relation.foreignKey, child.TableName)
+	}
+	if parent := relation.Parent(); len(parent.keys) != 1 {
+		return Relation{}, fmt.Errorf("gorp: Field %s of table %s is related to table %s, which must have exactly one primary key column", col.fieldName, t.TableName, parent.TableName)
+	}
+	return relation, nil
+}
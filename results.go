@@ -0,0 +1,118 @@
+package gorp
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+)
+
+// A ResultColumn maps a field of a result struct to a column or an
+// Expression.  Use As to create them.
+type ResultColumn struct {
+	field  string
+	source interface{}
+}
+
+// As returns a ResultColumn that selects fieldPtrOrExpr, a pointer to
+// a field of the query's target (or of a joined target) or an
+// Expression, into the result struct's field named field.
+func As(field string, fieldPtrOrExpr interface{}) ResultColumn {
+	return ResultColumn{field: field, source: fieldPtrOrExpr}
+}
+
+// SelectResults runs this query plan as a select statement that
+// selects columns, instead of the table's columns, and appends the
+// rows to target, a pointer to a slice of structs (or of pointers to
+// structs) that don't need to be mapped to a table.  This lets
+// reporting queries with aggregates and group by clauses scan into
+// report structs:
+//
+//     type PersonTotals struct {
+//         PersonId int64
+//         Invoices int64
+//         Latest   int64
+//     }
+//
+//     var totals []PersonTotals
+//     err := dbmap.Query(inv).Where().Equal(&inv.IsPaid, false).
+//         GroupBy(&inv.PersonId).
+//         SelectResults(&totals,
+//             gorp.As("PersonId", &inv.PersonId),
+//             gorp.As("Invoices", gorp.Raw("count(*)")),
+//             gorp.As("Latest", gorp.Func("max", &inv.Created)))
+//
+// Each column is selected with its field name as an alias.  Columns
+// are subject to column restrictions and masks, and masked columns
+// can't be used in expressions, whose results would reveal their
+// values.
+func (plan *QueryPlan) SelectResults(target interface{}, columns ...ResultColumn) error {
+	targetType := reflect.TypeOf(target)
+	if targetType == nil || targetType.Kind() != reflect.Ptr || targetType.Elem().Kind() != reflect.Slice {
+		return errors.New("gorp: SelectResults must be run with a pointer to a slice as its target")
+	}
+	query, masked, err := plan.resultsQuery(columns)
+	if err != nil {
+		return err
+	}
+	if _, err = plan.executor.Select(target, query, plan.args...); err != nil {
+		return err
+	}
+	slice := reflect.ValueOf(target).Elem()
+	for i := 0; i < slice.Len(); i++ {
+		row := reflect.Indirect(slice.Index(i))
+		for field, col := range masked {
+			if v := row.FieldByName(field); v.IsValid() && v.CanSet() {
+				col.mask(v)
+			}
+		}
+	}
+	return nil
+}
+
+// resultsQuery returns the select statement for SelectResults, along
+// with the masked columns, keyed by the result field they are
+// selected into.
+func (plan *QueryPlan) resultsQuery(columns []ResultColumn) (string, map[string]*ColumnMap, error) {
+	if len(plan.Errors) > 0 {
+		return "", nil, plan.Errors[0]
+	}
+	if len(columns) == 0 {
+		return "", nil, errors.New("gorp: SelectResults requires at least one column")
+	}
+	dialect := plan.table.dbmap.Dialect
+	role := RoleFromContext(plan.ctx)
+	masked := make(map[string]*ColumnMap)
+	list := bytes.Buffer{}
+	var selectArgs []interface{}
+	for index, column := range columns {
+		if index != 0 {
+			list.WriteString(",")
+		}
+		if expr, ok := column.source.(Expression); ok {
+			sql, args, err := expr.Expr(plan.expressionColumns(), dialect, plan.bindOffset+len(selectArgs))
+			if err != nil {
+				return "", nil, err
+			}
+			list.WriteString(sql)
+			selectArgs = append(selectArgs, args...)
+		} else {
+			fieldMap, err := plan.colMap.fieldMapForPointer(column.source)
+			if err != nil {
+				return "", nil, err
+			}
+			if !plan.readable(fieldMap.column) {
+				return "", nil, readAccessError{col: fieldMap.column, table: plan.table.TableName}
+			}
+			if fieldMap.column.masked(role) {
+				masked[column.field] = fieldMap.column
+			}
+			list.WriteString(fieldMap.quotedTable + "." + fieldMap.quotedColumn)
+		}
+		list.WriteString(" as ")
+		list.WriteString(dialect.QuoteField(column.field))
+	}
+	plan.selectArgs = selectArgs
+	defer func() { plan.selectArgs = nil }()
+	query, err := plan.buildSelect(list.String())
+	return query, masked, err
+}
@@ -0,0 +1,183 @@
+package gorp
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// A retentionPolicy moves rows that are older than a retention period
+// to an archive table.
+type retentionPolicy struct {
+	ageCol       *ColumnMap
+	keepFor      time.Duration
+	archiveTable string
+}
+
+// SetRetention keeps rows of this table for keepFor, measured from
+// the time in ageField, after which DbMap.Archive moves them to
+// archiveTable (in the same schema as this table):
+//
+//     dbmap.AddTable(AuditEvent{}).SetKeys(true, "Id").
+//         SetRetention("Created", 90*24*time.Hour, "audit_event_archive")
+//
+// The archive table must already exist and have the same columns as
+// this table.  If archiveTable is empty, old rows are deleted instead
+// of archived.  The table must have a single primary key column.
+// Panics if ageField can't be found.
+func (t *TableMap) SetRetention(ageField string, keepFor time.Duration, archiveTable string) *TableMap {
+	if len(t.keys) != 1 {
+		panic(fmt.Sprintf("gorp: SetRetention: table %s must have exactly one primary key column", t.TableName))
+	}
+	t.retention = &retentionPolicy{
+		ageCol:       t.ColMap(ageField),
+		keepFor:      keepFor,
+		archiveTable: archiveTable,
+	}
+	return t
+}
+
+// Archive moves rows of model's table that are older than its
+// retention period (see TableMap.SetRetention) to its archive table,
+// batchSize rows at a time, and returns the number of rows moved.
+// Each batch is copied with an insert ... select statement and then
+// deleted, in a transaction of its own.
+//
+// Archive is meant to be run by a scheduler, e.g. using
+// DbMap.StartPeriodic with DbMap.ArchiveJob.
+func (m *DbMap) Archive(model interface{}, batchSize int) (int64, error) {
+	return archive(m, m, model, batchSize)
+}
+
+// Archive has the same behavior as DbMap.Archive, but runs every
+// batch in this transaction.
+func (t *Transaction) Archive(model interface{}, batchSize int) (int64, error) {
+	return archive(t.dbmap, t, model, batchSize)
+}
+
+// ArchiveJob returns a job for DbMap.StartPeriodic that archives the
+// old rows of every table with a retention policy:
+//
+//     runner, err := dbmap.StartPeriodic("archive", time.Hour, dbmap.ArchiveJob(1000), nil)
+//
+// The job stops at the first error.
+func (m *DbMap) ArchiveJob(batchSize int) func(m *DbMap) error {
+	return func(m *DbMap) error {
+		for _, table := range m.tables {
+			if table.retention == nil {
+				continue
+			}
+			if _, err := table.archive(m, batchSize); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func archive(m *DbMap, exec SqlExecutor, model interface{}, batchSize int) (int64, error) {
+	t, err := toType(model)
+	if err != nil {
+		return 0, err
+	}
+	table, err := m.tableFor(t, false)
+	if err != nil {
+		return 0, err
+	}
+	return table.archive(exec, batchSize)
+}
+
+func (t *TableMap) archive(exec SqlExecutor, batchSize int) (int64, error) {
+	if err := t.dbmap.checkWritable(); err != nil {
+		return 0, err
+	}
+	if t.retention == nil {
+		return 0, fmt.Errorf("gorp: Archive: table %s has no retention policy", t.TableName)
+	}
+	if batchSize <= 0 {
+		return 0, fmt.Errorf("gorp: Archive: invalid batch size %d", batchSize)
+	}
+	cutoff := time.Now().Add(-t.retention.keepFor)
+	var moved int64
+	for {
+		batch, err := t.oldKeys(exec, cutoff, batchSize)
+		if err != nil {
+			return moved, err
+		}
+		if batch.Len() == 0 {
+			return moved, nil
+		}
+		rows, err := t.archiveBatch(exec, batch)
+		moved += rows
+		if err != nil {
+			return moved, err
+		}
+		if batch.Len() < batchSize {
+			return moved, nil
+		}
+	}
+}
+
+// oldKeys returns the keys of up to limit rows that are older than
+// cutoff, oldest first.
+func (t *TableMap) oldKeys(exec SqlExecutor, cutoff time.Time, limit int) (reflect.Value, error) {
+	key := t.keys[0]
+	plan := t.maintenanceQuery(exec)
+	agePtr, err := plan.colMap.pointerForColumn(t.retention.ageCol)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	keyPtr, err := plan.colMap.pointerForColumn(key)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	keys := reflect.New(reflect.SliceOf(key.gotype))
+	err = plan.Where(Less(agePtr, cutoff)).OrderBy(Asc(agePtr)).Limit(int64(limit)).SelectColumn(keyPtr, keys.Interface())
+	return keys.Elem(), err
+}
+
+// archiveBatch copies the rows with the passed in keys to the archive
+// table and deletes them, returning the number of rows deleted.
+func (t *TableMap) archiveBatch(exec SqlExecutor, keys reflect.Value) (int64, error) {
+	dbmap, ok := exec.(*DbMap)
+	if ok {
+		tx, err := dbmap.Begin()
+		if err != nil {
+			return 0, err
+		}
+		rows, err := t.archiveBatch(tx, keys)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		return rows, tx.Commit()
+	}
+
+	dialect := t.dbmap.Dialect
+	quotedTable := dialect.QuotedTableForQuery(t.SchemaName, t.TableName)
+	keyList, args := bindList(dialect, keys)
+	where := fmt.Sprintf(" where %s in %s", dialect.QuoteField(t.keys[0].ColumnName), keyList)
+	if t.retention.archiveTable != "" {
+		columns := bytes.Buffer{}
+		for _, col := range t.columns {
+			if col.inSchema() {
+				if columns.Len() > 0 {
+					columns.WriteString(", ")
+				}
+				columns.WriteString(dialect.QuoteField(col.ColumnName))
+			}
+		}
+		_, err := exec.Exec(fmt.Sprintf("insert into %s (%s) select %s from %s%s",
+			dialect.QuotedTableForQuery(t.SchemaName, t.retention.archiveTable),
+			columns.String(), columns.String(), quotedTable, where), args...)
+		if err != nil {
+			return 0, err
+		}
+	}
+	res, err := exec.Exec("delete from "+quotedTable+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,113 @@
+package gorp
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+)
+
+// Rows is an iterator over the results of a select statement, for
+// callers that want to control the pace of reading rows.  It works
+// like sql.Rows, but Scan loads a whole row into a struct using the
+// same column-to-field mapping as Select:
+//
+//     rows, err := dbmap.Query(inv).Where().Equal(&inv.IsPaid, false).SelectRows()
+//     if err != nil {
+//         return err
+//     }
+//     defer rows.Close()
+//     for rows.Next() {
+//         row := new(Invoice)
+//         if err := rows.Scan(row); err != nil {
+//             return err
+//         }
+//         ...
+//     }
+//     return rows.Err()
+//
+// Rows must be closed if iteration is stopped before Next returns
+// false.
+type Rows struct {
+	rows    *sql.Rows
+	exec    SqlExecutor
+	t       reflect.Type
+	scanner *rowScanner
+
+	// masked holds the columns to mask after each row is scanned.
+	masked []*ColumnMap
+}
+
+// openRows runs query and prepares to scan its rows into values of
+// type t, which must be a struct type.
+func openRows(m *DbMap, exec SqlExecutor, t reflect.Type, query string, args []interface{}) (*Rows, error) {
+	rows, err := exec.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	cols, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+	scanner, err := newRowScanner(m, t, cols, true)
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+	return &Rows{rows: rows, exec: exec, t: t, scanner: scanner}, nil
+}
+
+// Next prepares the next row to be read by Scan.  It returns false
+// when there are no more rows or an error occurred; check Err to tell
+// the two apart.
+func (r *Rows) Next() bool {
+	return r.rows.Next()
+}
+
+// Scan loads the current row into dest, which must be a pointer to a
+// value of the query's struct type, and runs dest's PostGet hook.
+func (r *Rows) Scan(dest interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.Elem().Type() != r.t {
+		return fmt.Errorf("gorp: Scan expects a *%s, got %T", r.t.Name(), dest)
+	}
+	return r.scan(v)
+}
+
+func (r *Rows) scan(v reflect.Value) error {
+	if err := r.scanner.scan(r.rows, v); err != nil {
+		return err
+	}
+	maskRow(r.masked, v)
+	if hook, ok := v.Interface().(HasPostGet); ok {
+		return hook.PostGet(r.exec)
+	}
+	return nil
+}
+
+// Err returns the error, if any, that stopped iteration.
+func (r *Rows) Err() error {
+	return r.rows.Err()
+}
+
+// Close closes the underlying result set.  It is safe to call Close
+// more than once.
+func (r *Rows) Close() error {
+	return r.rows.Close()
+}
+
+// SelectRows will run this query plan as a SELECT statement, and
+// return an iterator over its rows instead of loading them all at
+// once.
+func (plan *QueryPlan) SelectRows() (*Rows, error) {
+	query, err := plan.selectQuery()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := openRows(plan.dbMap, plan.executor, plan.table.gotype, query, plan.args)
+	if err != nil {
+		return nil, err
+	}
+	rows.masked = plan.maskedColumns()
+	return rows, nil
+}
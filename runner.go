@@ -0,0 +1,203 @@
+package gorp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// A periodic calls a function every interval on its own goroutine
+// until it is halted.
+type periodic struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+func startPeriodic(interval time.Duration, tick func()) *periodic {
+	p := &periodic{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				tick()
+			}
+		}
+	}()
+	return p
+}
+
+// halt stops p, waiting for a tick that is in progress to finish.
+func (p *periodic) halt() {
+	close(p.stop)
+	<-p.done
+}
+
+// A PeriodicRunner runs a job every interval on one process out of all
+// of the processes that started a runner with the same name, using
+// the database's advisory locks to elect a leader.  This allows
+// database maintenance (sweeping expired rows, fixing counter caches,
+// refreshing materialized views) to be scheduled by the application
+// itself, without running a separate scheduler.  Create one with
+// DbMap.StartPeriodic.
+//
+// The leader holds its lock on a dedicated connection for as long as
+// it runs.  If that connection is lost, the lock is released by the
+// database, and another runner takes over on its next tick.
+type PeriodicRunner struct {
+	dbmap   *DbMap
+	locker  AdvisoryLocker
+	name    string
+	job     func(m *DbMap) error
+	onError func(err error)
+	loop    *periodic
+
+	mu     sync.Mutex
+	conn   *sql.Conn
+	leader bool
+}
+
+// StartPeriodic starts a runner that calls job every interval while
+// it is the leader for name.  Errors returned by job, and errors
+// encountered while electing a leader, are passed to onError, which
+// may be nil.  The dialect must implement AdvisoryLocker.
+//
+//     runner, err := dbmap.StartPeriodic("sweep-sessions", time.Minute, func(m *gorp.DbMap) error {
+//         _, err := m.SweepExpired(Session{}, 1000)
+//         return err
+//     }, nil)
+//
+func (m *DbMap) StartPeriodic(name string, interval time.Duration, job func(m *DbMap) error, onError func(err error)) (*PeriodicRunner, error) {
+	locker, ok := m.Dialect.(AdvisoryLocker)
+	if !ok {
+		return nil, fmt.Errorf("gorp: StartPeriodic: dialect %T does not support advisory locks", m.Dialect)
+	}
+	if m.Db == nil {
+		return nil, errors.New("gorp: StartPeriodic: DbMap has no database")
+	}
+	r := &PeriodicRunner{
+		dbmap:   m,
+		locker:  locker,
+		name:    name,
+		job:     job,
+		onError: onError,
+	}
+	r.loop = startPeriodic(interval, r.tick)
+	return r, nil
+}
+
+// IsLeader returns true if this runner currently holds the lock for
+// its name.
+func (r *PeriodicRunner) IsLeader() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.leader
+}
+
+func (r *PeriodicRunner) tick() {
+	leader, err := r.elect()
+	if err != nil {
+		r.fail(err)
+		return
+	}
+	if leader {
+		if err = r.job(r.dbmap); err != nil {
+			r.fail(err)
+		}
+	}
+}
+
+func (r *PeriodicRunner) fail(err error) {
+	if r.onError != nil {
+		r.onError(err)
+	}
+}
+
+// elect checks that this runner still holds its lock, or tries to
+// acquire it if it doesn't.
+func (r *PeriodicRunner) elect() (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ctx := context.Background()
+	if r.conn != nil {
+		if err := r.conn.PingContext(ctx); err == nil {
+			if r.leader {
+				return true, nil
+			}
+		} else {
+			// The lock (if we held it) went away with the connection.
+			r.conn.Close()
+			r.conn = nil
+			r.leader = false
+		}
+	}
+	if r.conn == nil {
+		conn, err := r.dbmap.Db.Conn(ctx)
+		if err != nil {
+			return false, err
+		}
+		r.conn = conn
+	}
+	query, args := r.locker.TryAdvisoryLock(r.name)
+	var acquired interface{}
+	if err := r.conn.QueryRowContext(ctx, query, args...).Scan(&acquired); err != nil {
+		return false, err
+	}
+	r.leader = lockAcquired(acquired)
+	return r.leader, nil
+}
+
+// lockAcquired interprets the result of a try-lock query, which is a
+// boolean on some databases and 1 or 0 (or null) on others.
+func lockAcquired(result interface{}) bool {
+	switch v := result.(type) {
+	case bool:
+		return v
+	case int64:
+		return v == 1
+	case []byte:
+		return string(v) == "1" || string(v) == "t" || string(v) == "true"
+	}
+	return false
+}
+
+// Stop stops the runner, waiting for a job that is in progress to
+// finish, and releases its lock so that another runner can take over.
+func (r *PeriodicRunner) Stop() error {
+	r.loop.halt()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil {
+		return nil
+	}
+	var err error
+	if r.leader {
+		query, args := r.locker.AdvisoryUnlock(r.name)
+		_, err = r.conn.ExecContext(context.Background(), query, args...)
+	}
+	if closeErr := r.conn.Close(); err == nil {
+		err = closeErr
+	}
+	r.conn = nil
+	r.leader = false
+	return err
+}
+
+// advisoryLockKey hashes a lock name to a key, for databases whose
+// advisory locks are identified by integers.
+func advisoryLockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
@@ -0,0 +1,116 @@
+package gorp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SchemaError is returned by ValidateSchema if any of the columns
+// mapped by a DbMap are missing from the database.
+type SchemaError struct {
+	// MissingColumns maps table names to the names of (non-optional)
+	// columns that were not found in that table.
+	MissingColumns map[string][]string
+
+	// UnmappedColumns maps table names to the names of columns of
+	// that table that aren't mapped to any field.  It is only filled
+	// in for strict DbMaps (see DbMap.SetStrict).
+	UnmappedColumns map[string][]string
+}
+
+// Error returns a description of the missing and unmapped columns
+func (e SchemaError) Error() string {
+	var problems []string
+	if len(e.MissingColumns) > 0 {
+		problems = append(problems, "missing columns: "+describeColumns(e.MissingColumns))
+	}
+	if len(e.UnmappedColumns) > 0 {
+		problems = append(problems, "unmapped columns: "+describeColumns(e.UnmappedColumns))
+	}
+	return "gorp: SchemaError " + strings.Join(problems, ", ")
+}
+
+// describeColumns lists the columns of each table in columns.
+func describeColumns(columns map[string][]string) string {
+	tables := make([]string, 0, len(columns))
+	for table := range columns {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	described := make([]string, 0, len(tables))
+	for _, table := range tables {
+		described = append(described, fmt.Sprintf("%s(%s)", table, strings.Join(columns[table], ", ")))
+	}
+	return strings.Join(described, "; ")
+}
+
+// ValidateSchema checks every table registered with this DbMap
+// against the database, to ensure that all non-transient columns
+// exist.
+//
+// Columns that have been marked as optional (see
+// ColumnMap.SetOptional) are not reported as errors if they are
+// missing.  Instead, they will be left out of generated SQL until a
+// later call to ValidateSchema finds them.
+//
+// If any non-optional columns are missing, a SchemaError will be
+// returned.  Strict DbMaps (see SetStrict) also return one if any
+// columns in the database aren't mapped.  If a table does not exist
+// at all, the error from the database is returned.
+func (m *DbMap) ValidateSchema() error {
+	schemaErr := SchemaError{MissingColumns: make(map[string][]string), UnmappedColumns: make(map[string][]string)}
+	for _, table := range m.tables {
+		existing, err := m.tableColumns(table)
+		if err != nil {
+			return err
+		}
+		changed := false
+		for _, col := range table.columns {
+			if col.Transient {
+				continue
+			}
+			_, found := existing[strings.ToLower(col.ColumnName)]
+			switch {
+			case col.isOptional:
+				if col.setMissing(!found) {
+					changed = true
+				}
+			case !found:
+				schemaErr.MissingColumns[table.TableName] = append(schemaErr.MissingColumns[table.TableName], col.ColumnName)
+			}
+		}
+		if changed {
+			table.ResetSql()
+		}
+		if m.strict {
+			if unmapped := table.unmappedColumns(existing); len(unmapped) > 0 {
+				schemaErr.UnmappedColumns[table.TableName] = unmapped
+			}
+		}
+	}
+	if len(schemaErr.MissingColumns) > 0 || len(schemaErr.UnmappedColumns) > 0 {
+		return schemaErr
+	}
+	return nil
+}
+
+// tableColumns returns the set of (lower case) column names that
+// exist in the database for the passed in table.
+func (m *DbMap) tableColumns(table *TableMap) (map[string]struct{}, error) {
+	query := fmt.Sprintf("select * from %s where 1=0", m.Dialect.QuotedTableForQuery(table.SchemaName, table.TableName))
+	rows, err := m.query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]struct{}, len(cols))
+	for _, col := range cols {
+		existing[strings.ToLower(col)] = struct{}{}
+	}
+	return existing, nil
+}
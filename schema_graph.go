@@ -0,0 +1,353 @@
+package gorp
+
+import (
+	"sort"
+)
+
+// A SchemaGraph describes the tables registered with a DbMap and the
+// relations declared between them, for tools that generate entity
+// relationship diagrams or check the impact of schema changes.  It is
+// a snapshot; changes made to the DbMap's tables afterwards aren't
+// reflected in it.  Create one with DbMap.SchemaGraph.
+type SchemaGraph struct {
+	Tables    []GraphTable
+	Relations []GraphRelation
+}
+
+// A GraphTable is a table in a SchemaGraph.
+type GraphTable struct {
+	Schema  string
+	Name    string
+	GoType  string
+	Columns []GraphColumn
+
+	// Keys are the names of the primary key columns.
+	Keys []string
+
+	// Tags are the table's tags (see TableMap.Tag).
+	Tags []string
+}
+
+// A GraphColumn is a column of a GraphTable.  Transient fields are
+// not included.
+type GraphColumn struct {
+	Name          string
+	Field         string
+	GoType        string
+	MaxSize       int
+	PrimaryKey    bool
+	AutoIncrement bool
+	Unique        bool
+	NotNull       bool
+	Version       bool
+}
+
+// RelationKind is the kind of a GraphRelation.
+type RelationKind string
+
+// The kinds of relations in a SchemaGraph.
+const (
+	// RelationReferences is a foreign key declared with
+	// ColumnMap.References.
+	RelationReferences RelationKind = "references"
+
+	// RelationHasMany is a one-to-many association declared with
+	// TableMap.HasMany or ColumnMap.HasMany.
+	RelationHasMany RelationKind = "hasMany"
+
+	// RelationHasOne and RelationBelongsTo are relations declared with
+	// ColumnMap.HasOne and ColumnMap.BelongsTo.
+	RelationHasOne    RelationKind = "hasOne"
+	RelationBelongsTo RelationKind = "belongsTo"
+
+	// RelationPolymorphic is one of the possible targets of a
+	// polymorphic association declared with TableMap.Polymorphic.
+	RelationPolymorphic RelationKind = "polymorphic"
+
+	// RelationManyToMany is one side of a many-to-many association
+	// declared with TableMap.ManyToMany, from the join table to one
+	// of the associated tables.
+	RelationManyToMany RelationKind = "manyToMany"
+
+	// RelationTree is the parent of a self-referencing table with a
+	// closure table (see TableMap.SetClosureTable).
+	RelationTree RelationKind = "tree"
+)
+
+// A GraphRelation is a relation from the columns of one table (the
+// child, which holds the key) to the primary key columns of another
+// (the parent).  Tables are identified by their qualified name, e.g.
+// "billing.invoice" for tables with a schema and "invoice" otherwise.
+type GraphRelation struct {
+	Kind RelationKind
+
+	// Name is the name of polymorphic associations, and the
+	// discriminator of their target type after a colon, e.g.
+	// "commentable:post".  For relations declared on fields (see
+	// ColumnMap.HasMany), it is the table and the field, e.g.
+	// "post.Comments".  It is empty for other kinds.
+	Name string
+
+	Table   string
+	Columns []string
+
+	Parent        string
+	ParentColumns []string
+}
+
+// Table returns the table in the graph with the passed in qualified
+// name, or nil if there is none.
+func (g *SchemaGraph) Table(name string) *GraphTable {
+	for i := range g.Tables {
+		if g.Tables[i].QualifiedName() == name {
+			return &g.Tables[i]
+		}
+	}
+	return nil
+}
+
+// QualifiedName returns the table's name, prefixed with its schema and
+// a dot if it has one.
+func (t *GraphTable) QualifiedName() string {
+	return qualifiedTableName(t.Schema, t.Name)
+}
+
+// RelationsOf returns the relations in the graph that the table with
+// the passed in qualified name is part of, as either the child or the
+// parent.
+func (g *SchemaGraph) RelationsOf(name string) []GraphRelation {
+	var relations []GraphRelation
+	for _, relation := range g.Relations {
+		if relation.Table == name || relation.Parent == name {
+			relations = append(relations, relation)
+		}
+	}
+	return relations
+}
+
+// SchemaGraph returns a graph of the tables registered with this
+// DbMap and the relations declared between them:
+//
+//     graph, err := dbmap.SchemaGraph()
+//     ...
+//     for _, rel := range graph.RelationsOf("post") {
+//         fmt.Printf("%s.%v -> %s.%v\n", rel.Table, rel.Columns, rel.Parent, rel.ParentColumns)
+//     }
+//
+// Tables are listed in the order they were registered.  An error is
+// returned if a column references an unregistered table.
+func (m *DbMap) SchemaGraph() (*SchemaGraph, error) {
+	graph := &SchemaGraph{Tables: make([]GraphTable, 0, len(m.tables))}
+	for _, table := range m.tables {
+		graph.Tables = append(graph.Tables, table.graphTable())
+	}
+	for _, table := range m.tables {
+		relations, err := table.graphRelations()
+		if err != nil {
+			return nil, err
+		}
+		graph.Relations = append(graph.Relations, relations...)
+	}
+	return graph, nil
+}
+
+// qualifiedTableName returns the name that identifies a table in a
+// SchemaGraph.
+func qualifiedTableName(schema, name string) string {
+	if schema == "" {
+		return name
+	}
+	return schema + "." + name
+}
+
+// graphTable returns the description of this table for a SchemaGraph.
+func (t *TableMap) graphTable() GraphTable {
+	gt := GraphTable{
+		Schema: t.SchemaName,
+		Name:   t.TableName,
+		GoType: t.gotype.String(),
+		Keys:   columnNames(t.keys),
+		Tags:   append([]string(nil), t.tags...),
+	}
+	for _, col := range t.columns {
+		if !col.inSchema() {
+			continue
+		}
+		gt.Columns = append(gt.Columns, GraphColumn{
+			Name:          col.ColumnName,
+			Field:         col.fieldName,
+			GoType:        col.gotype.String(),
+			MaxSize:       col.MaxSize,
+			PrimaryKey:    col.isPK,
+			AutoIncrement: col.isAutoIncr,
+			Unique:        col.Unique,
+			NotNull:       col.isNotNull,
+			Version:       col == t.version,
+		})
+	}
+	return gt
+}
+
+// graphRelations returns the relations in which this table holds the
+// key of another table.
+func (t *TableMap) graphRelations() ([]GraphRelation, error) {
+	name := qualifiedTableName(t.SchemaName, t.TableName)
+	var relations []GraphRelation
+
+	fks, err := t.foreignKeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, fk := range fks {
+		relations = append(relations, GraphRelation{
+			Kind:          RelationReferences,
+			Table:         name,
+			Columns:       []string{fk.col.ColumnName},
+			Parent:        qualifiedTableName(fk.parent.SchemaName, fk.parent.TableName),
+			ParentColumns: columnNames(fk.parent.keys),
+		})
+	}
+
+	for _, assoc := range t.dbmap.hasManyOf(t) {
+		var relationName string
+		if assoc.field != nil {
+			relationName = qualifiedTableName(assoc.parent.SchemaName, assoc.parent.TableName) + "." + assoc.field.fieldName
+		}
+		relations = append(relations, GraphRelation{
+			Kind:          RelationHasMany,
+			Name:          relationName,
+			Table:         name,
+			Columns:       []string{assoc.foreignKey.ColumnName},
+			Parent:        qualifiedTableName(assoc.parent.SchemaName, assoc.parent.TableName),
+			ParentColumns: columnNames(assoc.parent.keys),
+		})
+	}
+
+	fieldRelations, err := t.dbmap.fieldRelationsOf(t)
+	if err != nil {
+		return nil, err
+	}
+	for _, relation := range fieldRelations {
+		parent := relation.Parent()
+		relations = append(relations, GraphRelation{
+			Kind:          relation.Kind,
+			Name:          qualifiedTableName(relation.Table.SchemaName, relation.Table.TableName) + "." + relation.Field,
+			Table:         name,
+			Columns:       []string{relation.ForeignKey.ColumnName},
+			Parent:        qualifiedTableName(parent.SchemaName, parent.TableName),
+			ParentColumns: columnNames(parent.keys),
+		})
+	}
+
+	for _, assoc := range t.dbmap.manyToManyThrough(t) {
+		for _, side := range []struct {
+			key    *ColumnMap
+			parent *TableMap
+		}{{assoc.leftKey, assoc.left}, {assoc.rightKey, assoc.right}} {
+			relations = append(relations, GraphRelation{
+				Kind:          RelationManyToMany,
+				Table:         name,
+				Columns:       []string{side.key.ColumnName},
+				Parent:        qualifiedTableName(side.parent.SchemaName, side.parent.TableName),
+				ParentColumns: columnNames(side.parent.keys),
+			})
+		}
+	}
+
+	names := make([]string, 0, len(t.polymorphics))
+	for assocName := range t.polymorphics {
+		names = append(names, assocName)
+	}
+	sort.Strings(names)
+	for _, assocName := range names {
+		assoc := t.polymorphics[assocName]
+		discriminators := make([]string, 0, len(assoc.types))
+		for discriminator := range assoc.types {
+			discriminators = append(discriminators, discriminator)
+		}
+		sort.Strings(discriminators)
+		for _, discriminator := range discriminators {
+			parent := tableOrNil(t.dbmap, assoc.types[discriminator])
+			if parent == nil {
+				// Polymorphic targets only need to be registered by
+				// the time they are loaded.
+				continue
+			}
+			relations = append(relations, GraphRelation{
+				Kind:          RelationPolymorphic,
+				Name:          assoc.Name + ":" + discriminator,
+				Table:         name,
+				Columns:       []string{t.ColMap(assoc.typeField).ColumnName, t.ColMap(assoc.idField).ColumnName},
+				Parent:        qualifiedTableName(parent.SchemaName, parent.TableName),
+				ParentColumns: columnNames(parent.keys),
+			})
+		}
+	}
+
+	if t.closure != nil {
+		relations = append(relations, GraphRelation{
+			Kind:          RelationTree,
+			Table:         name,
+			Columns:       []string{t.closure.parentCol.ColumnName},
+			Parent:        name,
+			ParentColumns: columnNames(t.keys),
+		})
+	}
+	return relations, nil
+}
+
+// hasManyOf returns the one-to-many associations in which child is
+// the child table.
+func (m *DbMap) hasManyOf(child *TableMap) []*HasManyMap {
+	var assocs []*HasManyMap
+	for _, table := range m.tables {
+		for _, assoc := range table.hasMany {
+			if assoc.child == child {
+				assocs = append(assocs, assoc)
+			}
+		}
+	}
+	return assocs
+}
+
+// fieldRelationsOf returns the has-one and belongs-to relations
+// declared on fields in which child is the child table.  Has-many
+// relations declared on fields are associations (see hasManyOf).
+func (m *DbMap) fieldRelationsOf(child *TableMap) ([]Relation, error) {
+	var relations []Relation
+	for _, table := range m.tables {
+		tableRelations, err := table.Relations()
+		if err != nil {
+			return nil, err
+		}
+		for _, relation := range tableRelations {
+			if relation.Child() == child && relation.Kind != RelationHasMany {
+				relations = append(relations, relation)
+			}
+		}
+	}
+	return relations, nil
+}
+
+// manyToManyThrough returns the many-to-many associations that use
+// join as their join table.
+func (m *DbMap) manyToManyThrough(join *TableMap) []*ManyToManyMap {
+	var assocs []*ManyToManyMap
+	for _, table := range m.tables {
+		for _, assoc := range table.manyToMany {
+			if assoc.join == join {
+				assocs = append(assocs, assoc)
+			}
+		}
+	}
+	return assocs
+}
+
+// columnNames returns the names of cols.
+func columnNames(cols []*ColumnMap) []string {
+	names := make([]string, len(cols))
+	for i, col := range cols {
+		names[i] = col.ColumnName
+	}
+	return names
+}
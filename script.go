@@ -0,0 +1,191 @@
+package gorp
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// A ScriptError is returned by ExecScript when a statement of the
+// script fails.
+type ScriptError struct {
+	// Statement is the index of the failed statement, starting at 1.
+	Statement int
+	Query     string
+	Err       error
+}
+
+// Error returns a description of the failed statement and its error.
+func (e ScriptError) Error() string {
+	return fmt.Sprintf("gorp: ExecScript: statement %d failed: %s", e.Statement, e.Err)
+}
+
+// ExecScript splits script into statements (see SplitScript) and runs
+// them one by one with Exec, so they are traced and audited like any
+// other statement.  This is meant for SQL files provided by vendors or
+// written for the database's command line client.  ExecScript stops at
+// the first statement that fails, returning a ScriptError, or with
+// ctx.Err() if ctx is canceled between statements.  Statements that
+// have already run are not rolled back; use Transaction.ExecScript to
+// run a script atomically on databases with transactional DDL.
+func (m *DbMap) ExecScript(ctx context.Context, script string) error {
+	return execScript(ctx, m, m, script)
+}
+
+// ExecScript has the same behavior as DbMap.ExecScript(), but runs in
+// a transaction.
+func (t *Transaction) ExecScript(ctx context.Context, script string) error {
+	return execScript(ctx, t.dbmap, t, script)
+}
+
+func execScript(ctx context.Context, m *DbMap, exec SqlExecutor, script string) error {
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+	statements, err := m.SplitScript(script)
+	if err != nil {
+		return err
+	}
+	for i, statement := range statements {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if _, err = exec.Exec(statement); err != nil {
+			return ScriptError{Statement: i + 1, Query: statement, Err: err}
+		}
+	}
+	return nil
+}
+
+// SplitScript splits script into the statements it contains, without
+// their terminating semicolons.  Semicolons in string literals, quoted
+// identifiers, and comments don't end statements, and statements that
+// only contain comments are dropped.  If the dialect implements
+// ScriptSplitter, its syntax is used instead, e.g. Postgres'
+// dollar-quoted function bodies and MySQL's DELIMITER command.
+func (m *DbMap) SplitScript(script string) ([]string, error) {
+	if splitter, ok := m.Dialect.(ScriptSplitter); ok {
+		return splitter.SplitScript(script)
+	}
+	return splitScript(script, scriptSyntax{})
+}
+
+// scriptSyntax describes the extensions to standard SQL syntax that
+// affect how a dialect's scripts are split into statements.
+type scriptSyntax struct {
+	// dollarQuotes enables Postgres' $tag$...$tag$ strings.
+	dollarQuotes bool
+
+	// backslashEscapes makes backslashes escape the next character
+	// in string literals, and backticks enables `quoted` identifiers,
+	// as in MySQL.
+	backslashEscapes bool
+	backticks        bool
+
+	// delimiters enables the DELIMITER command of MySQL's command
+	// line client, which changes the statement terminator.
+	delimiters bool
+}
+
+var dollarQuoteRegexp = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+
+// splitScript splits script into statements using syntax.
+func splitScript(script string, syntax scriptSyntax) ([]string, error) {
+	var statements []string
+	delimiter := ";"
+	start := 0
+	hasCode := false
+	for i := 0; i < len(script); {
+		rest := script[i:]
+		lineStart := i == 0 || script[i-1] == '\n'
+		switch {
+		case syntax.delimiters && lineStart && !hasCode && isDelimiterCommand(rest):
+			end := lineEnd(script, i)
+			fields := strings.Fields(script[i:end])
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("gorp: SplitScript: invalid delimiter command %q", strings.TrimSpace(script[i:end]))
+			}
+			delimiter = fields[1]
+			i, start = end, end
+		case strings.HasPrefix(rest, "--"):
+			i = lineEnd(script, i)
+		case strings.HasPrefix(rest, "/*"):
+			end := strings.Index(rest[2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("gorp: SplitScript: unterminated comment at offset %d", i)
+			}
+			i += end + 4
+		case rest[0] == '\'' || rest[0] == '"' || (syntax.backticks && rest[0] == '`'):
+			end, err := quoteEnd(script, i, syntax.backslashEscapes && rest[0] != '`')
+			if err != nil {
+				return nil, err
+			}
+			i, hasCode = end, true
+		case syntax.dollarQuotes && rest[0] == '$' && (i == 0 || !isIdentChar(script[i-1])) && dollarQuoteRegexp.MatchString(rest):
+			tag := dollarQuoteRegexp.FindString(rest)
+			end := strings.Index(rest[len(tag):], tag)
+			if end < 0 {
+				return nil, fmt.Errorf("gorp: SplitScript: unterminated dollar-quoted string at offset %d", i)
+			}
+			i, hasCode = i+len(tag)+end+len(tag), true
+		case strings.HasPrefix(rest, delimiter):
+			if hasCode {
+				statements = append(statements, strings.TrimSpace(script[start:i]))
+			}
+			i += len(delimiter)
+			start, hasCode = i, false
+		default:
+			if !strings.ContainsRune(" \t\r\n", rune(rest[0])) {
+				hasCode = true
+			}
+			i++
+		}
+	}
+	if hasCode {
+		statements = append(statements, strings.TrimSpace(script[start:]))
+	}
+	return statements, nil
+}
+
+// isDelimiterCommand returns true if s starts with a DELIMITER
+// command.
+func isDelimiterCommand(s string) bool {
+	s = strings.TrimLeft(s, " \t")
+	return len(s) > len("delimiter") && strings.EqualFold(s[:len("delimiter")], "delimiter") &&
+		(s[len("delimiter")] == ' ' || s[len("delimiter")] == '\t')
+}
+
+// lineEnd returns the offset of the end of the line containing
+// offset i, after its newline.
+func lineEnd(s string, i int) int {
+	if end := strings.IndexByte(s[i:], '\n'); end >= 0 {
+		return i + end + 1
+	}
+	return len(s)
+}
+
+// quoteEnd returns the offset after the closing quote of the quoted
+// string or identifier starting at offset i.  Doubled quotes don't
+// close it, nor do escaped ones if backslashEscapes is true.
+func quoteEnd(s string, i int, backslashEscapes bool) (int, error) {
+	quote := s[i]
+	for j := i + 1; j < len(s); j++ {
+		switch {
+		case backslashEscapes && s[j] == '\\':
+			j++
+		case s[j] == quote:
+			if j+1 < len(s) && s[j+1] == quote {
+				j++
+				continue
+			}
+			return j + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("gorp: SplitScript: unterminated quote at offset %d", i)
+}
+
+// isIdentChar returns true if c can be part of an unquoted identifier.
+func isIdentChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
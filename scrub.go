@@ -0,0 +1,108 @@
+package gorp
+
+import (
+	"reflect"
+	"regexp"
+)
+
+// Scrubbed replaces arguments that an ArgScrubber removes entirely.
+const Scrubbed = "[scrubbed]"
+
+// An ArgScrubber transforms the arguments of a statement before they
+// are logged (see DbMap.TraceOn) or audited (see
+// DbMap.SetStatementAuditor), e.g. to remove personal data from logs
+// for compliance.  ScrubArgs must not modify args; it should return a
+// new slice instead.  The statement itself is always run with the
+// original arguments.
+type ArgScrubber interface {
+	ScrubArgs(query string, args []interface{}) []interface{}
+}
+
+// ScrubFunc is a function that implements ArgScrubber.
+type ScrubFunc func(query string, args []interface{}) []interface{}
+
+// ScrubArgs calls f(query, args).
+func (f ScrubFunc) ScrubArgs(query string, args []interface{}) []interface{} {
+	return f(query, args)
+}
+
+// SetArgScrubber makes this DbMap pass the arguments of every
+// statement it logs or audits to scrubber first, so sensitive values
+// never reach the logger or the auditor.  Unlike column masks (see
+// ColumnMap.SetMask), scrubbers see every argument of every statement,
+// including raw SQL run with Exec and Select.  Pass nil to stop
+// scrubbing.
+//
+//     dbmap.SetArgScrubber(gorp.Scrubbers(
+//         gorp.ScrubPattern(regexp.MustCompile(`\d{13,19}`), "[card]"),
+//         gorp.AllowTypes(int64(0), false, time.Time{}),
+//     ))
+//
+func (m *DbMap) SetArgScrubber(scrubber ArgScrubber) {
+	m.scrubber = scrubber
+}
+
+// scrubArgs returns args as they may be logged or audited.
+func (m *DbMap) scrubArgs(query string, args []interface{}) []interface{} {
+	if m.scrubber == nil {
+		return args
+	}
+	return m.scrubber.ScrubArgs(query, args)
+}
+
+// ScrubPattern returns an ArgScrubber that replaces each match of
+// pattern in string and []byte arguments with replacement, which may
+// refer to submatches as in regexp.Regexp.ReplaceAllString.  Other
+// arguments are left as they are.
+func ScrubPattern(pattern *regexp.Regexp, replacement string) ArgScrubber {
+	return ScrubFunc(func(query string, args []interface{}) []interface{} {
+		scrubbed := make([]interface{}, len(args))
+		for i, arg := range args {
+			switch v := arg.(type) {
+			case string:
+				scrubbed[i] = pattern.ReplaceAllString(v, replacement)
+			case []byte:
+				scrubbed[i] = pattern.ReplaceAll(v, []byte(replacement))
+			default:
+				scrubbed[i] = arg
+			}
+		}
+		return scrubbed
+	})
+}
+
+// AllowTypes returns an ArgScrubber that only lets through nil
+// arguments and arguments with the same type as one of examples, and
+// replaces all others with Scrubbed.  Use it as an allowlist of types
+// that can't hold sensitive data, e.g. keys and flags:
+//
+//     gorp.AllowTypes(int64(0), false)
+//
+func AllowTypes(examples ...interface{}) ArgScrubber {
+	allowed := make(map[reflect.Type]bool, len(examples))
+	for _, example := range examples {
+		allowed[reflect.TypeOf(example)] = true
+	}
+	return ScrubFunc(func(query string, args []interface{}) []interface{} {
+		scrubbed := make([]interface{}, len(args))
+		for i, arg := range args {
+			if arg == nil || allowed[reflect.TypeOf(arg)] {
+				scrubbed[i] = arg
+			} else {
+				scrubbed[i] = Scrubbed
+			}
+		}
+		return scrubbed
+	})
+}
+
+// Scrubbers returns an ArgScrubber that applies each of scrubbers in
+// order.
+func Scrubbers(scrubbers ...ArgScrubber) ArgScrubber {
+	return ScrubFunc(func(query string, args []interface{}) []interface{} {
+		for _, scrubber := range scrubbers {
+			args = scrubber.ScrubArgs(query, args)
+		}
+		return args
+	})
+}
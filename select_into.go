@@ -0,0 +1,135 @@
+package gorp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// SelectInto will run this query plan as a SELECT statement that
+// selects the columns of several of the plan's tables, and append
+// each row's values for each table to the corresponding target.  The
+// targets must be pointers to slices of the tables' types (or of
+// pointers to them), so joined rows can be loaded without a combined
+// struct:
+//
+//     var invoices []*Invoice
+//     var people []*Person
+//     err := dbmap.Query(inv).
+//         Join(person).On(gorp.Equal(&inv.PersonId, &person.Id)).
+//         Where().
+//         Equal(&inv.IsPaid, false).
+//         SelectInto(&invoices, &people)
+//
+// After it returns, people[i] is the person joined to invoices[i].
+// Each table's columns are selected with its name as a prefix of
+// their aliases, so columns with the same name don't collide.  Each
+// table's column access rules, masks, and PostGet hooks are applied.
+// Plans run with SelectInto aren't cached or memoized.
+func (plan *QueryPlan) SelectInto(targets ...interface{}) error {
+	plan.storeJoin()
+	if len(plan.Errors) > 0 {
+		return plan.Errors[0]
+	}
+	if len(targets) == 0 {
+		return errors.New("gorp: SelectInto requires at least one target")
+	}
+	slices := make([]reflect.Value, len(targets))
+	tables := make([]*TableMap, len(targets))
+	fields := make([]reflect.StructField, len(targets))
+	for i, target := range targets {
+		targetType := reflect.TypeOf(target)
+		if targetType == nil || targetType.Kind() != reflect.Ptr || targetType.Elem().Kind() != reflect.Slice {
+			return errors.New("gorp: SelectInto must be run with pointers to slices as its targets")
+		}
+		slices[i] = reflect.ValueOf(target).Elem()
+		t := targetType.Elem().Elem()
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if tables[i] = plan.intoTable(t); tables[i] == nil {
+			return fmt.Errorf("gorp: SelectInto: type %s is not the type of one of the plan's tables", t)
+		}
+		fields[i] = reflect.StructField{Name: fmt.Sprintf("T%d", i), Type: t}
+	}
+
+	// Each row is scanned into a struct with a field for each target,
+	// which is then split up.
+	rowType := reflect.StructOf(fields)
+	dialect := plan.table.dbmap.Dialect
+	columns := bytes.Buffer{}
+	var indexes [][]int
+	for i, table := range tables {
+		quotedTable := dialect.QuotedTableForQuery(table.SchemaName, table.TableName)
+		cols := plan.selectColumns()
+		if table != plan.table {
+			cols = cols[:0:0]
+			for _, col := range table.columns {
+				if col.inSchema() && plan.readable(col) {
+					cols = append(cols, col)
+				}
+			}
+		}
+		for _, col := range cols {
+			if len(indexes) > 0 {
+				columns.WriteString(",")
+			}
+			columns.WriteString(quotedTable)
+			columns.WriteString(".")
+			columns.WriteString(dialect.QuoteField(col.ColumnName))
+			columns.WriteString(" as ")
+			columns.WriteString(dialect.QuoteField(table.TableName + "__" + col.ColumnName))
+			field, _ := table.gotype.FieldByName(col.fieldName)
+			indexes = append(indexes, append([]int{i}, field.Index...))
+		}
+	}
+	query, err := plan.buildSelect(columns.String())
+	if err != nil {
+		return err
+	}
+
+	scanner := positionalScanner(plan.dbMap, indexes)
+	rows, err := plan.executor.query(query, plan.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	role := RoleFromContext(plan.ctx)
+	for rows.Next() {
+		row := reflect.New(rowType)
+		if err = scanner.scan(rows, row); err != nil {
+			return err
+		}
+		for i, table := range tables {
+			v := reflect.New(table.gotype)
+			v.Elem().Set(row.Elem().Field(i))
+			maskRow(table.maskedColumns(role), v)
+			if hook, ok := v.Interface().(HasPostGet); ok {
+				if err = hook.PostGet(plan.executor); err != nil {
+					return err
+				}
+			}
+			if slices[i].Type().Elem().Kind() != reflect.Ptr {
+				v = v.Elem()
+			}
+			slices[i].Set(reflect.Append(slices[i], v))
+		}
+	}
+	return rows.Err()
+}
+
+// intoTable returns the plan's table or the joined table with type t,
+// or nil if there is none.
+func (plan *QueryPlan) intoTable(t reflect.Type) *TableMap {
+	if plan.table.gotype == t {
+		return plan.table
+	}
+	for _, join := range plan.joins {
+		if join.table != nil && join.table.gotype == t {
+			return join.table
+		}
+	}
+	return nil
+}
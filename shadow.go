@@ -0,0 +1,174 @@
+package gorp
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// A ShadowPolicy determines how errors from shadow writes are
+// handled.
+type ShadowPolicy int
+
+const (
+	// ShadowBestEffort passes errors from shadow writes to the
+	// shadow's error handler (see TableMap.OnShadowError), but does not
+	// return them to the caller.
+	ShadowBestEffort ShadowPolicy = iota
+
+	// ShadowStrict returns errors from shadow writes to the caller, as
+	// if the write to the primary table had failed.  The primary write
+	// has already been made by then: it is only undone if it runs in a
+	// transaction that the caller rolls back, and a shadow table in
+	// another database (see TableMap.SetShadow) is never part of that
+	// transaction.
+	ShadowStrict
+)
+
+// A shadowWriter mirrors writes from a table to a second table.
+type shadowWriter struct {
+	dbmap      *DbMap
+	schemaName string
+	tableName  string
+	policy     ShadowPolicy
+	onError    func(table *TableMap, err error)
+
+	// table is generated from the primary table the first time it is
+	// needed, and discarded when the primary table's SQL is reset.  mu
+	// guards table and the statements it caches.
+	mu    sync.Mutex
+	table *TableMap
+}
+
+// SetShadow mirrors every row written to this table through
+// Insert(), Update(), or Delete() to a second table, to support live
+// migrations to new schemas or databases.  The shadow table must have
+// the same columns as this table.
+//
+// If target is nil, the shadow table lives in the same database as
+// this table and shadow writes run on the same executor (so they are
+// part of the same transaction, if any).  Otherwise, shadow writes are
+// run directly against target.  If name is empty, this table's name
+// is used.
+//
+// Rows are written to the shadow table using their primary key, so
+// auto-increment values generated by the primary table are copied to
+// the shadow table.  Writes made by query plans (e.g.
+// dbmap.Query(t).Assign(...).Update()) are not mirrored.
+func (t *TableMap) SetShadow(target *DbMap, schema, name string, policy ShadowPolicy) *TableMap {
+	if target == nil {
+		target = t.dbmap
+	}
+	if name == "" {
+		name = t.TableName
+	}
+	if schema == "" {
+		schema = t.SchemaName
+	}
+	t.shadow = &shadowWriter{
+		dbmap:      target,
+		schemaName: schema,
+		tableName:  name,
+		policy:     policy,
+	}
+	return t
+}
+
+// OnShadowError sets a function that will be called with any error
+// encountered while writing to this table's shadow table.  The
+// handler is called regardless of the shadow's policy.
+func (t *TableMap) OnShadowError(handler func(table *TableMap, err error)) *TableMap {
+	if t.shadow == nil {
+		panic(fmt.Sprintf("gorp: OnShadowError: no shadow table has been set for %s", t.TableName))
+	}
+	t.shadow.onError = handler
+	return t
+}
+
+// RemoveShadow stops mirroring writes from this table.
+func (t *TableMap) RemoveShadow() *TableMap {
+	t.shadow = nil
+	return t
+}
+
+// reset discards the shadow table, so that it is generated again from
+// the primary table.
+func (w *shadowWriter) reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.table = nil
+}
+
+// shadowTable returns a copy of t which is pointed at the shadow
+// table.  Keys are copied without auto-increment, so that generated
+// values are copied from the primary table, and there is no version
+// column, since the primary table has already checked the version.
+// w.mu must be held.
+func (w *shadowWriter) shadowTable(t *TableMap) *TableMap {
+	if w.table != nil {
+		return w.table
+	}
+	table := &TableMap{
+		TableName:  w.tableName,
+		SchemaName: w.schemaName,
+		gotype:     t.gotype,
+		dbmap:      w.dbmap,
+	}
+	copies := make(map[*ColumnMap]*ColumnMap, len(t.columns))
+	for _, col := range t.columns {
+		colCopy := *col
+		colCopy.isAutoIncr = false
+		copies[col] = &colCopy
+		table.columns = append(table.columns, &colCopy)
+	}
+	for _, key := range t.keys {
+		table.keys = append(table.keys, copies[key])
+	}
+	w.table = table
+	return table
+}
+
+// write mirrors op for elem to the shadow table.  exec is the
+// executor used for the primary write.
+func (w *shadowWriter) write(t *TableMap, exec SqlExecutor, op writeOp, elem reflect.Value) error {
+	if w.dbmap != t.dbmap {
+		exec = w.dbmap
+	}
+
+	var (
+		bi  bindInstance
+		err error
+	)
+	w.mu.Lock()
+	table := w.shadowTable(t)
+	switch op {
+	case writeInsert:
+		bi, err = table.bindInsert(elem)
+	case writeUpdate:
+		bi, err = table.bindUpdate(elem)
+	case writeDelete:
+		bi, err = table.bindDelete(elem)
+	}
+	w.mu.Unlock()
+	if err == nil {
+		_, err = exec.Exec(bi.query, bi.args...)
+	}
+	if err != nil {
+		if w.onError != nil {
+			w.onError(t, err)
+		}
+		if w.policy == ShadowStrict {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeShadow mirrors op for elem to this table's shadow table, if it
+// has one.
+func (t *TableMap) writeShadow(exec SqlExecutor, op writeOp, elem reflect.Value) error {
+	if t.shadow == nil {
+		return nil
+	}
+	return t.shadow.write(t, exec, op, elem)
+}
@@ -0,0 +1,155 @@
+package gorp
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// A Snapshot holds a copy of the rows of one or more tables, so that
+// they can be restored later.  Create one with DbMap.Snapshot.
+type Snapshot struct {
+	dbmap  *DbMap
+	tables []*tableSnapshot
+}
+
+// A tableSnapshot holds the rows of a single table, as values returned
+// by the driver for the table's columns.
+type tableSnapshot struct {
+	table   *TableMap
+	columns []*ColumnMap
+	rows    [][]interface{}
+}
+
+// Snapshot copies every row of the tables for models into memory, or
+// of every registered table if no models are passed in.  Call Restore
+// on the returned Snapshot to put the tables back the way they were,
+// e.g. after a test that can't run inside a single rolled-back
+// transaction:
+//
+//     snapshot, err := dbmap.Snapshot(Invoice{}, Person{})
+//     if err != nil {
+//         t.Fatal(err)
+//     }
+//     defer snapshot.Restore()
+//
+// Snapshots are meant for the small tables of test databases; every
+// row is held in memory.
+func (m *DbMap) Snapshot(models ...interface{}) (*Snapshot, error) {
+	tables, _, err := m.creationOrder()
+	if err != nil {
+		return nil, err
+	}
+	if len(models) > 0 {
+		selected := make(map[*TableMap]bool, len(models))
+		for _, model := range models {
+			t, err := toType(model)
+			if err != nil {
+				return nil, err
+			}
+			table, err := m.tableFor(t, false)
+			if err != nil {
+				return nil, err
+			}
+			selected[table] = true
+		}
+		tables = filterTables(tables, func(t *TableMap) bool {
+			return selected[t]
+		})
+	}
+
+	snapshot := &Snapshot{dbmap: m}
+	for _, table := range tables {
+		tableSnapshot, err := snapshotTable(m, table)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.tables = append(snapshot.tables, tableSnapshot)
+	}
+	return snapshot, nil
+}
+
+func snapshotTable(m *DbMap, table *TableMap) (*tableSnapshot, error) {
+	snapshot := &tableSnapshot{table: table}
+	s := bytes.Buffer{}
+	s.WriteString("select ")
+	for _, col := range table.columns {
+		if col.inSchema() {
+			if len(snapshot.columns) > 0 {
+				s.WriteString(", ")
+			}
+			s.WriteString(m.Dialect.QuoteField(col.ColumnName))
+			snapshot.columns = append(snapshot.columns, col)
+		}
+	}
+	s.WriteString(" from ")
+	s.WriteString(m.Dialect.QuotedTableForQuery(table.SchemaName, table.TableName))
+	rows, err := m.query(s.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		values := make([]interface{}, len(snapshot.columns))
+		targets := make([]interface{}, len(values))
+		for i := range values {
+			targets[i] = &values[i]
+		}
+		if err = rows.Scan(targets...); err != nil {
+			return nil, err
+		}
+		snapshot.rows = append(snapshot.rows, values)
+	}
+	return snapshot, rows.Err()
+}
+
+// Restore deletes every row of the snapshot's tables and inserts the
+// rows that were copied when the snapshot was taken, in a single
+// transaction.  Rows are inserted with their original primary keys,
+// but auto increment sequences are not reset.
+func (s *Snapshot) Restore() error {
+	tx, err := s.dbmap.Begin()
+	if err != nil {
+		return err
+	}
+	if err = s.restore(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Snapshot) restore(exec SqlExecutor) error {
+	dialect := s.dbmap.Dialect
+	// Children are deleted before their parents, and parents are
+	// inserted before their children.
+	for i := len(s.tables) - 1; i >= 0; i-- {
+		table := s.tables[i].table
+		if _, err := exec.Exec("delete from " + dialect.QuotedTableForQuery(table.SchemaName, table.TableName)); err != nil {
+			return err
+		}
+	}
+	for _, snapshot := range s.tables {
+		if len(snapshot.rows) == 0 {
+			continue
+		}
+		columns := bytes.Buffer{}
+		values := bytes.Buffer{}
+		for i, col := range snapshot.columns {
+			if i > 0 {
+				columns.WriteString(", ")
+				values.WriteString(", ")
+			}
+			columns.WriteString(dialect.QuoteField(col.ColumnName))
+			values.WriteString(dialect.BindVar(i))
+		}
+		query := fmt.Sprintf("insert into %s (%s) values (%s)",
+			dialect.QuotedTableForQuery(snapshot.table.SchemaName, snapshot.table.TableName),
+			columns.String(), values.String())
+		for _, row := range snapshot.rows {
+			if _, err := exec.Exec(query, row...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,445 @@
+package gorp
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// snapshotFormat identifies the format written by ExportSnapshot.
+const snapshotFormat = "gorp-snapshot/1"
+
+// A SnapshotSpec selects the rows of one table for ExportSnapshot.
+type SnapshotSpec struct {
+	// Model is a value of the table's type, e.g. Invoice{}.
+	Model interface{}
+
+	// Where is a condition in raw SQL that selects the rows to
+	// export, with a ? placeholder for each of Args.  If it is empty,
+	// every row is exported.  Being raw SQL, it isn't checked against
+	// the columns' read access.
+	Where string
+	Args  []interface{}
+
+	// WithDeleted exports the table's soft-deleted rows too (see
+	// TableMap.SetSoftDelete).
+	WithDeleted bool
+
+	// Unmasked exports the table's values as they are stored: column
+	// masks and read access restrictions aren't applied.  Only set it
+	// for snapshots that are kept as safe as the database itself.
+	Unmasked bool
+
+	// AsOf, if it isn't zero, exports the table as it was at that
+	// time.  The table must be system-versioned, and the dialect must
+	// implement SystemTimeQuerier.
+	AsOf time.Time
+}
+
+// snapshotHeader is the first value of an exported snapshot.
+type snapshotHeader struct {
+	Format  string    `json:"format"`
+	TakenAt time.Time `json:"taken_at"`
+}
+
+// snapshotTableHeader precedes the rows of each table of an exported
+// snapshot, which are arrays of the values of Columns.
+type snapshotTableHeader struct {
+	Schema  string   `json:"schema,omitempty"`
+	Table   string   `json:"table"`
+	Columns []string `json:"columns"`
+}
+
+// snapshotBytes is the encoding of a value that isn't valid UTF-8
+// text.
+type snapshotBytes struct {
+	Bytes string `json:"$bytes"`
+}
+
+// ExportSnapshot writes the rows selected by specs to w, in a portable
+// format that RestoreSnapshot loads, e.g. to copy the rows involved in
+// a support case to a staging database:
+//
+//     err := dbmap.ExportSnapshot(f,
+//         gorp.SnapshotSpec{Model: Person{}, Where: "Id = ?", Args: []interface{}{id}},
+//         gorp.SnapshotSpec{Model: Invoice{}, Where: "PersonId = ?", Args: []interface{}{id}})
+//
+// Every table is read in a single serializable transaction, so the
+// snapshot is consistent.  Tables are written parents first (see
+// ColumnMap.References).  The format is a stream of JSON values: a
+// header recording when the snapshot was taken, then, for each table,
+// an object naming the table and its columns followed by an array of
+// column values for each row.  Hooks aren't run.  Soft-deleted rows
+// are left out, as are the columns that a context without a role may
+// not read, and column masks are applied as they are for a context
+// without a role (see SnapshotSpec.Unmasked).  Tables with a tenant
+// column can't be exported; use ExportSnapshotContext.
+func (m *DbMap) ExportSnapshot(w io.Writer, specs ...SnapshotSpec) error {
+	return m.ExportSnapshotContext(context.Background(), w, specs...)
+}
+
+// ExportSnapshotContext is the same as ExportSnapshot, but reads the
+// rows within ctx, which decides the columns that are masked (see
+// WithRole) or left out (see ColumnMap.SetReadAccess) as it does for
+// query plans, and the tenant whose rows are exported from tables with
+// a tenant column (see WithTenant).
+func (m *DbMap) ExportSnapshotContext(ctx context.Context, w io.Writer, specs ...SnapshotSpec) error {
+	tables, _, err := m.creationOrder()
+	if err != nil {
+		return err
+	}
+	byTable := make(map[*TableMap][]SnapshotSpec, len(specs))
+	for _, spec := range specs {
+		t, err := toType(spec.Model)
+		if err != nil {
+			return err
+		}
+		table, err := m.tableFor(t, false)
+		if err != nil {
+			return err
+		}
+		if _, ok := TenantFrom(ctx); table.tenant != nil && !ok {
+			return fmt.Errorf("gorp: ExportSnapshot: No tenant in the context of an export of table %s; use WithTenant", table.TableName)
+		}
+		byTable[table] = append(byTable[table], spec)
+	}
+
+	m.trace("begin;")
+	sqlTx, err := m.Db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+	tx := &Transaction{dbmap: m, tx: sqlTx}
+	// The transaction only reads, so it is rolled back either way.
+	defer tx.Rollback()
+
+	enc := json.NewEncoder(w)
+	if err = enc.Encode(snapshotHeader{Format: snapshotFormat, TakenAt: m.now().UTC()}); err != nil {
+		return err
+	}
+	for _, table := range tables {
+		for _, spec := range byTable[table] {
+			if err = m.exportTable(ctx, withContext(ctx, m, tx), enc, table, spec); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// exportTable writes the rows of table selected by spec to enc, as
+// they may be read within ctx.
+func (m *DbMap) exportTable(ctx context.Context, exec SqlExecutor, enc *json.Encoder, table *TableMap, spec SnapshotSpec) error {
+	header := snapshotTableHeader{Schema: table.SchemaName, Table: table.TableName}
+	role := RoleFromContext(ctx)
+	var (
+		args    []interface{}
+		columns []*ColumnMap
+	)
+	s := bytes.Buffer{}
+	s.WriteString("select ")
+	for _, col := range table.columns {
+		if !col.inSchema() {
+			continue
+		}
+		if !spec.Unmasked && !col.isPK && col.readAccess != nil && !col.readAccess(ctx) {
+			continue
+		}
+		if len(header.Columns) > 0 {
+			s.WriteString(", ")
+		}
+		s.WriteString(m.Dialect.QuoteField(col.ColumnName))
+		header.Columns = append(header.Columns, col.ColumnName)
+		columns = append(columns, col)
+	}
+	s.WriteString(" from ")
+	s.WriteString(m.Dialect.QuotedTableForQuery(table.SchemaName, table.TableName))
+	if !spec.AsOf.IsZero() {
+		querier, ok := m.Dialect.(SystemTimeQuerier)
+		if !ok {
+			return fmt.Errorf("gorp: ExportSnapshot: dialect %T does not support system-versioned tables", m.Dialect)
+		}
+		s.WriteString(" ")
+		s.WriteString(querier.AsOf(m.Dialect.BindVar(0)))
+		args = append(args, spec.AsOf)
+	}
+	var conditions []string
+	if table.tenant != nil {
+		// ExportSnapshotContext has checked that ctx holds a tenant.
+		tenant, _ := TenantFrom(ctx)
+		conditions = append(conditions, m.Dialect.QuoteField(table.tenant.ColumnName)+" = "+m.Dialect.BindVar(len(args)))
+		args = append(args, tenant)
+	}
+	if table.deletedAt != nil && !spec.WithDeleted {
+		conditions = append(conditions, m.Dialect.QuoteField(table.deletedAt.ColumnName)+" is null")
+	}
+	if spec.Where != "" {
+		where, err := bindExpr(m.Dialect, spec.Where, len(args), len(spec.Args))
+		if err != nil {
+			return err
+		}
+		if len(conditions) > 0 {
+			where = "(" + where + ")"
+		}
+		conditions = append(conditions, where)
+		args = append(args, spec.Args...)
+	}
+	if len(conditions) > 0 {
+		s.WriteString(" where ")
+		s.WriteString(strings.Join(conditions, " and "))
+	}
+
+	rows, err := exec.query(s.String(), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if err = enc.Encode(header); err != nil {
+		return err
+	}
+	values := make([]interface{}, len(header.Columns))
+	targets := make([]interface{}, len(values))
+	for i := range values {
+		targets[i] = &values[i]
+	}
+	for rows.Next() {
+		if err = rows.Scan(targets...); err != nil {
+			return err
+		}
+		for i, value := range values {
+			if b, ok := value.([]byte); ok && utf8.Valid(b) {
+				value = string(b)
+			}
+			if !spec.Unmasked && columns[i].masked(role) {
+				value = maskValue(columns[i], value)
+			}
+			if b, ok := value.([]byte); ok {
+				value = snapshotBytes{Bytes: base64.StdEncoding.EncodeToString(b)}
+			}
+			values[i] = value
+		}
+		if err = enc.Encode(values); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// A restoredTable holds the rows of one table read from an exported
+// snapshot.
+type restoredTable struct {
+	table   *TableMap
+	columns []*ColumnMap
+	rows    [][]interface{}
+}
+
+// RestoreSnapshot loads a snapshot written by ExportSnapshot, in a
+// single transaction.  Rows of the snapshot's tables with the same
+// primary keys as the snapshot's rows are deleted, children first, and
+// then the snapshot's rows are inserted, parents first; other rows are
+// left alone.  The tables must be registered with the DbMap, and have
+// every column in the snapshot.  The whole snapshot is read into
+// memory before any rows are written.
+func (m *DbMap) RestoreSnapshot(r io.Reader) error {
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+	tables, err := m.readSnapshot(r)
+	if err != nil {
+		return err
+	}
+	tx, err := m.Begin()
+	if err != nil {
+		return err
+	}
+	if err = m.restoreSnapshot(tx, tables); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// readSnapshot reads the tables of an exported snapshot.
+func (m *DbMap) readSnapshot(r io.Reader) ([]*restoredTable, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var header snapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, err
+	}
+	if header.Format != snapshotFormat {
+		return nil, fmt.Errorf("gorp: RestoreSnapshot: unknown snapshot format %q", header.Format)
+	}
+	var tables []*restoredTable
+	var current *restoredTable
+	for {
+		var value json.RawMessage
+		if err := dec.Decode(&value); err == io.EOF {
+			return tables, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(value, []byte("{")) {
+			var tableHeader snapshotTableHeader
+			if err := json.Unmarshal(value, &tableHeader); err != nil {
+				return nil, err
+			}
+			restored, err := m.restoredTable(tableHeader)
+			if err != nil {
+				return nil, err
+			}
+			current = restored
+			tables = append(tables, current)
+			continue
+		}
+		if current == nil {
+			return nil, fmt.Errorf("gorp: RestoreSnapshot: row found before its table")
+		}
+		row, err := current.decodeRow(value)
+		if err != nil {
+			return nil, err
+		}
+		current.rows = append(current.rows, row)
+	}
+}
+
+// restoredTable returns the table and columns named by header.
+func (m *DbMap) restoredTable(header snapshotTableHeader) (*restoredTable, error) {
+	for _, table := range m.tables {
+		if table.SchemaName != header.Schema || table.TableName != header.Table {
+			continue
+		}
+		restored := &restoredTable{table: table}
+		for _, name := range header.Columns {
+			col := colMapOrNil(table, name)
+			if col == nil || !col.inSchema() {
+				return nil, fmt.Errorf("gorp: RestoreSnapshot: no column %s in table %s", name, table.TableName)
+			}
+			restored.columns = append(restored.columns, col)
+		}
+		return restored, nil
+	}
+	return nil, fmt.Errorf("gorp: RestoreSnapshot: no table %s registered", header.Table)
+}
+
+// decodeRow decodes a row of the table, converting each value to one
+// that the driver accepts for its column.
+func (t *restoredTable) decodeRow(data []byte) ([]interface{}, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	if len(raw) != len(t.columns) {
+		return nil, fmt.Errorf("gorp: RestoreSnapshot: row of table %s has %d values, expected %d", t.table.TableName, len(raw), len(t.columns))
+	}
+	row := make([]interface{}, len(raw))
+	for i, value := range raw {
+		converted, err := decodeSnapshotValue(value, t.columns[i].gotype)
+		if err != nil {
+			return nil, err
+		}
+		row[i] = converted
+	}
+	return row, nil
+}
+
+// decodeSnapshotValue decodes an exported value of a column whose field
+// is of type fieldType.
+func decodeSnapshotValue(data json.RawMessage, fieldType reflect.Type) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	for fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	switch v := value.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, nil
+		}
+		return v.Float64()
+	case map[string]interface{}:
+		var b snapshotBytes
+		if err := json.Unmarshal(data, &b); err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(b.Bytes)
+	case string:
+		switch {
+		case fieldType == reflect.TypeOf(time.Time{}):
+			return time.Parse(time.RFC3339Nano, v)
+		case fieldType.Kind() == reflect.Slice && fieldType.Elem().Kind() == reflect.Uint8:
+			return []byte(v), nil
+		}
+	}
+	return value, nil
+}
+
+// restoreSnapshot replaces the rows of tables.
+func (m *DbMap) restoreSnapshot(exec SqlExecutor, tables []*restoredTable) error {
+	dialect := m.Dialect
+	for i := len(tables) - 1; i >= 0; i-- {
+		restored := tables[i]
+		table := restored.table
+		keys := make([]int, 0, len(table.keys))
+		where := bytes.Buffer{}
+		for _, key := range table.keys {
+			for j, col := range restored.columns {
+				if col == key {
+					if where.Len() > 0 {
+						where.WriteString(" and ")
+					}
+					where.WriteString(dialect.QuoteField(col.ColumnName))
+					where.WriteString(" = ")
+					where.WriteString(dialect.BindVar(len(keys)))
+					keys = append(keys, j)
+				}
+			}
+		}
+		if len(table.keys) == 0 || len(keys) != len(table.keys) {
+			continue
+		}
+		query := "delete from " + dialect.QuotedTableForQuery(table.SchemaName, table.TableName) + " where " + where.String()
+		for _, row := range restored.rows {
+			args := make([]interface{}, len(keys))
+			for k, j := range keys {
+				args[k] = row[j]
+			}
+			if _, err := exec.Exec(query, args...); err != nil {
+				return err
+			}
+		}
+	}
+	for _, restored := range tables {
+		columns := bytes.Buffer{}
+		values := bytes.Buffer{}
+		for i, col := range restored.columns {
+			if i > 0 {
+				columns.WriteString(", ")
+				values.WriteString(", ")
+			}
+			columns.WriteString(dialect.QuoteField(col.ColumnName))
+			values.WriteString(dialect.BindVar(i))
+		}
+		query := fmt.Sprintf("insert into %s (%s) values (%s)",
+			dialect.QuotedTableForQuery(restored.table.SchemaName, restored.table.TableName),
+			columns.String(), values.String())
+		for _, row := range restored.rows {
+			if _, err := exec.Exec(query, row...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
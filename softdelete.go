@@ -0,0 +1,128 @@
+package gorp
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// SetSoftDelete marks deletedField as the soft-delete column of this
+// table.  A row whose deletion time is set is deleted: query plan
+// deletes set the column to the current time instead of removing rows,
+// and query plan selects (including counts and existence checks) skip
+// deleted rows, both of the plan's table and of the tables it joins
+// (see QueryPlan.Join), which includes Hydrate, SelectInto and Preload.
+// Use WithDeleted to include deleted rows in a select, or to remove
+// them with a delete.
+//
+//     dbmap.AddTable(Post{}).SetKeys(true, "Id").SetSoftDelete("DeletedAt")
+//
+// The field must be nullable, e.g. a *time.Time, since a null deletion
+// time means the row hasn't been deleted.  Deletion times are taken
+// from the DbMap's clock (see DbMap.SetClock), not the database's.
+// Rows deleted using Delete() or raw SQL are removed, and rows loaded
+// using Get(), raw SQL, a Loader or a Tree are not filtered.
+// SweepExpired and Archive include deleted rows.  Panics if
+// deletedField can't be found.
+func (t *TableMap) SetSoftDelete(deletedField string) *TableMap {
+	t.deletedAt = t.ColMap(deletedField)
+	t.ResetSql()
+	return t
+}
+
+// WithDeleted includes soft-deleted rows (see TableMap.SetSoftDelete)
+// in this plan's selects, and makes its deletes remove rows instead of
+// marking them as deleted.  Unlike Unscoped, it keeps the default
+// scopes of the plan's table (see TableMap.AddDefaultScope).
+func (plan *QueryPlan) WithDeleted() Query {
+	plan.withDeleted = true
+	return plan
+}
+
+// softDeletes returns true if this plan's table has a soft-delete
+// column that the plan respects.
+func (plan *QueryPlan) softDeletes() bool {
+	return plan.table.deletedAt != nil && !plan.unscoped && !plan.withDeleted
+}
+
+// undeletedClause returns a condition matching rows of the plan's table
+// that haven't been soft-deleted, or an empty string if the plan should
+// include deleted rows.
+func (plan *QueryPlan) undeletedClause() string {
+	if !plan.softDeletes() {
+		return ""
+	}
+	dialect := plan.table.dbmap.Dialect
+	return dialect.QuotedTableForQuery(plan.table.SchemaName, plan.table.TableName) + "." + dialect.QuoteField(plan.table.deletedAt.ColumnName) + " is null"
+}
+
+// undeletedJoinClauses returns conditions matching rows of the tables
+// joined by this plan that haven't been soft-deleted, or nil if the
+// plan should include deleted rows.
+func (plan *QueryPlan) undeletedJoinClauses() []string {
+	if plan.unscoped || plan.withDeleted {
+		return nil
+	}
+	var clauses []string
+	for _, join := range plan.joins {
+		if join.table == nil || join.table.deletedAt == nil {
+			continue
+		}
+		clauses = append(clauses, join.quotedJoinTable+"."+plan.table.dbmap.Dialect.QuoteField(join.table.deletedAt.ColumnName)+" is null")
+	}
+	return clauses
+}
+
+// softDelete marks the rows that this plan would delete as deleted,
+// returning the number of rows marked.  Rows that are already deleted
+// keep their deletion time.  Delete actions of the table's
+// associations (see HasManyMap.OnDelete) are not run.
+func (plan *QueryPlan) softDelete() (int64, error) {
+	query, err := plan.softDeleteQuery()
+	if err != nil {
+		return -1, err
+	}
+	if !plan.scoped() {
+		if err = plan.dbMap.audit(query, plan.args); err != nil {
+			return -1, err
+		}
+	}
+	return plan.execCount(query)
+}
+
+// softDeleteQuery returns the update statement that marks the rows that
+// this plan would delete as deleted.
+func (plan *QueryPlan) softDeleteQuery() (string, error) {
+	dialect := plan.table.dbmap.Dialect
+	quotedTable := dialect.QuotedTableForQuery(plan.table.SchemaName, plan.table.TableName)
+	// The deletion time's bind variable comes first in the statement,
+	// so its argument must too.
+	plan.args = append(plan.args, plan.dbMap.now())
+	buffer := bytes.Buffer{}
+	buffer.WriteString("update ")
+	buffer.WriteString(quotedTable)
+	buffer.WriteString(" set ")
+	buffer.WriteString(dialect.QuoteField(plan.table.deletedAt.ColumnName))
+	buffer.WriteString(" = ")
+	buffer.WriteString(dialect.BindVar(len(plan.args) - 1))
+
+	joinTables, whereClause, err := plan.deleteWhereClause()
+	if err != nil {
+		return "", err
+	}
+	if joinTables != "" {
+		if len(plan.table.keys) != 1 {
+			return "", fmt.Errorf("gorp: Cannot soft delete from table %s with joins, since it doesn't have exactly one primary key column", plan.table.TableName)
+		}
+		key := quotedTable + "." + dialect.QuoteField(plan.table.keys[0].ColumnName)
+		whereClause = fmt.Sprintf(" where %s in (select %s from %s, %s%s)", key, key, quotedTable, joinTables, whereClause)
+	}
+	buffer.WriteString(whereClause)
+	if whereClause == "" {
+		buffer.WriteString(" where ")
+	} else {
+		buffer.WriteString(" and ")
+	}
+	buffer.WriteString(plan.undeletedClause())
+	buffer.WriteString(plan.commentClause())
+	return buffer.String(), nil
+}
@@ -0,0 +1,310 @@
+package gorp
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCachedQueries is the maximum number of generated statements that
+// will be cached for each table.  Plans with shapes that vary a lot
+// (e.g. long lists of ORed filters) would otherwise grow the cache
+// forever.
+const maxCachedQueries = 256
+
+// A sqlCache holds SQL generated by query plans, keyed by the shape of
+// the plan that generated it.
+type sqlCache struct {
+	mu      sync.RWMutex
+	queries map[string]string
+}
+
+func (c *sqlCache) get(key string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	query, ok := c.queries[key]
+	return query, ok
+}
+
+func (c *sqlCache) set(key, query string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.queries == nil {
+		c.queries = make(map[string]string)
+	}
+	if len(c.queries) < maxCachedQueries {
+		c.queries[key] = query
+	}
+}
+
+func (c *sqlCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.queries = nil
+}
+
+// errUnshapedFilter is returned by filters whose sub-filters can't
+// describe their shape.
+var errUnshapedFilter = errors.New("gorp: filter does not support SQL caching")
+
+// A shapedFilter is a filter that can describe the where clause it
+// would generate without generating it.  The description is written
+// to key, and must be different for any two filters that would
+// generate different SQL.  Arguments must be appended to args in the
+// same order that Where() would return them.
+//
+// Query plans only cache generated SQL if all of their filters
+// implement shapedFilter; filters defined outside of gorp will simply
+// cause the SQL to be regenerated every time.
+type shapedFilter interface {
+	shape(structMap ColumnResolver, key *bytes.Buffer, args []interface{}) ([]interface{}, error)
+}
+
+// writeFilterShape writes the shape of filter to key, returning false
+// if filter can't describe its shape.
+func writeFilterShape(filter Filter, structMap ColumnResolver, key *bytes.Buffer, args []interface{}) ([]interface{}, bool, error) {
+	shaper, ok := filter.(shapedFilter)
+	if !ok {
+		return args, false, nil
+	}
+	args, err := shaper.shape(structMap, key, args)
+	return args, err == nil, err
+}
+
+// cachedQuery returns the statement of the passed in kind for this
+// plan, using the table's cache if possible and calling build
+// otherwise.  Either way, plan.args will contain the arguments for the
+// returned statement.
+func (plan *QueryPlan) cachedQuery(kind string, build func() (string, error)) (string, error) {
+	if len(plan.Errors) > 0 {
+		return "", plan.Errors[0]
+	}
+	// Comments are left out of the shape and cached statements, since
+	// they may be unique to each plan (e.g. a request id).
+	comment := plan.commentClause()
+	key, args, ok := plan.selectShape(kind)
+	if ok {
+		if query, found := plan.table.queryCache.get(key); found {
+			plan.args = args
+			return query + comment, nil
+		}
+	}
+	query, err := build()
+	if err != nil {
+		return "", err
+	}
+	if ok && strings.HasSuffix(query, comment) {
+		plan.table.queryCache.set(key, strings.TrimSuffix(query, comment))
+	}
+	return query, nil
+}
+
+// selectShape returns a key describing the statement of the passed in
+// kind that would be generated for this plan, along with its
+// arguments.  It returns false if the plan's statement can't be
+// cached.
+func (plan *QueryPlan) selectShape(kind string) (string, []interface{}, bool) {
+	plan.storeJoin()
+	if plan.from != nil {
+		// Derived tables are built from other plans, which aren't
+		// part of the shape.
+		return "", nil, false
+	}
+	if len(plan.hydrated) > 0 {
+		// Hydrated plans are run with their own scanner, which
+		// depends on the plan's joined tables.
+		return "", nil, false
+	}
+	key := bytes.Buffer{}
+	key.WriteString(kind)
+	var args []interface{}
+	if plan.selectCols != nil {
+		key.WriteString(" cols")
+		for index, col := range plan.table.columns {
+			if plan.selectCols[col] {
+				key.WriteString(" ")
+				key.WriteString(strconv.Itoa(index))
+			}
+		}
+	}
+	for _, hint := range plan.hints {
+		key.WriteString(" hint ")
+		key.WriteString(hint)
+	}
+	for _, index := range plan.indexes {
+		key.WriteString(" index ")
+		key.WriteString(index)
+	}
+	if plan.asOf != nil {
+		key.WriteString(" asof")
+		args = append(args, *plan.asOf)
+	}
+	if stale := plan.staleReadClause(); stale != "" {
+		key.WriteString(" stale ")
+		key.WriteString(stale)
+	}
+	for index, col := range plan.table.columns {
+		if !plan.readable(col) {
+			key.WriteString(" hide ")
+			key.WriteString(strconv.Itoa(index))
+		}
+	}
+	for _, join := range plan.joins {
+		var ok bool
+		var err error
+		key.WriteString(" join ")
+		key.WriteString(join.quotedJoinTable)
+		if args, ok, err = writeFilterShape(join, plan.colMap, &key, args); !ok || err != nil {
+			return "", nil, false
+		}
+	}
+	if filter := plan.whereFilter(); filter != nil {
+		var ok bool
+		var err error
+		key.WriteString(" where ")
+		if args, ok, err = writeFilterShape(filter, plan.colMap, &key, args); !ok || err != nil {
+			return "", nil, false
+		}
+	}
+	if !plan.unscoped && !plan.withDeleted {
+		// Joined tables may soft delete even if the plan's table
+		// doesn't.
+		key.WriteString(" undeleted")
+	}
+	if plan.table.expiresAt != nil && !plan.includeExpired {
+		key.WriteString(" unexpired")
+		args = append(args, time.Now())
+	}
+	if len(plan.resultColumns) > 0 {
+		// Mapped result columns change the column names of filters
+		// without changing their shape.
+		return "", nil, false
+	}
+	if len(plan.computed) > 0 {
+		// Computed fields are expressions, which can't describe their
+		// shape.
+		return "", nil, false
+	}
+	for _, groupBy := range plan.groupBy {
+		column, ok := groupBy.(string)
+		if !ok {
+			// Expressions can't describe their shape.
+			return "", nil, false
+		}
+		key.WriteString(" group ")
+		key.WriteString(column)
+	}
+	for _, orderBy := range plan.orderBy {
+		key.WriteString(" order ")
+		key.WriteString(orderBy)
+	}
+	if plan.limitBy != "" {
+		key.WriteString(" limitby ")
+		key.WriteString(plan.limitBy)
+	}
+	if plan.offset > 0 {
+		key.WriteString(" offset")
+	}
+	limit := plan.effectiveLimit()
+	if limit > 0 {
+		key.WriteString(" limit")
+	}
+	_, limitArgs := limitClause(plan.table.dbmap.Dialect, limit, plan.offset, len(args))
+	args = append(args, limitArgs...)
+	return key.String(), args, true
+}
+
+func (filter *combinedFilter) shapeFilters(name string, structMap ColumnResolver, key *bytes.Buffer, args []interface{}) ([]interface{}, error) {
+	key.WriteString(name)
+	key.WriteString("(")
+	for index, subFilter := range filter.subFilters {
+		var ok bool
+		var err error
+		if index != 0 {
+			key.WriteString(",")
+		}
+		if args, ok, err = writeFilterShape(subFilter, structMap, key, args); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, errUnshapedFilter
+		}
+	}
+	key.WriteString(")")
+	return args, nil
+}
+
+func (filter *andFilter) shape(structMap ColumnResolver, key *bytes.Buffer, args []interface{}) ([]interface{}, error) {
+	return filter.shapeFilters("and", structMap, key, args)
+}
+
+func (filter *orFilter) shape(structMap ColumnResolver, key *bytes.Buffer, args []interface{}) ([]interface{}, error) {
+	return filter.shapeFilters("or", structMap, key, args)
+}
+
+func (filter *comparisonFilter) shape(structMap ColumnResolver, key *bytes.Buffer, args []interface{}) ([]interface{}, error) {
+	args, err := shapeOperand(filter.left, structMap, key, args)
+	if err != nil {
+		return nil, err
+	}
+	key.WriteString(filter.comparison)
+	return shapeOperand(filter.right, structMap, key, args)
+}
+
+// shapeOperand writes the shape of one side of a comparison to key.
+func shapeOperand(operand interface{}, structMap ColumnResolver, key *bytes.Buffer, args []interface{}) ([]interface{}, error) {
+	if _, ok := operand.(Expression); ok {
+		// Expressions can't describe their shape.
+		return nil, errUnshapedFilter
+	}
+	if reflect.ValueOf(operand).Kind() == reflect.Ptr {
+		column, err := structMap.tableColumnForPointer(operand)
+		if err != nil {
+			return nil, err
+		}
+		key.WriteString(column)
+		return args, nil
+	}
+	key.WriteString("?")
+	return append(args, operand), nil
+}
+
+func (filter *foldFilter) shape(structMap ColumnResolver, key *bytes.Buffer, args []interface{}) ([]interface{}, error) {
+	key.WriteString("fold ")
+	return filter.comparisonFilter.shape(structMap, key, args)
+}
+
+func (filter *notFilter) shape(structMap ColumnResolver, key *bytes.Buffer, args []interface{}) ([]interface{}, error) {
+	key.WriteString("not ")
+	args, ok, err := writeFilterShape(filter.filter, structMap, key, args)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errUnshapedFilter
+	}
+	return args, nil
+}
+
+func (filter *nullFilter) shape(structMap ColumnResolver, key *bytes.Buffer, args []interface{}) ([]interface{}, error) {
+	column, err := structMap.tableColumnForPointer(filter.addr)
+	if err != nil {
+		return nil, err
+	}
+	key.WriteString(column)
+	key.WriteString(" null")
+	return args, nil
+}
+
+func (filter *notNullFilter) shape(structMap ColumnResolver, key *bytes.Buffer, args []interface{}) ([]interface{}, error) {
+	column, err := structMap.tableColumnForPointer(filter.addr)
+	if err != nil {
+		return nil, err
+	}
+	key.WriteString(column)
+	key.WriteString(" not null")
+	return args, nil
+}
@@ -0,0 +1,139 @@
+package gorp
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// InvalidTransition is returned by Update when a state column (see
+// ColumnMap.AllowTransition) would move from one state to a state that
+// isn't allowed.
+type InvalidTransition struct {
+	TableName  string
+	ColumnName string
+
+	// From is the state found in the database, and To is the state
+	// that the update tried to set.
+	From interface{}
+	To   interface{}
+
+	// Stale is true if the transition was allowed when the row was
+	// checked, but the row's state was changed by someone else before
+	// it was updated.  It can only be true for columns using
+	// compare-and-swap (see ColumnMap.SetCompareAndSwap).
+	Stale bool
+}
+
+// Error returns a description of the transition
+func (e InvalidTransition) Error() string {
+	if e.Stale {
+		return fmt.Sprintf("gorp: InvalidTransition table %s column %s changed to %v before it could be updated to %v",
+			e.TableName, e.ColumnName, e.From, e.To)
+	}
+	return fmt.Sprintf("gorp: InvalidTransition table %s column %s cannot change from %v to %v",
+		e.TableName, e.ColumnName, e.From, e.To)
+}
+
+// AllowTransition marks this column as a state machine column, and
+// allows its value to change from from to each of the passed in to
+// states.  Once a column has any allowed transitions, Update() reads
+// the column's current value before updating a row, and returns an
+// InvalidTransition error if the change isn't allowed.  Updates that
+// don't change the state are always allowed.
+//
+//     table.ColMap("Status").
+//         AllowTransition("draft", "sent").
+//         AllowTransition("sent", "paid", "void")
+//
+func (c *ColumnMap) AllowTransition(from interface{}, to ...interface{}) *ColumnMap {
+	if c.transitions == nil {
+		c.transitions = make(map[string]map[string]bool)
+	}
+	fromKey := CacheKey(from)
+	if c.transitions[fromKey] == nil {
+		c.transitions[fromKey] = make(map[string]bool)
+	}
+	for _, state := range to {
+		c.transitions[fromKey][CacheKey(state)] = true
+	}
+	return c
+}
+
+// SetCompareAndSwap adds the state read before an update to the
+// update's where clause, so that the update fails with a stale
+// InvalidTransition error if another update changed the state in the
+// meantime.  It only has an effect on columns with allowed transitions.
+func (c *ColumnMap) SetCompareAndSwap(b bool) *ColumnMap {
+	c.compareAndSwap = b
+	return c
+}
+
+// checkTransitions validates the transitions of any state columns in
+// an update of elem, and adds compare-and-swap conditions to bi.  It
+// returns the states that were compared, so that a failed update can
+// be reported.
+func (t *TableMap) checkTransitions(exec SqlExecutor, bi *bindInstance, elem reflect.Value) (map[*ColumnMap]interface{}, error) {
+	var swapped map[*ColumnMap]interface{}
+	for _, col := range t.columns {
+		if col.transitions == nil || !col.inSchema() {
+			continue
+		}
+		current, err := t.currentState(exec, col, bi.keys)
+		if err != nil {
+			return nil, err
+		}
+		if len(current) == 0 {
+			// The row doesn't exist, so the update won't change it.
+			continue
+		}
+		from, to := current[0], fieldValue(elem, col.fieldName)
+		fromKey, toKey := CacheKey(from), CacheKey(to)
+		if fromKey != toKey && !col.transitions[fromKey][toKey] {
+			return nil, InvalidTransition{TableName: t.TableName, ColumnName: col.ColumnName, From: from, To: to}
+		}
+		if col.compareAndSwap {
+			bi.query = strings.TrimSuffix(bi.query, ";") + " and " +
+				t.dbmap.Dialect.QuoteField(col.ColumnName) + "=" + t.dbmap.Dialect.BindVar(len(bi.args)) + ";"
+			bi.args = append(bi.args, from)
+			if swapped == nil {
+				swapped = make(map[*ColumnMap]interface{})
+			}
+			swapped[col] = to
+		}
+	}
+	return swapped, nil
+}
+
+// staleTransition returns an InvalidTransition error for the first
+// compare-and-swap column whose state no longer matches.
+func (t *TableMap) staleTransition(exec SqlExecutor, bi bindInstance, swapped map[*ColumnMap]interface{}) error {
+	for _, col := range t.columns {
+		to, ok := swapped[col]
+		if !ok {
+			continue
+		}
+		current, err := t.currentState(exec, col, bi.keys)
+		if err != nil {
+			return err
+		}
+		if len(current) > 0 {
+			return InvalidTransition{TableName: t.TableName, ColumnName: col.ColumnName, From: current[0], To: to, Stale: true}
+		}
+	}
+	return nil
+}
+
+// currentState reads col's current value for the row with the passed
+// in keys.  The result is empty if the row doesn't exist.
+func (t *TableMap) currentState(exec SqlExecutor, col *ColumnMap, keys []interface{}) ([]interface{}, error) {
+	dialect := t.dbmap.Dialect
+	query := fmt.Sprintf("select %s from %s where ", dialect.QuoteField(col.ColumnName), dialect.QuotedTableForQuery(t.SchemaName, t.TableName))
+	for x, key := range t.keys {
+		if x > 0 {
+			query += " and "
+		}
+		query += dialect.QuoteField(key.ColumnName) + "=" + dialect.BindVar(x)
+	}
+	return selectKeys(exec, col.gotype, query, keys...)
+}
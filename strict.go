@@ -0,0 +1,87 @@
+package gorp
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var valuerType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+
+// SetStrict makes this DbMap check struct mappings for mistakes that
+// would otherwise lose data silently, if b is true.  AddTable panics
+// if two of the struct's fields map to the same column, if a db tag
+// holds something other than a column name (e.g. `db:"name,omitempty"`),
+// or, unless the DbMap has a TypeConverter, if a field's type can
+// never be stored in a column.  ValidateSchema also reports columns
+// that exist in the database but aren't mapped to a field, whose
+// values would never be loaded.  Fields tagged `db:"-"` are left out
+// of both checks.  Like AddTable, SetStrict must be called before the
+// DbMap is used.
+func (m *DbMap) SetStrict(b bool) *DbMap {
+	m.strict = b
+	return m
+}
+
+// checkStrict panics if the table's mapping has any of the mistakes
+// that strict DbMaps reject.
+func (t *TableMap) checkStrict() {
+	var problems []string
+	byName := make(map[string]*ColumnMap, len(t.columns))
+	for _, col := range t.columns {
+		if col.Transient {
+			continue
+		}
+		name := strings.ToLower(col.ColumnName)
+		if other, ok := byName[name]; ok {
+			problems = append(problems, fmt.Sprintf("fields %s and %s both map to column %s", other.fieldName, col.fieldName, col.ColumnName))
+		}
+		byName[name] = col
+		if strings.ContainsAny(col.ColumnName, ", \t\n") {
+			problems = append(problems, fmt.Sprintf("field %s has db tag %q, which is not a column name", col.fieldName, col.ColumnName))
+		}
+		if t.dbmap.TypeConverter == nil && !storable(col.gotype) {
+			problems = append(problems, fmt.Sprintf("field %s of type %s can't be stored in a column", col.fieldName, col.gotype))
+		}
+	}
+	if len(problems) > 0 {
+		panic(fmt.Sprintf("gorp: AddTable: struct %s can't be mapped strictly: %s", t.gotype.Name(), strings.Join(problems, "; ")))
+	}
+}
+
+// storable returns false for field types that no driver can store,
+// whatever their values.  Structs and slices are left to the driver,
+// since they may implement driver.Valuer, or be declared as relations
+// after the table is added.
+func storable(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Func, reflect.Chan, reflect.Map, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+		return reflect.PtrTo(t).Implements(valuerType)
+	}
+	return true
+}
+
+// unmappedColumns returns the names of the columns in existing, the
+// (lower case) names of the table's columns in the database, that
+// aren't mapped to any of the table's fields.
+func (t *TableMap) unmappedColumns(existing map[string]struct{}) []string {
+	mapped := make(map[string]bool, len(t.columns))
+	for _, col := range t.columns {
+		if !col.Transient {
+			mapped[strings.ToLower(col.ColumnName)] = true
+		}
+	}
+	var unmapped []string
+	for name := range existing {
+		if !mapped[name] {
+			unmapped = append(unmapped, name)
+		}
+	}
+	sort.Strings(unmapped)
+	return unmapped
+}
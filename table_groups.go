@@ -0,0 +1,80 @@
+package gorp
+
+import (
+	"strings"
+)
+
+// Tag adds this table to the named groups, so that the tables of a
+// group can be created and dropped together (see
+// DbMap.CreateTablesTagged and DbMap.DropTablesTagged).  This lets
+// integration tests that share a database reset only the tables they
+// own:
+//
+//     dbmap.AddTable(Invoice{}).SetKeys(true, "Id").Tag("billing")
+//     dbmap.AddTable(Payment{}).SetKeys(true, "Id").Tag("billing")
+//     ...
+//     dbmap.DropTablesTagged("billing")
+//     dbmap.CreateTablesTagged("billing")
+//
+func (t *TableMap) Tag(tags ...string) *TableMap {
+	t.tags = append(t.tags, tags...)
+	return t
+}
+
+// HasTag returns true if this table has been added to the named
+// group.
+func (t *TableMap) HasTag(tag string) bool {
+	for _, tableTag := range t.tags {
+		if tableTag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateTablesTagged is the same as CreateTables, but only creates
+// the tables that have been tagged with tag.
+func (m *DbMap) CreateTablesTagged(tag string) error {
+	return m.createTables(false, func(t *TableMap) bool {
+		return t.HasTag(tag)
+	})
+}
+
+// DropTablesTagged is the same as DropTablesIfExists, but only drops
+// the tables that have been tagged with tag.
+func (m *DbMap) DropTablesTagged(tag string) error {
+	return m.dropTables(true, func(t *TableMap) bool {
+		return t.HasTag(tag)
+	})
+}
+
+// CreateTablesMatching is the same as CreateTables, but only creates
+// the tables whose names start with prefix.
+func (m *DbMap) CreateTablesMatching(prefix string) error {
+	return m.createTables(false, func(t *TableMap) bool {
+		return strings.HasPrefix(t.TableName, prefix)
+	})
+}
+
+// DropTablesMatching is the same as DropTablesIfExists, but only
+// drops the tables whose names start with prefix.
+func (m *DbMap) DropTablesMatching(prefix string) error {
+	return m.dropTables(true, func(t *TableMap) bool {
+		return strings.HasPrefix(t.TableName, prefix)
+	})
+}
+
+// filterTables returns the tables for which match returns true, or
+// all of them if match is nil.
+func filterTables(tables []*TableMap, match func(*TableMap) bool) []*TableMap {
+	if match == nil {
+		return tables
+	}
+	matched := make([]*TableMap, 0, len(tables))
+	for _, t := range tables {
+		if match(t) {
+			matched = append(matched, t)
+		}
+	}
+	return matched
+}
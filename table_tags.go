@@ -0,0 +1,89 @@
+package gorp
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// AddTablesFromTags adds a table for each model, configured by a
+// `gorp` tag on one of the model's fields (or of an embedded struct's
+// fields), and returns the tables in the same order:
+//
+//     type Invoice struct {
+//         Id   int64 `gorp:"table=invoices,pk=Id,autoincrement"`
+//         Memo string
+//     }
+//
+//     dbmap.AddTablesFromTags(Invoice{}, Person{}, InvoiceLine{})
+//
+// The tag is a comma separated list of options:
+//
+//     table=name     the table name (defaults to the type name)
+//     schema=name    the schema name
+//     pk=Field       a primary key field; repeat for composite keys
+//     autoincrement  the primary key is auto-incremented
+//
+// Panics if a model has no `gorp` tag or if the tag is invalid, like
+// AddTable and SetKeys do for invalid mappings.
+func (m *DbMap) AddTablesFromTags(models ...interface{}) []*TableMap {
+	tables := make([]*TableMap, 0, len(models))
+	for _, model := range models {
+		t := reflect.TypeOf(model)
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		tag, ok := tableTag(t)
+		if !ok {
+			panic(fmt.Sprintf("gorp: AddTablesFromTags: %s has no field with a gorp tag", t.Name()))
+		}
+		var schema, name string
+		var keys []string
+		autoIncr := false
+		for _, option := range strings.Split(tag, ",") {
+			option = strings.TrimSpace(option)
+			key, value := option, ""
+			if i := strings.Index(option, "="); i >= 0 {
+				key, value = option[:i], option[i+1:]
+			}
+			switch key {
+			case "table":
+				name = value
+			case "schema":
+				schema = value
+			case "pk":
+				keys = append(keys, value)
+			case "autoincrement":
+				autoIncr = true
+			case "":
+			default:
+				panic(fmt.Sprintf("gorp: AddTablesFromTags: unknown option %q in the gorp tag of %s", option, t.Name()))
+			}
+		}
+		table := m.AddTableWithNameAndSchema(reflect.Zero(t).Interface(), schema, name)
+		if len(keys) > 0 {
+			table.SetKeys(autoIncr, keys...)
+		} else if autoIncr {
+			panic(fmt.Sprintf("gorp: AddTablesFromTags: the gorp tag of %s has autoincrement but no pk", t.Name()))
+		}
+		tables = append(tables, table)
+	}
+	return tables
+}
+
+// tableTag returns the first `gorp` tag on t's fields, looking
+// through embedded structs in field order.
+func tableTag(t reflect.Type) (string, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if tag, ok := f.Tag.Lookup("gorp"); ok {
+			return tag, true
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			if tag, ok := tableTag(f.Type); ok {
+				return tag, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,83 @@
+package gorp
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// tenantKey is the context key of the tenant set by WithTenant.
+type tenantKey struct{}
+
+// WithTenant returns a copy of ctx that holds tenant, the value of the
+// tenant columns (see TableMap.SetTenantColumn) of the rows that query
+// plans run with the context may read and write.
+func WithTenant(ctx context.Context, tenant interface{}) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFrom returns the tenant that ctx holds (see WithTenant), or
+// false if it holds none.
+func TenantFrom(ctx context.Context) (interface{}, bool) {
+	tenant := ctx.Value(tenantKey{})
+	return tenant, tenant != nil
+}
+
+// SetTenantColumn marks tenantField as the column that holds the
+// tenant each row belongs to, so that query plans on this table only
+// see and change the rows of the tenant in their context:
+//
+//     dbmap.AddTable(Invoice{}).SetKeys(true, "Id").SetTenantColumn("AccountId")
+//     ...
+//     ctx = gorp.WithTenant(ctx, account.Id)
+//     results, err := dbmap.QueryContext(ctx, inv).Where().Equal(&inv.IsPaid, false).Select()
+//
+// The tenant is compared to the column in the where clause of every
+// select, update, and delete statement, and in the join condition of
+// plans that join the table, and is assigned to the column by every
+// insert.  Unscoped doesn't remove the comparison, plans may not
+// assign the column themselves, and plans can't truncate the table.
+// Plans on the table fail unless their context holds a tenant, so a
+// missing tenant can't expose every tenant's rows.  Get(), Insert(),
+// Update(), Delete(), and raw SQL take no context, so they are not
+// restricted; use query plans for tenants' data.  SweepExpired and
+// Archive maintain the rows of every tenant.  Panics if tenantField
+// can't be found.
+func (t *TableMap) SetTenantColumn(tenantField string) *TableMap {
+	t.tenant = t.ColMap(tenantField)
+	t.ResetSql()
+	return t
+}
+
+// tenantFilter returns a filter comparing the tenant column of table
+// to the tenant in the plan's context, with target, a pointer to a
+// struct of table's type, supplying the field pointer.  It returns
+// nil if table has no tenant column.
+func (plan *QueryPlan) tenantFilter(table *TableMap, target reflect.Value) (Filter, error) {
+	if table.tenant == nil {
+		return nil, nil
+	}
+	tenant, ok := TenantFrom(plan.ctx)
+	if !ok {
+		return nil, fmt.Errorf("gorp: No tenant in the context of a query on table %s; use WithTenant", table.TableName)
+	}
+	field := target.Elem().FieldByName(table.tenant.fieldName)
+	return Equal(field.Addr().Interface(), tenant), nil
+}
+
+// assignTenant assigns the tenant in the plan's context to the tenant
+// column of the plan's table, if it has one.
+func (plan *QueryPlan) assignTenant() {
+	if plan.table.tenant == nil {
+		return
+	}
+	tenant, ok := TenantFrom(plan.ctx)
+	if !ok {
+		// The plan has already failed (see tenantFilter).
+		return
+	}
+	dialect := plan.table.dbmap.Dialect
+	plan.assignCols = append(plan.assignCols, dialect.QuoteField(plan.table.tenant.ColumnName))
+	plan.assignBindVars = append(plan.assignBindVars, dialect.BindVar(len(plan.args)))
+	plan.args = append(plan.args, tenant)
+}
@@ -0,0 +1,92 @@
+package gorp
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SetWriteLimit limits the write statements run against the table to
+// perSecond per second on average, allowing bursts of up to burst
+// statements, so that bulk jobs can't crowd out other traffic on a
+// shared database:
+//
+//     dbmap.AddTable(Event{}).SetKeys(true, "Id").SetWriteLimit(200, 50)
+//
+// Writes over the limit wait until it allows them.  Insert(),
+// Update(), and Delete() count one statement per row, and query plans
+// one per insert, update, or delete statement.  Writes stop waiting
+// with the context's error if the context they run with (see
+// QueryContext) is done first, including the writes of hooks run by a
+// query plan.  Statements run by Exec, and the writes of cascading
+// deletes, aren't limited.  The limit is shared by every DbMap and
+// Transaction using the table.  A perSecond of 0 or less removes the
+// limit.
+func (t *TableMap) SetWriteLimit(perSecond float64, burst int) *TableMap {
+	if perSecond <= 0 {
+		t.writeLimit = nil
+		return t
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	t.writeLimit = &tokenBucket{rate: perSecond, burst: float64(burst), tokens: float64(burst)}
+	return t
+}
+
+// A tokenBucket is a rate limiter that holds up to burst tokens, and
+// gains rate tokens per second.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes a token from the bucket, and returns how long the
+// caller must wait before using it.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel returns a reserved token that won't be used.
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens++
+}
+
+// throttleWrite waits until the table's write limit allows another
+// write statement, or until ctx is done.
+func (t *TableMap) throttleWrite(ctx context.Context) error {
+	if t.writeLimit == nil {
+		return nil
+	}
+	wait := t.writeLimit.reserve(time.Now())
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		t.writeLimit.cancel()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,81 @@
+package gorp
+
+import "time"
+
+// SetAutoCreateTime marks the column as holding the time its row was
+// created, if b is true.  Query plan inserts set it to the current time
+// unless the plan assigns it explicitly:
+//
+//     table.ColMap("Created").SetAutoCreateTime(true)
+//     table.ColMap("Updated").SetAutoUpdateTime(true)
+//     err := dbmap.Query(inv).Assign(&inv.Memo, "new").Insert()
+//
+// The column's field should be a time.Time or *time.Time.  The current
+// time is taken from the DbMap's clock (see DbMap.SetClock).  Rows
+// written using Insert() and Update() are not affected; use hooks for
+// those.
+func (c *ColumnMap) SetAutoCreateTime(b bool) *ColumnMap {
+	c.autoCreateTime = b
+	return c
+}
+
+// SetAutoUpdateTime marks the column as holding the time its row was
+// last written, if b is true.  Query plan inserts and updates set it to
+// the current time unless the plan assigns it explicitly.  See
+// SetAutoCreateTime.
+func (c *ColumnMap) SetAutoUpdateTime(b bool) *ColumnMap {
+	c.autoUpdateTime = b
+	return c
+}
+
+// SetClock sets the function that query plans call for the current
+// time, e.g. to set automatic timestamps (see
+// ColumnMap.SetAutoCreateTime) and soft deletion times.  Tests can use
+// it to pin the time.  Passing nil restores time.Now.  Like AddTable,
+// SetClock must be called before the DbMap is used.
+func (m *DbMap) SetClock(clock func() time.Time) *DbMap {
+	m.clock = clock
+	return m
+}
+
+// now returns the current time according to the DbMap's clock.
+func (m *DbMap) now() time.Time {
+	if m.clock != nil {
+		return m.clock()
+	}
+	return time.Now()
+}
+
+// assignTimestamps assigns the current time to the automatic timestamp
+// columns of the plan's table that the plan doesn't assign already:
+// the auto-update columns and, if insert is true, the auto-create
+// columns.
+func (plan *QueryPlan) assignTimestamps(insert bool) {
+	dialect := plan.table.dbmap.Dialect
+	var now time.Time
+	for _, col := range plan.table.columns {
+		if !col.inSchema() || !(col.autoUpdateTime || insert && col.autoCreateTime) {
+			continue
+		}
+		quoted := dialect.QuoteField(col.ColumnName)
+		if plan.assigns(quoted) {
+			continue
+		}
+		if now.IsZero() {
+			now = plan.dbMap.now()
+		}
+		plan.assignCols = append(plan.assignCols, quoted)
+		plan.assignBindVars = append(plan.assignBindVars, dialect.BindVar(len(plan.args)))
+		plan.args = append(plan.args, now)
+	}
+}
+
+// assigns returns true if the plan assigns a value to quotedColumn.
+func (plan *QueryPlan) assigns(quotedColumn string) bool {
+	for _, col := range plan.assignCols {
+		if col == quotedColumn {
+			return true
+		}
+	}
+	return false
+}
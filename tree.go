@@ -0,0 +1,205 @@
+package gorp
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+)
+
+// Descendants returns the rows below the rows matching root in a
+// self-referencing table, where parentFieldPtr is a pointer to the
+// field of model that holds the primary key of a row's parent.  Both
+// parentFieldPtr and root must refer to fields of model, the same way
+// they would for a query plan:
+//
+//     cat := new(Category)
+//     children, err := dbmap.Descendants(cat, &cat.ParentId, gorp.Equal(&cat.Id, rootId), 0)
+//
+// Results are ordered by depth, and do not include the root rows.  If
+// depth is greater than zero, only rows at most depth levels below the
+// root rows are returned; otherwise, the whole subtree is returned, so
+// the table must not contain cycles.
+//
+// If the table has a closure table (see TableMap.SetClosureTable), it
+// is used to find the rows.  Otherwise, if the dialect implements
+// RecursiveQuerier, a single recursive query is used; if not, one
+// query is run per level.
+func (m *DbMap) Descendants(model interface{}, parentFieldPtr interface{}, root Filter, depth int) ([]interface{}, error) {
+	return treeQuery(m, m, model, parentFieldPtr, root, depth, false)
+}
+
+// Ancestors returns the rows above the rows matching start in a
+// self-referencing table, ordered from the nearest parent up.  See
+// Descendants for details.
+func (m *DbMap) Ancestors(model interface{}, parentFieldPtr interface{}, start Filter, depth int) ([]interface{}, error) {
+	return treeQuery(m, m, model, parentFieldPtr, start, depth, true)
+}
+
+// Descendants has the same behavior as DbMap.Descendants(), but runs
+// in a transaction.
+func (t *Transaction) Descendants(model interface{}, parentFieldPtr interface{}, root Filter, depth int) ([]interface{}, error) {
+	return treeQuery(t.dbmap, t, model, parentFieldPtr, root, depth, false)
+}
+
+// Ancestors has the same behavior as DbMap.Ancestors(), but runs in a
+// transaction.
+func (t *Transaction) Ancestors(model interface{}, parentFieldPtr interface{}, start Filter, depth int) ([]interface{}, error) {
+	return treeQuery(t.dbmap, t, model, parentFieldPtr, start, depth, true)
+}
+
+// A treePlan holds the parts of a tree query that are shared by the
+// recursive and level-by-level implementations.
+type treePlan struct {
+	exec      SqlExecutor
+	plan      *QueryPlan
+	table     *TableMap
+	parentCol *ColumnMap
+	start     Filter
+	depth     int
+	up        bool
+}
+
+func treeQuery(m *DbMap, exec SqlExecutor, model interface{}, parentFieldPtr interface{}, start Filter, depth int, up bool) ([]interface{}, error) {
+	plan := query(m, exec, model).(*QueryPlan)
+	if len(plan.Errors) > 0 {
+		return nil, plan.Errors[0]
+	}
+	if len(plan.table.keys) != 1 {
+		return nil, errors.New("gorp: Tree queries require a table with exactly one primary key column")
+	}
+	if start == nil {
+		return nil, errors.New("gorp: Tree queries require a filter for the starting rows")
+	}
+	parentMap, err := plan.colMap.fieldMapForPointer(parentFieldPtr)
+	if err != nil {
+		return nil, err
+	}
+	tree := &treePlan{
+		exec:      exec,
+		plan:      plan,
+		table:     plan.table,
+		parentCol: parentMap.column,
+		start:     start,
+		depth:     depth,
+		up:        up,
+	}
+	if plan.table.closure != nil {
+		return tree.closureQuery()
+	}
+	if querier, ok := m.Dialect.(RecursiveQuerier); ok && querier.SupportsRecursiveQueries() {
+		return tree.recursive()
+	}
+	return tree.levels()
+}
+
+// recursive runs the tree query using a recursive common table
+// expression.
+func (tree *treePlan) recursive() ([]interface{}, error) {
+	dialect := tree.table.dbmap.Dialect
+	quotedTable := dialect.QuotedTableForQuery(tree.table.SchemaName, tree.table.TableName)
+	quotedKey := quotedTable + "." + dialect.QuoteField(tree.table.keys[0].ColumnName)
+	quotedParent := quotedTable + "." + dialect.QuoteField(tree.parentCol.ColumnName)
+
+	where, args, err := tree.start.Where(tree.plan.colMap, dialect, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Walking down the tree joins each row's parent column to the ids
+	// found so far; walking up joins each row's key to the parents
+	// found so far.
+	next, join := quotedParent, "gorp_tree.gorp_id"
+	if tree.up {
+		next, join = quotedKey, "gorp_tree.gorp_parent"
+	}
+
+	s := bytes.Buffer{}
+	s.WriteString("with recursive gorp_tree (gorp_id, gorp_parent, gorp_depth) as (select ")
+	s.WriteString(quotedKey + ", " + quotedParent + ", 0 from " + quotedTable)
+	if where != "" {
+		s.WriteString(" where " + where)
+	}
+	s.WriteString(" union all select ")
+	s.WriteString(quotedKey + ", " + quotedParent + ", gorp_tree.gorp_depth + 1 from " + quotedTable)
+	s.WriteString(" inner join gorp_tree on " + next + " = " + join)
+	if tree.depth > 0 {
+		s.WriteString(" where gorp_tree.gorp_depth < " + dialect.BindVar(len(args)))
+		args = append(args, tree.depth)
+	}
+	s.WriteString(") select ")
+	x := 0
+	for _, col := range tree.table.columns {
+		if col.inSchema() {
+			if x > 0 {
+				s.WriteString(",")
+			}
+			s.WriteString(quotedTable + "." + dialect.QuoteField(col.ColumnName))
+			x++
+		}
+	}
+	s.WriteString(" from " + quotedTable + " inner join gorp_tree on " + quotedKey + " = gorp_tree.gorp_id")
+	s.WriteString(" where gorp_tree.gorp_depth > 0 order by gorp_tree.gorp_depth")
+	return tree.exec.Select(reflect.New(tree.table.gotype).Interface(), s.String(), args...)
+}
+
+// levels runs the tree query one level at a time, for dialects that
+// don't support recursive queries.
+func (tree *treePlan) levels() ([]interface{}, error) {
+	start, err := tree.plan.Where(tree.start).Select()
+	if err != nil {
+		return nil, err
+	}
+	keyCol := tree.table.keys[0]
+	matchCol, valueField := tree.parentCol, keyCol.fieldName
+	if tree.up {
+		matchCol, valueField = keyCol, tree.parentCol.fieldName
+	}
+
+	var results []interface{}
+	level := start
+	for depth := 0; tree.depth <= 0 || depth < tree.depth; depth++ {
+		values := distinctValues(level, valueField)
+		if len(values) == 0 {
+			break
+		}
+		level, err = tree.exec.Select(reflect.New(tree.table.gotype).Interface(), selectInQuery(tree.table, matchCol, len(values)), values...)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, level...)
+	}
+	return results, nil
+}
+
+// distinctValues returns the distinct, non-null values of field in
+// rows, which must be pointers to structs.
+func distinctValues(rows []interface{}, field string) []interface{} {
+	seen := make(map[string]bool, len(rows))
+	values := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		value := reflect.ValueOf(row).Elem().FieldByName(field).Interface()
+		if valuer, ok := value.(driver.Valuer); ok {
+			v, err := valuer.Value()
+			if err != nil {
+				continue
+			}
+			value = v
+		}
+		if value == nil {
+			continue
+		}
+		if ptr := reflect.ValueOf(value); ptr.Kind() == reflect.Ptr {
+			if ptr.IsNil() {
+				continue
+			}
+			value = ptr.Elem().Interface()
+		}
+		key := CacheKey(value)
+		if !seen[key] {
+			seen[key] = true
+			values = append(values, value)
+		}
+	}
+	return values
+}
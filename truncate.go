@@ -0,0 +1,118 @@
+package gorp
+
+import (
+	"errors"
+	"fmt"
+)
+
+// A TruncateOption changes the behavior of a truncate statement.  Not
+// every dialect supports every option (see TruncateOptioner).
+type TruncateOption int
+
+const (
+	// TruncateCascade also truncates tables with foreign keys
+	// referencing the truncated table.
+	TruncateCascade TruncateOption = iota + 1
+
+	// TruncateRestartIdentity resets the sequences of the truncated
+	// table's auto increment columns.
+	TruncateRestartIdentity
+)
+
+// Truncate removes every row from the plan's table using the
+// dialect's truncate statement (see DbMap.TruncateTable).  Unlike
+// Delete, it is not rejected by DbMap.RequireWhereForMutations.
+//
+// Since a truncate statement can't be filtered, Truncate returns an
+// error for tables with a tenant column (see TableMap.SetTenantColumn),
+// for tables with a soft-delete column unless the plan includes
+// deleted rows (see WithDeleted), and for tables with default scopes
+// that the plan includes (see Unscoped), rather than removing rows the
+// plan can't see.
+func (plan *QueryPlan) Truncate(options ...TruncateOption) error {
+	if len(plan.Errors) > 0 {
+		return plan.Errors[0]
+	}
+	switch {
+	case plan.table.tenant != nil:
+		return fmt.Errorf("gorp: Cannot truncate table %s, since it has a tenant column", plan.table.TableName)
+	case plan.softDeletes():
+		return fmt.Errorf("gorp: Cannot truncate table %s, since it has a soft-delete column; use WithDeleted to remove every row", plan.table.TableName)
+	case len(plan.defaultScopes()) > 0:
+		return fmt.Errorf("gorp: Cannot truncate table %s, since it has default scopes; use Unscoped to remove every row", plan.table.TableName)
+	}
+	err := plan.table.truncate(plan.executor, options)
+	plan.clearMemo()
+	return err
+}
+
+// TruncateTable removes every row from model's table, which is meant
+// for test teardown and data resets.  The statement used depends on
+// the dialect (see Dialect.TruncateClause), and options add clauses
+// such as CASCADE or RESTART IDENTITY:
+//
+//     err := dbmap.TruncateTable(Invoice{}, gorp.TruncateRestartIdentity)
+//
+// An error is returned if the dialect doesn't support one of the
+// options.
+func (m *DbMap) TruncateTable(model interface{}, options ...TruncateOption) error {
+	return truncateTable(m, m, model, options)
+}
+
+// TruncateTable has the same behavior as DbMap.TruncateTable, but
+// runs in a transaction.
+func (t *Transaction) TruncateTable(model interface{}, options ...TruncateOption) error {
+	return truncateTable(t.dbmap, t, model, options)
+}
+
+func truncateTable(m *DbMap, exec SqlExecutor, model interface{}, options []TruncateOption) error {
+	t, err := toType(model)
+	if err != nil {
+		return err
+	}
+	table, err := m.tableFor(t, false)
+	if err != nil {
+		return err
+	}
+	return table.truncate(exec, options)
+}
+
+// truncate runs the truncate statement for this table.
+func (t *TableMap) truncate(exec SqlExecutor, options []TruncateOption) error {
+	if err := t.dbmap.checkWritable(); err != nil {
+		return err
+	}
+	query, err := t.truncateSql(options)
+	if err != nil {
+		return err
+	}
+	return t.dbmap.execAudited(exec, query)
+}
+
+// truncateSql returns the truncate statement for this table.
+func (t *TableMap) truncateSql(options []TruncateOption) (string, error) {
+	var cascade, restartIdentity bool
+	for _, option := range options {
+		switch option {
+		case TruncateCascade:
+			cascade = true
+		case TruncateRestartIdentity:
+			restartIdentity = true
+		default:
+			return "", fmt.Errorf("gorp: Unknown truncate option %d", option)
+		}
+	}
+	dialect := t.dbmap.Dialect
+	suffix := ""
+	if cascade || restartIdentity {
+		optioner, ok := dialect.(TruncateOptioner)
+		if !ok {
+			return "", errors.New("gorp: Truncate options are not supported by this dialect")
+		}
+		var err error
+		if suffix, err = optioner.TruncateOptions(cascade, restartIdentity); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%s %s%s;", dialect.TruncateClause(), dialect.QuotedTableForQuery(t.SchemaName, t.TableName), suffix), nil
+}
@@ -0,0 +1,76 @@
+package gorp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// SetFilterable allows end users to filter on this column through
+// DbMap.TermFilters.  Columns are not filterable by default, so that
+// a report builder or search UI can only filter on columns that have
+// been explicitly exposed.
+func (c *ColumnMap) SetFilterable(b bool) *ColumnMap {
+	c.isFilterable = b
+	return c
+}
+
+// A FilterTerm is a single condition of an end-user filter
+// expression, e.g. from a report builder's query string.  Field is the
+// name of a filterable field (or its column), Op is one of the column
+// ops of a FilterDefinition (eq, ne, lt, le, gt, ge, eqFold, null, or
+// notNull), and Value is the JSON encoded value to compare to.
+type FilterTerm struct {
+	Field string          `json:"field"`
+	Op    string          `json:"op"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// TermFilters converts end-user filter terms to Filters on target's
+// fields, which can be passed to Where() on a query for target:
+//
+//     filters, err := dbmap.TermFilters(inv, terms...)
+//     if err != nil {
+//         return err
+//     }
+//     results, err := dbmap.Query(inv).Where(filters...).Select()
+//
+// Only columns marked with ColumnMap.SetFilterable may be used, and
+// values are decoded into their column's Go type, so terms from
+// untrusted sources can't reach other columns or inject SQL.
+func (m *DbMap) TermFilters(target interface{}, terms ...FilterTerm) ([]Filter, error) {
+	plan := query(m, m, target).(*QueryPlan)
+	if len(plan.Errors) > 0 {
+		return nil, plan.Errors[0]
+	}
+	filters := make([]Filter, 0, len(terms))
+	for _, term := range terms {
+		col := plan.filterableColumn(term.Field)
+		if col == nil {
+			return nil, fmt.Errorf("gorp: Cannot filter on field %q of table %s", term.Field, plan.table.TableName)
+		}
+		if !columnOps[term.Op] {
+			return nil, fmt.Errorf("gorp: Unknown filter op %q", term.Op)
+		}
+		filter, err := plan.columnFilter(col, term.Op, term.Value)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+// filterableColumn returns the filterable column of the plan's table
+// matching name, or nil if there isn't one.
+func (plan *QueryPlan) filterableColumn(name string) *ColumnMap {
+	for _, col := range plan.table.columns {
+		if !col.isFilterable || !col.inSchema() {
+			continue
+		}
+		if strings.EqualFold(col.fieldName, name) || strings.EqualFold(col.ColumnName, name) {
+			return col
+		}
+	}
+	return nil
+}
@@ -0,0 +1,390 @@
+package gorp
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A Validator is a constraint on the values of a column, declared with
+// ColumnMap.Validate.  It is checked in Go before rows are written,
+// and enforced by the database with a check constraint in the tables
+// created by CreateTables.
+type Validator interface {
+	// Validate returns an error if value, the non-NULL value of the
+	// column's field, violates the constraint.
+	Validate(value interface{}) error
+
+	// Check returns the condition of a check constraint that enforces
+	// the constraint on quotedColumn, or "" if the dialect can't
+	// express it.
+	Check(d Dialect, quotedColumn string) string
+}
+
+// A ValidationError is returned by Insert, Update, and query plan
+// assignments when a value violates one of its column's validators.
+type ValidationError struct {
+	TableName  string
+	ColumnName string
+	Value      interface{}
+	Err        error
+
+	// Errors holds an error for each invalid column when a row
+	// has several, starting with this one, so that they can all be
+	// reported at once.
+	Errors []ValidationError
+}
+
+// Error returns a description of the invalid value, or values.
+func (e ValidationError) Error() string {
+	message := fmt.Sprintf("gorp: Invalid value %v for column %s of table %s: %s", e.Value, e.ColumnName, e.TableName, e.Err)
+	if len(e.Errors) < 2 {
+		return message
+	}
+	messages := []string{message}
+	for _, err := range e.Errors[1:] {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// errRequired is the error of a ValidationError for a missing value of
+// a required column.
+var errRequired = errors.New("is required")
+
+// validationError returns the first of errs, holding all of them, or
+// nil if there are none.
+func validationError(errs []ValidationError) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	err := errs[0]
+	err.Errors = errs
+	return err
+}
+
+// setValidationRules adds the rules of a field's validate tag, a comma
+// separated list, to the column:
+//
+//     Name string `validate:"required,maxsize"`
+//
+// A required column's value can't be NULL or an empty string, and
+// query plan inserts must assign it.  A maxsize column's value can't be
+// longer than the column's MaxSize (see SetMaxSize), in characters;
+// there is no check constraint, since the column's type enforces it.
+// Other rules are ignored, since the tag is shared with other
+// validation packages.
+func (c *ColumnMap) setValidationRules(rules string) {
+	for _, rule := range strings.Split(rules, ",") {
+		switch strings.TrimSpace(rule) {
+		case "required":
+			c.required = true
+		case "maxsize":
+			c.validators = append(c.validators, maxSizeValidator{c})
+		}
+	}
+}
+
+// Validate adds validators to the column:
+//
+//     table.ColMap("Quantity").Validate(gorp.MinValue(1), gorp.MaxValue(100))
+//     table.ColMap("Sku").Validate(gorp.Length(8, 8), gorp.Pattern("^[A-Z0-9]+$"))
+//
+// NULL values (nil pointers, and invalid sql.Null* values) are always
+// valid, as they are for check constraints.  Validators are checked
+// after the PreInsert and PreUpdate hooks, so values set by hooks are
+// validated too.  Insert and Update report every invalid column of a
+// row (see ValidationError.Errors).
+//
+// Fields can also declare validation rules with a validate tag:
+// "required" columns can't be NULL or empty strings, and query plan
+// inserts must assign them, and "maxsize" columns can't hold more
+// characters than their MaxSize.  Other rules in the tag are ignored,
+// so it can be shared with other validation packages:
+//
+//     Name string `validate:"required,maxsize"`
+func (c *ColumnMap) Validate(validators ...Validator) *ColumnMap {
+	c.validators = append(c.validators, validators...)
+	return c
+}
+
+// validateValue checks value against the column's validators and, if
+// it is required, that it is set.
+func (c *ColumnMap) validateValue(table *TableMap, value interface{}) error {
+	if len(c.validators) == 0 && !c.required {
+		return nil
+	}
+	value, ok := validatedValue(value)
+	if c.required && (!ok || isEmptyString(value)) {
+		return ValidationError{TableName: table.TableName, ColumnName: c.ColumnName, Value: value, Err: errRequired}
+	}
+	if !ok {
+		return nil
+	}
+	for _, validator := range c.validators {
+		if err := validator.Validate(value); err != nil {
+			return ValidationError{TableName: table.TableName, ColumnName: c.ColumnName, Value: value, Err: err}
+		}
+	}
+	return nil
+}
+
+// validate checks the values of elem's fields against their columns'
+// validators, returning an error for each invalid column.
+func (t *TableMap) validate(elem reflect.Value) error {
+	var errs []ValidationError
+	for _, col := range t.columns {
+		if len(col.validators) == 0 && !col.required || !col.inSchema() {
+			continue
+		}
+		if err := col.validateValue(t, elem.FieldByName(col.fieldName).Interface()); err != nil {
+			errs = append(errs, err.(ValidationError))
+		}
+	}
+	return validationError(errs)
+}
+
+// validate runs the Validate hook of the plan's target, if it has one,
+// before a write of type op.  The target holds the values that the
+// plan assigns (see runPreHook), so the hook can check them; its other
+// fields hold whatever the caller left in them.  Insert plans must
+// also assign the table's required columns.
+func (plan *QueryPlan) validate(op writeOp) error {
+	if op == writeInsert {
+		var errs []ValidationError
+		for _, col := range plan.table.columns {
+			if !col.required || !col.inSchema() || col.isAutoIncr || col == plan.table.tenant {
+				continue
+			}
+			if !plan.assigns(plan.table.dbmap.Dialect.QuoteField(col.ColumnName)) {
+				errs = append(errs, ValidationError{TableName: plan.table.TableName, ColumnName: col.ColumnName, Err: errRequired})
+			}
+		}
+		if err := validationError(errs); err != nil {
+			return err
+		}
+	}
+	if v, ok := plan.target.Interface().(HasValidate); ok {
+		return v.Validate(plan.executor)
+	}
+	return nil
+}
+
+// isEmptyString returns true if value is an empty string or []byte.
+func isEmptyString(value interface{}) bool {
+	switch s := value.(type) {
+	case string:
+		return s == ""
+	case []byte:
+		return len(s) == 0
+	}
+	return false
+}
+
+// checkConstraints returns the conditions of the check constraints
+// that enforce the table's validators.
+func (t *TableMap) checkConstraints(d Dialect) []string {
+	var checks []string
+	for _, col := range t.columns {
+		if !col.inSchema() {
+			continue
+		}
+		for _, validator := range col.validators {
+			if check := validator.Check(d, d.QuoteField(col.ColumnName)); check != "" {
+				checks = append(checks, check)
+			}
+		}
+	}
+	return checks
+}
+
+// validatedValue returns the value to validate for value, with
+// pointers dereferenced and driver.Valuers converted, or false if it
+// is NULL.
+func validatedValue(value interface{}) (interface{}, bool) {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, false
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil, false
+	}
+	value = v.Interface()
+	if valuer, ok := value.(driver.Valuer); ok {
+		converted, err := valuer.Value()
+		if err != nil || converted == nil {
+			return nil, false
+		}
+		value = converted
+	}
+	return value, true
+}
+
+// MinValue returns a Validator for numeric columns whose values must be
+// at least min.
+func MinValue(min float64) Validator {
+	return rangeValidator{bound: min, min: true}
+}
+
+// MaxValue returns a Validator for numeric columns whose values must be
+// at most max.
+func MaxValue(max float64) Validator {
+	return rangeValidator{bound: max}
+}
+
+type rangeValidator struct {
+	bound float64
+	min   bool
+}
+
+func (r rangeValidator) Validate(value interface{}) error {
+	v := reflect.ValueOf(value)
+	var n float64
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		n = v.Float()
+	default:
+		return fmt.Errorf("value of type %T is not a number", value)
+	}
+	if r.min && n < r.bound {
+		return fmt.Errorf("must be at least %s", r.literal())
+	}
+	if !r.min && n > r.bound {
+		return fmt.Errorf("must be at most %s", r.literal())
+	}
+	return nil
+}
+
+func (r rangeValidator) Check(d Dialect, quotedColumn string) string {
+	if r.min {
+		return quotedColumn + " >= " + r.literal()
+	}
+	return quotedColumn + " <= " + r.literal()
+}
+
+func (r rangeValidator) literal() string {
+	return strconv.FormatFloat(r.bound, 'f', -1, 64)
+}
+
+// Length returns a Validator for string columns whose values must be
+// at least min and, unless max is 0, at most max characters long.
+func Length(min, max int) Validator {
+	return lengthValidator{min: min, max: max}
+}
+
+type lengthValidator struct {
+	min, max int
+}
+
+func (l lengthValidator) Validate(value interface{}) error {
+	var length int
+	switch s := value.(type) {
+	case string:
+		length = utf8.RuneCountInString(s)
+	case []byte:
+		length = utf8.RuneCount(s)
+	default:
+		if v := reflect.ValueOf(value); v.Kind() == reflect.String {
+			length = utf8.RuneCountInString(v.String())
+		} else {
+			return fmt.Errorf("value of type %T is not a string", value)
+		}
+	}
+	if length < l.min {
+		return fmt.Errorf("must be at least %d characters long", l.min)
+	}
+	if l.max > 0 && length > l.max {
+		return fmt.Errorf("must be at most %d characters long", l.max)
+	}
+	return nil
+}
+
+func (l lengthValidator) Check(d Dialect, quotedColumn string) string {
+	length := "length(" + quotedColumn + ")"
+	if lengther, ok := d.(CharLengther); ok {
+		length = lengther.CharLength(quotedColumn)
+	}
+	switch {
+	case l.min > 0 && l.max > 0:
+		return fmt.Sprintf("%s between %d and %d", length, l.min, l.max)
+	case l.max > 0:
+		return fmt.Sprintf("%s <= %d", length, l.max)
+	case l.min > 0:
+		return fmt.Sprintf("%s >= %d", length, l.min)
+	}
+	return ""
+}
+
+// maxSizeValidator is the Validator of a column's maxsize validation
+// rule (see setValidationRules).  It reads the column's MaxSize when it
+// validates, since SetMaxSize may be called after the table is added.
+type maxSizeValidator struct {
+	col *ColumnMap
+}
+
+func (m maxSizeValidator) Validate(value interface{}) error {
+	if m.col.MaxSize <= 0 {
+		return nil
+	}
+	return Length(0, m.col.MaxSize).Validate(value)
+}
+
+func (m maxSizeValidator) Check(d Dialect, quotedColumn string) string {
+	return ""
+}
+
+// Pattern returns a Validator for string columns whose values must
+// match the regular expression expr.  The check constraint is only
+// created if the dialect implements RegexpMatcher, and uses the
+// database's regular expressions, so expr should only use the syntax
+// that they share with Go's.  Panics if expr doesn't compile.
+func Pattern(expr string) Validator {
+	return patternValidator{expr: expr, re: regexp.MustCompile(expr)}
+}
+
+type patternValidator struct {
+	expr string
+	re   *regexp.Regexp
+}
+
+func (p patternValidator) Validate(value interface{}) error {
+	var matched bool
+	switch s := value.(type) {
+	case string:
+		matched = p.re.MatchString(s)
+	case []byte:
+		matched = p.re.Match(s)
+	default:
+		if v := reflect.ValueOf(value); v.Kind() == reflect.String {
+			matched = p.re.MatchString(v.String())
+		} else {
+			return fmt.Errorf("value of type %T is not a string", value)
+		}
+	}
+	if !matched {
+		return fmt.Errorf("must match %s", p.expr)
+	}
+	return nil
+}
+
+func (p patternValidator) Check(d Dialect, quotedColumn string) string {
+	if matcher, ok := d.(RegexpMatcher); ok {
+		return matcher.MatchRegexp(quotedColumn, p.expr)
+	}
+	return ""
+}
@@ -0,0 +1,240 @@
+package gorp
+
+import (
+	"crypto/rand"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// A VersionStrategy generates the values of a table's version column,
+// which Update and Delete use for optimistic locking (see
+// TableMap.SetVersionCol).  Rows whose version field holds its zero
+// value are assumed to be new, so their version isn't checked.
+type VersionStrategy interface {
+	// NextVersion returns the version to store for a row whose
+	// version field holds current.  The returned value must be
+	// convertible to the field's type and differ from current.
+	NextVersion(current interface{}) (interface{}, error)
+}
+
+// IntegerVersion is the default VersionStrategy, which counts the
+// writes of each row in an integer field, starting at 1.
+type IntegerVersion struct{}
+
+// NextVersion returns current + 1.
+func (IntegerVersion) NextVersion(current interface{}) (interface{}, error) {
+	v := reflect.ValueOf(current)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() + 1, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() + 1, nil
+	}
+	return nil, fmt.Errorf("gorp: IntegerVersion: version field of type %T is not an integer", current)
+}
+
+// TimestampVersion is a VersionStrategy for time.Time fields, e.g. an
+// UpdatedAt column, which stores the time of each write.  Precision is
+// the precision that the database stores times with, and defaults to
+// time.Microsecond; versions are truncated to it, so that the version
+// read back from the database still matches.
+type TimestampVersion struct {
+	Precision time.Duration
+}
+
+// NextVersion returns the current time, or current plus the precision
+// if the clock hasn't moved past current.
+func (s TimestampVersion) NextVersion(current interface{}) (interface{}, error) {
+	last, ok := current.(time.Time)
+	if !ok {
+		return nil, fmt.Errorf("gorp: TimestampVersion: version field of type %T is not a time.Time", current)
+	}
+	precision := s.Precision
+	if precision <= 0 {
+		precision = time.Microsecond
+	}
+	next := time.Now().UTC().Truncate(precision)
+	if !next.After(last) {
+		next = last.Add(precision)
+	}
+	return next, nil
+}
+
+// UUIDVersion is a VersionStrategy for string fields, e.g. an ETag
+// column, which stores a new random (version 4) UUID on each write.
+type UUIDVersion struct{}
+
+// NextVersion returns a new random UUID.
+func (UUIDVersion) NextVersion(current interface{}) (interface{}, error) {
+	if reflect.ValueOf(current).Kind() != reflect.String {
+		return nil, fmt.Errorf("gorp: UUIDVersion: version field of type %T is not a string", current)
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// SetVersionStrategy sets the strategy that generates the values of
+// the table's version column, which must be set with SetVersionCol
+// first (unless the struct has a Version field):
+//
+//     table := dbmap.AddTable(Document{}).SetKeys(true, "Id")
+//     table.SetVersionCol("ETag")
+//     table.SetVersionStrategy(gorp.UUIDVersion{})
+//
+// Passing nil restores the default, IntegerVersion.
+//
+// Automatically calls ResetSql() to ensure SQL statements are regenerated.
+func (t *TableMap) SetVersionStrategy(strategy VersionStrategy) *TableMap {
+	t.versionStrategy = strategy
+	t.ResetSql()
+	return t
+}
+
+// versioning returns the strategy for the table's version column.
+func (t *TableMap) versioning() VersionStrategy {
+	if t.versionStrategy == nil {
+		return IntegerVersion{}
+	}
+	return t.versionStrategy
+}
+
+// versionChecked returns true if a write of the row should fail with
+// an OptimisticLockError if no row was affected.
+func (bi bindInstance) versionChecked() bool {
+	return bi.versField != "" && !reflect.ValueOf(bi.existingVersion).IsZero()
+}
+
+// nextVersion returns the version to store for elem, whose version
+// field is field, converted to the field's type.
+func nextVersion(strategy VersionStrategy, elem reflect.Value, field string) (interface{}, error) {
+	f := elem.FieldByName(field)
+	next, err := strategy.NextVersion(f.Interface())
+	if err != nil {
+		return nil, err
+	}
+	v := reflect.ValueOf(next)
+	if !v.IsValid() || !v.Type().ConvertibleTo(f.Type()) {
+		return nil, fmt.Errorf("gorp: %T returned a version of type %T for field %s of type %s", strategy, next, field, f.Type())
+	}
+	return v.Convert(f.Type()).Interface(), nil
+}
+
+// checkVersion adds optimistic locking to the plan's update statement,
+// if the plan's table has a version column (see TableMap.SetVersionCol),
+// the plan's target holds a version, and the plan's where clause
+// selects the target's row by its primary key: the statement only
+// updates the row if its version matches the target's, and assigns
+// the next version to it.  It returns the target's version and the
+// next version, or nil if the version isn't checked, and a function
+// that restores the plan's filters, assignments, and arguments to what
+// they were before checkVersion, once the statement has run.  Plans
+// that assign the version column themselves aren't checked.
+func (plan *QueryPlan) checkVersion() (current, next interface{}, undo func(), err error) {
+	plan.storeJoin()
+	filters, args, assigned := plan.filters, len(plan.args), len(plan.assignCols)
+	undo = func() {
+		plan.filters = filters
+		plan.args = plan.args[:args]
+		plan.assignCols = plan.assignCols[:assigned]
+		plan.assignBindVars = plan.assignBindVars[:assigned]
+	}
+	col := plan.table.version
+	if col == nil || !col.inSchema() || plan.from != nil {
+		return nil, nil, undo, nil
+	}
+	quoted := plan.table.dbmap.Dialect.QuoteField(col.ColumnName)
+	if plan.assigns(quoted) {
+		return nil, nil, undo, nil
+	}
+	elem := plan.target.Elem()
+	current = elem.FieldByName(col.fieldName).Interface()
+	if reflect.ValueOf(current).IsZero() || !plan.filtersOnTargetKey() {
+		return nil, nil, undo, nil
+	}
+	fieldPtr, err := plan.colMap.pointerForColumn(col)
+	if err != nil {
+		return nil, nil, undo, err
+	}
+	next, err = nextVersion(plan.table.versioning(), elem, col.fieldName)
+	if err != nil {
+		return nil, nil, undo, err
+	}
+	plan.assignCols = append(plan.assignCols, quoted)
+	plan.assignBindVars = append(plan.assignBindVars, plan.table.dbmap.Dialect.BindVar(len(plan.args)))
+	plan.args = append(plan.args, next)
+
+	checked := new(andFilter)
+	if filters != nil {
+		checked.Add(filters)
+	}
+	checked.Add(Equal(fieldPtr, current))
+	plan.filters = checked
+	return current, next, undo, nil
+}
+
+// filtersOnTargetKey returns true if the plan's where clause compares
+// each of the table's primary key columns to the target's key, so that
+// the plan writes the target's row at most.
+func (plan *QueryPlan) filtersOnTargetKey() bool {
+	filter, ok := plan.filters.(*andFilter)
+	if !ok || len(plan.table.keys) == 0 {
+		return false
+	}
+	elem := plan.target.Elem()
+	for _, key := range plan.table.keys {
+		if !plan.comparesKey(filter, key, elem.FieldByName(key.fieldName)) {
+			return false
+		}
+	}
+	return true
+}
+
+// comparesKey returns true if one of filter's conditions is that key
+// equals value.
+func (plan *QueryPlan) comparesKey(filter *andFilter, key *ColumnMap, value reflect.Value) bool {
+	for _, subFilter := range filter.subFilters {
+		comparison, ok := subFilter.(*comparisonFilter)
+		if !ok || comparison.comparison != "=" {
+			continue
+		}
+		fieldMap, err := plan.colMap.fieldMapForPointer(comparison.left)
+		if err != nil || fieldMap.column != key {
+			continue
+		}
+		right := reflect.ValueOf(comparison.right)
+		if right.IsValid() && right.Type().ConvertibleTo(value.Type()) &&
+			reflect.DeepEqual(right.Convert(value.Type()).Interface(), value.Interface()) {
+			return true
+		}
+	}
+	return false
+}
+
+// versionedUpdate returns the result of running an update statement
+// that checks the target's version, current, and assigns it next: if
+// no rows were updated, it returns an OptimisticLockError; otherwise,
+// it stores next in the target's version field.
+func (plan *QueryPlan) versionedUpdate(rows int64, current, next interface{}) (int64, error) {
+	elem := plan.target.Elem()
+	if rows > 0 {
+		elem.FieldByName(plan.table.version.fieldName).Set(reflect.ValueOf(next))
+		return rows, nil
+	}
+	keys := make([]interface{}, 0, len(plan.table.keys))
+	for _, key := range plan.table.keys {
+		value := elem.FieldByName(key.fieldName).Interface()
+		if reflect.ValueOf(value).IsZero() {
+			// The target doesn't identify a row, so there's no way to
+			// tell whether one exists.
+			return -1, OptimisticLockError{TableName: plan.table.TableName, LocalVersionValue: current}
+		}
+		keys = append(keys, value)
+	}
+	return lockError(plan.dbMap, plan.executor, plan.table.TableName, current, elem, keys...)
+}